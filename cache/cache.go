@@ -8,6 +8,10 @@ import (
 	"github.com/ammiranda/tree_service/models"
 )
 
+// DefaultTreeID is the tree whose entries a provider holds unless it was
+// returned by ForTree. It matches repository.DefaultTreeID.
+const DefaultTreeID int64 = 1
+
 var (
 	provider CacheProvider
 	once     sync.Once
@@ -24,11 +28,17 @@ type PaginatedTreeResponse struct {
 		TotalPages int64 `json:"totalPages"`
 		HasNext    bool  `json:"hasNext"`
 		HasPrev    bool  `json:"hasPrev"`
+		// Cursor is the cursor the page was requested with (cursor pagination only)
+		Cursor string `json:"cursor,omitempty"`
+		// NextCursor fetches the following page when HasNext is set
+		NextCursor string `json:"nextCursor,omitempty"`
 	} `json:"pagination"`
 }
 
 // CacheProvider defines the interface for cache implementations.
 // It provides methods for caching and retrieving tree structures.
+// Entries are namespaced per tree: a provider reads and writes the entries
+// of DefaultTreeID, and ForTree returns views for other trees.
 type CacheProvider interface {
 	// GetPaginatedTree retrieves the paginated tree from cache if available.
 	// Parameters:
@@ -46,10 +56,35 @@ type CacheProvider interface {
 	//   - response: The paginated tree response to cache
 	SetPaginatedTree(page, pageSize int, response *PaginatedTreeResponse)
 
-	// InvalidateCache removes all cached data.
+	// GetCursorTree retrieves a cursor-paginated tree page from cache if available.
+	// Parameters:
+	//   - cursor: The opaque cursor the page was requested with ("" for the first page)
+	//   - pageSize: The size of each page
+	// Returns:
+	//   - The paginated tree response
+	//   - A boolean indicating whether the response was found in cache
+	GetCursorTree(cursor string, pageSize int) (*PaginatedTreeResponse, bool)
+
+	// SetCursorTree stores a cursor-paginated tree page in cache.
+	// Parameters:
+	//   - cursor: The opaque cursor the page was requested with ("" for the first page)
+	//   - pageSize: The size of each page
+	//   - response: The paginated tree response to cache
+	SetCursorTree(cursor string, pageSize int, response *PaginatedTreeResponse)
+
+	// InvalidateCache removes all cached data of the provider's tree,
+	// leaving other trees' entries in place.
 	// This is typically called when the tree structure is modified.
 	InvalidateCache()
 
+	// ForTree returns a view of the cache namespaced to the given tree.
+	// Views share the provider's storage and need no initialization.
+	// Parameters:
+	//   - treeID: The ID of the tree whose entries the view holds
+	// Returns:
+	//   - The tree-scoped cache provider
+	ForTree(treeID int64) CacheProvider
+
 	// SetCacheTTL sets the cache time-to-live duration.
 	// Parameters:
 	//   - ttl: The duration after which cached data should expire
@@ -91,13 +126,34 @@ func SetPaginatedTree(page, pageSize int, response *PaginatedTreeResponse) {
 	provider.SetPaginatedTree(page, pageSize, response)
 }
 
-// InvalidateCache removes all cached data
+// GetCursorTree retrieves a cursor-paginated tree page from cache if available
+func GetCursorTree(cursor string, pageSize int) (*PaginatedTreeResponse, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	return provider.GetCursorTree(cursor, pageSize)
+}
+
+// SetCursorTree stores a cursor-paginated tree page in cache
+func SetCursorTree(cursor string, pageSize int, response *PaginatedTreeResponse) {
+	mu.Lock()
+	defer mu.Unlock()
+	provider.SetCursorTree(cursor, pageSize, response)
+}
+
+// InvalidateCache removes all cached data of the default tree
 func InvalidateCache() {
 	mu.Lock()
 	defer mu.Unlock()
 	provider.InvalidateCache()
 }
 
+// ForTree returns a view of the cache namespaced to the given tree
+func ForTree(treeID int64) CacheProvider {
+	mu.RLock()
+	defer mu.RUnlock()
+	return provider.ForTree(treeID)
+}
+
 // SetCacheTTL sets the cache time-to-live duration
 func SetCacheTTL(ttl time.Duration) {
 	mu.Lock()
@@ -2,12 +2,19 @@ package cache
 
 import (
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 )
 
 // MemoryCache implements CacheProvider using in-memory storage
 type MemoryCache struct {
+	*memoryStore
+	treeID int64
+}
+
+// memoryStore holds the entries shared by a MemoryCache and its tree views
+type memoryStore struct {
 	mu       sync.RWMutex
 	data     map[string]*PaginatedTreeResponse
 	ttl      time.Duration
@@ -17,28 +24,65 @@ type MemoryCache struct {
 // NewMemoryCache creates a new in-memory cache provider
 func NewMemoryCache() *MemoryCache {
 	return &MemoryCache{
-		ttl:      5 * time.Minute,
-		data:     make(map[string]*PaginatedTreeResponse),
-		expiries: make(map[string]time.Time),
+		memoryStore: &memoryStore{
+			ttl:      5 * time.Minute,
+			data:     make(map[string]*PaginatedTreeResponse),
+			expiries: make(map[string]time.Time),
+		},
+		treeID: DefaultTreeID,
 	}
 }
 
+// ForTree returns a view of the cache namespaced to the given tree
+func (c *MemoryCache) ForTree(treeID int64) CacheProvider {
+	return &MemoryCache{memoryStore: c.memoryStore, treeID: treeID}
+}
+
 // Initialize performs any necessary setup for the cache provider
 func (c *MemoryCache) Initialize() error {
 	return nil
 }
 
-// getCacheKey generates a cache key for the given page and pageSize
-func getCacheKey(page, pageSize int) string {
-	return fmt.Sprintf("tree:%d:%d", page, pageSize)
+// getTreePrefix returns the prefix shared by every cache key of a tree
+func getTreePrefix(treeID int64) string {
+	return fmt.Sprintf("tree:%d:", treeID)
+}
+
+// getCacheKey generates a cache key for the given tree, page and pageSize
+func getCacheKey(treeID int64, page, pageSize int) string {
+	return fmt.Sprintf("%spage:%d:%d", getTreePrefix(treeID), page, pageSize)
+}
+
+// getCursorCacheKey generates a cache key for the given tree, cursor and pageSize
+func getCursorCacheKey(treeID int64, cursor string, pageSize int) string {
+	return fmt.Sprintf("%scursor:%s:%d", getTreePrefix(treeID), cursor, pageSize)
 }
 
 // GetPaginatedTree retrieves the paginated tree from cache if available
 func (c *MemoryCache) GetPaginatedTree(page, pageSize int) (*PaginatedTreeResponse, bool) {
+	return c.get(getCacheKey(c.treeID, page, pageSize))
+}
+
+// SetPaginatedTree stores the paginated tree in cache
+func (c *MemoryCache) SetPaginatedTree(page, pageSize int, response *PaginatedTreeResponse) {
+	c.set(getCacheKey(c.treeID, page, pageSize), response)
+}
+
+// GetCursorTree retrieves a cursor-paginated tree page from cache if available
+func (c *MemoryCache) GetCursorTree(cursor string, pageSize int) (*PaginatedTreeResponse, bool) {
+	return c.get(getCursorCacheKey(c.treeID, cursor, pageSize))
+}
+
+// SetCursorTree stores a cursor-paginated tree page in cache
+func (c *MemoryCache) SetCursorTree(cursor string, pageSize int, response *PaginatedTreeResponse) {
+	c.set(getCursorCacheKey(c.treeID, cursor, pageSize), response)
+}
+
+// get returns the unexpired response stored under key
+func (c *MemoryCache) get(key string) (*PaginatedTreeResponse, bool) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	key := getCacheKey(page, pageSize)
 	expiry, exists := c.expiries[key]
 	if !exists || time.Now().After(expiry) {
 		return nil, false
@@ -51,23 +95,27 @@ func (c *MemoryCache) GetPaginatedTree(page, pageSize int) (*PaginatedTreeRespon
 	return nil, false
 }
 
-// SetPaginatedTree stores the paginated tree in cache
-func (c *MemoryCache) SetPaginatedTree(page, pageSize int, response *PaginatedTreeResponse) {
+// set stores response under key until the TTL expires
+func (c *MemoryCache) set(key string, response *PaginatedTreeResponse) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	key := getCacheKey(page, pageSize)
 	c.data[key] = response
 	c.expiries[key] = time.Now().Add(c.ttl)
 }
 
-// InvalidateCache removes all cached data
+// InvalidateCache removes all cached data of the cache's tree
 func (c *MemoryCache) InvalidateCache() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
-	c.data = make(map[string]*PaginatedTreeResponse)
-	c.expiries = make(map[string]time.Time)
+	prefix := getTreePrefix(c.treeID)
+	for key := range c.data {
+		if strings.HasPrefix(key, prefix) {
+			delete(c.data, key)
+			delete(c.expiries, key)
+		}
+	}
 }
 
 // SetCacheTTL sets the cache time-to-live duration
@@ -14,6 +14,7 @@ import (
 type RedisCache struct {
 	client *redis.Client
 	ttl    time.Duration
+	treeID int64
 }
 
 // NewRedisCache creates a new Redis cache provider
@@ -36,9 +37,16 @@ func NewRedisCache() *RedisCache {
 	return &RedisCache{
 		client: client,
 		ttl:    5 * time.Minute,
+		treeID: DefaultTreeID,
 	}
 }
 
+// ForTree returns a view of the cache namespaced to the given tree. The view
+// shares the Redis connection and copies the current TTL.
+func (c *RedisCache) ForTree(treeID int64) CacheProvider {
+	return &RedisCache{client: c.client, ttl: c.ttl, treeID: treeID}
+}
+
 // Initialize performs any necessary setup for the cache provider
 func (c *RedisCache) Initialize() error {
 	ctx := context.Background()
@@ -46,15 +54,39 @@ func (c *RedisCache) Initialize() error {
 	return err
 }
 
-// getRedisKey generates a cache key for the given page and pageSize
-func getRedisKey(page, pageSize int) string {
-	return fmt.Sprintf("tree:%d:%d", page, pageSize)
+// getRedisKey generates a cache key for the given tree, page and pageSize
+func getRedisKey(treeID int64, page, pageSize int) string {
+	return getCacheKey(treeID, page, pageSize)
+}
+
+// getRedisCursorKey generates a cache key for the given tree, cursor and pageSize
+func getRedisCursorKey(treeID int64, cursor string, pageSize int) string {
+	return getCursorCacheKey(treeID, cursor, pageSize)
 }
 
 // GetPaginatedTree retrieves the paginated tree from cache if available
 func (c *RedisCache) GetPaginatedTree(page, pageSize int) (*PaginatedTreeResponse, bool) {
+	return c.get(getRedisKey(c.treeID, page, pageSize))
+}
+
+// SetPaginatedTree stores the paginated tree in cache
+func (c *RedisCache) SetPaginatedTree(page, pageSize int, response *PaginatedTreeResponse) {
+	c.set(getRedisKey(c.treeID, page, pageSize), response)
+}
+
+// GetCursorTree retrieves a cursor-paginated tree page from cache if available
+func (c *RedisCache) GetCursorTree(cursor string, pageSize int) (*PaginatedTreeResponse, bool) {
+	return c.get(getRedisCursorKey(c.treeID, cursor, pageSize))
+}
+
+// SetCursorTree stores a cursor-paginated tree page in cache
+func (c *RedisCache) SetCursorTree(cursor string, pageSize int, response *PaginatedTreeResponse) {
+	c.set(getRedisCursorKey(c.treeID, cursor, pageSize), response)
+}
+
+// get loads and decodes the response stored under key
+func (c *RedisCache) get(key string) (*PaginatedTreeResponse, bool) {
 	ctx := context.Background()
-	key := getRedisKey(page, pageSize)
 
 	data, err := c.client.Get(ctx, key).Result()
 	if err != nil {
@@ -69,10 +101,9 @@ func (c *RedisCache) GetPaginatedTree(page, pageSize int) (*PaginatedTreeRespons
 	return &response, true
 }
 
-// SetPaginatedTree stores the paginated tree in cache
-func (c *RedisCache) SetPaginatedTree(page, pageSize int, response *PaginatedTreeResponse) {
+// set encodes and stores response under key with the configured TTL
+func (c *RedisCache) set(key string, response *PaginatedTreeResponse) {
 	ctx := context.Background()
-	key := getRedisKey(page, pageSize)
 
 	data, err := json.Marshal(response)
 	if err != nil {
@@ -82,15 +113,15 @@ func (c *RedisCache) SetPaginatedTree(page, pageSize int, response *PaginatedTre
 	c.client.Set(ctx, key, data, c.ttl)
 }
 
-// InvalidateCache removes all cached data
+// InvalidateCache removes all cached data of the cache's tree
 func (c *RedisCache) InvalidateCache() {
 	ctx := context.Background()
-	// Use scan to find and delete all tree:* keys
+	// Use scan to find and delete all of the tree's keys
 	var cursor uint64
 	for {
 		var keys []string
 		var err error
-		keys, cursor, err = c.client.Scan(ctx, cursor, "tree:*", 100).Result()
+		keys, cursor, err = c.client.Scan(ctx, cursor, getTreePrefix(c.treeID)+"*", 100).Result()
 		if err != nil {
 			return
 		}
@@ -19,7 +19,7 @@ func main() {
 	}
 
 	// Initialize repository
-	repo, err := repository.NewPostgresRepository(cfgProvider)
+	repo, err := repository.New(cfgProvider)
 	if err != nil {
 		log.Fatalf("Failed to create repository: %v", err)
 	}
@@ -446,3 +446,351 @@ func GetDatabaseConfig(ctx context.Context, provider Provider) (*DatabaseConfig,
 
 	return cfg, nil
 }
+
+// Default limits applied to bulk tree imports
+const (
+	DefaultImportMaxNodes = 1000
+	DefaultImportMaxDepth = 32
+)
+
+// ImportConfig holds the limits applied to bulk tree imports
+type ImportConfig struct {
+	MaxNodes int
+	MaxDepth int
+}
+
+// GetImportConfig retrieves the bulk import limits using the provided config
+// provider. IMPORT_MAX_NODES and IMPORT_MAX_DEPTH are optional and fall back
+// to the defaults when unset.
+func GetImportConfig(ctx context.Context, provider Provider) (*ImportConfig, error) {
+	cfg := &ImportConfig{
+		MaxNodes: DefaultImportMaxNodes,
+		MaxDepth: DefaultImportMaxDepth,
+	}
+
+	if value, err := provider.GetString(ctx, "IMPORT_MAX_NODES"); err == nil {
+		maxNodes, err := strconv.Atoi(value)
+		if err != nil || maxNodes <= 0 {
+			return nil, &ValidationError{Field: "IMPORT_MAX_NODES", Message: "must be a positive number"}
+		}
+		cfg.MaxNodes = maxNodes
+	}
+
+	if value, err := provider.GetString(ctx, "IMPORT_MAX_DEPTH"); err == nil {
+		maxDepth, err := strconv.Atoi(value)
+		if err != nil || maxDepth <= 0 {
+			return nil, &ValidationError{Field: "IMPORT_MAX_DEPTH", Message: "must be a positive number"}
+		}
+		cfg.MaxDepth = maxDepth
+	}
+
+	return cfg, nil
+}
+
+// DefaultBatchMaxOperations is the default limit on the number of operations
+// in a single POST /api/batch request
+const DefaultBatchMaxOperations = 1000
+
+// BatchConfig holds the limits applied to batch requests
+type BatchConfig struct {
+	MaxOperations int
+}
+
+// GetBatchConfig retrieves the batch request limits using the provided
+// config provider. BATCH_MAX_OPERATIONS is optional and falls back to the
+// default.
+func GetBatchConfig(ctx context.Context, provider Provider) (*BatchConfig, error) {
+	cfg := &BatchConfig{
+		MaxOperations: DefaultBatchMaxOperations,
+	}
+
+	if value, err := provider.GetString(ctx, "BATCH_MAX_OPERATIONS"); err == nil {
+		maxOperations, err := strconv.Atoi(value)
+		if err != nil || maxOperations <= 0 {
+			return nil, &ValidationError{Field: "BATCH_MAX_OPERATIONS", Message: "must be a positive number"}
+		}
+		cfg.MaxOperations = maxOperations
+	}
+
+	return cfg, nil
+}
+
+// DefaultAttributesMaxBytes is the default limit on the encoded size of a
+// node's attributes
+const DefaultAttributesMaxBytes = 4096
+
+// AttributesConfig holds the limits applied to node attributes
+type AttributesConfig struct {
+	MaxBytes   int
+	KeyPattern *regexp.Regexp
+}
+
+// GetAttributesConfig retrieves the node attribute limits using the provided
+// config provider. ATTRIBUTES_MAX_BYTES is optional and falls back to the
+// default; ATTRIBUTES_KEY_PATTERN is an optional regular expression every
+// attribute key must match.
+func GetAttributesConfig(ctx context.Context, provider Provider) (*AttributesConfig, error) {
+	cfg := &AttributesConfig{
+		MaxBytes: DefaultAttributesMaxBytes,
+	}
+
+	if value, err := provider.GetString(ctx, "ATTRIBUTES_MAX_BYTES"); err == nil {
+		maxBytes, err := strconv.Atoi(value)
+		if err != nil || maxBytes <= 0 {
+			return nil, &ValidationError{Field: "ATTRIBUTES_MAX_BYTES", Message: "must be a positive number"}
+		}
+		cfg.MaxBytes = maxBytes
+	}
+
+	if value, err := provider.GetString(ctx, "ATTRIBUTES_KEY_PATTERN"); err == nil {
+		pattern, err := regexp.Compile(value)
+		if err != nil {
+			return nil, &ValidationError{Field: "ATTRIBUTES_KEY_PATTERN", Message: "must be a valid regular expression"}
+		}
+		cfg.KeyPattern = pattern
+	}
+
+	return cfg, nil
+}
+
+// Defaults for purging the trash
+const (
+	DefaultTrashRetention     = 30 * 24 * time.Hour
+	DefaultTrashPurgeInterval = time.Hour
+)
+
+// TrashConfig holds the settings of the background job that purges trashed
+// nodes
+type TrashConfig struct {
+	// Retention is how long deleted nodes stay restorable
+	Retention time.Duration
+	// PurgeInterval is how often the purge job runs
+	PurgeInterval time.Duration
+}
+
+// GetTrashConfig retrieves the trash purge settings using the provided config
+// provider. TRASH_RETENTION and TRASH_PURGE_INTERVAL are optional Go
+// durations (e.g. "720h") and fall back to the defaults when unset.
+func GetTrashConfig(ctx context.Context, provider Provider) (*TrashConfig, error) {
+	cfg := &TrashConfig{
+		Retention:     DefaultTrashRetention,
+		PurgeInterval: DefaultTrashPurgeInterval,
+	}
+
+	if value, err := provider.GetString(ctx, "TRASH_RETENTION"); err == nil {
+		retention, err := time.ParseDuration(value)
+		if err != nil || retention <= 0 {
+			return nil, &ValidationError{Field: "TRASH_RETENTION", Message: "must be a positive duration"}
+		}
+		cfg.Retention = retention
+	}
+
+	if value, err := provider.GetString(ctx, "TRASH_PURGE_INTERVAL"); err == nil {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval <= 0 {
+			return nil, &ValidationError{Field: "TRASH_PURGE_INTERVAL", Message: "must be a positive duration"}
+		}
+		cfg.PurgeInterval = interval
+	}
+
+	return cfg, nil
+}
+
+// DefaultEventsBufferSize is the default number of change events kept for
+// clients resuming GET /api/events
+const DefaultEventsBufferSize = 1000
+
+// EventsConfig holds the settings of the change event feed
+type EventsConfig struct {
+	BufferSize int
+}
+
+// GetEventsConfig retrieves the change event feed settings using the
+// provided config provider. EVENTS_BUFFER_SIZE is optional and falls back
+// to the default.
+func GetEventsConfig(ctx context.Context, provider Provider) (*EventsConfig, error) {
+	cfg := &EventsConfig{
+		BufferSize: DefaultEventsBufferSize,
+	}
+
+	if value, err := provider.GetString(ctx, "EVENTS_BUFFER_SIZE"); err == nil {
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			return nil, &ValidationError{Field: "EVENTS_BUFFER_SIZE", Message: "must be a positive number"}
+		}
+		cfg.BufferSize = size
+	}
+
+	return cfg, nil
+}
+
+// Defaults for delivering webhooks
+const (
+	DefaultWebhookMaxAttempts    = 8
+	DefaultWebhookInitialBackoff = 10 * time.Second
+	DefaultWebhookMaxBackoff     = time.Hour
+	DefaultWebhookTimeout        = 10 * time.Second
+	DefaultWebhookPollInterval   = 5 * time.Second
+)
+
+// WebhookConfig holds the settings of the background worker that delivers
+// webhooks
+type WebhookConfig struct {
+	// MaxAttempts is how many times a delivery is attempted before it is
+	// marked dead
+	MaxAttempts int
+	// InitialBackoff is the wait after the first failed attempt; it doubles
+	// with every further failure
+	InitialBackoff time.Duration
+	// MaxBackoff caps the wait between attempts
+	MaxBackoff time.Duration
+	// Timeout bounds a single delivery request
+	Timeout time.Duration
+	// PollInterval is how often the worker looks for due deliveries
+	PollInterval time.Duration
+}
+
+// GetWebhookConfig retrieves the webhook delivery settings using the
+// provided config provider. WEBHOOK_MAX_ATTEMPTS is an optional positive
+// number; WEBHOOK_INITIAL_BACKOFF, WEBHOOK_MAX_BACKOFF, WEBHOOK_TIMEOUT and
+// WEBHOOK_POLL_INTERVAL are optional Go durations. Unset values fall back to
+// the defaults.
+func GetWebhookConfig(ctx context.Context, provider Provider) (*WebhookConfig, error) {
+	cfg := &WebhookConfig{
+		MaxAttempts:    DefaultWebhookMaxAttempts,
+		InitialBackoff: DefaultWebhookInitialBackoff,
+		MaxBackoff:     DefaultWebhookMaxBackoff,
+		Timeout:        DefaultWebhookTimeout,
+		PollInterval:   DefaultWebhookPollInterval,
+	}
+
+	if value, err := provider.GetString(ctx, "WEBHOOK_MAX_ATTEMPTS"); err == nil {
+		attempts, err := strconv.Atoi(value)
+		if err != nil || attempts <= 0 {
+			return nil, &ValidationError{Field: "WEBHOOK_MAX_ATTEMPTS", Message: "must be a positive number"}
+		}
+		cfg.MaxAttempts = attempts
+	}
+
+	durations := []struct {
+		key    string
+		target *time.Duration
+	}{
+		{"WEBHOOK_INITIAL_BACKOFF", &cfg.InitialBackoff},
+		{"WEBHOOK_MAX_BACKOFF", &cfg.MaxBackoff},
+		{"WEBHOOK_TIMEOUT", &cfg.Timeout},
+		{"WEBHOOK_POLL_INTERVAL", &cfg.PollInterval},
+	}
+	for _, d := range durations {
+		if value, err := provider.GetString(ctx, d.key); err == nil {
+			duration, err := time.ParseDuration(value)
+			if err != nil || duration <= 0 {
+				return nil, &ValidationError{Field: d.key, Message: "must be a positive duration"}
+			}
+			*d.target = duration
+		}
+	}
+
+	if cfg.MaxBackoff < cfg.InitialBackoff {
+		return nil, &ValidationError{Field: "WEBHOOK_MAX_BACKOFF", Message: "must not be less than WEBHOOK_INITIAL_BACKOFF"}
+	}
+
+	return cfg, nil
+}
+
+// Databases the service can store its trees in
+const (
+	// DriverPostgres stores the trees in PostgreSQL
+	DriverPostgres = "postgres"
+	// DriverSQLite stores the trees in an embedded SQLite database, for
+	// local development
+	DriverSQLite = "sqlite"
+	// DriverMemory keeps the trees in memory, optionally snapshotted to a
+	// JSON file, for demos
+	DriverMemory = "memory"
+)
+
+// DefaultSQLitePath is the SQLite database file used when SQLITE_PATH is unset
+const DefaultSQLitePath = "tree_service.db"
+
+// DefaultSnapshotInterval is how often the in-memory repository writes its
+// snapshot when MEMORY_SNAPSHOT_INTERVAL is unset
+const DefaultSnapshotInterval = 30 * time.Second
+
+// Structures a Postgres-backed repository can answer hierarchy queries
+// from. The migrations create both and their triggers keep both up to date
+// on every write, whichever one is selected.
+const (
+	// HierarchyPath uses a materialized path on every node
+	HierarchyPath = "path"
+	// HierarchyClosure uses a closure table of every ancestor-descendant pair
+	HierarchyClosure = "closure"
+)
+
+// RepositoryConfig selects the Repository implementation the service runs on
+type RepositoryConfig struct {
+	// Driver is the database the trees are stored in
+	Driver string
+	// Hierarchy is the structure a Postgres database answers hierarchy
+	// queries from
+	Hierarchy string
+	// SQLitePath is the file a SQLite database is stored in, or ":memory:"
+	SQLitePath string
+	// SnapshotPath is the JSON file the in-memory repository is persisted
+	// to, or empty to keep nothing
+	SnapshotPath string
+	// SnapshotInterval is how often the in-memory repository is persisted,
+	// or 0 to persist it only on shutdown
+	SnapshotInterval time.Duration
+}
+
+// GetRepositoryConfig retrieves the repository selection using the provided
+// config provider. DB_DRIVER is optional, one of "postgres" (the default),
+// "sqlite" and "memory". DB_HIERARCHY is optional, one of "path" (the
+// default) and "closure". SQLITE_PATH is optional and falls back to the
+// default. MEMORY_SNAPSHOT_PATH is optional; MEMORY_SNAPSHOT_INTERVAL is an
+// optional Go duration (e.g. "1m") that falls back to the default.
+func GetRepositoryConfig(ctx context.Context, provider Provider) (*RepositoryConfig, error) {
+	cfg := &RepositoryConfig{
+		Driver:           DriverPostgres,
+		Hierarchy:        HierarchyPath,
+		SQLitePath:       DefaultSQLitePath,
+		SnapshotInterval: DefaultSnapshotInterval,
+	}
+
+	if value, err := provider.GetString(ctx, "DB_DRIVER"); err == nil {
+		switch value {
+		case DriverPostgres, DriverSQLite, DriverMemory:
+			cfg.Driver = value
+		default:
+			return nil, &ValidationError{Field: "DB_DRIVER", Message: "must be one of postgres, sqlite, memory"}
+		}
+	}
+
+	if value, err := provider.GetString(ctx, "DB_HIERARCHY"); err == nil {
+		switch value {
+		case HierarchyPath, HierarchyClosure:
+			cfg.Hierarchy = value
+		default:
+			return nil, &ValidationError{Field: "DB_HIERARCHY", Message: "must be one of path, closure"}
+		}
+	}
+
+	if value, err := provider.GetString(ctx, "SQLITE_PATH"); err == nil {
+		cfg.SQLitePath = value
+	}
+
+	if value, err := provider.GetString(ctx, "MEMORY_SNAPSHOT_PATH"); err == nil {
+		cfg.SnapshotPath = value
+	}
+
+	if value, err := provider.GetString(ctx, "MEMORY_SNAPSHOT_INTERVAL"); err == nil {
+		interval, err := time.ParseDuration(value)
+		if err != nil || interval < 0 {
+			return nil, &ValidationError{Field: "MEMORY_SNAPSHOT_INTERVAL", Message: "must be a non-negative duration"}
+		}
+		cfg.SnapshotInterval = interval
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,246 @@
+package export
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/ammiranda/tree_service/models"
+)
+
+// Format identifies an export output format
+type Format string
+
+const (
+	// JSON writes a nested JSON array of models.Node
+	JSON Format = "json"
+	// CSV writes one row per node with id, parent_id, label, depth and path columns
+	CSV Format = "csv"
+	// DOT writes a Graphviz digraph
+	DOT Format = "dot"
+	// Mermaid writes a Mermaid flowchart
+	Mermaid Format = "mermaid"
+)
+
+// ErrUnknownFormat is returned when an export format is not supported
+var ErrUnknownFormat = errors.New("unknown export format")
+
+// ParseFormat returns the Format with the given name
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(strings.ToLower(name)); f {
+	case JSON, CSV, DOT, Mermaid:
+		return f, nil
+	}
+	return "", ErrUnknownFormat
+}
+
+// ContentType returns the MIME type of the format
+func (f Format) ContentType() string {
+	switch f {
+	case JSON:
+		return "application/json"
+	case CSV:
+		return "text/csv; charset=utf-8"
+	case DOT:
+		return "text/vnd.graphviz; charset=utf-8"
+	default:
+		return "text/plain; charset=utf-8"
+	}
+}
+
+// Extension returns the file extension conventionally used for the format
+func (f Format) Extension() string {
+	switch f {
+	case DOT:
+		return "dot"
+	case Mermaid:
+		return "mmd"
+	default:
+		return string(f)
+	}
+}
+
+// Writer streams trees to an underlying io.Writer one root at a time, so an
+// export never has to hold the whole forest in memory
+type Writer interface {
+	// WriteTree writes a root node and all of its descendants
+	WriteTree(root *models.Node) error
+	// Close writes any trailing output; it does not close the underlying writer
+	Close() error
+}
+
+// NewWriter creates a Writer for the given format and writes its header
+func NewWriter(w io.Writer, format Format) (Writer, error) {
+	switch format {
+	case JSON:
+		return newJSONWriter(w)
+	case CSV:
+		return newCSVWriter(w)
+	case DOT:
+		return newDOTWriter(w)
+	case Mermaid:
+		return newMermaidWriter(w)
+	}
+	return nil, ErrUnknownFormat
+}
+
+// jsonWriter writes a JSON array of nested nodes
+type jsonWriter struct {
+	w     io.Writer
+	enc   *json.Encoder
+	count int
+}
+
+func newJSONWriter(w io.Writer) (*jsonWriter, error) {
+	if _, err := io.WriteString(w, "["); err != nil {
+		return nil, err
+	}
+	return &jsonWriter{w: w, enc: json.NewEncoder(w)}, nil
+}
+
+func (j *jsonWriter) WriteTree(root *models.Node) error {
+	if j.count > 0 {
+		if _, err := io.WriteString(j.w, ","); err != nil {
+			return err
+		}
+	}
+	j.count++
+	return j.enc.Encode(root)
+}
+
+func (j *jsonWriter) Close() error {
+	_, err := io.WriteString(j.w, "]\n")
+	return err
+}
+
+// csvWriter writes one row per node. Depth and path are relative to the
+// exported root, and path is the slash-separated chain of IDs leading to the node.
+type csvWriter struct {
+	w *csv.Writer
+}
+
+func newCSVWriter(w io.Writer) (*csvWriter, error) {
+	c := &csvWriter{w: csv.NewWriter(w)}
+	if err := c.w.Write([]string{"id", "parent_id", "label", "depth", "path"}); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *csvWriter) WriteTree(root *models.Node) error {
+	var walk func(node *models.Node, depth int, path string) error
+	walk = func(node *models.Node, depth int, path string) error {
+		path = path + "/" + strconv.FormatInt(node.ID, 10)
+		parentID := ""
+		if node.ParentID != nil {
+			parentID = strconv.FormatInt(*node.ParentID, 10)
+		}
+		record := []string{strconv.FormatInt(node.ID, 10), parentID, node.Label, strconv.Itoa(depth), path}
+		if err := c.w.Write(record); err != nil {
+			return err
+		}
+		for _, child := range node.Children {
+			if err := walk(child, depth+1, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := walk(root, 0, ""); err != nil {
+		return err
+	}
+	c.w.Flush()
+	return c.w.Error()
+}
+
+func (c *csvWriter) Close() error {
+	c.w.Flush()
+	return c.w.Error()
+}
+
+// dotWriter writes a Graphviz digraph
+type dotWriter struct {
+	w io.Writer
+}
+
+func newDOTWriter(w io.Writer) (*dotWriter, error) {
+	if _, err := io.WriteString(w, "digraph tree {\n"); err != nil {
+		return nil, err
+	}
+	return &dotWriter{w: w}, nil
+}
+
+func (d *dotWriter) WriteTree(root *models.Node) error {
+	return walkEdges(root, func(node *models.Node) error {
+		_, err := fmt.Fprintf(d.w, "  n%d [label=%s];\n", node.ID, quoteDOT(node.Label))
+		return err
+	}, func(parent, child *models.Node) error {
+		_, err := fmt.Fprintf(d.w, "  n%d -> n%d;\n", parent.ID, child.ID)
+		return err
+	})
+}
+
+func (d *dotWriter) Close() error {
+	_, err := io.WriteString(d.w, "}\n")
+	return err
+}
+
+// quoteDOT returns label as a quoted DOT string
+func quoteDOT(label string) string {
+	replacer := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	return `"` + replacer.Replace(label) + `"`
+}
+
+// mermaidWriter writes a top-down Mermaid flowchart
+type mermaidWriter struct {
+	w io.Writer
+}
+
+func newMermaidWriter(w io.Writer) (*mermaidWriter, error) {
+	if _, err := io.WriteString(w, "flowchart TD\n"); err != nil {
+		return nil, err
+	}
+	return &mermaidWriter{w: w}, nil
+}
+
+func (m *mermaidWriter) WriteTree(root *models.Node) error {
+	return walkEdges(root, func(node *models.Node) error {
+		_, err := fmt.Fprintf(m.w, "  n%d[%s]\n", node.ID, quoteMermaid(node.Label))
+		return err
+	}, func(parent, child *models.Node) error {
+		_, err := fmt.Fprintf(m.w, "  n%d --> n%d\n", parent.ID, child.ID)
+		return err
+	})
+}
+
+func (m *mermaidWriter) Close() error {
+	return nil
+}
+
+// quoteMermaid returns label as a quoted Mermaid node text, using Mermaid's
+// entity codes for characters that would end the string
+func quoteMermaid(label string) string {
+	replacer := strings.NewReplacer(`"`, "#quot;", "\n", " ")
+	return `"` + replacer.Replace(label) + `"`
+}
+
+// walkEdges visits every node of a tree depth-first, calling node for each
+// node and edge for each parent/child pair
+func walkEdges(root *models.Node, node func(*models.Node) error, edge func(parent, child *models.Node) error) error {
+	if err := node(root); err != nil {
+		return err
+	}
+	for _, child := range root.Children {
+		if err := edge(root, child); err != nil {
+			return err
+		}
+		if err := walkEdges(child, node, edge); err != nil {
+			return err
+		}
+	}
+	return nil
+}
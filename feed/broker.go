@@ -0,0 +1,166 @@
+package feed
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ammiranda/tree_service/models"
+)
+
+// Event types published for node changes
+const (
+	NodeCreated  = "node.created"
+	NodeUpdated  = "node.updated"
+	NodeMoved    = "node.moved"
+	NodeDeleted  = "node.deleted"
+	NodeRestored = "node.restored"
+)
+
+// IsEventType reports whether t is one of the event types above
+func IsEventType(t string) bool {
+	switch t {
+	case NodeCreated, NodeUpdated, NodeMoved, NodeDeleted, NodeRestored:
+		return true
+	}
+	return false
+}
+
+// subscriberBuffer is how many events a subscriber may fall behind before it
+// is dropped. Dropped subscribers can resume from the broker's buffer.
+const subscriberBuffer = 64
+
+// Event is a change to a node
+type Event struct {
+	ID     int64        `json:"id"`
+	Type   string       `json:"type"`
+	TreeID int64        `json:"treeId"`
+	NodeID int64        `json:"nodeId"`
+	Node   *models.Node `json:"node,omitempty"`
+	// Path holds the IDs from the root of the node's tree down to the node
+	// itself, after the change or, for deletes, before it
+	Path []int64 `json:"path"`
+	// PreviousPath is the node's path before a change of parent
+	PreviousPath []int64   `json:"previousPath,omitempty"`
+	Time         time.Time `json:"time"`
+}
+
+// InSubtree reports whether the event concerns the subtree below rootID,
+// before or after the change
+func (e *Event) InSubtree(rootID int64) bool {
+	for _, id := range e.Path {
+		if id == rootID {
+			return true
+		}
+	}
+	for _, id := range e.PreviousPath {
+		if id == rootID {
+			return true
+		}
+	}
+	return false
+}
+
+// Broker fans published events out to subscribers and keeps the most recent
+// ones so that subscribers can resume after a disconnect
+type Broker struct {
+	mu          sync.Mutex
+	buffer      []Event
+	size        int
+	lastID      int64
+	subscribers map[*Subscription]struct{}
+}
+
+// NewBroker creates a broker that keeps the last size events
+func NewBroker(size int) *Broker {
+	return &Broker{
+		buffer:      make([]Event, 0, size),
+		size:        size,
+		subscribers: make(map[*Subscription]struct{}),
+	}
+}
+
+// Publish assigns the event the next ID and the current time, buffers it
+// and delivers it to every subscriber. Subscribers that have fallen too far
+// behind are dropped: their channel is closed.
+func (b *Broker) Publish(event Event) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.lastID++
+	event.ID = b.lastID
+	event.Time = time.Now()
+
+	if len(b.buffer) == b.size {
+		copy(b.buffer, b.buffer[1:])
+		b.buffer = b.buffer[:len(b.buffer)-1]
+	}
+	b.buffer = append(b.buffer, event)
+
+	for sub := range b.subscribers {
+		select {
+		case sub.events <- event:
+		default:
+			delete(b.subscribers, sub)
+			close(sub.events)
+		}
+	}
+	return event
+}
+
+// Subscription receives the events published after it was created
+type Subscription struct {
+	// Backlog holds the buffered events after the ID the subscription
+	// resumed from, oldest first
+	Backlog []Event
+	// Missed is set when the subscription resumed from an ID whose
+	// successors are no longer all buffered, e.g. because they were evicted
+	// or the ID is from before a restart
+	Missed bool
+
+	events chan Event
+	broker *Broker
+}
+
+// Subscribe registers a new subscription. With resume set, the events
+// buffered after lastEventID are returned as its backlog.
+func (b *Broker) Subscribe(lastEventID int64, resume bool) *Subscription {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	sub := &Subscription{
+		events: make(chan Event, subscriberBuffer),
+		broker: b,
+	}
+	if resume {
+		oldestID := b.lastID + 1
+		if len(b.buffer) > 0 {
+			oldestID = b.buffer[0].ID
+		}
+		sub.Missed = lastEventID < oldestID-1 || lastEventID > b.lastID
+		for _, event := range b.buffer {
+			if event.ID > lastEventID {
+				sub.Backlog = append(sub.Backlog, event)
+			}
+		}
+	}
+	b.subscribers[sub] = struct{}{}
+	return sub
+}
+
+// Events returns the channel the subscription's events are delivered on.
+// It is closed when the subscription is dropped for falling behind or
+// closed.
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Close unregisters the subscription
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+
+	if _, ok := s.broker.subscribers[s]; ok {
+		delete(s.broker.subscribers, s)
+		close(s.events)
+	}
+}
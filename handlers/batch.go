@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/ammiranda/tree_service/feed"
+	"github.com/ammiranda/tree_service/models"
+	"github.com/ammiranda/tree_service/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ApplyBatch applies an ordered list of create, update, move and delete
+// operations in one transaction: either all of them take effect or none
+// does. Later operations can refer to nodes created earlier in the batch by
+// the tempId of their create operation, passed as a string wherever a node
+// ID is expected.
+//
+// On success the response lists the result of every operation and the IDs
+// the temp IDs were created with. If an operation is invalid or fails, the
+// response has the status of the failure, e.g. 404 for a missing node, and
+// the index of the operation.
+func (h *TreeHandler) ApplyBatch(c *gin.Context) {
+	var req models.BatchRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate the request
+	if err := req.Validate(h.batchMaxOps); err != nil {
+		var opErr *models.BatchOperationError
+		if errors.As(err, &opErr) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": opErr.Err.Error(), "index": opErr.Index})
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	for i, op := range req.Operations {
+		if err := h.attributeLimits.Validate(op.Attributes); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error(), "index": i})
+			return
+		}
+	}
+
+	// Read the existing nodes the batch changes first so the events can say
+	// where they were, including the children reparent deletes move
+	type nodeBefore struct {
+		path     []int64
+		node     *models.Node
+		children []int64
+	}
+	before := make(map[int64]nodeBefore)
+	ops := make([]*repository.BatchOp, 0, len(req.Operations))
+	for _, op := range req.Operations {
+		batchOp := toBatchOp(op)
+		if id := batchOp.Node.ID; id != 0 {
+			previous, ok := before[id]
+			if !ok {
+				previous.path, previous.node = h.nodeState(c, id)
+			}
+			if batchOp.Type == repository.BatchDelete && batchOp.Mode == repository.DeleteModeReparent && previous.node != nil {
+				if subtree, err := h.repoFor(c).GetSubtree(c.Request.Context(), id, 1); err == nil {
+					for _, child := range subtree[1:] {
+						previous.children = append(previous.children, child.ID)
+					}
+				}
+			}
+			before[id] = previous
+		}
+		ops = append(ops, batchOp)
+	}
+
+	results, err := h.repoFor(c).ApplyBatch(c.Request.Context(), ops)
+	if err != nil {
+		var batchErr *repository.BatchError
+		if !errors.As(err, &batchErr) {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		status, message := batchErrorResponse(batchErr.Err)
+		c.JSON(status, gin.H{"error": message, "index": batchErr.Index})
+		return
+	}
+
+	// Invalidate cache once for the whole batch
+	treeCache(c).InvalidateCache()
+
+	data := make([]*models.BatchResult, 0, len(results))
+	ids := make(map[string]int64)
+	for i, result := range results {
+		op := req.Operations[i]
+		data = append(data, &models.BatchResult{
+			Index:   i,
+			Op:      op.Op,
+			ID:      result.NodeID,
+			TempID:  op.TempID,
+			Deleted: result.Deleted,
+		})
+		if op.TempID != "" {
+			ids[op.TempID] = result.NodeID
+		}
+
+		switch ops[i].Type {
+		case repository.BatchCreate:
+			h.publishChange(c, feed.NodeCreated, result.NodeID, nil)
+		case repository.BatchUpdate:
+			h.publishChange(c, feed.NodeUpdated, result.NodeID, before[result.NodeID].path)
+		case repository.BatchMove:
+			h.publishChange(c, feed.NodeMoved, result.NodeID, before[result.NodeID].path)
+		case repository.BatchDelete:
+			// Nodes created by the batch itself were never published
+			if previous := before[result.NodeID]; previous.node != nil {
+				for _, childID := range previous.children {
+					h.publishChange(c, feed.NodeMoved, childID, append(append([]int64(nil), previous.path...), childID))
+				}
+				h.publish(c, feed.NodeDeleted, previous.node, previous.path, nil)
+			}
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"results": data,
+		"ids":     ids,
+	})
+}
+
+// toBatchOp converts a validated request operation into a repository one
+func toBatchOp(op *models.BatchOperation) *repository.BatchOp {
+	batchOp := &repository.BatchOp{
+		Type:       repository.BatchOpType(op.Op),
+		TempID:     op.TempID,
+		Label:      op.Label,
+		SetParent:  op.ParentID.Set,
+		Parent:     toBatchRef(op.ParentID.Value),
+		Attributes: op.Attributes,
+		BeforeID:   toBatchRef(op.BeforeID),
+		AfterID:    toBatchRef(op.AfterID),
+		Index:      op.Index,
+		Mode:       repository.DeleteMode(op.Mode),
+		Version:    op.Version,
+	}
+	if op.ID != nil {
+		batchOp.Node = *toBatchRef(op.ID)
+	}
+	if batchOp.Type == repository.BatchDelete && batchOp.Mode == "" {
+		batchOp.Mode = repository.DeleteModeCascade
+	}
+	return batchOp
+}
+
+// toBatchRef converts a request node reference into a repository one
+func toBatchRef(ref *models.NodeRef) *repository.BatchRef {
+	if ref == nil {
+		return nil
+	}
+	return &repository.BatchRef{ID: ref.ID, TempID: ref.TempID}
+}
+
+// batchErrorResponse maps the error a batch operation failed with to a
+// status and message, the same ones the single-node endpoints respond with
+func batchErrorResponse(err error) (int, string) {
+	switch {
+	case errors.Is(err, repository.ErrNodeNotFound):
+		return http.StatusNotFound, "node not found"
+	case errors.Is(err, repository.ErrVersionConflict):
+		return http.StatusPreconditionFailed, versionConflictMessage
+	case errors.Is(err, repository.ErrCycleDetected):
+		return http.StatusConflict, "cannot move a node under itself or one of its descendants"
+	case errors.Is(err, repository.ErrNodeHasChildren):
+		return http.StatusConflict, "node has children"
+	case errors.Is(err, repository.ErrCrossTreeParent):
+		return http.StatusBadRequest, crossTreeParentMessage
+	case errors.Is(err, repository.ErrInvalidInput):
+		return http.StatusBadRequest, err.Error()
+	default:
+		return http.StatusInternalServerError, err.Error()
+	}
+}
@@ -0,0 +1,207 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ammiranda/tree_service/feed"
+	"github.com/ammiranda/tree_service/models"
+	"github.com/ammiranda/tree_service/repository"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// eventsHeartbeat is how often an idle event stream sends a comment, so
+// proxies don't close it
+const eventsHeartbeat = 30 * time.Second
+
+// resetEvent is sent first on a resumed stream when events were missed; the
+// client should reload whatever it keeps in sync
+const resetEvent = "reset"
+
+// WithEventBroker sets the broker node change events are published to
+func WithEventBroker(broker *feed.Broker) Option {
+	return func(h *TreeHandler) {
+		h.events = broker
+	}
+}
+
+// StreamEvents streams the node changes of the request's tree as
+// Server-Sent Events. The optional rootId query parameter limits the stream
+// to changes in that node's subtree. A Last-Event-ID header resumes after
+// that event from the broker's buffer.
+func (h *TreeHandler) StreamEvents(c *gin.Context) {
+	var rootID *int64
+	if rootIDStr := c.Query("rootId"); rootIDStr != "" {
+		id, err := strconv.ParseInt(rootIDStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid root ID"})
+			return
+		}
+		if _, err := h.repoFor(c).GetNode(c.Request.Context(), id); err != nil {
+			if errors.Is(err, repository.ErrNodeNotFound) {
+				c.JSON(http.StatusNotFound, gin.H{"error": "root node not found"})
+				return
+			}
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		rootID = &id
+	}
+
+	var lastEventID int64
+	lastEventIDStr := c.GetHeader("Last-Event-ID")
+	if lastEventIDStr != "" {
+		id, err := strconv.ParseInt(lastEventIDStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid Last-Event-ID"})
+			return
+		}
+		lastEventID = id
+	}
+
+	sub := h.events.Subscribe(lastEventID, lastEventIDStr != "")
+	defer sub.Close()
+
+	c.Header("Content-Type", sse.ContentType)
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	treeID := requestTreeID(c)
+	send := func(event feed.Event) {
+		if event.TreeID != treeID || (rootID != nil && !event.InSubtree(*rootID)) {
+			return
+		}
+		c.Render(-1, sse.Event{
+			Id:    strconv.FormatInt(event.ID, 10),
+			Event: event.Type,
+			Data:  event,
+		})
+	}
+
+	if sub.Missed {
+		c.Render(-1, sse.Event{Event: resetEvent, Data: gin.H{"lastEventId": lastEventID}})
+	}
+	for _, event := range sub.Backlog {
+		send(event)
+	}
+	c.Writer.Flush()
+
+	heartbeat := time.NewTicker(eventsHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case event, ok := <-sub.Events():
+			if !ok {
+				// Dropped for falling behind; the client resumes with Last-Event-ID
+				return
+			}
+			send(event)
+		case <-heartbeat.C:
+			if _, err := c.Writer.WriteString(": heartbeat\n\n"); err != nil {
+				return
+			}
+		}
+		c.Writer.Flush()
+	}
+}
+
+// nodeState returns the path from the root of the request's tree down to
+// node id, and the node itself, for a change event. Both are nil if the node
+// can't be read.
+func (h *TreeHandler) nodeState(c *gin.Context, id int64) ([]int64, *models.Node) {
+	ancestors, err := h.repoFor(c).GetAncestors(c.Request.Context(), id)
+	if err != nil || len(ancestors) == 0 {
+		return nil, nil
+	}
+	path := make([]int64, 0, len(ancestors))
+	for _, ancestor := range ancestors {
+		path = append(path, ancestor.ID)
+	}
+	return path, toModelNode(ancestors[len(ancestors)-1])
+}
+
+// publishChange publishes a change to node id, which has been committed, for
+// the request's tree. previousPath is the node's path before the change, or
+// nil if the change can't have moved it.
+func (h *TreeHandler) publishChange(c *gin.Context, eventType string, id int64, previousPath []int64) {
+	path, node := h.nodeState(c, id)
+	if node == nil {
+		return
+	}
+	if previousPath != nil && equalPaths(previousPath, path) {
+		previousPath = nil
+	}
+	h.publish(c, eventType, node, path, previousPath)
+}
+
+// publishImported publishes a created event for every node of a committed
+// import below rootID, whose IDs are ids, parents before children. The
+// paths are derived from the imported root's, so the whole import is read
+// with two queries.
+func (h *TreeHandler) publishImported(c *gin.Context, rootID int64, ids map[string]int64) {
+	created := make(map[int64]bool, len(ids))
+	for _, id := range ids {
+		created[id] = true
+	}
+
+	rootPath, _ := h.nodeState(c, rootID)
+	if rootPath == nil {
+		return
+	}
+	nodes, err := h.repoFor(c).GetSubtree(c.Request.Context(), rootID, repository.UnlimitedDepth)
+	if err != nil {
+		return
+	}
+
+	// The subtree is ordered by depth, so every parent's path is known
+	// before its children's
+	paths := make(map[int64][]int64, len(nodes))
+	for _, node := range nodes {
+		path := rootPath
+		if node.ID != rootID {
+			parentPath, ok := paths[*node.ParentID]
+			if !ok {
+				continue
+			}
+			path = append(append(make([]int64, 0, len(parentPath)+1), parentPath...), node.ID)
+		}
+		paths[node.ID] = path
+		if created[node.ID] {
+			h.publish(c, feed.NodeCreated, toModelNode(node), path, nil)
+		}
+	}
+}
+
+// publish publishes a committed change to node for the request's tree. The
+// repository queued the change for the tree's webhooks when it committed it.
+func (h *TreeHandler) publish(c *gin.Context, eventType string, node *models.Node, path, previousPath []int64) {
+	h.events.Publish(feed.Event{
+		Type:         eventType,
+		TreeID:       requestTreeID(c),
+		NodeID:       node.ID,
+		Node:         node,
+		Path:         path,
+		PreviousPath: previousPath,
+	})
+}
+
+// equalPaths reports whether two node paths are the same
+func equalPaths(a, b []int64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,143 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/ammiranda/tree_service/export"
+	"github.com/ammiranda/tree_service/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// exportPageSize is the number of trees loaded per query while exporting
+const exportPageSize = 100
+
+// flusher is implemented by writers that can push buffered output to the client
+type flusher interface {
+	Flush()
+}
+
+// ExportTree writes trees from repo to w in the given format. With a rootID
+// only that node's subtree is exported; otherwise every tree is exported,
+// loading one page of trees at a time. Nothing is written to w if the first
+// load fails, so callers can still report the error.
+func ExportTree(ctx context.Context, repo repository.Repository, w io.Writer, format export.Format, rootID *int64) error {
+	var nodes []*repository.Node
+	var hasMore bool
+	var err error
+	if rootID != nil {
+		nodes, err = repo.GetSubtree(ctx, *rootID, repository.UnlimitedDepth)
+	} else {
+		nodes, hasMore, err = repo.GetNodesAfter(ctx, 0, exportPageSize)
+	}
+	if err != nil {
+		return err
+	}
+
+	writer, err := export.NewWriter(w, format)
+	if err != nil {
+		return err
+	}
+
+	for {
+		if len(nodes) > 0 {
+			rootNodes, err := BuildTreeFromNodes(nodes)
+			if err != nil {
+				return err
+			}
+			for _, root := range rootNodes {
+				if err := writer.WriteTree(root); err != nil {
+					return err
+				}
+			}
+			if f, ok := w.(flusher); ok {
+				f.Flush()
+			}
+		}
+
+		if !hasMore || len(nodes) == 0 {
+			break
+		}
+
+		// Seek past the last root of this page
+		lastRootID := int64(0)
+		for _, node := range nodes {
+			if node.ParentID == nil && node.ID > lastRootID {
+				lastRootID = node.ID
+			}
+		}
+		nodes, hasMore, err = repo.GetNodesAfter(ctx, lastRootID, exportPageSize)
+		if err != nil {
+			return err
+		}
+	}
+
+	return writer.Close()
+}
+
+// exportResponseWriter sets the export headers on the first write, so errors
+// raised before any output can still be sent as regular JSON responses
+type exportResponseWriter struct {
+	c       *gin.Context
+	format  export.Format
+	started bool
+}
+
+func (w *exportResponseWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		w.c.Header("Content-Type", w.format.ContentType())
+		w.c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="tree.%s"`, w.format.Extension()))
+		w.c.Status(http.StatusOK)
+	}
+	return w.c.Writer.Write(p)
+}
+
+func (w *exportResponseWriter) Flush() {
+	w.c.Writer.Flush()
+}
+
+// ExportTree streams the trees, or the subtree below the optional rootId
+// query parameter, in the format given by the format query parameter:
+// json (default), csv, dot or mermaid
+func (h *TreeHandler) ExportTree(c *gin.Context) {
+	format, err := export.ParseFormat(c.DefaultQuery("format", string(export.JSON)))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "format must be one of json, csv, dot or mermaid"})
+		return
+	}
+
+	var rootID *int64
+	if rootIDStr := c.Query("rootId"); rootIDStr != "" {
+		id, err := strconv.ParseInt(rootIDStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid root ID"})
+			return
+		}
+		rootID = &id
+	}
+
+	w := &exportResponseWriter{c: c, format: format}
+	err = ExportTree(c.Request.Context(), h.repoFor(c), w, format, rootID)
+	if err == nil {
+		return
+	}
+
+	// Once streaming has started the status is already sent; just stop
+	if w.started {
+		log.Printf("Error streaming export: %v", err)
+		c.Abort()
+		return
+	}
+	if errors.Is(err, repository.ErrNodeNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+		return
+	}
+	c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+}
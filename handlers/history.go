@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ammiranda/tree_service/models"
+	"github.com/ammiranda/tree_service/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ActorHeader is the request header naming who makes a change, recorded in
+// the node history when no authenticated actor is set
+const ActorHeader = "X-Actor"
+
+// ActorContextKey is the gin context key under which authentication
+// middleware can store the authenticated actor. It takes precedence over
+// ActorHeader.
+const ActorContextKey = "actor"
+
+// ActorContext is middleware that attributes the changes made by the
+// remaining handlers to the request's actor in the node history
+func ActorContext() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		actor := c.GetHeader(ActorHeader)
+		if authenticated := c.GetString(ActorContextKey); authenticated != "" {
+			actor = authenticated
+		}
+		if actor != "" {
+			c.Request = c.Request.WithContext(repository.WithActor(c.Request.Context(), actor))
+		}
+		c.Next()
+	}
+}
+
+// GetNodeHistory returns the changes made to a node, most recent first,
+// paginated with page and pageSize. History is kept for trashed and purged
+// nodes.
+func (h *TreeHandler) GetNodeHistory(c *gin.Context) {
+	nodeID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node ID"})
+		return
+	}
+
+	page := 1
+	if pageStr := c.Query("page"); pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
+	}
+
+	pageSize := defaultPageSize
+	if pageSizeStr := c.Query("pageSize"); pageSizeStr != "" {
+		ps, err := strconv.Atoi(pageSizeStr)
+		if err != nil || ps <= 0 || ps > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("page size must be between 1 and %d", maxPageSize)})
+			return
+		}
+		pageSize = ps
+	}
+
+	entries, total, err := h.repoFor(c).GetNodeHistory(c.Request.Context(), nodeID, page, pageSize)
+	if err != nil {
+		if errors.Is(err, repository.ErrNodeNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	data := make([]*models.HistoryEntry, 0, len(entries))
+	for _, entry := range entries {
+		data = append(data, &models.HistoryEntry{
+			ID:          entry.ID,
+			NodeID:      entry.NodeID,
+			Action:      string(entry.Action),
+			OldLabel:    entry.OldLabel,
+			NewLabel:    entry.NewLabel,
+			OldParentID: entry.OldParentID,
+			NewParentID: entry.NewParentID,
+			Actor:       entry.Actor,
+			ChangedAt:   entry.ChangedAt,
+		})
+	}
+
+	totalPages := (total + int64(pageSize) - 1) / int64(pageSize)
+	c.JSON(http.StatusOK, gin.H{
+		"data": data,
+		"pagination": gin.H{
+			"page":       page,
+			"pageSize":   pageSize,
+			"total":      total,
+			"totalPages": totalPages,
+			"hasNext":    int64(page) < totalPages,
+			"hasPrev":    page > 1,
+		},
+	})
+}
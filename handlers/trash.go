@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ammiranda/tree_service/feed"
+	"github.com/ammiranda/tree_service/models"
+	"github.com/ammiranda/tree_service/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListTrash returns the deleted nodes that can still be restored, most
+// recently deleted first. Each entry is the node a delete was issued for;
+// the descendants deleted with it are restored and purged along with it.
+func (h *TreeHandler) ListTrash(c *gin.Context) {
+	page := 1
+	if pageStr := c.Query("page"); pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
+	}
+
+	pageSize := defaultPageSize
+	if pageSizeStr := c.Query("pageSize"); pageSizeStr != "" {
+		ps, err := strconv.Atoi(pageSizeStr)
+		if err != nil || ps <= 0 || ps > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("page size must be between 1 and %d", maxPageSize)})
+			return
+		}
+		pageSize = ps
+	}
+
+	nodes, total, err := h.repoFor(c).ListTrash(c.Request.Context(), page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	data := make([]*models.Node, 0, len(nodes))
+	for _, node := range nodes {
+		data = append(data, toModelNode(node))
+	}
+
+	totalPages := (total + int64(pageSize) - 1) / int64(pageSize)
+	c.JSON(http.StatusOK, gin.H{
+		"data": data,
+		"pagination": gin.H{
+			"page":       page,
+			"pageSize":   pageSize,
+			"total":      total,
+			"totalPages": totalPages,
+			"hasNext":    int64(page) < totalPages,
+			"hasPrev":    page > 1,
+		},
+	})
+}
+
+// RestoreNode moves a trashed node, and the descendants deleted with it,
+// back into the tree. It fails with 409 when the node's parent is itself in
+// the trash; restore the parent first.
+func (h *TreeHandler) RestoreNode(c *gin.Context) {
+	nodeID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node ID"})
+		return
+	}
+
+	restored, err := h.repoFor(c).RestoreNode(c.Request.Context(), nodeID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNodeNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "node not found in trash"})
+		case errors.Is(err, repository.ErrParentDeleted):
+			c.JSON(http.StatusConflict, gin.H{"error": "parent node is in the trash"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// Invalidate cache since the nodes are visible again
+	treeCache(c).InvalidateCache()
+	h.publishChange(c, feed.NodeRestored, nodeID, nil)
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":       nodeID,
+		"restored": restored,
+	})
+}
+
+// PurgeNode permanently deletes a trashed node and its descendants
+func (h *TreeHandler) PurgeNode(c *gin.Context) {
+	nodeID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node ID"})
+		return
+	}
+
+	purged, err := h.repoFor(c).PurgeNode(c.Request.Context(), nodeID)
+	if err != nil {
+		if errors.Is(err, repository.ErrNodeNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "node not found in trash"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Trashed nodes are never cached, so the cache stays valid
+	c.JSON(http.StatusOK, gin.H{
+		"id":     nodeID,
+		"purged": purged,
+	})
+}
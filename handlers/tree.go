@@ -4,9 +4,14 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ammiranda/tree_service/cache"
+	"github.com/ammiranda/tree_service/config"
+	"github.com/ammiranda/tree_service/feed"
 	"github.com/ammiranda/tree_service/models"
 	"github.com/ammiranda/tree_service/repository"
 
@@ -22,16 +27,64 @@ var (
 	ErrTreeNotFound = errors.New("tree not found")
 )
 
+// versionConflictMessage is the error returned when an If-Match header no
+// longer matches the node's ETag
+const versionConflictMessage = "node has been modified since it was read"
+
 // TreeHandler handles tree-related HTTP requests
 type TreeHandler struct {
-	repo repository.Repository
+	repo            repository.Repository
+	importMaxNodes  int
+	importMaxDepth  int
+	batchMaxOps     int
+	attributeLimits models.AttributeLimits
+	events          *feed.Broker
+}
+
+// Option configures optional TreeHandler settings
+type Option func(*TreeHandler)
+
+// WithImportLimits sets the maximum number of nodes and levels a single
+// bulk import may contain
+func WithImportLimits(maxNodes, maxDepth int) Option {
+	return func(h *TreeHandler) {
+		h.importMaxNodes = maxNodes
+		h.importMaxDepth = maxDepth
+	}
+}
+
+// WithBatchLimit sets the maximum number of operations a single batch
+// request may contain
+func WithBatchLimit(maxOperations int) Option {
+	return func(h *TreeHandler) {
+		h.batchMaxOps = maxOperations
+	}
+}
+
+// WithAttributeLimits sets the size limit and optional key pattern applied
+// to node attributes
+func WithAttributeLimits(limits models.AttributeLimits) Option {
+	return func(h *TreeHandler) {
+		h.attributeLimits = limits
+	}
 }
 
 // NewTreeHandler creates a new TreeHandler instance
-func NewTreeHandler(repo repository.Repository) *TreeHandler {
-	return &TreeHandler{
-		repo: repo,
+func NewTreeHandler(repo repository.Repository, opts ...Option) *TreeHandler {
+	h := &TreeHandler{
+		repo:           repo,
+		importMaxNodes: config.DefaultImportMaxNodes,
+		importMaxDepth: config.DefaultImportMaxDepth,
+		batchMaxOps:    config.DefaultBatchMaxOperations,
+		attributeLimits: models.AttributeLimits{
+			MaxBytes: config.DefaultAttributesMaxBytes,
+		},
+		events: feed.NewBroker(config.DefaultEventsBufferSize),
 	}
+	for _, opt := range opts {
+		opt(h)
+	}
+	return h
 }
 
 // BuildTreeFromNodes builds the tree structure from a list of nodes
@@ -46,9 +99,7 @@ func BuildTreeFromNodes(nodes []*repository.Node) ([]*models.Node, error) {
 
 	// First pass: create all nodes
 	for _, node := range nodes {
-		modelNode := models.NewNode(node.Label)
-		modelNode.ID = node.ID
-		nodeMap[node.ID] = modelNode
+		nodeMap[node.ID] = toModelNode(node)
 	}
 
 	// Second pass: connect children to parents and identify root/orphaned nodes
@@ -72,10 +123,42 @@ func BuildTreeFromNodes(nodes []*repository.Node) ([]*models.Node, error) {
 		return nil, ErrTreeNotFound
 	}
 
+	// Order siblings by position; roots keep the order they were paged in
+	for _, modelNode := range nodeMap {
+		children := modelNode.Children
+		sort.SliceStable(children, func(i, j int) bool {
+			if children[i].Position != children[j].Position {
+				return children[i].Position < children[j].Position
+			}
+			return children[i].ID < children[j].ID
+		})
+	}
+
 	return rootNodes, nil
 }
 
-// GetTree returns all trees in the database with pagination
+// toModelNode converts a repository node into a childless API node
+func toModelNode(node *repository.Node) *models.Node {
+	modelNode := models.NewNode(node.Label)
+	modelNode.ID = node.ID
+	modelNode.ParentID = node.ParentID
+	modelNode.Position = node.Position
+	modelNode.Attributes = node.Attributes
+	modelNode.CreatedAt = node.CreatedAt
+	modelNode.UpdatedAt = node.UpdatedAt
+	modelNode.Version = node.Version
+	modelNode.DeletedAt = node.DeletedAt
+	return modelNode
+}
+
+// AttributeFilterPrefix marks GET /api/tree query parameters that filter on
+// node attributes, e.g. attr.status=active
+const AttributeFilterPrefix = "attr."
+
+// GetTree returns all trees in the database with pagination.
+// Query parameters of the form attr.<key>=<value> prune the trees to the
+// nodes whose attributes match, plus their ancestors. An asOf parameter
+// (RFC 3339) returns the trees as they were at that time instead.
 func (h *TreeHandler) GetTree(c *gin.Context) {
 	// Get pagination parameters
 	page := 1
@@ -106,15 +189,64 @@ func (h *TreeHandler) GetTree(c *gin.Context) {
 		pageSize = ps
 	}
 
+	// Parse attribute filters
+	filter := make(map[string]string)
+	for param, values := range c.Request.URL.Query() {
+		if !strings.HasPrefix(param, AttributeFilterPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(param, AttributeFilterPrefix)
+		if key == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "attribute filters must name a key, e.g. attr.status=active"})
+			return
+		}
+		filter[key] = values[0]
+	}
+
+	asOf, err := models.ParseAsOf(c.Query("asOf"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A cursor parameter (even an empty one for the first page) switches to
+	// keyset pagination, which skips the page arithmetic and total count
+	if cursor, ok := c.GetQuery("cursor"); ok {
+		if asOf != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "asOf is not supported with cursor pagination"})
+			return
+		}
+		if len(filter) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "attribute filters are not supported with cursor pagination"})
+			return
+		}
+		h.getTreeByCursor(c, cursor, pageSize)
+		return
+	}
+
+	if asOf != nil {
+		if len(filter) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "attribute filters are not supported with asOf"})
+			return
+		}
+		h.getTreeAsOf(c, *asOf, page, pageSize)
+		return
+	}
+
+	if len(filter) > 0 {
+		h.getTreeByAttributes(c, filter, page, pageSize)
+		return
+	}
+
 	// Try to get from cache first
-	if cachedResponse, found := cache.GetPaginatedTree(page, pageSize); found {
+	if cachedResponse, found := treeCache(c).GetPaginatedTree(page, pageSize); found {
 		c.JSON(http.StatusOK, cachedResponse)
 		return
 	}
 
 	// If not in cache, get all nodes from repository
 	ctx := c.Request.Context()
-	allNodes, total, err := h.repo.GetAllNodes(ctx, page, pageSize)
+	allNodes, total, err := h.repoFor(c).GetAllNodes(ctx, page, pageSize)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
 		return
@@ -144,15 +276,135 @@ func (h *TreeHandler) GetTree(c *gin.Context) {
 			return
 		}
 		response.Data = rootNodes
+
+		// Let clients switch to cursor pagination from any page
+		if response.Pagination.HasNext {
+			response.Pagination.NextCursor = models.EncodeCursor(rootNodes[len(rootNodes)-1].ID)
+		}
 	}
 
 	// Store in cache
-	cache.SetPaginatedTree(page, pageSize, response)
+	treeCache(c).SetPaginatedTree(page, pageSize, response)
 
 	// Return response
 	c.JSON(http.StatusOK, response)
 }
 
+// getTreeByAttributes serves GET /api/tree with attribute filters. Filtered
+// pages bypass the cache, whose keys only cover pagination.
+func (h *TreeHandler) getTreeByAttributes(c *gin.Context, filter map[string]string, page, pageSize int) {
+	nodes, total, err := h.repoFor(c).GetNodesByAttributes(c.Request.Context(), filter, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Create response
+	response := &cache.PaginatedTreeResponse{
+		Data: make([]*models.Node, 0),
+	}
+	response.Pagination.Page = page
+	response.Pagination.PageSize = pageSize
+	response.Pagination.Total = total
+	response.Pagination.TotalPages = (total + int64(pageSize) - 1) / int64(pageSize)
+	response.Pagination.HasNext = int64(page) < response.Pagination.TotalPages
+	response.Pagination.HasPrev = page > 1
+
+	if len(nodes) > 0 {
+		rootNodes, err := BuildTreeFromNodes(nodes)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		response.Data = rootNodes
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// getTreeAsOf serves GET /api/tree with asOf. Historical pages bypass the
+// cache, which only holds the live tree.
+func (h *TreeHandler) getTreeAsOf(c *gin.Context, asOf time.Time, page, pageSize int) {
+	nodes, total, err := h.repoFor(c).GetAllNodesAsOf(c.Request.Context(), asOf, page, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Create response
+	response := &cache.PaginatedTreeResponse{
+		Data: make([]*models.Node, 0),
+	}
+	response.Pagination.Page = page
+	response.Pagination.PageSize = pageSize
+	response.Pagination.Total = total
+	response.Pagination.TotalPages = (total + int64(pageSize) - 1) / int64(pageSize)
+	response.Pagination.HasNext = int64(page) < response.Pagination.TotalPages
+	response.Pagination.HasPrev = page > 1
+
+	if len(nodes) > 0 {
+		rootNodes, err := BuildTreeFromNodes(nodes)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		response.Data = rootNodes
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// getTreeByCursor serves GET /api/tree with keyset pagination
+func (h *TreeHandler) getTreeByCursor(c *gin.Context, cursor string, pageSize int) {
+	var afterID int64
+	if cursor != "" {
+		id, err := models.DecodeCursor(cursor)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid cursor"})
+			return
+		}
+		afterID = id
+	}
+
+	// Try to get from cache first
+	if cachedResponse, found := treeCache(c).GetCursorTree(cursor, pageSize); found {
+		c.JSON(http.StatusOK, cachedResponse)
+		return
+	}
+
+	nodes, hasMore, err := h.repoFor(c).GetNodesAfter(c.Request.Context(), afterID, pageSize)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Create response
+	response := &cache.PaginatedTreeResponse{
+		Data: make([]*models.Node, 0),
+	}
+	response.Pagination.PageSize = pageSize
+	response.Pagination.Cursor = cursor
+	response.Pagination.HasNext = hasMore
+	response.Pagination.HasPrev = cursor != ""
+
+	if len(nodes) > 0 {
+		rootNodes, err := BuildTreeFromNodes(nodes)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		response.Data = rootNodes
+		if hasMore {
+			response.Pagination.NextCursor = models.EncodeCursor(rootNodes[len(rootNodes)-1].ID)
+		}
+	}
+
+	// Store in cache
+	treeCache(c).SetCursorTree(cursor, pageSize, response)
+
+	c.JSON(http.StatusOK, response)
+}
+
 // CreateNode creates a new node in the tree
 func (h *TreeHandler) CreateNode(c *gin.Context) {
 	var req models.CreateNodeRequest
@@ -166,54 +418,430 @@ func (h *TreeHandler) CreateNode(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	if err := h.attributeLimits.Validate(req.Attributes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
-	ctx := c.Request.Context()
 	var parentID *int64
 	if req.ParentID > 0 {
 		parentID = &req.ParentID
-		// Check if parent exists
-		_, err := h.repo.GetNode(ctx, *parentID)
+	}
+
+	// Create node using repository, which checks that the parent exists
+	id, err := h.repoFor(c).CreateNodeWithAttributes(c.Request.Context(), req.Label, parentID, req.Attributes)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNodeNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "parent node not found"})
+		case errors.Is(err, repository.ErrCrossTreeParent):
+			c.JSON(http.StatusBadRequest, gin.H{"error": crossTreeParentMessage})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// Invalidate cache since we modified the tree
+	treeCache(c).InvalidateCache()
+	h.publishChange(c, feed.NodeCreated, id, nil)
+
+	c.JSON(http.StatusCreated, gin.H{
+		"id":         id,
+		"label":      req.Label,
+		"parentId":   parentID,
+		"attributes": req.Attributes,
+	})
+}
+
+// UpdateNode replaces an existing node in the tree.
+// PUT has full-replacement semantics: omitting parentId makes the node a
+// root and omitting attributes clears them. Clients that only want to
+// change some fields should use PatchNode. With an If-Match header the
+// update only happens if the node's ETag still matches, otherwise it fails
+// with 412.
+func (h *TreeHandler) UpdateNode(c *gin.Context) {
+	var req models.UpdateNodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate the request
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.attributeLimits.Validate(req.Attributes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Get node ID from path
+	nodeID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node ID"})
+		return
+	}
+
+	version, err := models.ParseIfMatch(c.GetHeader("If-Match"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Replace the label, parent and attributes
+	ctx := c.Request.Context()
+	repo := h.repoFor(c)
+	previousPath, _ := h.nodeState(c, nodeID)
+	patch := repository.NodePatch{
+		Label:      &req.Label,
+		SetParent:  true,
+		ParentID:   req.ParentID,
+		Attributes: req.ReplacementAttributes(),
+	}
+	if version != nil {
+		err = repo.UpdateNodeIfVersion(ctx, nodeID, *version, patch)
+	} else {
+		err = repo.PatchNode(ctx, nodeID, patch)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNodeNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+		case errors.Is(err, repository.ErrVersionConflict):
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": versionConflictMessage})
+		case errors.Is(err, repository.ErrCycleDetected):
+			c.JSON(http.StatusConflict, gin.H{"error": "cannot move a node under itself or one of its descendants"})
+		case errors.Is(err, repository.ErrCrossTreeParent):
+			c.JSON(http.StatusBadRequest, gin.H{"error": crossTreeParentMessage})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// Invalidate cache since we modified the tree
+	treeCache(c).InvalidateCache()
+	h.publishChange(c, feed.NodeUpdated, nodeID, previousPath)
+
+	node, err := repo.GetNode(ctx, nodeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("ETag", models.FormatETag(node.Version))
+	c.JSON(http.StatusOK, gin.H{
+		"id":       nodeID,
+		"label":    req.Label,
+		"parentId": req.ParentID,
+		"version":  node.Version,
+	})
+}
+
+// PatchNode partially updates an existing node in the tree.
+// Omitted fields are left untouched and an explicit "parentId": null makes
+// the node a root. Like UpdateNode it honours If-Match.
+func (h *TreeHandler) PatchNode(c *gin.Context) {
+	// Get node ID from path
+	nodeID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node ID"})
+		return
+	}
+
+	var req models.PatchNodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate the request
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := h.attributeLimits.Validate(req.Attributes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	version, err := models.ParseIfMatch(c.GetHeader("If-Match"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	repo := h.repoFor(c)
+	previousPath, _ := h.nodeState(c, nodeID)
+	patch := repository.NodePatch{
+		Label:      req.Label,
+		SetParent:  req.ParentID.Set,
+		ParentID:   req.ParentID.Value,
+		Attributes: req.Attributes,
+	}
+	if version != nil {
+		err = repo.UpdateNodeIfVersion(ctx, nodeID, *version, patch)
+	} else {
+		err = repo.PatchNode(ctx, nodeID, patch)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNodeNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+		case errors.Is(err, repository.ErrVersionConflict):
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": versionConflictMessage})
+		case errors.Is(err, repository.ErrCycleDetected):
+			c.JSON(http.StatusConflict, gin.H{"error": "cannot move a node under itself or one of its descendants"})
+		case errors.Is(err, repository.ErrCrossTreeParent):
+			c.JSON(http.StatusBadRequest, gin.H{"error": crossTreeParentMessage})
+		case errors.Is(err, repository.ErrInvalidInput):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// Invalidate cache since we modified the tree
+	treeCache(c).InvalidateCache()
+	h.publishChange(c, feed.NodeUpdated, nodeID, previousPath)
+
+	node, err := repo.GetNode(ctx, nodeID)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Header("ETag", models.FormatETag(node.Version))
+	c.JSON(http.StatusOK, toModelNode(node))
+}
+
+// MoveNode moves a node to a new parent and/or place among its siblings.
+// The request body takes an optional parentId (omitted keeps the current
+// parent, null makes the node a root) and at most one of beforeId, afterId
+// or index; without one the node is placed last. Like UpdateNode it
+// honours If-Match.
+func (h *TreeHandler) MoveNode(c *gin.Context) {
+	// Get node ID from path
+	nodeID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node ID"})
+		return
+	}
+
+	var req models.MoveNodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate the request
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	version, err := models.ParseIfMatch(c.GetHeader("If-Match"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ctx := c.Request.Context()
+	repo := h.repoFor(c)
+	previousPath, _ := h.nodeState(c, nodeID)
+	parentID := req.ParentID.Value
+	if !req.ParentID.Set {
+		node, err := repo.GetNode(ctx, nodeID)
 		if err != nil {
 			if errors.Is(err, repository.ErrNodeNotFound) {
-				c.JSON(http.StatusNotFound, gin.H{"error": "parent node not found"})
+				c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
 				return
 			}
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		parentID = node.ParentID
+	}
+
+	pos := repository.MovePosition{
+		BeforeID: req.BeforeID,
+		AfterID:  req.AfterID,
+		Index:    req.Index,
+	}
+	if version != nil {
+		err = repo.MoveNodeIfVersion(ctx, nodeID, *version, parentID, pos)
+	} else {
+		err = repo.MoveNode(ctx, nodeID, parentID, pos)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNodeNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+		case errors.Is(err, repository.ErrVersionConflict):
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": versionConflictMessage})
+		case errors.Is(err, repository.ErrCycleDetected):
+			c.JSON(http.StatusConflict, gin.H{"error": "cannot move a node under itself or one of its descendants"})
+		case errors.Is(err, repository.ErrCrossTreeParent):
+			c.JSON(http.StatusBadRequest, gin.H{"error": crossTreeParentMessage})
+		case errors.Is(err, repository.ErrInvalidInput):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "beforeId and afterId must be children of the new parent, and roots cannot be positioned"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
 	}
 
-	// Create node using repository
-	id, err := h.repo.CreateNode(ctx, req.Label, parentID)
+	// Invalidate cache since we modified the tree
+	treeCache(c).InvalidateCache()
+	h.publishChange(c, feed.NodeMoved, nodeID, previousPath)
+
+	node, err := repo.GetNode(ctx, nodeID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	c.Header("ETag", models.FormatETag(node.Version))
+	c.JSON(http.StatusOK, toModelNode(node))
+}
+
+// DeleteNode moves a node to the trash, from where it can be restored until
+// the purge job removes it.
+// The optional mode query parameter selects what happens to the node's
+// children: cascade (default) deletes the whole subtree, reparent moves the
+// children to the deleted node's parent and refuse fails with 409 when the
+// node has children. Like UpdateNode it honours If-Match.
+func (h *TreeHandler) DeleteNode(c *gin.Context) {
+	// Get node ID from path
+	nodeID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node ID"})
+		return
+	}
+
+	mode := repository.DeleteMode(c.DefaultQuery("mode", string(repository.DeleteModeCascade)))
+	if !mode.Valid() {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "mode must be one of cascade, reparent or refuse"})
+		return
+	}
+
+	version, err := models.ParseIfMatch(c.GetHeader("If-Match"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Read the node first so the delete event can say where it was, and in
+	// reparent mode its children, whose move events need their old paths
+	path, node := h.nodeState(c, nodeID)
+	var children []*repository.Node
+	if mode == repository.DeleteModeReparent && node != nil {
+		if subtree, err := h.repoFor(c).GetSubtree(c.Request.Context(), nodeID, 1); err == nil {
+			children = subtree[1:]
+		}
+	}
+
+	// Delete node using repository
+	var deleted int64
+	if version != nil {
+		deleted, err = h.repoFor(c).DeleteNodeIfVersion(c.Request.Context(), nodeID, *version, mode)
+	} else {
+		deleted, err = h.repoFor(c).DeleteNode(c.Request.Context(), nodeID, mode)
+	}
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNodeNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+		case errors.Is(err, repository.ErrVersionConflict):
+			c.JSON(http.StatusPreconditionFailed, gin.H{"error": versionConflictMessage})
+		case errors.Is(err, repository.ErrNodeHasChildren):
+			c.JSON(http.StatusConflict, gin.H{"error": "node has children"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
 	// Invalidate cache since we modified the tree
-	cache.InvalidateCache()
+	treeCache(c).InvalidateCache()
+	for _, child := range children {
+		h.publishChange(c, feed.NodeMoved, child.ID, append(append([]int64(nil), path...), child.ID))
+	}
+	if node != nil {
+		h.publish(c, feed.NodeDeleted, node, path, nil)
+	}
 
-	c.JSON(http.StatusCreated, gin.H{
-		"id":       id,
-		"label":    req.Label,
-		"parentId": parentID,
+	c.JSON(http.StatusOK, gin.H{
+		"id":      nodeID,
+		"mode":    mode,
+		"deleted": deleted,
 	})
 }
 
-// UpdateNode updates an existing node in the tree
-func (h *TreeHandler) UpdateNode(c *gin.Context) {
-	var req models.UpdateNodeRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+// GetNode returns a single node with its descendants.
+// The optional depth query parameter limits how many levels of descendants
+// are included; 0 returns only the node itself and omitting it returns the
+// whole subtree. An asOf parameter (RFC 3339) returns the node as it was at
+// that time, without an ETag since historical reads carry no version.
+func (h *TreeHandler) GetNode(c *gin.Context) {
+	// Get node ID from path
+	nodeID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid node ID"})
 		return
 	}
 
-	// Validate the request
-	if err := req.Validate(); err != nil {
+	depth := repository.UnlimitedDepth
+	if depthStr := c.Query("depth"); depthStr != "" {
+		d, err := strconv.Atoi(depthStr)
+		if err != nil || d < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "depth must be a non-negative integer"})
+			return
+		}
+		depth = d
+	}
+
+	asOf, err := models.ParseAsOf(c.Query("asOf"))
+	if err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
 
+	var nodes []*repository.Node
+	if asOf != nil {
+		nodes, err = h.repoFor(c).GetSubtreeAsOf(c.Request.Context(), nodeID, depth, *asOf)
+	} else {
+		nodes, err = h.repoFor(c).GetSubtree(c.Request.Context(), nodeID, depth)
+	}
+	if err != nil {
+		if errors.Is(err, repository.ErrNodeNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The requested node is the only root since its parent is not in the set
+	rootNodes, err := BuildTreeFromNodes(nodes)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	if asOf == nil {
+		c.Header("ETag", models.FormatETag(rootNodes[0].Version))
+	}
+	c.JSON(http.StatusOK, rootNodes[0])
+}
+
+// GetAncestors returns the breadcrumb of a node: the ordered chain of nodes
+// from the root of its tree down to the node itself
+func (h *TreeHandler) GetAncestors(c *gin.Context) {
 	// Get node ID from path
 	nodeID, err := strconv.ParseInt(c.Param("id"), 10, 64)
 	if err != nil {
@@ -221,8 +849,7 @@ func (h *TreeHandler) UpdateNode(c *gin.Context) {
 		return
 	}
 
-	// Update node using repository
-	err = h.repo.UpdateNode(c.Request.Context(), nodeID, req.Label, req.ParentID)
+	ancestors, err := h.repoFor(c).GetAncestors(c.Request.Context(), nodeID)
 	if err != nil {
 		if errors.Is(err, repository.ErrNodeNotFound) {
 			c.JSON(http.StatusNotFound, gin.H{"error": "node not found"})
@@ -232,12 +859,153 @@ func (h *TreeHandler) UpdateNode(c *gin.Context) {
 		return
 	}
 
-	// Invalidate cache since we modified the tree
-	cache.InvalidateCache()
+	data := make([]*models.Node, 0, len(ancestors))
+	for _, node := range ancestors {
+		data = append(data, toModelNode(node))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+// Search finds nodes by label.
+// The q query parameter is matched case-insensitively as a substring and by
+// trigram similarity. Results are paginated with page and pageSize and can be
+// limited to the subtree below rootId. Each hit carries its ancestor path
+// from the root and a relevance score between 0 and 1.
+func (h *TreeHandler) Search(c *gin.Context) {
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+	if len(query) > 100 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q cannot exceed 100 characters"})
+		return
+	}
+
+	page := 1
+	if pageStr := c.Query("page"); pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
+	}
+
+	pageSize := defaultPageSize
+	if pageSizeStr := c.Query("pageSize"); pageSizeStr != "" {
+		ps, err := strconv.Atoi(pageSizeStr)
+		if err != nil || ps <= 0 || ps > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("page size must be between 1 and %d", maxPageSize)})
+			return
+		}
+		pageSize = ps
+	}
+
+	var rootID *int64
+	if rootIDStr := c.Query("rootId"); rootIDStr != "" {
+		id, err := strconv.ParseInt(rootIDStr, 10, 64)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "invalid root ID"})
+			return
+		}
+		rootID = &id
+	}
+
+	hits, total, err := h.repoFor(c).SearchNodes(c.Request.Context(), query, rootID, page, pageSize)
+	if err != nil {
+		if errors.Is(err, repository.ErrNodeNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "root node not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
 
+	data := make([]*models.SearchHit, 0, len(hits))
+	for _, hit := range hits {
+		path := make([]*models.PathNode, 0, len(hit.Path))
+		for _, ancestor := range hit.Path {
+			path = append(path, &models.PathNode{ID: ancestor.ID, Label: ancestor.Label})
+		}
+		data = append(data, &models.SearchHit{
+			Node:  toModelNode(hit.Node),
+			Path:  path,
+			Score: hit.Score,
+		})
+	}
+
+	totalPages := (total + int64(pageSize) - 1) / int64(pageSize)
 	c.JSON(http.StatusOK, gin.H{
-		"id":       nodeID,
-		"label":    req.Label,
+		"data": data,
+		"pagination": gin.H{
+			"page":       page,
+			"pageSize":   pageSize,
+			"total":      total,
+			"totalPages": totalPages,
+			"hasNext":    int64(page) < totalPages,
+			"hasPrev":    page > 1,
+		},
+	})
+}
+
+// ImportTree creates a nested tree in one transaction.
+// The request body holds a root node in the same shape as models.Node
+// (label plus children) and an optional parentId to attach it under. The
+// response maps each node's JSON pointer in the request body (e.g.
+// "/root/children/0") to the ID it was created with.
+func (h *TreeHandler) ImportTree(c *gin.Context) {
+	var req models.ImportTreeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate the request
+	if err := req.Validate(h.importMaxNodes, h.importMaxDepth); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := req.ValidateAttributes(h.attributeLimits); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	ids, err := h.repoFor(c).ImportTree(c.Request.Context(), req.ParentID, toImportNode(req.Root, "/root"))
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrNodeNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "parent node not found"})
+		case errors.Is(err, repository.ErrCrossTreeParent):
+			c.JSON(http.StatusBadRequest, gin.H{"error": crossTreeParentMessage})
+		case errors.Is(err, repository.ErrInvalidInput):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// Invalidate cache since we modified the tree
+	treeCache(c).InvalidateCache()
+	h.publishImported(c, ids["/root"], ids)
+
+	c.JSON(http.StatusCreated, gin.H{
 		"parentId": req.ParentID,
+		"created":  len(ids),
+		"ids":      ids,
 	})
 }
+
+// toImportNode converts a request node into a repository import node keyed
+// by its JSON pointer
+func toImportNode(node *models.Node, path string) *repository.ImportNode {
+	importNode := &repository.ImportNode{
+		Key:        path,
+		Label:      node.Label,
+		Attributes: node.Attributes,
+		Children:   make([]*repository.ImportNode, 0, len(node.Children)),
+	}
+	for i, child := range node.Children {
+		importNode.Children = append(importNode.Children, toImportNode(child, fmt.Sprintf("%s/children/%d", path, i)))
+	}
+	return importNode
+}
@@ -0,0 +1,167 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ammiranda/tree_service/cache"
+	"github.com/ammiranda/tree_service/models"
+	"github.com/ammiranda/tree_service/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// treeIDKey is the gin context key under which TreeScope stores the tree ID
+const treeIDKey = "treeID"
+
+// crossTreeParentMessage is the error returned when a node would be linked
+// to a parent in another tree
+const crossTreeParentMessage = "parent node belongs to another tree"
+
+// TreeScope is middleware for the routes under /api/trees/:treeId. It checks
+// that the tree exists and scopes the remaining handlers to it; routes
+// without it operate on the default tree.
+func (h *TreeHandler) TreeScope() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		treeID, err := strconv.ParseInt(c.Param("treeId"), 10, 64)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "invalid tree ID"})
+			return
+		}
+
+		if _, err := h.repo.GetTree(c.Request.Context(), treeID); err != nil {
+			if errors.Is(err, repository.ErrTreeNotFound) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "tree not found"})
+				return
+			}
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Set(treeIDKey, treeID)
+		c.Next()
+	}
+}
+
+// requestTreeID returns the tree selected by TreeScope, or the default tree
+func requestTreeID(c *gin.Context) int64 {
+	if treeID, ok := c.Get(treeIDKey); ok {
+		return treeID.(int64)
+	}
+	return repository.DefaultTreeID
+}
+
+// repoFor returns the repository scoped to the request's tree
+func (h *TreeHandler) repoFor(c *gin.Context) repository.Repository {
+	treeID := requestTreeID(c)
+	if treeID == repository.DefaultTreeID {
+		return h.repo
+	}
+	return h.repo.ForTree(treeID)
+}
+
+// treeCache returns the cache namespaced to the request's tree, so writes to
+// one tree only invalidate that tree's cached pages
+func treeCache(c *gin.Context) cache.CacheProvider {
+	return cache.ForTree(requestTreeID(c))
+}
+
+// ListTrees returns every named tree
+func (h *TreeHandler) ListTrees(c *gin.Context) {
+	trees, err := h.repo.ListTrees(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	data := make([]*models.Tree, 0, len(trees))
+	for _, tree := range trees {
+		data = append(data, toModelTree(tree))
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+// CreateTree creates a new, empty named tree
+func (h *TreeHandler) CreateTree(c *gin.Context) {
+	var req models.CreateTreeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate the request
+	req.Name = strings.TrimSpace(req.Name)
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	tree, err := h.repo.CreateTree(c.Request.Context(), req.Name)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrTreeExists):
+			c.JSON(http.StatusConflict, gin.H{"error": "a tree with this name already exists"})
+		case errors.Is(err, repository.ErrInvalidInput):
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	c.JSON(http.StatusCreated, toModelTree(tree))
+}
+
+// GetTreeInfo returns a named tree's metadata. TreeScope has already
+// checked that the tree exists.
+func (h *TreeHandler) GetTreeInfo(c *gin.Context) {
+	tree, err := h.repo.GetTree(c.Request.Context(), requestTreeID(c))
+	if err != nil {
+		if errors.Is(err, repository.ErrTreeNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "tree not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, toModelTree(tree))
+}
+
+// DeleteTree deletes a named tree with all of its nodes. The default tree
+// cannot be deleted.
+func (h *TreeHandler) DeleteTree(c *gin.Context) {
+	treeID := requestTreeID(c)
+	deleted, err := h.repo.DeleteTree(c.Request.Context(), treeID)
+	if err != nil {
+		switch {
+		case errors.Is(err, repository.ErrTreeNotFound):
+			c.JSON(http.StatusNotFound, gin.H{"error": "tree not found"})
+		case errors.Is(err, repository.ErrInvalidInput):
+			c.JSON(http.StatusBadRequest, gin.H{"error": "the default tree cannot be deleted"})
+		default:
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	// Invalidate cache since we removed the tree
+	treeCache(c).InvalidateCache()
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":      treeID,
+		"deleted": deleted,
+	})
+}
+
+// toModelTree converts a repository tree into its API representation
+func toModelTree(tree *repository.Tree) *models.Tree {
+	return &models.Tree{
+		ID:        tree.ID,
+		Name:      tree.Name,
+		CreatedAt: tree.CreatedAt,
+	}
+}
@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/ammiranda/tree_service/feed"
+	"github.com/ammiranda/tree_service/models"
+	"github.com/ammiranda/tree_service/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListWebhooks returns the webhooks of the request's tree
+func (h *TreeHandler) ListWebhooks(c *gin.Context) {
+	webhooks, err := h.repoFor(c).ListWebhooks(c.Request.Context())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	data := make([]*models.Webhook, 0, len(webhooks))
+	for _, webhook := range webhooks {
+		data = append(data, toModelWebhook(webhook))
+	}
+	c.JSON(http.StatusOK, gin.H{"data": data})
+}
+
+// CreateWebhook subscribes a URL to the node changes of the request's tree.
+// The response is the only one that includes the signing secret.
+func (h *TreeHandler) CreateWebhook(c *gin.Context) {
+	var req models.CreateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate the request
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := validateEventTypes(req.Events); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	secret := req.Secret
+	if secret == "" {
+		generated, err := generateWebhookSecret()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+		secret = generated
+	}
+
+	webhook, err := h.repoFor(c).CreateWebhook(c.Request.Context(), &repository.Webhook{
+		URL:        req.URL,
+		Secret:     secret,
+		EventTypes: req.Events,
+		Active:     req.Active == nil || *req.Active,
+	})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	response := toModelWebhook(webhook)
+	response.Secret = webhook.Secret
+	c.JSON(http.StatusCreated, response)
+}
+
+// GetWebhook returns a single webhook of the request's tree
+func (h *TreeHandler) GetWebhook(c *gin.Context) {
+	webhookID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook ID"})
+		return
+	}
+
+	webhook, err := h.repoFor(c).GetWebhook(c.Request.Context(), webhookID)
+	if err != nil {
+		if errors.Is(err, repository.ErrWebhookNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, toModelWebhook(webhook))
+}
+
+// UpdateWebhook partially updates a webhook. Deactivating a webhook pauses
+// its pending deliveries; new changes aren't queued for it until it is
+// activated again.
+func (h *TreeHandler) UpdateWebhook(c *gin.Context) {
+	webhookID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook ID"})
+		return
+	}
+
+	var req models.UpdateWebhookRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Validate the request
+	if err := req.Validate(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	patch := repository.WebhookPatch{
+		URL:    req.URL,
+		Secret: req.Secret,
+		Active: req.Active,
+	}
+	if req.Events != nil {
+		if err := validateEventTypes(*req.Events); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		patch.SetEventTypes = true
+		patch.EventTypes = *req.Events
+	}
+
+	webhook, err := h.repoFor(c).UpdateWebhook(c.Request.Context(), webhookID, patch)
+	if err != nil {
+		if errors.Is(err, repository.ErrWebhookNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, toModelWebhook(webhook))
+}
+
+// DeleteWebhook deletes a webhook together with its delivery log
+func (h *TreeHandler) DeleteWebhook(c *gin.Context) {
+	webhookID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook ID"})
+		return
+	}
+
+	if err := h.repoFor(c).DeleteWebhook(c.Request.Context(), webhookID); err != nil {
+		if errors.Is(err, repository.ErrWebhookNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"id":      webhookID,
+		"deleted": true,
+	})
+}
+
+// ListWebhookDeliveries returns a webhook's delivery log, most recent first,
+// paginated with page and pageSize
+func (h *TreeHandler) ListWebhookDeliveries(c *gin.Context) {
+	webhookID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "invalid webhook ID"})
+		return
+	}
+
+	page := 1
+	if pageStr := c.Query("page"); pageStr != "" {
+		if p, err := strconv.Atoi(pageStr); err == nil && p > 0 {
+			page = p
+		}
+	}
+
+	pageSize := defaultPageSize
+	if pageSizeStr := c.Query("pageSize"); pageSizeStr != "" {
+		ps, err := strconv.Atoi(pageSizeStr)
+		if err != nil || ps <= 0 || ps > maxPageSize {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("page size must be between 1 and %d", maxPageSize)})
+			return
+		}
+		pageSize = ps
+	}
+
+	deliveries, total, err := h.repoFor(c).ListWebhookDeliveries(c.Request.Context(), webhookID, page, pageSize)
+	if err != nil {
+		if errors.Is(err, repository.ErrWebhookNotFound) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "webhook not found"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	data := make([]*models.WebhookDelivery, 0, len(deliveries))
+	for _, delivery := range deliveries {
+		entry := &models.WebhookDelivery{
+			ID:             delivery.ID,
+			WebhookID:      delivery.WebhookID,
+			Event:          delivery.EventType,
+			Status:         string(delivery.Status),
+			Attempts:       delivery.Attempts,
+			LastAttemptAt:  delivery.LastAttemptAt,
+			ResponseStatus: delivery.ResponseStatus,
+			LastError:      delivery.LastError,
+			CreatedAt:      delivery.CreatedAt,
+			Payload:        delivery.Payload,
+		}
+		if delivery.Status == repository.DeliveryPending {
+			nextAttemptAt := delivery.NextAttemptAt
+			entry.NextAttemptAt = &nextAttemptAt
+		}
+		data = append(data, entry)
+	}
+
+	totalPages := (total + int64(pageSize) - 1) / int64(pageSize)
+	c.JSON(http.StatusOK, gin.H{
+		"data": data,
+		"pagination": gin.H{
+			"page":       page,
+			"pageSize":   pageSize,
+			"total":      total,
+			"totalPages": totalPages,
+			"hasNext":    int64(page) < totalPages,
+			"hasPrev":    page > 1,
+		},
+	})
+}
+
+// validateEventTypes checks that every webhook event type is known
+func validateEventTypes(eventTypes []string) error {
+	for _, eventType := range eventTypes {
+		if !feed.IsEventType(eventType) {
+			return fmt.Errorf("unknown event type %q", eventType)
+		}
+	}
+	return nil
+}
+
+// generateWebhookSecret returns a random signing secret for webhooks
+// created without one
+func generateWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("error generating webhook secret: %w", err)
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// toModelWebhook converts a repository webhook into its API representation,
+// without the secret
+func toModelWebhook(webhook *repository.Webhook) *models.Webhook {
+	events := webhook.EventTypes
+	if events == nil {
+		events = []string{}
+	}
+	return &models.Webhook{
+		ID:        webhook.ID,
+		URL:       webhook.URL,
+		Events:    events,
+		Active:    webhook.Active,
+		CreatedAt: webhook.CreatedAt,
+		UpdatedAt: webhook.UpdatedAt,
+	}
+}
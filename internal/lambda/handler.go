@@ -1,39 +1,117 @@
 package lambda
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/ammiranda/tree_service/cache"
+	"github.com/ammiranda/tree_service/config"
+	"github.com/ammiranda/tree_service/export"
+	"github.com/ammiranda/tree_service/handlers"
 	"github.com/ammiranda/tree_service/models"
 	"github.com/ammiranda/tree_service/repository"
 
 	"github.com/aws/aws-lambda-go/events"
 )
 
+// nodePathPrefix is the path prefix of routes addressing a single node
+const nodePathPrefix = "/api/node/"
+
+// treesPathPrefix is the path prefix of routes scoped to a tree
+const treesPathPrefix = "/api/trees/"
+
 // Handler represents the Lambda handler with its dependencies
 type Handler struct {
-	repo repository.Repository
+	repo            repository.Repository
+	attributeLimits models.AttributeLimits
+	// treeID is the tree the handler's repository is scoped to
+	treeID int64
 }
 
 // NewHandler creates a new Handler with the given repository
 func NewHandler(repo repository.Repository) *Handler {
 	return &Handler{
 		repo: repo,
+		attributeLimits: models.AttributeLimits{
+			MaxBytes: config.DefaultAttributesMaxBytes,
+		},
+		treeID: repository.DefaultTreeID,
 	}
 }
 
 // Handle processes API Gateway events
 func (h *Handler) Handle(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
-	// Route the request based on HTTP method and path
+	// Attribute changes to the caller in the node history
+	if actor := header(request, handlers.ActorHeader); actor != "" {
+		ctx = repository.WithActor(ctx, actor)
+	}
+
+	if strings.HasPrefix(request.Path, treesPathPrefix) {
+		return h.handleTreeScoped(ctx, request)
+	}
+	return h.route(ctx, request)
+}
+
+// handleTreeScoped serves /api/trees/{treeId}/... like the matching /api/...
+// route, scoped to that tree. Like the HTTP server's TreeScope it answers
+// 400 for a malformed tree ID and 404 for a tree that doesn't exist.
+func (h *Handler) handleTreeScoped(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	treeIDStr, rest, _ := strings.Cut(strings.TrimPrefix(request.Path, treesPathPrefix), "/")
+	if id := request.PathParameters["treeId"]; id != "" {
+		treeIDStr = id
+	}
+	treeID, err := strconv.ParseInt(treeIDStr, 10, 64)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       `{"error": "invalid tree ID"}`,
+		}, nil
+	}
+
+	if _, err := h.repo.GetTree(ctx, treeID); err != nil {
+		if errors.Is(err, repository.ErrTreeNotFound) {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 404,
+				Body:       `{"error": "tree not found"}`,
+			}, nil
+		}
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       fmt.Sprintf(`{"error": %q}`, err.Error()),
+		}, nil
+	}
+
+	scoped := *h
+	scoped.treeID = treeID
+	if treeID != repository.DefaultTreeID {
+		scoped.repo = h.repo.ForTree(treeID)
+	}
+	request.Path = "/api/" + rest
+	return scoped.route(ctx, request)
+}
+
+// route dispatches a request on its HTTP method and /api/... path
+func (h *Handler) route(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	switch {
 	case request.HTTPMethod == "GET" && request.Path == "/api/tree":
 		return h.handleGetTree(ctx, request)
+	case request.HTTPMethod == "GET" && request.Path == "/api/tree/export":
+		return h.handleExportTree(ctx, request)
 	case request.HTTPMethod == "POST" && request.Path == "/api/tree":
 		return h.handleCreateNode(ctx, request)
+	case request.HTTPMethod == "GET" && strings.HasPrefix(request.Path, nodePathPrefix):
+		return h.handleGetNode(ctx, request)
+	case request.HTTPMethod == "PUT" && strings.HasPrefix(request.Path, nodePathPrefix):
+		return h.handleUpdateNode(ctx, request)
+	case request.HTTPMethod == "DELETE" && strings.HasPrefix(request.Path, nodePathPrefix):
+		return h.handleDeleteNode(ctx, request)
 	default:
 		return events.APIGatewayProxyResponse{
 			StatusCode: 404,
@@ -59,8 +137,64 @@ func (h *Handler) handleGetTree(ctx context.Context, request events.APIGatewayPr
 		}
 	}
 
+	// Parse attribute filters
+	filter := make(map[string]string)
+	for param, value := range request.QueryStringParameters {
+		if !strings.HasPrefix(param, handlers.AttributeFilterPrefix) {
+			continue
+		}
+		key := strings.TrimPrefix(param, handlers.AttributeFilterPrefix)
+		if key == "" {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Body:       `{"error": "attribute filters must name a key, e.g. attr.status=active"}`,
+			}, nil
+		}
+		filter[key] = value
+	}
+
+	// An asOf parameter reads the tree as it was at that time
+	asOf, err := models.ParseAsOf(request.QueryStringParameters["asOf"])
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       fmt.Sprintf(`{"error": %q}`, err.Error()),
+		}, nil
+	}
+
+	// A cursor parameter switches to keyset pagination
+	if cursor, ok := request.QueryStringParameters["cursor"]; ok {
+		if asOf != nil {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Body:       `{"error": "asOf is not supported with cursor pagination"}`,
+			}, nil
+		}
+		if len(filter) > 0 {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Body:       `{"error": "attribute filters are not supported with cursor pagination"}`,
+			}, nil
+		}
+		return h.handleGetTreeByCursor(ctx, cursor, pageSize)
+	}
+
+	if asOf != nil {
+		if len(filter) > 0 {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Body:       `{"error": "attribute filters are not supported with asOf"}`,
+			}, nil
+		}
+		return h.handleGetTreeAsOf(ctx, *asOf, page, pageSize)
+	}
+
+	if len(filter) > 0 {
+		return h.handleGetTreeByAttributes(ctx, filter, page, pageSize)
+	}
+
 	// Try to get from cache first
-	if cachedResponse, found := cache.GetPaginatedTree(page, pageSize); found {
+	if cachedResponse, found := h.treeCache().GetPaginatedTree(page, pageSize); found {
 		body, err := json.Marshal(cachedResponse)
 		if err != nil {
 			return events.APIGatewayProxyResponse{
@@ -94,8 +228,14 @@ func (h *Handler) handleGetTree(ctx context.Context, request events.APIGatewayPr
 	modelNodes := make([]*models.Node, len(nodes))
 	for i, node := range nodes {
 		modelNodes[i] = &models.Node{
-			ID:    node.ID,
-			Label: node.Label,
+			ID:         node.ID,
+			Label:      node.Label,
+			ParentID:   node.ParentID,
+			Position:   node.Position,
+			Attributes: node.Attributes,
+			CreatedAt:  node.CreatedAt,
+			UpdatedAt:  node.UpdatedAt,
+			Version:    node.Version,
 		}
 	}
 
@@ -117,9 +257,12 @@ func (h *Handler) handleGetTree(ctx context.Context, request events.APIGatewayPr
 	response.Pagination.TotalPages = totalPages
 	response.Pagination.HasNext = hasNext
 	response.Pagination.HasPrev = hasPrev
+	if hasNext && len(rootNodes) > 0 {
+		response.Pagination.NextCursor = models.EncodeCursor(rootNodes[len(rootNodes)-1].ID)
+	}
 
 	// Store in cache
-	cache.SetPaginatedTree(page, pageSize, response)
+	h.treeCache().SetPaginatedTree(page, pageSize, response)
 
 	// Marshal response
 	body, err := json.Marshal(response)
@@ -136,6 +279,174 @@ func (h *Handler) handleGetTree(ctx context.Context, request events.APIGatewayPr
 	}, nil
 }
 
+// handleGetTreeByAttributes serves GET /api/tree with attribute filters.
+// Filtered pages bypass the cache, whose keys only cover pagination.
+func (h *Handler) handleGetTreeByAttributes(ctx context.Context, filter map[string]string, page, pageSize int) (events.APIGatewayProxyResponse, error) {
+	nodes, total, err := h.repo.GetNodesByAttributes(ctx, filter, page, pageSize)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       fmt.Sprintf(`{"error": "%v"}`, err),
+		}, nil
+	}
+
+	body, err := json.Marshal(pageResponse(nodes, total, page, pageSize))
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       fmt.Sprintf(`{"error": "Failed to marshal response: %v"}`, err),
+		}, nil
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Body:       string(body),
+	}, nil
+}
+
+// handleGetTreeAsOf serves GET /api/tree with asOf. Historical pages
+// bypass the cache, which only holds the live tree.
+func (h *Handler) handleGetTreeAsOf(ctx context.Context, asOf time.Time, page, pageSize int) (events.APIGatewayProxyResponse, error) {
+	nodes, total, err := h.repo.GetAllNodesAsOf(ctx, asOf, page, pageSize)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       fmt.Sprintf(`{"error": "%v"}`, err),
+		}, nil
+	}
+
+	body, err := json.Marshal(pageResponse(nodes, total, page, pageSize))
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       fmt.Sprintf(`{"error": "Failed to marshal response: %v"}`, err),
+		}, nil
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Body:       string(body),
+	}, nil
+}
+
+func (h *Handler) handleGetTreeByCursor(ctx context.Context, cursor string, pageSize int) (events.APIGatewayProxyResponse, error) {
+	var afterID int64
+	if cursor != "" {
+		id, err := models.DecodeCursor(cursor)
+		if err != nil {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Body:       `{"error": "invalid cursor"}`,
+			}, nil
+		}
+		afterID = id
+	}
+
+	response, found := h.treeCache().GetCursorTree(cursor, pageSize)
+	if !found {
+		nodes, hasMore, err := h.repo.GetNodesAfter(ctx, afterID, pageSize)
+		if err != nil {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 500,
+				Body:       fmt.Sprintf(`{"error": "%v"}`, err),
+			}, nil
+		}
+
+		// Convert repository nodes to model nodes
+		modelNodes := make([]*models.Node, len(nodes))
+		for i, node := range nodes {
+			modelNodes[i] = &models.Node{
+				ID:         node.ID,
+				Label:      node.Label,
+				ParentID:   node.ParentID,
+				Position:   node.Position,
+				Attributes: node.Attributes,
+				CreatedAt:  node.CreatedAt,
+				UpdatedAt:  node.UpdatedAt,
+				Version:    node.Version,
+			}
+		}
+		rootNodes := buildTree(modelNodes, nodes)
+		if rootNodes == nil {
+			rootNodes = make([]*models.Node, 0)
+		}
+
+		response = &cache.PaginatedTreeResponse{
+			Data: rootNodes,
+		}
+		response.Pagination.PageSize = pageSize
+		response.Pagination.Cursor = cursor
+		response.Pagination.HasNext = hasMore
+		response.Pagination.HasPrev = cursor != ""
+		if hasMore && len(rootNodes) > 0 {
+			response.Pagination.NextCursor = models.EncodeCursor(rootNodes[len(rootNodes)-1].ID)
+		}
+
+		// Store in cache
+		h.treeCache().SetCursorTree(cursor, pageSize, response)
+	}
+
+	body, err := json.Marshal(response)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       fmt.Sprintf(`{"error": "Failed to marshal response: %v"}`, err),
+		}, nil
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Body:       string(body),
+	}, nil
+}
+
+func (h *Handler) handleExportTree(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	formatStr := request.QueryStringParameters["format"]
+	if formatStr == "" {
+		formatStr = string(export.JSON)
+	}
+	format, err := export.ParseFormat(formatStr)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       `{"error": "format must be one of json, csv, dot or mermaid"}`,
+		}, nil
+	}
+
+	var rootID *int64
+	if rootIDStr := request.QueryStringParameters["rootId"]; rootIDStr != "" {
+		id, err := strconv.ParseInt(rootIDStr, 10, 64)
+		if err != nil {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Body:       `{"error": "invalid root ID"}`,
+			}, nil
+		}
+		rootID = &id
+	}
+
+	// Lambda responses can't be streamed, so the export is buffered
+	var buf bytes.Buffer
+	if err := handlers.ExportTree(ctx, h.repo, &buf, format, rootID); err != nil {
+		if errors.Is(err, repository.ErrNodeNotFound) {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 404,
+				Body:       `{"error": "node not found"}`,
+			}, nil
+		}
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       fmt.Sprintf(`{"error": "%v"}`, err),
+		}, nil
+	}
+
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Headers: map[string]string{
+			"Content-Type":        format.ContentType(),
+			"Content-Disposition": fmt.Sprintf(`attachment; filename="tree.%s"`, format.Extension()),
+		},
+		Body: buf.String(),
+	}, nil
+}
+
 func (h *Handler) handleCreateNode(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
 	var req models.CreateNodeRequest
 	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
@@ -152,13 +463,19 @@ func (h *Handler) handleCreateNode(ctx context.Context, request events.APIGatewa
 			Body:       fmt.Sprintf(`{"error": "%v"}`, err),
 		}, nil
 	}
+	if err := h.attributeLimits.Validate(req.Attributes); err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       fmt.Sprintf(`{"error": %q}`, err.Error()),
+		}, nil
+	}
 
 	// Create the node
 	var parentID *int64
 	if req.ParentID > 0 {
 		parentID = &req.ParentID
 	}
-	id, err := h.repo.CreateNode(ctx, req.Label, parentID)
+	id, err := h.repo.CreateNodeWithAttributes(ctx, req.Label, parentID, req.Attributes)
 	if err != nil {
 		if errors.Is(err, repository.ErrNodeNotFound) {
 			return events.APIGatewayProxyResponse{
@@ -166,6 +483,195 @@ func (h *Handler) handleCreateNode(ctx context.Context, request events.APIGatewa
 				Body:       `{"error": "parent node not found"}`,
 			}, nil
 		}
+		if errors.Is(err, repository.ErrCrossTreeParent) {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Body:       `{"error": "parent node belongs to another tree"}`,
+			}, nil
+		}
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       fmt.Sprintf(`{"error": "%v"}`, err),
+		}, nil
+	}
+
+	// Invalidate cache
+	h.treeCache().InvalidateCache()
+
+	response := map[string]interface{}{
+		"id":         id,
+		"label":      req.Label,
+		"parentId":   parentID,
+		"attributes": req.Attributes,
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       fmt.Sprintf(`{"error": "Failed to marshal response: %v"}`, err),
+		}, nil
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: 201,
+		Body:       string(body),
+	}, nil
+}
+
+// handleGetNode serves GET /api/node/{id}: the node with its descendants,
+// limited by an optional depth, as it is now or at an optional asOf time.
+// Historical reads carry no version and so no ETag.
+func (h *Handler) handleGetNode(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	nodeID, err := nodeIDFromPath(request)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       `{"error": "invalid node ID"}`,
+		}, nil
+	}
+
+	depth := repository.UnlimitedDepth
+	if depthStr := request.QueryStringParameters["depth"]; depthStr != "" {
+		d, err := strconv.Atoi(depthStr)
+		if err != nil || d < 0 {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Body:       `{"error": "depth must be a non-negative integer"}`,
+			}, nil
+		}
+		depth = d
+	}
+
+	asOf, err := models.ParseAsOf(request.QueryStringParameters["asOf"])
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       fmt.Sprintf(`{"error": %q}`, err.Error()),
+		}, nil
+	}
+
+	var nodes []*repository.Node
+	if asOf != nil {
+		nodes, err = h.repo.GetSubtreeAsOf(ctx, nodeID, depth, *asOf)
+	} else {
+		nodes, err = h.repo.GetSubtree(ctx, nodeID, depth)
+	}
+	if err != nil {
+		if errors.Is(err, repository.ErrNodeNotFound) {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 404,
+				Body:       `{"error": "node not found"}`,
+			}, nil
+		}
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       fmt.Sprintf(`{"error": "%v"}`, err),
+		}, nil
+	}
+
+	// The requested node is the only root since its parent is not in the set
+	rootNodes, err := handlers.BuildTreeFromNodes(nodes)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       fmt.Sprintf(`{"error": "%v"}`, err),
+		}, nil
+	}
+
+	body, err := json.Marshal(rootNodes[0])
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       fmt.Sprintf(`{"error": "Failed to marshal response: %v"}`, err),
+		}, nil
+	}
+	response := events.APIGatewayProxyResponse{
+		StatusCode: 200,
+		Body:       string(body),
+	}
+	if asOf == nil {
+		response.Headers = map[string]string{
+			"ETag": models.FormatETag(rootNodes[0].Version),
+		}
+	}
+	return response, nil
+}
+
+func (h *Handler) handleUpdateNode(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	nodeID, err := nodeIDFromPath(request)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       `{"error": "invalid node ID"}`,
+		}, nil
+	}
+
+	var req models.UpdateNodeRequest
+	if err := json.Unmarshal([]byte(request.Body), &req); err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       fmt.Sprintf(`{"error": "Invalid request: %v"}`, err),
+		}, nil
+	}
+
+	// Validate the request
+	if err := req.Validate(); err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       fmt.Sprintf(`{"error": "%v"}`, err),
+		}, nil
+	}
+	if err := h.attributeLimits.Validate(req.Attributes); err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       fmt.Sprintf(`{"error": %q}`, err.Error()),
+		}, nil
+	}
+
+	version, err := models.ParseIfMatch(header(request, "If-Match"))
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       fmt.Sprintf(`{"error": %q}`, err.Error()),
+		}, nil
+	}
+
+	// Replace the label, parent and attributes
+	patch := repository.NodePatch{
+		Label:      &req.Label,
+		SetParent:  true,
+		ParentID:   req.ParentID,
+		Attributes: req.ReplacementAttributes(),
+	}
+	if version != nil {
+		err = h.repo.UpdateNodeIfVersion(ctx, nodeID, *version, patch)
+	} else {
+		err = h.repo.PatchNode(ctx, nodeID, patch)
+	}
+	if err != nil {
+		if errors.Is(err, repository.ErrNodeNotFound) {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 404,
+				Body:       `{"error": "node not found"}`,
+			}, nil
+		}
+		if errors.Is(err, repository.ErrVersionConflict) {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 412,
+				Body:       `{"error": "node has been modified since it was read"}`,
+			}, nil
+		}
+		if errors.Is(err, repository.ErrCycleDetected) {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 409,
+				Body:       `{"error": "cannot move a node under itself or one of its descendants"}`,
+			}, nil
+		}
+		if errors.Is(err, repository.ErrCrossTreeParent) {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 400,
+				Body:       `{"error": "parent node belongs to another tree"}`,
+			}, nil
+		}
 		return events.APIGatewayProxyResponse{
 			StatusCode: 500,
 			Body:       fmt.Sprintf(`{"error": "%v"}`, err),
@@ -173,12 +679,21 @@ func (h *Handler) handleCreateNode(ctx context.Context, request events.APIGatewa
 	}
 
 	// Invalidate cache
-	cache.InvalidateCache()
+	h.treeCache().InvalidateCache()
+
+	node, err := h.repo.GetNode(ctx, nodeID)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       fmt.Sprintf(`{"error": "%v"}`, err),
+		}, nil
+	}
 
 	response := map[string]interface{}{
-		"id":       id,
+		"id":       nodeID,
 		"label":    req.Label,
 		"parentId": req.ParentID,
+		"version":  node.Version,
 	}
 	body, err := json.Marshal(response)
 	if err != nil {
@@ -188,11 +703,159 @@ func (h *Handler) handleCreateNode(ctx context.Context, request events.APIGatewa
 		}, nil
 	}
 	return events.APIGatewayProxyResponse{
-		StatusCode: 201,
+		StatusCode: 200,
+		Headers: map[string]string{
+			"ETag": models.FormatETag(node.Version),
+		},
+		Body: string(body),
+	}, nil
+}
+
+func (h *Handler) handleDeleteNode(ctx context.Context, request events.APIGatewayProxyRequest) (events.APIGatewayProxyResponse, error) {
+	nodeID, err := nodeIDFromPath(request)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       `{"error": "invalid node ID"}`,
+		}, nil
+	}
+
+	mode := repository.DeleteModeCascade
+	if modeStr := request.QueryStringParameters["mode"]; modeStr != "" {
+		mode = repository.DeleteMode(modeStr)
+	}
+	if !mode.Valid() {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       `{"error": "mode must be one of cascade, reparent or refuse"}`,
+		}, nil
+	}
+
+	version, err := models.ParseIfMatch(header(request, "If-Match"))
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 400,
+			Body:       fmt.Sprintf(`{"error": %q}`, err.Error()),
+		}, nil
+	}
+
+	var deleted int64
+	if version != nil {
+		deleted, err = h.repo.DeleteNodeIfVersion(ctx, nodeID, *version, mode)
+	} else {
+		deleted, err = h.repo.DeleteNode(ctx, nodeID, mode)
+	}
+	if err != nil {
+		if errors.Is(err, repository.ErrNodeNotFound) {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 404,
+				Body:       `{"error": "node not found"}`,
+			}, nil
+		}
+		if errors.Is(err, repository.ErrVersionConflict) {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 412,
+				Body:       `{"error": "node has been modified since it was read"}`,
+			}, nil
+		}
+		if errors.Is(err, repository.ErrNodeHasChildren) {
+			return events.APIGatewayProxyResponse{
+				StatusCode: 409,
+				Body:       `{"error": "node has children"}`,
+			}, nil
+		}
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       fmt.Sprintf(`{"error": "%v"}`, err),
+		}, nil
+	}
+
+	// Invalidate cache
+	h.treeCache().InvalidateCache()
+
+	response := map[string]interface{}{
+		"id":      nodeID,
+		"mode":    mode,
+		"deleted": deleted,
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		return events.APIGatewayProxyResponse{
+			StatusCode: 500,
+			Body:       fmt.Sprintf(`{"error": "Failed to marshal response: %v"}`, err),
+		}, nil
+	}
+	return events.APIGatewayProxyResponse{
+		StatusCode: 200,
 		Body:       string(body),
 	}, nil
 }
 
+// treeCache returns the cache namespaced to the handler's tree, so writes to
+// one tree only invalidate that tree's cached pages
+func (h *Handler) treeCache() cache.CacheProvider {
+	return cache.ForTree(h.treeID)
+}
+
+// nodeIDFromPath extracts the node ID from a /api/node/{id} request,
+// preferring the API Gateway path parameter when one is provided
+func nodeIDFromPath(request events.APIGatewayProxyRequest) (int64, error) {
+	idStr := request.PathParameters["id"]
+	if idStr == "" {
+		idStr = strings.TrimPrefix(request.Path, nodePathPrefix)
+	}
+	return strconv.ParseInt(idStr, 10, 64)
+}
+
+// header returns a request header by name. API Gateway passes headers with
+// the case the client sent, so the lookup falls back to a case-insensitive scan.
+func header(request events.APIGatewayProxyRequest, name string) string {
+	if value, ok := request.Headers[name]; ok {
+		return value
+	}
+	for key, value := range request.Headers {
+		if strings.EqualFold(key, name) {
+			return value
+		}
+	}
+	return ""
+}
+
+// pageResponse builds an uncached GET /api/tree page from a page of nodes.
+// Unlike the live tree, a page without nodes is an empty page rather than
+// a 404.
+func pageResponse(nodes []*repository.Node, total int64, page, pageSize int) *cache.PaginatedTreeResponse {
+	// Convert repository nodes to model nodes
+	modelNodes := make([]*models.Node, len(nodes))
+	for i, node := range nodes {
+		modelNodes[i] = &models.Node{
+			ID:         node.ID,
+			Label:      node.Label,
+			ParentID:   node.ParentID,
+			Position:   node.Position,
+			Attributes: node.Attributes,
+			CreatedAt:  node.CreatedAt,
+			UpdatedAt:  node.UpdatedAt,
+			Version:    node.Version,
+		}
+	}
+	rootNodes := buildTree(modelNodes, nodes)
+	if rootNodes == nil {
+		rootNodes = make([]*models.Node, 0)
+	}
+
+	response := &cache.PaginatedTreeResponse{
+		Data: rootNodes,
+	}
+	response.Pagination.Page = page
+	response.Pagination.PageSize = pageSize
+	response.Pagination.Total = total
+	response.Pagination.TotalPages = (total + int64(pageSize) - 1) / int64(pageSize)
+	response.Pagination.HasNext = int64(page) < response.Pagination.TotalPages
+	response.Pagination.HasPrev = page > 1
+	return response
+}
+
 // buildTree converts a flat list of nodes into a tree structure
 func buildTree(modelNodes []*models.Node, repoNodes []*repository.Node) []*models.Node {
 	// Create a map of nodes by ID for quick lookup
@@ -214,5 +877,16 @@ func buildTree(modelNodes []*models.Node, repoNodes []*repository.Node) []*model
 		}
 	}
 
+	// Order siblings by position, as handlers.BuildTreeFromNodes does
+	for _, node := range modelNodes {
+		children := node.Children
+		sort.SliceStable(children, func(i, j int) bool {
+			if children[i].Position != children[j].Position {
+				return children[i].Position < children[j].Position
+			}
+			return children[i].ID < children[j].ID
+		})
+	}
+
 	return rootNodes
 }
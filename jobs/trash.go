@@ -0,0 +1,63 @@
+package jobs
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/ammiranda/tree_service/repository"
+)
+
+// PurgeExpiredTrash permanently deletes the nodes of every tree that have
+// been in the trash for longer than retention.
+//
+// Parameters:
+//   - ctx: Context for the operation
+//   - repo: Repository to purge; each tree is purged through ForTree
+//   - retention: How long trashed nodes are kept
+//   - now: The current time
+//
+// Returns:
+//   - int64: The number of nodes purged
+//   - error: Any error that occurred during the operation
+func PurgeExpiredTrash(ctx context.Context, repo repository.Repository, retention time.Duration, now time.Time) (int64, error) {
+	trees, err := repo.ListTrees(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error listing trees: %w", err)
+	}
+
+	before := now.Add(-retention)
+	var purged int64
+	for _, tree := range trees {
+		n, err := repo.ForTree(tree.ID).PurgeTrash(ctx, before)
+		if err != nil {
+			return purged, fmt.Errorf("error purging trash of tree %d: %w", tree.ID, err)
+		}
+		purged += n
+	}
+	return purged, nil
+}
+
+// RunTrashPurge runs PurgeExpiredTrash every interval until ctx is
+// cancelled. Errors are logged and retried on the next run.
+func RunTrashPurge(ctx context.Context, repo repository.Repository, retention, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			purged, err := PurgeExpiredTrash(ctx, repo, retention, now)
+			if err != nil {
+				log.Printf("Warning: Failed to purge trash: %v", err)
+				continue
+			}
+			if purged > 0 {
+				log.Printf("Purged %d expired nodes from the trash", purged)
+			}
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package jobs
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/ammiranda/tree_service/repository"
+)
+
+// Headers sent with every webhook delivery
+const (
+	// WebhookSignatureHeader carries "sha256=" followed by the hex encoded
+	// HMAC-SHA256 of the request body, keyed with the webhook's secret
+	WebhookSignatureHeader = "X-Webhook-Signature"
+	// WebhookEventHeader carries the event type, e.g. "node.created"
+	WebhookEventHeader = "X-Webhook-Event"
+	// WebhookDeliveryHeader carries the delivery ID, which stays the same
+	// across retries so receivers can ignore duplicates
+	WebhookDeliveryHeader = "X-Webhook-Delivery"
+)
+
+// webhookBatchSize is how many deliveries of a tree are claimed at once
+const webhookBatchSize = 100
+
+// maxErrorBodyBytes is how much of a failed response's body is kept in the
+// delivery log
+const maxErrorBodyBytes = 512
+
+// SignWebhookPayload computes the value of the WebhookSignatureHeader for a
+// payload
+func SignWebhookPayload(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookDispatcher delivers queued webhook deliveries, retrying failed
+// ones with exponential backoff until they succeed or run out of attempts
+type WebhookDispatcher struct {
+	repo           repository.Repository
+	client         *http.Client
+	maxAttempts    int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+}
+
+// NewWebhookDispatcher creates a dispatcher for the webhooks of every tree
+// in repo. Failed deliveries are retried after initialBackoff, doubling up to
+// maxBackoff, and are marked dead after maxAttempts attempts.
+func NewWebhookDispatcher(repo repository.Repository, client *http.Client, maxAttempts int, initialBackoff, maxBackoff time.Duration) *WebhookDispatcher {
+	return &WebhookDispatcher{
+		repo:           repo,
+		client:         client,
+		maxAttempts:    maxAttempts,
+		initialBackoff: initialBackoff,
+		maxBackoff:     maxBackoff,
+	}
+}
+
+// Backoff returns how long to wait before retrying a delivery that has
+// failed attempts times
+func (d *WebhookDispatcher) Backoff(attempts int) time.Duration {
+	backoff := d.initialBackoff
+	for i := 1; i < attempts && backoff < d.maxBackoff; i++ {
+		backoff *= 2
+	}
+	if backoff > d.maxBackoff {
+		backoff = d.maxBackoff
+	}
+	return backoff
+}
+
+// DeliverDue attempts every delivery that is due, tree by tree.
+//
+// Parameters:
+//   - ctx: Context for the operation
+//   - now: The current time
+//
+// Returns:
+//   - int: The number of deliveries attempted
+//   - error: Any error that occurred reading or updating the deliveries;
+//     failed deliveries themselves are recorded, not returned
+func (d *WebhookDispatcher) DeliverDue(ctx context.Context, now time.Time) (int, error) {
+	trees, err := d.repo.ListTrees(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("error listing trees: %w", err)
+	}
+
+	// A claim lasts until every delivery of the batch could have timed out
+	lease := webhookBatchSize * d.client.Timeout
+	if lease <= 0 {
+		lease = time.Hour
+	}
+
+	attempted := 0
+	for _, tree := range trees {
+		repo := d.repo.ForTree(tree.ID)
+		deliveries, err := repo.ClaimWebhookDeliveries(ctx, now, lease, webhookBatchSize)
+		if err != nil {
+			return attempted, fmt.Errorf("error claiming deliveries of tree %d: %w", tree.ID, err)
+		}
+
+		webhooks := make(map[int64]*repository.Webhook)
+		for _, delivery := range deliveries {
+			webhook, ok := webhooks[delivery.WebhookID]
+			if !ok {
+				webhook, err = repo.GetWebhook(ctx, delivery.WebhookID)
+				if errors.Is(err, repository.ErrWebhookNotFound) {
+					// Deleted since the claim, together with its deliveries
+					continue
+				}
+				if err != nil {
+					return attempted, fmt.Errorf("error getting webhook %d: %w", delivery.WebhookID, err)
+				}
+				webhooks[webhook.ID] = webhook
+			}
+
+			if err := repo.RecordWebhookAttempt(ctx, delivery.ID, d.attempt(ctx, webhook, delivery)); err != nil {
+				return attempted, fmt.Errorf("error recording delivery %d: %w", delivery.ID, err)
+			}
+			attempted++
+		}
+	}
+	return attempted, nil
+}
+
+// attempt POSTs a delivery to its webhook and decides what happens next
+func (d *WebhookDispatcher) attempt(ctx context.Context, webhook *repository.Webhook, delivery *repository.WebhookDelivery) repository.DeliveryAttempt {
+	status, err := d.send(ctx, webhook, delivery)
+	result := repository.DeliveryAttempt{
+		Status:         repository.DeliverySucceeded,
+		ResponseStatus: status,
+		AttemptedAt:    time.Now(),
+	}
+	if err == nil {
+		return result
+	}
+
+	result.Error = err.Error()
+	attempts := delivery.Attempts + 1
+	if attempts >= d.maxAttempts {
+		result.Status = repository.DeliveryDead
+		return result
+	}
+	result.Status = repository.DeliveryPending
+	result.NextAttemptAt = result.AttemptedAt.Add(d.Backoff(attempts))
+	return result
+}
+
+// send POSTs a delivery's payload and returns the response status, or 0 if
+// there was no response. Any status outside 2xx is an error.
+func (d *WebhookDispatcher) send(ctx context.Context, webhook *repository.Webhook, delivery *repository.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("error creating request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "tree-service-webhooks")
+	req.Header.Set(WebhookEventHeader, delivery.EventType)
+	req.Header.Set(WebhookDeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	req.Header.Set(WebhookSignatureHeader, SignWebhookPayload(webhook.Secret, delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if err := resp.Body.Close(); err != nil {
+			log.Printf("Warning: Error closing webhook response body: %v", err)
+		}
+	}()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodyBytes))
+		if len(body) > 0 {
+			return resp.StatusCode, fmt.Errorf("receiver responded %s: %s", resp.Status, body)
+		}
+		return resp.StatusCode, fmt.Errorf("receiver responded %s", resp.Status)
+	}
+	// Drain the body so the connection can be reused
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return resp.StatusCode, nil
+}
+
+// Run calls DeliverDue every interval until ctx is cancelled. Errors are
+// logged and retried on the next run.
+func (d *WebhookDispatcher) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if _, err := d.DeliverDue(ctx, now); err != nil {
+				log.Printf("Warning: Failed to deliver webhooks: %v", err)
+			}
+		}
+	}
+}
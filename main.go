@@ -3,16 +3,26 @@ package main
 import (
 	"context"
 	"log"
+	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/ammiranda/tree_service/cache"
 	"github.com/ammiranda/tree_service/config"
+	"github.com/ammiranda/tree_service/feed"
 	"github.com/ammiranda/tree_service/handlers"
+	"github.com/ammiranda/tree_service/jobs"
+	"github.com/ammiranda/tree_service/models"
 	"github.com/ammiranda/tree_service/repository"
 
 	"github.com/gin-gonic/gin"
 )
 
+// shutdownTimeout is how long in-flight requests get to finish on shutdown
+const shutdownTimeout = 10 * time.Second
+
 func main() {
 	// Set development environment
 	if err := os.Setenv("APP_ENV", "development"); err != nil {
@@ -26,7 +36,7 @@ func main() {
 	cfgProvider := config.NewEnvProvider("")
 
 	// Initialize repository
-	repo, err := repository.NewPostgresRepository(cfgProvider)
+	repo, err := repository.New(cfgProvider)
 	if err != nil {
 		log.Fatal("Failed to create repository:", err)
 	}
@@ -44,22 +54,120 @@ func main() {
 		log.Fatal("Failed to initialize cache:", err)
 	}
 
+	// Load bulk import limits
+	importCfg, err := config.GetImportConfig(ctx, cfgProvider)
+	if err != nil {
+		log.Fatal("Failed to load import config:", err)
+	}
+
+	// Load batch request limits
+	batchCfg, err := config.GetBatchConfig(ctx, cfgProvider)
+	if err != nil {
+		log.Fatal("Failed to load batch config:", err)
+	}
+
+	// Load node attribute limits
+	attributesCfg, err := config.GetAttributesConfig(ctx, cfgProvider)
+	if err != nil {
+		log.Fatal("Failed to load attributes config:", err)
+	}
+
+	// Purge expired trash in the background
+	trashCfg, err := config.GetTrashConfig(ctx, cfgProvider)
+	if err != nil {
+		log.Fatal("Failed to load trash config:", err)
+	}
+	jobsCtx, stopJobs := context.WithCancel(ctx)
+	defer stopJobs()
+	go jobs.RunTrashPurge(jobsCtx, repo, trashCfg.Retention, trashCfg.PurgeInterval)
+
+	// Deliver webhooks in the background
+	webhookCfg, err := config.GetWebhookConfig(ctx, cfgProvider)
+	if err != nil {
+		log.Fatal("Failed to load webhook config:", err)
+	}
+	dispatcher := jobs.NewWebhookDispatcher(repo, &http.Client{Timeout: webhookCfg.Timeout},
+		webhookCfg.MaxAttempts, webhookCfg.InitialBackoff, webhookCfg.MaxBackoff)
+	go dispatcher.Run(jobsCtx, webhookCfg.PollInterval)
+
+	// Load change event feed settings
+	eventsCfg, err := config.GetEventsConfig(ctx, cfgProvider)
+	if err != nil {
+		log.Fatal("Failed to load events config:", err)
+	}
+
 	// Initialize handlers
-	treeHandler := handlers.NewTreeHandler(repo)
+	treeHandler := handlers.NewTreeHandler(repo,
+		handlers.WithImportLimits(importCfg.MaxNodes, importCfg.MaxDepth),
+		handlers.WithBatchLimit(batchCfg.MaxOperations),
+		handlers.WithAttributeLimits(models.AttributeLimits{
+			MaxBytes:   attributesCfg.MaxBytes,
+			KeyPattern: attributesCfg.KeyPattern,
+		}),
+		handlers.WithEventBroker(feed.NewBroker(eventsCfg.BufferSize)),
+	)
 
 	// Initialize router
 	r := gin.Default()
 
-	// API routes
-	api := r.Group("/api")
+	// API routes; the unscoped ones operate on the default tree
+	api := r.Group("/api", handlers.ActorContext())
+	registerTreeRoutes(api, treeHandler)
+
+	// Named trees, each with the same routes as the default tree
+	api.GET("/trees", treeHandler.ListTrees)
+	api.POST("/trees", treeHandler.CreateTree)
+	trees := api.Group("/trees/:treeId", treeHandler.TreeScope())
 	{
-		api.GET("/tree", treeHandler.GetTree)
-		api.POST("/tree", treeHandler.CreateNode)
-		api.PUT("/node/:id", treeHandler.UpdateNode)
+		trees.GET("", treeHandler.GetTreeInfo)
+		trees.DELETE("", treeHandler.DeleteTree)
+		registerTreeRoutes(trees, treeHandler)
 	}
 
 	// Start server
-	if err := r.Run(":8080"); err != nil {
-		log.Fatal("Failed to start server:", err)
+	srv := &http.Server{Addr: ":8080", Handler: r}
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal("Failed to start server:", err)
+		}
+	}()
+
+	// Shut down gracefully on SIGINT or SIGTERM, so the background jobs stop
+	// and the repository is cleaned up before exiting
+	signalCtx, stopSignals := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stopSignals()
+	<-signalCtx.Done()
+
+	shutdownCtx, cancelShutdown := context.WithTimeout(ctx, shutdownTimeout)
+	defer cancelShutdown()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		log.Printf("Warning: Failed to shut down server: %v", err)
 	}
 }
+
+// registerTreeRoutes registers the node and tree routes on group
+func registerTreeRoutes(group *gin.RouterGroup, treeHandler *handlers.TreeHandler) {
+	group.GET("/tree", treeHandler.GetTree)
+	group.GET("/tree/export", treeHandler.ExportTree)
+	group.POST("/tree", treeHandler.CreateNode)
+	group.POST("/tree/import", treeHandler.ImportTree)
+	group.POST("/batch", treeHandler.ApplyBatch)
+	group.GET("/search", treeHandler.Search)
+	group.GET("/node/:id", treeHandler.GetNode)
+	group.GET("/node/:id/ancestors", treeHandler.GetAncestors)
+	group.GET("/node/:id/history", treeHandler.GetNodeHistory)
+	group.PUT("/node/:id", treeHandler.UpdateNode)
+	group.PATCH("/node/:id", treeHandler.PatchNode)
+	group.POST("/node/:id/move", treeHandler.MoveNode)
+	group.DELETE("/node/:id", treeHandler.DeleteNode)
+	group.GET("/trash", treeHandler.ListTrash)
+	group.POST("/trash/:id/restore", treeHandler.RestoreNode)
+	group.DELETE("/trash/:id", treeHandler.PurgeNode)
+	group.GET("/events", treeHandler.StreamEvents)
+	group.GET("/webhooks", treeHandler.ListWebhooks)
+	group.POST("/webhooks", treeHandler.CreateWebhook)
+	group.GET("/webhooks/:id", treeHandler.GetWebhook)
+	group.PATCH("/webhooks/:id", treeHandler.UpdateWebhook)
+	group.DELETE("/webhooks/:id", treeHandler.DeleteWebhook)
+	group.GET("/webhooks/:id/deliveries", treeHandler.ListWebhookDeliveries)
+}
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_nodes_parent_position;
+ALTER TABLE nodes DROP COLUMN IF EXISTS position;
@@ -0,0 +1,9 @@
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS position DOUBLE PRECISION;
+
+-- Keep the existing id order among siblings
+UPDATE nodes SET position = id WHERE position IS NULL;
+
+ALTER TABLE nodes ALTER COLUMN position SET NOT NULL;
+ALTER TABLE nodes ALTER COLUMN position SET DEFAULT 1;
+
+CREATE INDEX IF NOT EXISTS idx_nodes_parent_position ON nodes (parent_id, position);
@@ -0,0 +1 @@
+DROP INDEX IF EXISTS idx_nodes_label_trgm;
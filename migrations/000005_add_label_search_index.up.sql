@@ -0,0 +1,3 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS idx_nodes_label_trgm ON nodes USING GIN (label gin_trgm_ops);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_nodes_tree_parent;
+ALTER TABLE nodes DROP COLUMN IF EXISTS tree_id;
+DROP TABLE IF EXISTS trees;
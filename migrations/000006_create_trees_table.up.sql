@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS trees (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Existing nodes move into the default tree, which always has ID 1
+INSERT INTO trees (id, name) VALUES (1, 'default') ON CONFLICT (id) DO NOTHING;
+SELECT setval(pg_get_serial_sequence('trees', 'id'), (SELECT MAX(id) FROM trees));
+
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS tree_id INTEGER NOT NULL DEFAULT 1 REFERENCES trees(id);
+
+CREATE INDEX IF NOT EXISTS idx_nodes_tree_parent ON nodes (tree_id, parent_id);
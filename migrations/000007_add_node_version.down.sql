@@ -0,0 +1,3 @@
+DROP TRIGGER IF EXISTS increment_nodes_version ON nodes;
+DROP FUNCTION IF EXISTS increment_node_version();
+ALTER TABLE nodes DROP COLUMN IF EXISTS version;
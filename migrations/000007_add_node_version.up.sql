@@ -0,0 +1,16 @@
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+
+-- Every update bumps the version, so no write path can forget to
+CREATE OR REPLACE FUNCTION increment_node_version()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.version = OLD.version + 1;
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS increment_nodes_version ON nodes;
+CREATE TRIGGER increment_nodes_version
+    BEFORE UPDATE ON nodes
+    FOR EACH ROW
+    EXECUTE FUNCTION increment_node_version();
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_nodes_tree_deleted_at;
+ALTER TABLE nodes DROP COLUMN IF EXISTS deleted_at;
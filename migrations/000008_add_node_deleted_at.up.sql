@@ -0,0 +1,3 @@
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+CREATE INDEX IF NOT EXISTS idx_nodes_tree_deleted_at ON nodes (tree_id, deleted_at) WHERE deleted_at IS NOT NULL;
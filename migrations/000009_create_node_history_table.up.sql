@@ -0,0 +1,17 @@
+-- No foreign key to nodes: history is kept after a node is purged
+CREATE TABLE IF NOT EXISTS node_history (
+    id BIGSERIAL PRIMARY KEY,
+    node_id INTEGER NOT NULL,
+    tree_id INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    old_label TEXT,
+    new_label TEXT,
+    old_parent_id INTEGER,
+    new_parent_id INTEGER,
+    position DOUBLE PRECISION NOT NULL,
+    attributes JSONB NOT NULL DEFAULT '{}'::jsonb,
+    actor TEXT,
+    changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_node_history_node ON node_history (node_id, id);
@@ -0,0 +1,19 @@
+DROP TRIGGER IF EXISTS move_nodes_descendants ON nodes;
+DROP FUNCTION IF EXISTS move_node_descendants();
+DROP TRIGGER IF EXISTS set_nodes_path ON nodes;
+DROP FUNCTION IF EXISTS set_node_path();
+DROP INDEX IF EXISTS idx_nodes_path;
+
+DROP TRIGGER IF EXISTS update_nodes_updated_at ON nodes;
+CREATE TRIGGER update_nodes_updated_at
+    BEFORE UPDATE ON nodes
+    FOR EACH ROW
+    EXECUTE FUNCTION update_updated_at_column();
+
+DROP TRIGGER IF EXISTS increment_nodes_version ON nodes;
+CREATE TRIGGER increment_nodes_version
+    BEFORE UPDATE ON nodes
+    FOR EACH ROW
+    EXECUTE FUNCTION increment_node_version();
+
+ALTER TABLE nodes DROP COLUMN IF EXISTS path;
@@ -0,0 +1,62 @@
+ALTER TABLE nodes ADD COLUMN IF NOT EXISTS path TEXT;
+
+-- Rewriting the paths of a moved node's descendants only changes their
+-- path, which is not a modification of the nodes themselves
+DROP TRIGGER IF EXISTS increment_nodes_version ON nodes;
+CREATE TRIGGER increment_nodes_version
+    BEFORE UPDATE ON nodes
+    FOR EACH ROW
+    WHEN (OLD.path IS NOT DISTINCT FROM NEW.path OR OLD.parent_id IS DISTINCT FROM NEW.parent_id)
+    EXECUTE FUNCTION increment_node_version();
+
+DROP TRIGGER IF EXISTS update_nodes_updated_at ON nodes;
+CREATE TRIGGER update_nodes_updated_at
+    BEFORE UPDATE ON nodes
+    FOR EACH ROW
+    WHEN (OLD.path IS NOT DISTINCT FROM NEW.path OR OLD.parent_id IS DISTINCT FROM NEW.parent_id)
+    EXECUTE FUNCTION update_updated_at_column();
+
+-- Backfill the existing rows from the roots down
+WITH RECURSIVE paths AS (
+    SELECT id, '/' || id || '/' AS path FROM nodes WHERE parent_id IS NULL
+    UNION ALL
+    SELECT n.id, p.path || n.id || '/' FROM nodes n
+    INNER JOIN paths p ON n.parent_id = p.id
+)
+UPDATE nodes SET path = paths.path FROM paths WHERE nodes.id = paths.id;
+
+ALTER TABLE nodes ALTER COLUMN path SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_nodes_path ON nodes (path text_pattern_ops);
+
+-- A node's path is its parent's path followed by its own ID
+CREATE OR REPLACE FUNCTION set_node_path()
+RETURNS TRIGGER AS $$
+BEGIN
+    NEW.path = COALESCE((SELECT path FROM nodes WHERE id = NEW.parent_id), '/') || NEW.id || '/';
+    RETURN NEW;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS set_nodes_path ON nodes;
+CREATE TRIGGER set_nodes_path
+    BEFORE INSERT OR UPDATE OF parent_id ON nodes
+    FOR EACH ROW
+    EXECUTE FUNCTION set_node_path();
+
+-- Moving a node moves its whole subtree
+CREATE OR REPLACE FUNCTION move_node_descendants()
+RETURNS TRIGGER AS $$
+BEGIN
+    UPDATE nodes SET path = NEW.path || substr(path, length(OLD.path) + 1)
+    WHERE path LIKE OLD.path || '%' AND id <> NEW.id;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS move_nodes_descendants ON nodes;
+CREATE TRIGGER move_nodes_descendants
+    AFTER UPDATE OF parent_id ON nodes
+    FOR EACH ROW
+    WHEN (OLD.path IS DISTINCT FROM NEW.path)
+    EXECUTE FUNCTION move_node_descendants();
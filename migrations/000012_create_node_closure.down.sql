@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS move_nodes_closure ON nodes;
+DROP FUNCTION IF EXISTS move_node_closure();
+DROP TRIGGER IF EXISTS insert_nodes_closure ON nodes;
+DROP FUNCTION IF EXISTS insert_node_closure();
+DROP TABLE IF EXISTS node_closure;
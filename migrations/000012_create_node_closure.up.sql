@@ -0,0 +1,64 @@
+-- node_closure holds a row for every node and each of its ancestors,
+-- including the node itself at depth 0
+CREATE TABLE IF NOT EXISTS node_closure (
+    ancestor_id INTEGER NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    descendant_id INTEGER NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+    depth INTEGER NOT NULL,
+    PRIMARY KEY (ancestor_id, descendant_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_node_closure_descendant_id ON node_closure(descendant_id);
+
+-- Backfill the existing rows from every node down
+WITH RECURSIVE closure AS (
+    SELECT id AS ancestor_id, id AS descendant_id, 0 AS depth FROM nodes
+    UNION ALL
+    SELECT c.ancestor_id, n.id, c.depth + 1 FROM nodes n
+    INNER JOIN closure c ON n.parent_id = c.descendant_id
+)
+INSERT INTO node_closure (ancestor_id, descendant_id, depth)
+SELECT ancestor_id, descendant_id, depth FROM closure
+ON CONFLICT DO NOTHING;
+
+-- A new node's ancestors are itself and its parent's ancestors
+CREATE OR REPLACE FUNCTION insert_node_closure()
+RETURNS TRIGGER AS $$
+BEGIN
+    INSERT INTO node_closure (ancestor_id, descendant_id, depth)
+    SELECT NEW.id, NEW.id, 0
+    UNION ALL
+    SELECT ancestor_id, NEW.id, depth + 1 FROM node_closure WHERE descendant_id = NEW.parent_id;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS insert_nodes_closure ON nodes;
+CREATE TRIGGER insert_nodes_closure
+    AFTER INSERT ON nodes
+    FOR EACH ROW
+    EXECUTE FUNCTION insert_node_closure();
+
+-- Moving a node detaches its whole subtree from the old ancestors and
+-- attaches it below the new parent's
+CREATE OR REPLACE FUNCTION move_node_closure()
+RETURNS TRIGGER AS $$
+BEGIN
+    DELETE FROM node_closure
+    WHERE descendant_id IN (SELECT descendant_id FROM node_closure WHERE ancestor_id = NEW.id)
+        AND ancestor_id NOT IN (SELECT descendant_id FROM node_closure WHERE ancestor_id = NEW.id);
+
+    INSERT INTO node_closure (ancestor_id, descendant_id, depth)
+    SELECT a.ancestor_id, d.descendant_id, a.depth + d.depth + 1
+    FROM node_closure a
+    CROSS JOIN node_closure d
+    WHERE a.descendant_id = NEW.parent_id AND d.ancestor_id = NEW.id;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS move_nodes_closure ON nodes;
+CREATE TRIGGER move_nodes_closure
+    AFTER UPDATE OF parent_id ON nodes
+    FOR EACH ROW
+    WHEN (OLD.parent_id IS DISTINCT FROM NEW.parent_id)
+    EXECUTE FUNCTION move_node_closure();
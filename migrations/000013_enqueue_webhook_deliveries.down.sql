@@ -0,0 +1,2 @@
+DROP TRIGGER IF EXISTS enqueue_node_history_webhooks ON node_history;
+DROP FUNCTION IF EXISTS enqueue_webhook_deliveries();
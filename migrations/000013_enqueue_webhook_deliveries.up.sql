@@ -0,0 +1,68 @@
+-- Every history entry is queued for the subscribed active webhooks of its
+-- tree by the statement that records it, so the deliveries commit or roll
+-- back with the change. The payload has the JSON of the events of
+-- GET /api/events, with the entry's ID as the event ID.
+CREATE OR REPLACE FUNCTION enqueue_webhook_deliveries()
+RETURNS TRIGGER AS $$
+DECLARE
+    change_type TEXT;
+    change_payload JSONB;
+BEGIN
+    change_type := CASE NEW.action
+        WHEN 'create' THEN 'node.created'
+        WHEN 'update' THEN 'node.updated'
+        WHEN 'move' THEN 'node.moved'
+        WHEN 'delete' THEN 'node.deleted'
+        WHEN 'restore' THEN 'node.restored'
+    END;
+
+    IF NOT EXISTS (
+        SELECT 1 FROM webhooks w
+        WHERE w.tree_id = NEW.tree_id AND w.active
+            AND (cardinality(w.event_types) = 0 OR change_type = ANY(w.event_types))
+    ) THEN
+        RETURN NULL;
+    END IF;
+
+    -- A path such as '/1/5/9/' becomes [1, 5, 9]; a re-parented node's
+    -- previous path is its old parent's followed by its own ID
+    SELECT jsonb_build_object(
+        'id', NEW.id,
+        'type', change_type,
+        'treeId', NEW.tree_id,
+        'nodeId', NEW.node_id,
+        'node', jsonb_build_object(
+            'id', n.id,
+            'label', n.label,
+            'parentId', n.parent_id,
+            'position', n.position,
+            'createdAt', n.created_at,
+            'updatedAt', n.updated_at,
+            'version', n.version
+        )
+        || CASE WHEN n.attributes <> '{}'::jsonb THEN jsonb_build_object('attributes', n.attributes) ELSE '{}'::jsonb END
+        || CASE WHEN n.deleted_at IS NOT NULL THEN jsonb_build_object('deletedAt', n.deleted_at) ELSE '{}'::jsonb END,
+        'path', to_jsonb(string_to_array(trim(both '/' from n.path), '/')::bigint[]),
+        'time', NEW.changed_at
+    )
+    || CASE WHEN NEW.action IN ('update', 'move') AND NEW.old_parent_id IS DISTINCT FROM NEW.new_parent_id THEN
+        jsonb_build_object('previousPath', to_jsonb(string_to_array(trim(both '/' from
+            COALESCE((SELECT p.path FROM nodes p WHERE p.id = NEW.old_parent_id), '/') || NEW.node_id), '/')::bigint[]))
+    ELSE '{}'::jsonb END
+    INTO change_payload
+    FROM nodes n WHERE n.id = NEW.node_id;
+
+    INSERT INTO webhook_deliveries (webhook_id, tree_id, event_type, payload)
+    SELECT w.id, NEW.tree_id, change_type, change_payload FROM webhooks w
+    WHERE w.tree_id = NEW.tree_id AND w.active
+        AND (cardinality(w.event_types) = 0 OR change_type = ANY(w.event_types))
+    ORDER BY w.id;
+    RETURN NULL;
+END;
+$$ language 'plpgsql';
+
+DROP TRIGGER IF EXISTS enqueue_node_history_webhooks ON node_history;
+CREATE TRIGGER enqueue_node_history_webhooks
+    AFTER INSERT ON node_history
+    FOR EACH ROW
+    EXECUTE FUNCTION enqueue_webhook_deliveries();
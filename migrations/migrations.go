@@ -53,6 +53,419 @@ var Migrations = []Migration{
 			DROP FUNCTION IF EXISTS update_updated_at_column();
 		`,
 	},
+	{
+		ID:   3,
+		Name: "add_node_position",
+		Up: `
+			ALTER TABLE nodes ADD COLUMN IF NOT EXISTS position DOUBLE PRECISION;
+
+			-- Keep the existing id order among siblings
+			UPDATE nodes SET position = id WHERE position IS NULL;
+
+			ALTER TABLE nodes ALTER COLUMN position SET NOT NULL;
+			ALTER TABLE nodes ALTER COLUMN position SET DEFAULT 1;
+
+			CREATE INDEX IF NOT EXISTS idx_nodes_parent_position ON nodes (parent_id, position);
+		`,
+		Down: `
+			DROP INDEX IF EXISTS idx_nodes_parent_position;
+			ALTER TABLE nodes DROP COLUMN IF EXISTS position;
+		`,
+	},
+	{
+		ID:   4,
+		Name: "add_node_attributes",
+		Up: `
+			ALTER TABLE nodes ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}'::jsonb
+		`,
+		Down: `ALTER TABLE nodes DROP COLUMN IF EXISTS attributes`,
+	},
+	{
+		ID:   5,
+		Name: "add_label_search_index",
+		Up: `
+			CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+			CREATE INDEX IF NOT EXISTS idx_nodes_label_trgm ON nodes USING GIN (label gin_trgm_ops);
+		`,
+		Down: `DROP INDEX IF EXISTS idx_nodes_label_trgm`,
+	},
+	{
+		ID:   6,
+		Name: "create_trees_table",
+		Up: `
+			CREATE TABLE IF NOT EXISTS trees (
+				id SERIAL PRIMARY KEY,
+				name TEXT NOT NULL UNIQUE,
+				created_at TIMESTAMP WITH TIME ZONE DEFAULT CURRENT_TIMESTAMP
+			);
+
+			INSERT INTO trees (id, name) VALUES (1, 'default') ON CONFLICT (id) DO NOTHING;
+			SELECT setval(pg_get_serial_sequence('trees', 'id'), (SELECT MAX(id) FROM trees));
+
+			ALTER TABLE nodes ADD COLUMN IF NOT EXISTS tree_id INTEGER NOT NULL DEFAULT 1 REFERENCES trees(id);
+
+			CREATE INDEX IF NOT EXISTS idx_nodes_tree_parent ON nodes (tree_id, parent_id);
+		`,
+		Down: `
+			DROP INDEX IF EXISTS idx_nodes_tree_parent;
+			ALTER TABLE nodes DROP COLUMN IF EXISTS tree_id;
+			DROP TABLE IF EXISTS trees;
+		`,
+	},
+	{
+		ID:   7,
+		Name: "add_node_version",
+		Up: `
+			ALTER TABLE nodes ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;
+
+			CREATE OR REPLACE FUNCTION increment_node_version()
+			RETURNS TRIGGER AS $$
+			BEGIN
+				NEW.version = OLD.version + 1;
+				RETURN NEW;
+			END;
+			$$ language 'plpgsql';
+
+			DROP TRIGGER IF EXISTS increment_nodes_version ON nodes;
+			CREATE TRIGGER increment_nodes_version
+				BEFORE UPDATE ON nodes
+				FOR EACH ROW
+				EXECUTE FUNCTION increment_node_version();
+		`,
+		Down: `
+			DROP TRIGGER IF EXISTS increment_nodes_version ON nodes;
+			DROP FUNCTION IF EXISTS increment_node_version();
+			ALTER TABLE nodes DROP COLUMN IF EXISTS version;
+		`,
+	},
+	{
+		ID:   8,
+		Name: "add_node_deleted_at",
+		Up: `
+			ALTER TABLE nodes ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE;
+
+			CREATE INDEX IF NOT EXISTS idx_nodes_tree_deleted_at ON nodes (tree_id, deleted_at) WHERE deleted_at IS NOT NULL;
+		`,
+		Down: `
+			DROP INDEX IF EXISTS idx_nodes_tree_deleted_at;
+			ALTER TABLE nodes DROP COLUMN IF EXISTS deleted_at;
+		`,
+	},
+	{
+		ID:   9,
+		Name: "create_node_history_table",
+		Up: `
+			-- No foreign key to nodes: history is kept after a node is purged
+			CREATE TABLE IF NOT EXISTS node_history (
+				id BIGSERIAL PRIMARY KEY,
+				node_id INTEGER NOT NULL,
+				tree_id INTEGER NOT NULL,
+				action TEXT NOT NULL,
+				old_label TEXT,
+				new_label TEXT,
+				old_parent_id INTEGER,
+				new_parent_id INTEGER,
+				position DOUBLE PRECISION NOT NULL,
+				attributes JSONB NOT NULL DEFAULT '{}'::jsonb,
+				actor TEXT,
+				changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_node_history_node ON node_history (node_id, id);
+		`,
+		Down: `DROP TABLE IF EXISTS node_history`,
+	},
+	{
+		ID:   10,
+		Name: "create_webhooks_tables",
+		Up: `
+			CREATE TABLE IF NOT EXISTS webhooks (
+				id SERIAL PRIMARY KEY,
+				tree_id INTEGER NOT NULL REFERENCES trees(id) ON DELETE CASCADE,
+				url TEXT NOT NULL,
+				secret TEXT NOT NULL,
+				event_types TEXT[] NOT NULL DEFAULT '{}',
+				active BOOLEAN NOT NULL DEFAULT TRUE,
+				created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_webhooks_tree ON webhooks (tree_id);
+
+			DROP TRIGGER IF EXISTS update_webhooks_updated_at ON webhooks;
+			CREATE TRIGGER update_webhooks_updated_at
+				BEFORE UPDATE ON webhooks
+				FOR EACH ROW
+				EXECUTE FUNCTION update_updated_at_column();
+
+			CREATE TABLE IF NOT EXISTS webhook_deliveries (
+				id BIGSERIAL PRIMARY KEY,
+				webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+				tree_id INTEGER NOT NULL,
+				event_type TEXT NOT NULL,
+				payload JSONB NOT NULL,
+				status TEXT NOT NULL DEFAULT 'pending',
+				attempts INTEGER NOT NULL DEFAULT 0,
+				next_attempt_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+				last_attempt_at TIMESTAMP WITH TIME ZONE,
+				response_status INTEGER,
+				last_error TEXT,
+				created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (tree_id, next_attempt_at) WHERE status = 'pending';
+			CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries (webhook_id, id);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS webhook_deliveries;
+			DROP TABLE IF EXISTS webhooks;
+		`,
+	},
+	{
+		ID:   11,
+		Name: "add_node_path",
+		Up: `
+			ALTER TABLE nodes ADD COLUMN IF NOT EXISTS path TEXT;
+
+			-- Rewriting the paths of a moved node's descendants only changes their
+			-- path, which is not a modification of the nodes themselves
+			DROP TRIGGER IF EXISTS increment_nodes_version ON nodes;
+			CREATE TRIGGER increment_nodes_version
+				BEFORE UPDATE ON nodes
+				FOR EACH ROW
+				WHEN (OLD.path IS NOT DISTINCT FROM NEW.path OR OLD.parent_id IS DISTINCT FROM NEW.parent_id)
+				EXECUTE FUNCTION increment_node_version();
+
+			DROP TRIGGER IF EXISTS update_nodes_updated_at ON nodes;
+			CREATE TRIGGER update_nodes_updated_at
+				BEFORE UPDATE ON nodes
+				FOR EACH ROW
+				WHEN (OLD.path IS NOT DISTINCT FROM NEW.path OR OLD.parent_id IS DISTINCT FROM NEW.parent_id)
+				EXECUTE FUNCTION update_updated_at_column();
+
+			-- Backfill the existing rows from the roots down
+			WITH RECURSIVE paths AS (
+				SELECT id, '/' || id || '/' AS path FROM nodes WHERE parent_id IS NULL
+				UNION ALL
+				SELECT n.id, p.path || n.id || '/' FROM nodes n
+				INNER JOIN paths p ON n.parent_id = p.id
+			)
+			UPDATE nodes SET path = paths.path FROM paths WHERE nodes.id = paths.id;
+
+			ALTER TABLE nodes ALTER COLUMN path SET NOT NULL;
+
+			CREATE INDEX IF NOT EXISTS idx_nodes_path ON nodes (path text_pattern_ops);
+
+			-- A node's path is its parent's path followed by its own ID
+			CREATE OR REPLACE FUNCTION set_node_path()
+			RETURNS TRIGGER AS $$
+			BEGIN
+				NEW.path = COALESCE((SELECT path FROM nodes WHERE id = NEW.parent_id), '/') || NEW.id || '/';
+				RETURN NEW;
+			END;
+			$$ language 'plpgsql';
+
+			DROP TRIGGER IF EXISTS set_nodes_path ON nodes;
+			CREATE TRIGGER set_nodes_path
+				BEFORE INSERT OR UPDATE OF parent_id ON nodes
+				FOR EACH ROW
+				EXECUTE FUNCTION set_node_path();
+
+			-- Moving a node moves its whole subtree
+			CREATE OR REPLACE FUNCTION move_node_descendants()
+			RETURNS TRIGGER AS $$
+			BEGIN
+				UPDATE nodes SET path = NEW.path || substr(path, length(OLD.path) + 1)
+				WHERE path LIKE OLD.path || '%' AND id <> NEW.id;
+				RETURN NULL;
+			END;
+			$$ language 'plpgsql';
+
+			DROP TRIGGER IF EXISTS move_nodes_descendants ON nodes;
+			CREATE TRIGGER move_nodes_descendants
+				AFTER UPDATE OF parent_id ON nodes
+				FOR EACH ROW
+				WHEN (OLD.path IS DISTINCT FROM NEW.path)
+				EXECUTE FUNCTION move_node_descendants();
+		`,
+		Down: `
+			DROP TRIGGER IF EXISTS move_nodes_descendants ON nodes;
+			DROP FUNCTION IF EXISTS move_node_descendants();
+			DROP TRIGGER IF EXISTS set_nodes_path ON nodes;
+			DROP FUNCTION IF EXISTS set_node_path();
+			DROP INDEX IF EXISTS idx_nodes_path;
+
+			DROP TRIGGER IF EXISTS update_nodes_updated_at ON nodes;
+			CREATE TRIGGER update_nodes_updated_at
+				BEFORE UPDATE ON nodes
+				FOR EACH ROW
+				EXECUTE FUNCTION update_updated_at_column();
+
+			DROP TRIGGER IF EXISTS increment_nodes_version ON nodes;
+			CREATE TRIGGER increment_nodes_version
+				BEFORE UPDATE ON nodes
+				FOR EACH ROW
+				EXECUTE FUNCTION increment_node_version();
+
+			ALTER TABLE nodes DROP COLUMN IF EXISTS path;
+		`,
+	},
+	{
+		ID:   12,
+		Name: "create_node_closure",
+		Up: `
+			-- node_closure holds a row for every node and each of its ancestors,
+			-- including the node itself at depth 0
+			CREATE TABLE IF NOT EXISTS node_closure (
+				ancestor_id INTEGER NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+				descendant_id INTEGER NOT NULL REFERENCES nodes(id) ON DELETE CASCADE,
+				depth INTEGER NOT NULL,
+				PRIMARY KEY (ancestor_id, descendant_id)
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_node_closure_descendant_id ON node_closure(descendant_id);
+
+			-- Backfill the existing rows from every node down
+			WITH RECURSIVE closure AS (
+				SELECT id AS ancestor_id, id AS descendant_id, 0 AS depth FROM nodes
+				UNION ALL
+				SELECT c.ancestor_id, n.id, c.depth + 1 FROM nodes n
+				INNER JOIN closure c ON n.parent_id = c.descendant_id
+			)
+			INSERT INTO node_closure (ancestor_id, descendant_id, depth)
+			SELECT ancestor_id, descendant_id, depth FROM closure
+			ON CONFLICT DO NOTHING;
+
+			-- A new node's ancestors are itself and its parent's ancestors
+			CREATE OR REPLACE FUNCTION insert_node_closure()
+			RETURNS TRIGGER AS $$
+			BEGIN
+				INSERT INTO node_closure (ancestor_id, descendant_id, depth)
+				SELECT NEW.id, NEW.id, 0
+				UNION ALL
+				SELECT ancestor_id, NEW.id, depth + 1 FROM node_closure WHERE descendant_id = NEW.parent_id;
+				RETURN NULL;
+			END;
+			$$ language 'plpgsql';
+
+			DROP TRIGGER IF EXISTS insert_nodes_closure ON nodes;
+			CREATE TRIGGER insert_nodes_closure
+				AFTER INSERT ON nodes
+				FOR EACH ROW
+				EXECUTE FUNCTION insert_node_closure();
+
+			-- Moving a node detaches its whole subtree from the old ancestors and
+			-- attaches it below the new parent's
+			CREATE OR REPLACE FUNCTION move_node_closure()
+			RETURNS TRIGGER AS $$
+			BEGIN
+				DELETE FROM node_closure
+				WHERE descendant_id IN (SELECT descendant_id FROM node_closure WHERE ancestor_id = NEW.id)
+					AND ancestor_id NOT IN (SELECT descendant_id FROM node_closure WHERE ancestor_id = NEW.id);
+
+				INSERT INTO node_closure (ancestor_id, descendant_id, depth)
+				SELECT a.ancestor_id, d.descendant_id, a.depth + d.depth + 1
+				FROM node_closure a
+				CROSS JOIN node_closure d
+				WHERE a.descendant_id = NEW.parent_id AND d.ancestor_id = NEW.id;
+				RETURN NULL;
+			END;
+			$$ language 'plpgsql';
+
+			DROP TRIGGER IF EXISTS move_nodes_closure ON nodes;
+			CREATE TRIGGER move_nodes_closure
+				AFTER UPDATE OF parent_id ON nodes
+				FOR EACH ROW
+				WHEN (OLD.parent_id IS DISTINCT FROM NEW.parent_id)
+				EXECUTE FUNCTION move_node_closure();
+		`,
+		Down: `
+			DROP TRIGGER IF EXISTS move_nodes_closure ON nodes;
+			DROP FUNCTION IF EXISTS move_node_closure();
+			DROP TRIGGER IF EXISTS insert_nodes_closure ON nodes;
+			DROP FUNCTION IF EXISTS insert_node_closure();
+			DROP TABLE IF EXISTS node_closure;
+		`,
+	},
+	{
+		ID:   13,
+		Name: "enqueue_webhook_deliveries",
+		Up: `
+			-- Every history entry is queued for the subscribed active webhooks of its
+			-- tree by the statement that records it, so the deliveries commit or roll
+			-- back with the change. The payload has the JSON of the events of
+			-- GET /api/events, with the entry's ID as the event ID.
+			CREATE OR REPLACE FUNCTION enqueue_webhook_deliveries()
+			RETURNS TRIGGER AS $$
+			DECLARE
+				change_type TEXT;
+				change_payload JSONB;
+			BEGIN
+				change_type := CASE NEW.action
+					WHEN 'create' THEN 'node.created'
+					WHEN 'update' THEN 'node.updated'
+					WHEN 'move' THEN 'node.moved'
+					WHEN 'delete' THEN 'node.deleted'
+					WHEN 'restore' THEN 'node.restored'
+				END;
+
+				IF NOT EXISTS (
+					SELECT 1 FROM webhooks w
+					WHERE w.tree_id = NEW.tree_id AND w.active
+						AND (cardinality(w.event_types) = 0 OR change_type = ANY(w.event_types))
+				) THEN
+					RETURN NULL;
+				END IF;
+
+				-- A path such as '/1/5/9/' becomes [1, 5, 9]; a re-parented node's
+				-- previous path is its old parent's followed by its own ID
+				SELECT jsonb_build_object(
+					'id', NEW.id,
+					'type', change_type,
+					'treeId', NEW.tree_id,
+					'nodeId', NEW.node_id,
+					'node', jsonb_build_object(
+						'id', n.id,
+						'label', n.label,
+						'parentId', n.parent_id,
+						'position', n.position,
+						'createdAt', n.created_at,
+						'updatedAt', n.updated_at,
+						'version', n.version
+					)
+					|| CASE WHEN n.attributes <> '{}'::jsonb THEN jsonb_build_object('attributes', n.attributes) ELSE '{}'::jsonb END
+					|| CASE WHEN n.deleted_at IS NOT NULL THEN jsonb_build_object('deletedAt', n.deleted_at) ELSE '{}'::jsonb END,
+					'path', to_jsonb(string_to_array(trim(both '/' from n.path), '/')::bigint[]),
+					'time', NEW.changed_at
+				)
+				|| CASE WHEN NEW.action IN ('update', 'move') AND NEW.old_parent_id IS DISTINCT FROM NEW.new_parent_id THEN
+					jsonb_build_object('previousPath', to_jsonb(string_to_array(trim(both '/' from
+						COALESCE((SELECT p.path FROM nodes p WHERE p.id = NEW.old_parent_id), '/') || NEW.node_id), '/')::bigint[]))
+				ELSE '{}'::jsonb END
+				INTO change_payload
+				FROM nodes n WHERE n.id = NEW.node_id;
+
+				INSERT INTO webhook_deliveries (webhook_id, tree_id, event_type, payload)
+				SELECT w.id, NEW.tree_id, change_type, change_payload FROM webhooks w
+				WHERE w.tree_id = NEW.tree_id AND w.active
+					AND (cardinality(w.event_types) = 0 OR change_type = ANY(w.event_types))
+				ORDER BY w.id;
+				RETURN NULL;
+			END;
+			$$ language 'plpgsql';
+
+			DROP TRIGGER IF EXISTS enqueue_node_history_webhooks ON node_history;
+			CREATE TRIGGER enqueue_node_history_webhooks
+				AFTER INSERT ON node_history
+				FOR EACH ROW
+				EXECUTE FUNCTION enqueue_webhook_deliveries();
+		`,
+		Down: `
+			DROP TRIGGER IF EXISTS enqueue_node_history_webhooks ON node_history;
+			DROP FUNCTION IF EXISTS enqueue_webhook_deliveries();
+		`,
+	},
 }
 
 // RunMigrations executes all pending migrations
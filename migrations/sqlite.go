@@ -0,0 +1,157 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// SQLiteMigrations is the list of migrations of the embedded SQLite
+// database. It creates the same tables as Migrations, with SQLite types:
+// times are stored as fixed-width UTC text so they compare chronologically,
+// and JSON values are stored as text.
+var SQLiteMigrations = []Migration{
+	{
+		ID:   1,
+		Name: "create_schema",
+		Up: `
+			CREATE TABLE IF NOT EXISTS trees (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				name TEXT NOT NULL UNIQUE,
+				created_at TEXT NOT NULL
+			);
+
+			INSERT OR IGNORE INTO trees (id, name, created_at)
+			VALUES (1, 'default', strftime('%Y-%m-%d %H:%M:%f000000', 'now'));
+
+			-- AUTOINCREMENT keeps the IDs of purged nodes from being reused
+			CREATE TABLE IF NOT EXISTS nodes (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				tree_id INTEGER NOT NULL DEFAULT 1 REFERENCES trees(id),
+				label TEXT NOT NULL,
+				parent_id INTEGER REFERENCES nodes(id),
+				position REAL NOT NULL DEFAULT 1,
+				attributes TEXT NOT NULL DEFAULT '{}',
+				created_at TEXT NOT NULL,
+				updated_at TEXT NOT NULL,
+				version INTEGER NOT NULL DEFAULT 1,
+				deleted_at TEXT
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_nodes_tree_parent ON nodes (tree_id, parent_id);
+			CREATE INDEX IF NOT EXISTS idx_nodes_parent_position ON nodes (parent_id, position);
+			CREATE INDEX IF NOT EXISTS idx_nodes_tree_deleted_at ON nodes (tree_id, deleted_at) WHERE deleted_at IS NOT NULL;
+
+			-- No foreign key to nodes: history is kept after a node is purged
+			CREATE TABLE IF NOT EXISTS node_history (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				node_id INTEGER NOT NULL,
+				tree_id INTEGER NOT NULL,
+				action TEXT NOT NULL,
+				old_label TEXT,
+				new_label TEXT,
+				old_parent_id INTEGER,
+				new_parent_id INTEGER,
+				position REAL NOT NULL,
+				attributes TEXT NOT NULL DEFAULT '{}',
+				actor TEXT,
+				changed_at TEXT NOT NULL
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_node_history_node ON node_history (node_id, id);
+
+			-- event_types is a JSON array of strings
+			CREATE TABLE IF NOT EXISTS webhooks (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				tree_id INTEGER NOT NULL REFERENCES trees(id) ON DELETE CASCADE,
+				url TEXT NOT NULL,
+				secret TEXT NOT NULL,
+				event_types TEXT NOT NULL DEFAULT '[]',
+				active INTEGER NOT NULL DEFAULT 1,
+				created_at TEXT NOT NULL,
+				updated_at TEXT NOT NULL
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_webhooks_tree ON webhooks (tree_id);
+
+			CREATE TABLE IF NOT EXISTS webhook_deliveries (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				webhook_id INTEGER NOT NULL REFERENCES webhooks(id) ON DELETE CASCADE,
+				tree_id INTEGER NOT NULL,
+				event_type TEXT NOT NULL,
+				payload TEXT NOT NULL,
+				status TEXT NOT NULL DEFAULT 'pending',
+				attempts INTEGER NOT NULL DEFAULT 0,
+				next_attempt_at TEXT NOT NULL,
+				last_attempt_at TEXT,
+				response_status INTEGER,
+				last_error TEXT,
+				created_at TEXT NOT NULL
+			);
+
+			CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_due ON webhook_deliveries (tree_id, next_attempt_at) WHERE status = 'pending';
+			CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_webhook ON webhook_deliveries (webhook_id, id);
+		`,
+		Down: `
+			DROP TABLE IF EXISTS webhook_deliveries;
+			DROP TABLE IF EXISTS webhooks;
+			DROP TABLE IF EXISTS node_history;
+			DROP TABLE IF EXISTS nodes;
+			DROP TABLE IF EXISTS trees;
+		`,
+	},
+}
+
+// RunSQLiteMigrations executes all pending SQLite migrations
+func RunSQLiteMigrations(ctx context.Context, db *sql.DB) error {
+	// Create migrations table if it doesn't exist
+	_, err := db.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS migrations (
+			id INTEGER PRIMARY KEY,
+			name TEXT NOT NULL,
+			applied_at TEXT NOT NULL DEFAULT CURRENT_TIMESTAMP
+		)
+	`)
+	if err != nil {
+		return fmt.Errorf("error creating migrations table: %w", err)
+	}
+
+	// Begin transaction
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			fmt.Printf("Warning: Error rolling back transaction: %v\n", err)
+		}
+	}()
+
+	// Apply pending migrations
+	for _, migration := range SQLiteMigrations {
+		var applied bool
+		err := tx.QueryRowContext(ctx,
+			"SELECT EXISTS(SELECT 1 FROM migrations WHERE id = ?)",
+			migration.ID,
+		).Scan(&applied)
+		if err != nil {
+			return fmt.Errorf("error querying migration %d (%s): %w", migration.ID, migration.Name, err)
+		}
+		if applied {
+			continue
+		}
+
+		// Execute migration
+		if _, err := tx.ExecContext(ctx, migration.Up); err != nil {
+			return fmt.Errorf("error executing migration %d (%s): %w", migration.ID, migration.Name, err)
+		}
+
+		// Record migration
+		if _, err := tx.ExecContext(ctx, "INSERT INTO migrations (id, name) VALUES (?, ?)",
+			migration.ID, migration.Name); err != nil {
+			return fmt.Errorf("error recording migration %d (%s): %w", migration.ID, migration.Name, err)
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// AttributeLimits constrains the attributes clients may store on a node
+type AttributeLimits struct {
+	MaxBytes   int            // Maximum size of the JSON-encoded attributes, 0 for no limit
+	KeyPattern *regexp.Regexp // Pattern every key must match, nil to accept any non-empty key
+}
+
+// Validate checks attributes against the limits
+func (l AttributeLimits) Validate(attributes map[string]interface{}) error {
+	for key := range attributes {
+		if key == "" {
+			return fmt.Errorf("attribute keys cannot be empty")
+		}
+		if l.KeyPattern != nil && !l.KeyPattern.MatchString(key) {
+			return fmt.Errorf("attribute key %q must match %s", key, l.KeyPattern)
+		}
+	}
+
+	if l.MaxBytes > 0 && len(attributes) > 0 {
+		encoded, err := json.Marshal(attributes)
+		if err != nil {
+			return fmt.Errorf("invalid attributes: %w", err)
+		}
+		if len(encoded) > l.MaxBytes {
+			return fmt.Errorf("attributes cannot exceed %d bytes", l.MaxBytes)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,208 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// NodeRef refers to a node in a batch request. A JSON number is the ID of
+// an existing node; a JSON string is the tempId of a node created by an
+// earlier operation of the same batch.
+type NodeRef struct {
+	ID     int64
+	TempID string
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (r *NodeRef) UnmarshalJSON(data []byte) error {
+	*r = NodeRef{}
+	if bytes.HasPrefix(data, []byte(`"`)) {
+		if err := json.Unmarshal(data, &r.TempID); err != nil {
+			return err
+		}
+		if r.TempID == "" {
+			return errors.New("temp ID cannot be empty")
+		}
+		return nil
+	}
+	if err := json.Unmarshal(data, &r.ID); err != nil {
+		return errors.New("node reference must be an ID or a temp ID")
+	}
+	if r.ID <= 0 {
+		return errors.New("node ID must be greater than 0")
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (r NodeRef) MarshalJSON() ([]byte, error) {
+	if r.TempID != "" {
+		return json.Marshal(r.TempID)
+	}
+	return json.Marshal(r.ID)
+}
+
+// OptionalNodeRef is a nullable NodeRef that remembers whether it was
+// present in the request body, like OptionalID
+type OptionalNodeRef struct {
+	Set   bool
+	Value *NodeRef
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (o *OptionalNodeRef) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	o.Value = nil
+	if string(data) == "null" {
+		return nil
+	}
+	o.Value = &NodeRef{}
+	return o.Value.UnmarshalJSON(data)
+}
+
+// MarshalJSON implements json.Marshaler
+func (o OptionalNodeRef) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.Value)
+}
+
+// BatchOperation is one operation of a batch request. op selects which
+// fields apply:
+//   - create: label (required), parentId, attributes and tempId, which later
+//     operations can use to refer to the new node
+//   - update: id plus at least one of label, parentId and attributes, with
+//     the semantics of PATCH /api/node/:id; version acts like If-Match
+//   - move: id, parentId (omitted keeps the current parent) and at most one
+//     of beforeId, afterId and index, like POST /api/node/:id/move
+//   - delete: id, mode (cascade by default) and version
+type BatchOperation struct {
+	Op         string                 `json:"op" validate:"required,oneof=create update move delete"`
+	TempID     string                 `json:"tempId,omitempty" validate:"max=100"`
+	ID         *NodeRef               `json:"id,omitempty"`
+	Label      *string                `json:"label,omitempty" validate:"omitempty,min=1,max=100"`
+	ParentID   OptionalNodeRef        `json:"parentId"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	BeforeID   *NodeRef               `json:"beforeId,omitempty"`
+	AfterID    *NodeRef               `json:"afterId,omitempty"`
+	Index      *int                   `json:"index,omitempty" validate:"omitempty,gte=0"`
+	Mode       string                 `json:"mode,omitempty" validate:"omitempty,oneof=cascade reparent refuse"`
+	Version    *int64                 `json:"version,omitempty" validate:"omitempty,gt=0"`
+}
+
+// BatchRequest represents the request body for applying a batch of
+// operations in one transaction
+type BatchRequest struct {
+	Operations []*BatchOperation `json:"operations" validate:"required"`
+}
+
+// BatchOperationError reports the operation of a batch request that is
+// invalid or failed
+type BatchOperationError struct {
+	Index int
+	Err   error
+}
+
+// Error implements error
+func (e *BatchOperationError) Error() string {
+	return fmt.Sprintf("operations[%d]: %v", e.Index, e.Err)
+}
+
+// Unwrap returns the operation's error
+func (e *BatchOperationError) Unwrap() error {
+	return e.Err
+}
+
+// Validate validates the batch request, allowing at most maxOperations
+// operations. Errors in a single operation are *BatchOperationError.
+func (r *BatchRequest) Validate(maxOperations int) error {
+	validate := validator.New()
+	if err := validate.Struct(r); err != nil {
+		return err
+	}
+	if len(r.Operations) == 0 {
+		return errors.New("operations cannot be empty")
+	}
+	if len(r.Operations) > maxOperations {
+		return fmt.Errorf("a batch cannot contain more than %d operations", maxOperations)
+	}
+
+	for i, op := range r.Operations {
+		if op == nil {
+			return &BatchOperationError{Index: i, Err: errors.New("operation cannot be null")}
+		}
+		if err := validate.Struct(op); err != nil {
+			return &BatchOperationError{Index: i, Err: err}
+		}
+		if err := op.validateFields(); err != nil {
+			return &BatchOperationError{Index: i, Err: err}
+		}
+	}
+	return nil
+}
+
+// validateFields checks that the operation sets the fields its op needs
+// and no fields of other ops
+func (o *BatchOperation) validateFields() error {
+	if o.Op == "create" {
+		if o.ID != nil {
+			return errors.New("create cannot set id; use tempId to name the new node")
+		}
+		if o.Label == nil {
+			return errors.New("create needs a label")
+		}
+	} else {
+		if o.ID == nil {
+			return fmt.Errorf("%s needs an id", o.Op)
+		}
+		if o.TempID != "" {
+			return errors.New("only create can set tempId")
+		}
+	}
+
+	if o.Op != "move" && (o.BeforeID != nil || o.AfterID != nil || o.Index != nil) {
+		return errors.New("only move can set beforeId, afterId and index")
+	}
+	if o.Op != "delete" && o.Mode != "" {
+		return errors.New("only delete can set mode")
+	}
+	if o.Version != nil && o.Op != "update" && o.Op != "delete" {
+		return errors.New("only update and delete can set version")
+	}
+	if (o.Label != nil || o.Attributes != nil) && o.Op != "create" && o.Op != "update" {
+		return errors.New("only create and update can set label and attributes")
+	}
+
+	switch o.Op {
+	case "update":
+		if o.Label == nil && !o.ParentID.Set && o.Attributes == nil {
+			return errors.New("update needs at least one of label, parentId and attributes")
+		}
+	case "move":
+		placements := 0
+		for _, set := range []bool{o.BeforeID != nil, o.AfterID != nil, o.Index != nil} {
+			if set {
+				placements++
+			}
+		}
+		if placements > 1 {
+			return errors.New("only one of beforeId, afterId and index may be set")
+		}
+	case "delete":
+		if o.ParentID.Set {
+			return errors.New("delete cannot set parentId")
+		}
+	}
+	return nil
+}
+
+// BatchResult is the outcome of one operation of a successful batch
+type BatchResult struct {
+	Index   int    `json:"index"`
+	Op      string `json:"op"`
+	ID      int64  `json:"id"`
+	TempID  string `json:"tempId,omitempty"`
+	Deleted int64  `json:"deleted,omitempty"`
+}
@@ -0,0 +1,36 @@
+package models
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidIfMatch is returned when an If-Match header is neither "*" nor a
+// single strong ETag
+var ErrInvalidIfMatch = errors.New(`If-Match must be "*" or a single strong ETag`)
+
+// FormatETag returns the strong ETag for a node version
+func FormatETag(version int64) string {
+	return `"` + strconv.FormatInt(version, 10) + `"`
+}
+
+// ParseIfMatch returns the node version required by an If-Match header, or
+// nil if the header is empty or "*" and the write is unconditional. Weak
+// ETags are rejected: If-Match uses strong comparison (RFC 9110, section
+// 13.1.1), under which they could never match.
+func ParseIfMatch(header string) (*int64, error) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return nil, nil
+	}
+
+	if len(header) < 2 || !strings.HasPrefix(header, `"`) || !strings.HasSuffix(header, `"`) {
+		return nil, ErrInvalidIfMatch
+	}
+	version, err := strconv.ParseInt(header[1:len(header)-1], 10, 64)
+	if err != nil || version <= 0 {
+		return nil, ErrInvalidIfMatch
+	}
+	return &version, nil
+}
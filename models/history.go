@@ -0,0 +1,36 @@
+package models
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrInvalidAsOf is returned when an asOf parameter is not an RFC 3339 time
+var ErrInvalidAsOf = errors.New("asOf must be an RFC 3339 time, e.g. 2024-01-01T12:00:00Z")
+
+// ParseAsOf parses an asOf query parameter, the time to read the tree at.
+// It returns nil if the parameter is empty and the read is of the live tree.
+func ParseAsOf(value string) (*time.Time, error) {
+	if value == "" {
+		return nil, nil
+	}
+	asOf, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return nil, ErrInvalidAsOf
+	}
+	return &asOf, nil
+}
+
+// HistoryEntry is one recorded change to a node. The old fields are omitted
+// for creates and restores and the new fields for deletes.
+type HistoryEntry struct {
+	ID          int64     `json:"id"`
+	NodeID      int64     `json:"nodeId"`
+	Action      string    `json:"action"`
+	OldLabel    *string   `json:"oldLabel,omitempty"`
+	NewLabel    *string   `json:"newLabel,omitempty"`
+	OldParentID *int64    `json:"oldParentId"`
+	NewParentID *int64    `json:"newParentId"`
+	Actor       string    `json:"actor,omitempty"`
+	ChangedAt   time.Time `json:"changedAt"`
+}
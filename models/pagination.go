@@ -0,0 +1,33 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// cursorPrefix marks the payload of a tree page cursor
+const cursorPrefix = "root:"
+
+// ErrInvalidCursor is returned when a pagination cursor can't be decoded
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// EncodeCursor returns the opaque cursor for the page that starts after the
+// root node with the given ID
+func EncodeCursor(lastRootID int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + strconv.FormatInt(lastRootID, 10)))
+}
+
+// DecodeCursor returns the root ID encoded in a cursor created by EncodeCursor
+func DecodeCursor(cursor string) (int64, error) {
+	payload, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(payload), cursorPrefix) {
+		return 0, ErrInvalidCursor
+	}
+	id, err := strconv.ParseInt(strings.TrimPrefix(string(payload), cursorPrefix), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, ErrInvalidCursor
+	}
+	return id, nil
+}
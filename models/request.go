@@ -1,19 +1,39 @@
 package models
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
 	"github.com/go-playground/validator/v10"
 )
 
 // CreateNodeRequest represents the request body for creating a node
 type CreateNodeRequest struct {
-	Label    string `json:"label" validate:"required,min=1,max=100"`
-	ParentID int64  `json:"parentId" validate:"omitempty,gt=0"`
+	Label      string                 `json:"label" validate:"required,min=1,max=100"`
+	ParentID   int64                  `json:"parentId" validate:"omitempty,gt=0"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
 }
 
-// UpdateNodeRequest represents the request body for updating a node
+// UpdateNodeRequest represents the request body for replacing a node.
+// An omitted parentId is the same as null and makes the node a root, and
+// omitted attributes are the same as {} and clear them; use
+// PatchNodeRequest to leave fields untouched.
 type UpdateNodeRequest struct {
-	Label    string `json:"label" validate:"required,min=1,max=100"`
-	ParentID *int64 `json:"parentId,omitempty" validate:"omitempty,gt=0"`
+	Label      string                 `json:"label" validate:"required,min=1,max=100"`
+	ParentID   *int64                 `json:"parentId,omitempty" validate:"omitempty,gt=0"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// CreateTreeRequest represents the request body for creating a named tree
+type CreateTreeRequest struct {
+	Name string `json:"name" validate:"required,min=1,max=100"`
+}
+
+// Validate validates the create tree request
+func (r *CreateTreeRequest) Validate() error {
+	validate := validator.New()
+	return validate.Struct(r)
 }
 
 // Validate validates the create node request
@@ -27,3 +47,154 @@ func (r *UpdateNodeRequest) Validate() error {
 	validate := validator.New()
 	return validate.Struct(r)
 }
+
+// ReplacementAttributes returns the attributes that replace the node's,
+// which are empty if the request omitted them
+func (r *UpdateNodeRequest) ReplacementAttributes() map[string]interface{} {
+	if r.Attributes == nil {
+		return map[string]interface{}{}
+	}
+	return r.Attributes
+}
+
+// OptionalID is a nullable ID that remembers whether it was present in the
+// request body, so an explicit null can be told apart from an omitted field
+type OptionalID struct {
+	Set   bool
+	Value *int64
+}
+
+// UnmarshalJSON implements json.Unmarshaler
+func (o *OptionalID) UnmarshalJSON(data []byte) error {
+	o.Set = true
+	o.Value = nil
+	if string(data) == "null" {
+		return nil
+	}
+	var id int64
+	if err := json.Unmarshal(data, &id); err != nil {
+		return err
+	}
+	o.Value = &id
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler
+func (o OptionalID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(o.Value)
+}
+
+// PatchNodeRequest represents the request body for partially updating a
+// node. Omitted fields are left untouched and an explicit "parentId": null
+// makes the node a root. Attributes, when set, replace all of the node's
+// attributes.
+type PatchNodeRequest struct {
+	Label      *string                `json:"label,omitempty" validate:"omitempty,min=1,max=100"`
+	ParentID   OptionalID             `json:"parentId"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+}
+
+// Validate validates the patch node request
+func (r *PatchNodeRequest) Validate() error {
+	validate := validator.New()
+	if err := validate.Struct(r); err != nil {
+		return err
+	}
+	if r.Label == nil && !r.ParentID.Set && r.Attributes == nil {
+		return errors.New("at least one of label, parentId and attributes must be set")
+	}
+	if r.ParentID.Value != nil && *r.ParentID.Value <= 0 {
+		return errors.New("parentId must be greater than 0")
+	}
+	return nil
+}
+
+// MoveNodeRequest represents the request body for moving a node.
+// An omitted parentId keeps the node under its current parent and null
+// makes it a root. At most one of beforeId, afterId and index may be set;
+// with none the node is placed after its new siblings.
+type MoveNodeRequest struct {
+	ParentID OptionalID `json:"parentId"`
+	BeforeID *int64     `json:"beforeId,omitempty" validate:"omitempty,gt=0"`
+	AfterID  *int64     `json:"afterId,omitempty" validate:"omitempty,gt=0"`
+	Index    *int       `json:"index,omitempty" validate:"omitempty,gte=0"`
+}
+
+// Validate validates the move node request
+func (r *MoveNodeRequest) Validate() error {
+	validate := validator.New()
+	if err := validate.Struct(r); err != nil {
+		return err
+	}
+	if r.ParentID.Value != nil && *r.ParentID.Value <= 0 {
+		return errors.New("parentId must be greater than 0")
+	}
+
+	placements := 0
+	for _, set := range []bool{r.BeforeID != nil, r.AfterID != nil, r.Index != nil} {
+		if set {
+			placements++
+		}
+	}
+	if placements > 1 {
+		return errors.New("only one of beforeId, afterId and index may be set")
+	}
+	return nil
+}
+
+// ImportTreeRequest represents the request body for importing a nested tree
+type ImportTreeRequest struct {
+	ParentID *int64 `json:"parentId,omitempty" validate:"omitempty,gt=0"`
+	Root     *Node  `json:"root" validate:"required"`
+}
+
+// Validate validates the import request against the given size limits
+func (r *ImportTreeRequest) Validate(maxNodes, maxDepth int) error {
+	validate := validator.New()
+	if err := validate.Struct(r); err != nil {
+		return err
+	}
+
+	count := 0
+	var walk func(node *Node, path string, depth int) error
+	walk = func(node *Node, path string, depth int) error {
+		if node == nil {
+			return fmt.Errorf("%s: node cannot be null", path)
+		}
+		count++
+		if count > maxNodes {
+			return fmt.Errorf("import cannot contain more than %d nodes", maxNodes)
+		}
+		if depth > maxDepth {
+			return fmt.Errorf("import cannot be deeper than %d levels", maxDepth)
+		}
+		if len(node.Label) < 1 || len(node.Label) > 100 {
+			return fmt.Errorf("%s: label must be between 1 and 100 characters", path)
+		}
+		for i, child := range node.Children {
+			if err := walk(child, fmt.Sprintf("%s/children/%d", path, i), depth+1); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(r.Root, "/root", 1)
+}
+
+// ValidateAttributes checks the attributes of every node in the import
+// against the given limits
+func (r *ImportTreeRequest) ValidateAttributes(limits AttributeLimits) error {
+	var walk func(node *Node, path string) error
+	walk = func(node *Node, path string) error {
+		if err := limits.Validate(node.Attributes); err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		for i, child := range node.Children {
+			if err := walk(child, fmt.Sprintf("%s/children/%d", path, i)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return walk(r.Root, "/root")
+}
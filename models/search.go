@@ -0,0 +1,14 @@
+package models
+
+// SearchHit is a node whose label matched a search
+type SearchHit struct {
+	Node  *Node       `json:"node"`
+	Path  []*PathNode `json:"path"`
+	Score float64     `json:"score"`
+}
+
+// PathNode is an ancestor in a search hit's path
+type PathNode struct {
+	ID    int64  `json:"id"`
+	Label string `json:"label"`
+}
@@ -1,10 +1,19 @@
 package models
 
+import "time"
+
 // Node represents a single node in the tree
 type Node struct {
-	ID       int64   `json:"id"`
-	Label    string  `json:"label" validate:"required"`
-	Children []*Node `json:"children"`
+	ID         int64                  `json:"id"`
+	Label      string                 `json:"label" validate:"required"`
+	ParentID   *int64                 `json:"parentId"`
+	Position   float64                `json:"position"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	CreatedAt  time.Time              `json:"createdAt"`
+	UpdatedAt  time.Time              `json:"updatedAt"`
+	Version    int64                  `json:"version"`
+	DeletedAt  *time.Time             `json:"deletedAt,omitempty"`
+	Children   []*Node                `json:"children"`
 }
 
 // NewNode creates a new node with the given label
@@ -19,3 +28,10 @@ func NewNode(label string) *Node {
 func (n *Node) AddChild(child *Node) {
 	n.Children = append(n.Children, child)
 }
+
+// Tree is a named, independent forest of nodes
+type Tree struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"createdAt"`
+}
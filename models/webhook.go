@@ -0,0 +1,91 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/go-playground/validator/v10"
+)
+
+// Webhook is a subscription to the node changes of a tree. The secret is
+// only included in the response to its creation.
+type Webhook struct {
+	ID        int64     `json:"id"`
+	URL       string    `json:"url"`
+	Secret    string    `json:"secret,omitempty"`
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"createdAt"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+// WebhookDelivery is one event queued for, or delivered to, a webhook.
+// NextAttemptAt is only set while the delivery is pending.
+type WebhookDelivery struct {
+	ID             int64           `json:"id"`
+	WebhookID      int64           `json:"webhookId"`
+	Event          string          `json:"event"`
+	Status         string          `json:"status"`
+	Attempts       int             `json:"attempts"`
+	NextAttemptAt  *time.Time      `json:"nextAttemptAt,omitempty"`
+	LastAttemptAt  *time.Time      `json:"lastAttemptAt,omitempty"`
+	ResponseStatus int             `json:"responseStatus,omitempty"`
+	LastError      string          `json:"lastError,omitempty"`
+	CreatedAt      time.Time       `json:"createdAt"`
+	Payload        json.RawMessage `json:"payload"`
+}
+
+// CreateWebhookRequest represents the request body for creating a webhook.
+// Without a secret one is generated; without events every event type is
+// delivered. Webhooks are active unless active is false.
+type CreateWebhookRequest struct {
+	URL    string   `json:"url" validate:"required,url,max=2048"`
+	Secret string   `json:"secret,omitempty" validate:"omitempty,min=16,max=256"`
+	Events []string `json:"events,omitempty" validate:"omitempty,dive,required"`
+	Active *bool    `json:"active,omitempty"`
+}
+
+// Validate validates the create webhook request
+func (r *CreateWebhookRequest) Validate() error {
+	validate := validator.New()
+	if err := validate.Struct(r); err != nil {
+		return err
+	}
+	return validateWebhookURL(r.URL)
+}
+
+// UpdateWebhookRequest represents the request body for partially updating
+// a webhook. Omitted fields are left untouched; an empty events list
+// delivers every event type.
+type UpdateWebhookRequest struct {
+	URL    *string   `json:"url,omitempty" validate:"omitempty,url,max=2048"`
+	Secret *string   `json:"secret,omitempty" validate:"omitempty,min=16,max=256"`
+	Events *[]string `json:"events,omitempty" validate:"omitempty,dive,required"`
+	Active *bool     `json:"active,omitempty"`
+}
+
+// Validate validates the update webhook request
+func (r *UpdateWebhookRequest) Validate() error {
+	validate := validator.New()
+	if err := validate.Struct(r); err != nil {
+		return err
+	}
+	if r.URL == nil && r.Secret == nil && r.Events == nil && r.Active == nil {
+		return errors.New("at least one of url, secret, events and active must be set")
+	}
+	if r.URL != nil {
+		return validateWebhookURL(*r.URL)
+	}
+	return nil
+}
+
+// validateWebhookURL checks that a webhook URL can be POSTed to
+func validateWebhookURL(rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	return nil
+}
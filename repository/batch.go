@@ -0,0 +1,191 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+)
+
+// BatchOpType is the kind of change a BatchOp makes
+type BatchOpType string
+
+const (
+	// BatchCreate creates a node
+	BatchCreate BatchOpType = "create"
+	// BatchUpdate changes the fields set in the operation, like PatchNode
+	BatchUpdate BatchOpType = "update"
+	// BatchMove moves a node, like MoveNode
+	BatchMove BatchOpType = "move"
+	// BatchDelete moves a node to the trash, like DeleteNode
+	BatchDelete BatchOpType = "delete"
+)
+
+// BatchRef refers to a node in a batch: either an existing node by ID, or a
+// node created by an earlier operation of the batch by its TempID
+type BatchRef struct {
+	ID     int64
+	TempID string
+}
+
+// BatchOp is one operation of a batch
+type BatchOp struct {
+	Type       BatchOpType            // Kind of change
+	TempID     string                 // Create only: name later operations can refer to the new node by
+	Node       BatchRef               // Update, move and delete: the node to change
+	Label      *string                // Create (required) and update: the label
+	SetParent  bool                   // Update and move: whether to change the parent to Parent; creates always use Parent
+	Parent     *BatchRef              // The parent, or nil for a root
+	Attributes map[string]interface{} // Create and update: the attributes, if non-nil
+	BeforeID   *BatchRef              // Move only: place the node directly before this sibling
+	AfterID    *BatchRef              // Move only: place the node directly after this sibling
+	Index      *int                   // Move only: place the node at this 0-based index among its siblings
+	Mode       DeleteMode             // Delete only: what happens to the node's children
+	Version    *int64                 // Update, move and delete: fail unless the node is at this version
+}
+
+// BatchResult is the outcome of one operation of a batch
+type BatchResult struct {
+	NodeID  int64 // ID of the created, changed or deleted node
+	Deleted int64 // Delete only: number of nodes moved to the trash
+}
+
+// BatchError reports the operation that made a batch fail. Err is the
+// error the operation failed with, e.g. ErrNodeNotFound.
+type BatchError struct {
+	Index int
+	Err   error
+}
+
+// Error implements error
+func (e *BatchError) Error() string {
+	return fmt.Sprintf("operation %d: %v", e.Index, e.Err)
+}
+
+// Unwrap returns the operation's error
+func (e *BatchError) Unwrap() error {
+	return e.Err
+}
+
+// batchApplier applies the single operations of a batch. ApplyBatch
+// implementations provide one bound to their transaction.
+type batchApplier interface {
+	createNode(ctx context.Context, label string, parentID *int64, attributes map[string]interface{}) (int64, error)
+	patchNode(ctx context.Context, id int64, patch NodePatch, version *int64) error
+	moveNode(ctx context.Context, id int64, parentID *int64, pos MovePosition, version *int64) error
+	deleteNode(ctx context.Context, id int64, mode DeleteMode, version *int64) (int64, error)
+	// parentOf returns the parent of a live node, for moves that keep it
+	parentOf(ctx context.Context, id int64) (*int64, error)
+}
+
+// applyBatch validates ops and applies them in order through a. It stops
+// at the first failing operation; the caller must then discard every change.
+func applyBatch(ctx context.Context, a batchApplier, ops []*BatchOp) ([]*BatchResult, error) {
+	if err := validateBatch(ops); err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]int64)
+	resolve := func(ref *BatchRef) *int64 {
+		if ref == nil {
+			return nil
+		}
+		if ref.TempID != "" {
+			id := ids[ref.TempID]
+			return &id
+		}
+		id := ref.ID
+		return &id
+	}
+
+	results := make([]*BatchResult, 0, len(ops))
+	for i, op := range ops {
+		result := &BatchResult{}
+		var err error
+		if op.Type == BatchCreate {
+			result.NodeID, err = a.createNode(ctx, *op.Label, resolve(op.Parent), op.Attributes)
+			if err == nil && op.TempID != "" {
+				ids[op.TempID] = result.NodeID
+			}
+		} else {
+			result.NodeID = *resolve(&op.Node)
+			switch op.Type {
+			case BatchUpdate:
+				err = a.patchNode(ctx, result.NodeID, NodePatch{
+					Label:      op.Label,
+					SetParent:  op.SetParent,
+					ParentID:   resolve(op.Parent),
+					Attributes: op.Attributes,
+				}, op.Version)
+			case BatchMove:
+				parentID := resolve(op.Parent)
+				if !op.SetParent {
+					parentID, err = a.parentOf(ctx, result.NodeID)
+				}
+				if err == nil {
+					err = a.moveNode(ctx, result.NodeID, parentID, MovePosition{
+						BeforeID: resolve(op.BeforeID),
+						AfterID:  resolve(op.AfterID),
+						Index:    op.Index,
+					}, op.Version)
+				}
+			case BatchDelete:
+				result.Deleted, err = a.deleteNode(ctx, result.NodeID, op.Mode, op.Version)
+			}
+		}
+		if err != nil {
+			return nil, &BatchError{Index: i, Err: err}
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// validateBatch checks the operations of a batch before any is applied:
+// every operation must have the fields its type needs, temp IDs must be
+// unique and may only be referred to after the operation creating them
+func validateBatch(ops []*BatchOp) error {
+	if len(ops) == 0 {
+		return ErrInvalidInput
+	}
+
+	tempIDs := make(map[string]bool)
+	checkRef := func(ref *BatchRef) error {
+		if ref != nil && ref.TempID != "" && !tempIDs[ref.TempID] {
+			return fmt.Errorf("%w: temp ID %q is not created by an earlier operation", ErrInvalidInput, ref.TempID)
+		}
+		return nil
+	}
+
+	for i, op := range ops {
+		var err error
+		switch op.Type {
+		case BatchCreate:
+			if op.Label == nil || *op.Label == "" {
+				err = fmt.Errorf("%w: create needs a label", ErrInvalidInput)
+			}
+		case BatchUpdate, BatchMove:
+			err = checkRef(&op.Node)
+		case BatchDelete:
+			err = checkRef(&op.Node)
+			if err == nil && !op.Mode.Valid() {
+				err = fmt.Errorf("%w: invalid delete mode %q", ErrInvalidInput, op.Mode)
+			}
+		default:
+			err = fmt.Errorf("%w: unknown operation %q", ErrInvalidInput, op.Type)
+		}
+		for _, ref := range []*BatchRef{op.Parent, op.BeforeID, op.AfterID} {
+			if err == nil {
+				err = checkRef(ref)
+			}
+		}
+		if err == nil && op.Type == BatchCreate && op.TempID != "" {
+			if tempIDs[op.TempID] {
+				err = fmt.Errorf("%w: duplicate temp ID %q", ErrInvalidInput, op.TempID)
+			}
+			tempIDs[op.TempID] = true
+		}
+		if err != nil {
+			return &BatchError{Index: i, Err: err}
+		}
+	}
+	return nil
+}
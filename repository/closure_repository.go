@@ -0,0 +1,32 @@
+package repository
+
+import (
+	"github.com/ammiranda/tree_service/config"
+)
+
+// ClosureRepository implements Repository using PostgreSQL like
+// PostgresRepository, but answers the subtree, ancestor and move queries
+// from the node_closure table instead of the nodes' materialized paths.
+// node_closure holds a row for every node and each of its ancestors. The
+// triggers keep it and the paths up to date on every write whichever
+// repository makes it, so only the reads differ.
+type ClosureRepository struct {
+	*PostgresRepository
+}
+
+// NewClosureRepository creates a new closure-table PostgreSQL repository
+func NewClosureRepository(cfgProvider config.Provider) (*ClosureRepository, error) {
+	repo, err := NewPostgresRepository(cfgProvider)
+	if err != nil {
+		return nil, err
+	}
+	repo.hierarchy = closureHierarchy{}
+	return &ClosureRepository{PostgresRepository: repo}, nil
+}
+
+// ForTree returns a copy of the repository scoped to the given tree
+func (r *ClosureRepository) ForTree(treeID int64) Repository {
+	scoped := *r.PostgresRepository
+	scoped.treeID = treeID
+	return &ClosureRepository{PostgresRepository: &scoped}
+}
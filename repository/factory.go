@@ -0,0 +1,41 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ammiranda/tree_service/config"
+)
+
+// New creates the Repository selected by the repository config. The
+// returned repository still has to be initialized.
+func New(cfgProvider config.Provider) (Repository, error) {
+	cfg, err := config.GetRepositoryConfig(context.Background(), cfgProvider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get repository config: %w", err)
+	}
+
+	// The embedded database and the in-memory repository keep the
+	// hierarchy in parent_id alone
+	switch cfg.Driver {
+	case config.DriverSQLite:
+		return NewSQLiteRepository(cfg.SQLitePath), nil
+	case config.DriverMemory:
+		return NewInMemoryRepository(cfg.SnapshotPath, cfg.SnapshotInterval), nil
+	}
+
+	switch cfg.Hierarchy {
+	case config.HierarchyClosure:
+		repo, err := NewClosureRepository(cfgProvider)
+		if err != nil {
+			return nil, err
+		}
+		return repo, nil
+	default:
+		repo, err := NewPostgresRepository(cfgProvider)
+		if err != nil {
+			return nil, err
+		}
+		return repo, nil
+	}
+}
@@ -0,0 +1,73 @@
+package repository
+
+// hierarchy expresses the tree-shaped queries of PostgresRepository in terms
+// of one of the structures the migrations maintain for the hierarchy. Every
+// method returns an SQL fragment over nodes tables aliased by the given
+// names.
+type hierarchy interface {
+	// subtree returns a condition matching the nodes aliased n that lie in
+	// the subtree of the node aliased s, including s itself
+	subtree(n, s string) string
+	// ancestors returns a condition matching the nodes aliased a that are
+	// ancestors of the node aliased s, including s itself
+	ancestors(a, s string) string
+	// depth returns an expression evaluating to how many levels the node
+	// aliased n lies below the node aliased s in its subtree
+	depth(n, s string) string
+	// root returns an expression evaluating to the ID of the root of the
+	// node aliased n
+	root(n string) string
+}
+
+// pathHierarchy uses the materialized path of every node, which lists the
+// IDs from its root down to the node itself, e.g. "/1/5/12/". Triggers from
+// migration 11 set it on insert and rewrite the subtree's paths when a node
+// changes parent. The triggers read the parent's path unlocked: writers lock
+// the new node's parent, or the moved subtree, before changing either.
+type pathHierarchy struct{}
+
+func (pathHierarchy) subtree(n, s string) string {
+	return n + ".path LIKE " + s + ".path || '%'"
+}
+
+func (pathHierarchy) ancestors(a, s string) string {
+	return a + ".id = ANY(string_to_array(trim(BOTH '/' FROM " + s + ".path), '/')::integer[])"
+}
+
+func (pathHierarchy) depth(n, s string) string {
+	return "(" + pathDepth(n) + " - " + pathDepth(s) + ")"
+}
+
+func (pathHierarchy) root(n string) string {
+	return "split_part(" + n + ".path, '/', 2)::integer"
+}
+
+// pathDepth returns an expression evaluating to the depth of the node
+// aliased n, 0 for roots, by counting the separators in its path
+func pathDepth(n string) string {
+	return "(length(" + n + ".path) - length(replace(" + n + ".path, '/', '')) - 2)"
+}
+
+// closureHierarchy uses the node_closure table, which holds a row for every
+// pair of a node and one of its ancestors, including the node itself at
+// depth 0. Triggers from migration 12 add a node's rows on insert and
+// replace its subtree's rows when it changes parent. Like the path triggers
+// they read the parent's rows unlocked, and rely on the same locking by the
+// writers.
+type closureHierarchy struct{}
+
+func (closureHierarchy) subtree(n, s string) string {
+	return "EXISTS (SELECT 1 FROM node_closure WHERE ancestor_id = " + s + ".id AND descendant_id = " + n + ".id)"
+}
+
+func (closureHierarchy) ancestors(a, s string) string {
+	return "EXISTS (SELECT 1 FROM node_closure WHERE ancestor_id = " + a + ".id AND descendant_id = " + s + ".id)"
+}
+
+func (closureHierarchy) depth(n, s string) string {
+	return "(SELECT depth FROM node_closure WHERE ancestor_id = " + s + ".id AND descendant_id = " + n + ".id)"
+}
+
+func (closureHierarchy) root(n string) string {
+	return "(SELECT ancestor_id FROM node_closure WHERE descendant_id = " + n + ".id ORDER BY depth DESC LIMIT 1)"
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"time"
+)
+
+// HistoryAction is the kind of change a HistoryEntry records
+type HistoryAction string
+
+const (
+	// HistoryCreate records a node being created
+	HistoryCreate HistoryAction = "create"
+	// HistoryUpdate records a change made by UpdateNode or PatchNode
+	HistoryUpdate HistoryAction = "update"
+	// HistoryMove records a change made by MoveNode, or a child being
+	// re-parented when its parent is deleted in reparent mode
+	HistoryMove HistoryAction = "move"
+	// HistoryDelete records a node being moved to the trash
+	HistoryDelete HistoryAction = "delete"
+	// HistoryRestore records a node being restored from the trash
+	HistoryRestore HistoryAction = "restore"
+)
+
+// HistoryEntry is one recorded change to a node. The Old fields hold the
+// node before the change and are nil for creates and restores; the New
+// fields hold it after the change and are nil for deletes.
+type HistoryEntry struct {
+	ID          int64                  // Unique identifier for the entry, increasing with each change
+	NodeID      int64                  // ID of the changed node
+	TreeID      int64                  // ID of the tree the node belongs to
+	Action      HistoryAction          // Kind of change
+	OldLabel    *string                // Label before the change
+	NewLabel    *string                // Label after the change
+	OldParentID *int64                 // Parent before the change; nil for a root too
+	NewParentID *int64                 // Parent after the change; nil for a root too
+	Position    float64                // Position after the change, or at the time of a delete
+	Attributes  map[string]interface{} // Attributes after the change, or at the time of a delete
+	Actor       string                 // Who made the change, empty if unknown
+	ChangedAt   time.Time              // Time of the change
+}
+
+// actorKey is the context key under which WithActor stores the actor
+type actorKey struct{}
+
+// WithActor returns a copy of ctx that attributes the changes made with it
+// to actor in the node history
+func WithActor(ctx context.Context, actor string) context.Context {
+	return context.WithValue(ctx, actorKey{}, actor)
+}
+
+// ActorFromContext returns the actor stored by WithActor, or an empty
+// string if there is none
+func ActorFromContext(ctx context.Context) string {
+	actor, _ := ctx.Value(actorKey{}).(string)
+	return actor
+}
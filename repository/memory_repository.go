@@ -0,0 +1,362 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// InMemoryRepository implements Repository entirely in memory, for demos
+// and local development. It shares MockRepository's storage and semantics,
+// which match PostgresRepository's: IDs are never reused, parents are
+// validated, moves that would form a cycle are refused, deletes cascade to
+// the trash and pages are cut the same way.
+//
+// Given a snapshot path, it loads its state from that JSON file on
+// Initialize and writes the state back every snapshot interval and on
+// Cleanup, so the data survives restarts.
+type InMemoryRepository struct {
+	*MockRepository
+	snapshot *memorySnapshotter
+}
+
+// memorySnapshotter writes the state of an InMemoryRepository to its
+// snapshot file. It is shared by the repository and its tree-scoped views.
+type memorySnapshotter struct {
+	path     string
+	interval time.Duration
+	stop     chan struct{}
+	done     chan struct{}
+
+	mu      sync.Mutex
+	written []byte // Last state written, to skip unchanged snapshots
+}
+
+// memorySnapshot is the JSON document a snapshot file holds
+type memorySnapshot struct {
+	Trees          []*Tree            `json:"trees"`
+	NextTreeID     int64              `json:"nextTreeId"`
+	Nodes          []*Node            `json:"nodes"`
+	LastNodeID     int64              `json:"lastNodeId"`
+	History        []*HistoryEntry    `json:"history"`
+	LastHistoryID  int64              `json:"lastHistoryId"`
+	Webhooks       []*Webhook         `json:"webhooks"`
+	LastWebhookID  int64              `json:"lastWebhookId"`
+	Deliveries     []*WebhookDelivery `json:"deliveries"`
+	LastDeliveryID int64              `json:"lastDeliveryId"`
+}
+
+// NewInMemoryRepository creates a new in-memory repository. If
+// snapshotPath is empty nothing is persisted; otherwise the state is
+// written to it every snapshotInterval, or only on Cleanup if
+// snapshotInterval is 0.
+func NewInMemoryRepository(snapshotPath string, snapshotInterval time.Duration) *InMemoryRepository {
+	repo := &InMemoryRepository{MockRepository: NewMockRepository()}
+	if snapshotPath != "" {
+		repo.snapshot = &memorySnapshotter{path: snapshotPath, interval: snapshotInterval}
+	}
+	return repo
+}
+
+// Initialize loads the snapshot file, if there is one, and starts writing
+// snapshots in the background
+func (r *InMemoryRepository) Initialize(ctx context.Context) error {
+	if r.snapshot == nil {
+		return nil
+	}
+
+	data, err := os.ReadFile(r.snapshot.path)
+	switch {
+	case os.IsNotExist(err):
+		// Start empty; the file is created by the first snapshot
+	case err != nil:
+		return fmt.Errorf("error reading snapshot: %w", err)
+	default:
+		if err := r.restore(data); err != nil {
+			return err
+		}
+		r.snapshot.written = data
+	}
+
+	if r.snapshot.interval > 0 {
+		r.snapshot.stop = make(chan struct{})
+		r.snapshot.done = make(chan struct{})
+		go r.runSnapshots()
+	}
+	return nil
+}
+
+// Cleanup stops the background snapshots and writes a final one. Unlike
+// MockRepository's, it keeps the data.
+func (r *InMemoryRepository) Cleanup(ctx context.Context) error {
+	if r.snapshot == nil {
+		return nil
+	}
+
+	if r.snapshot.stop != nil {
+		close(r.snapshot.stop)
+		<-r.snapshot.done
+		r.snapshot.stop = nil
+	}
+	return r.Snapshot(ctx)
+}
+
+// ForTree returns a view of the repository scoped to the given tree
+func (r *InMemoryRepository) ForTree(treeID int64) Repository {
+	return &InMemoryRepository{
+		MockRepository: &MockRepository{mockState: r.mockState, treeID: treeID},
+		snapshot:       r.snapshot,
+	}
+}
+
+// The methods below add the input checks PostgresRepository makes and
+// MockRepository leaves out: labels can't be empty, pages start at 1 and
+// page sizes can't be negative.
+
+// CreateNode creates a new node
+func (r *InMemoryRepository) CreateNode(ctx context.Context, label string, parentID *int64) (int64, error) {
+	return r.CreateNodeWithAttributes(ctx, label, parentID, nil)
+}
+
+// CreateNodeWithAttributes creates a new node carrying JSON attributes
+func (r *InMemoryRepository) CreateNodeWithAttributes(ctx context.Context, label string, parentID *int64, attributes map[string]interface{}) (int64, error) {
+	if label == "" {
+		return 0, ErrInvalidInput
+	}
+	return r.MockRepository.CreateNodeWithAttributes(ctx, label, parentID, attributes)
+}
+
+// UpdateNode updates a node
+func (r *InMemoryRepository) UpdateNode(ctx context.Context, id int64, label string, parentID *int64) error {
+	if label == "" {
+		return ErrInvalidInput
+	}
+	return r.MockRepository.UpdateNode(ctx, id, label, parentID)
+}
+
+// GetAllNodes retrieves a page of trees
+func (r *InMemoryRepository) GetAllNodes(ctx context.Context, page, pageSize int) ([]*Node, int64, error) {
+	if err := checkPage(page, pageSize); err != nil {
+		return nil, 0, err
+	}
+	return r.MockRepository.GetAllNodes(ctx, page, pageSize)
+}
+
+// GetNodesAfter retrieves up to pageSize roots with an ID greater than
+// afterID, with their complete subtrees
+func (r *InMemoryRepository) GetNodesAfter(ctx context.Context, afterID int64, pageSize int) ([]*Node, bool, error) {
+	if err := checkPage(1, pageSize); err != nil {
+		return nil, false, err
+	}
+	return r.MockRepository.GetNodesAfter(ctx, afterID, pageSize)
+}
+
+// GetNodesByAttributes retrieves a page of trees pruned to the nodes whose
+// attributes match filter and their ancestors
+func (r *InMemoryRepository) GetNodesByAttributes(ctx context.Context, filter map[string]string, page, pageSize int) ([]*Node, int64, error) {
+	if err := checkPage(page, pageSize); err != nil {
+		return nil, 0, err
+	}
+	return r.MockRepository.GetNodesByAttributes(ctx, filter, page, pageSize)
+}
+
+// SearchNodes finds nodes whose label matches query
+func (r *InMemoryRepository) SearchNodes(ctx context.Context, query string, rootID *int64, page, pageSize int) ([]*SearchHit, int64, error) {
+	if err := checkPage(page, pageSize); err != nil {
+		return nil, 0, err
+	}
+	return r.MockRepository.SearchNodes(ctx, query, rootID, page, pageSize)
+}
+
+// ListTrash retrieves a page of the trash
+func (r *InMemoryRepository) ListTrash(ctx context.Context, page, pageSize int) ([]*Node, int64, error) {
+	if err := checkPage(page, pageSize); err != nil {
+		return nil, 0, err
+	}
+	return r.MockRepository.ListTrash(ctx, page, pageSize)
+}
+
+// GetNodeHistory retrieves a page of a node's history, newest first
+func (r *InMemoryRepository) GetNodeHistory(ctx context.Context, id int64, page, pageSize int) ([]*HistoryEntry, int64, error) {
+	if err := checkPage(page, pageSize); err != nil {
+		return nil, 0, err
+	}
+	return r.MockRepository.GetNodeHistory(ctx, id, page, pageSize)
+}
+
+// GetAllNodesAsOf retrieves a page of trees as they were at asOf
+func (r *InMemoryRepository) GetAllNodesAsOf(ctx context.Context, asOf time.Time, page, pageSize int) ([]*Node, int64, error) {
+	if err := checkPage(page, pageSize); err != nil {
+		return nil, 0, err
+	}
+	return r.MockRepository.GetAllNodesAsOf(ctx, asOf, page, pageSize)
+}
+
+// ListWebhookDeliveries retrieves a page of a webhook's deliveries
+func (r *InMemoryRepository) ListWebhookDeliveries(ctx context.Context, webhookID int64, page, pageSize int) ([]*WebhookDelivery, int64, error) {
+	if err := checkPage(page, pageSize); err != nil {
+		return nil, 0, err
+	}
+	return r.MockRepository.ListWebhookDeliveries(ctx, webhookID, page, pageSize)
+}
+
+// checkPage refuses pages before the first and negative page sizes, like
+// PostgreSQL refusing a negative OFFSET or LIMIT
+func checkPage(page, pageSize int) error {
+	if page < 1 || pageSize < 0 {
+		return ErrInvalidInput
+	}
+	return nil
+}
+
+// Snapshot writes the repository's state to its snapshot file now. It does
+// nothing if the repository has no snapshot path or the state hasn't
+// changed since the last snapshot.
+func (r *InMemoryRepository) Snapshot(ctx context.Context) error {
+	if r.snapshot == nil {
+		return nil
+	}
+
+	data, err := r.encode()
+	if err != nil {
+		return err
+	}
+
+	r.snapshot.mu.Lock()
+	defer r.snapshot.mu.Unlock()
+
+	if bytes.Equal(data, r.snapshot.written) {
+		return nil
+	}
+
+	// Write a temporary file and rename it over the snapshot, so a crash
+	// mid-write never leaves a truncated snapshot behind
+	tmp, err := os.CreateTemp(filepath.Dir(r.snapshot.path), filepath.Base(r.snapshot.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating snapshot: %w", err)
+	}
+	defer func() {
+		if err := os.Remove(tmp.Name()); err != nil && !os.IsNotExist(err) {
+			fmt.Printf("Warning: Error removing temporary snapshot: %v\n", err)
+		}
+	}()
+	if _, err := tmp.Write(data); err != nil {
+		if closeErr := tmp.Close(); closeErr != nil {
+			fmt.Printf("Warning: Error closing temporary snapshot: %v\n", closeErr)
+		}
+		return fmt.Errorf("error writing snapshot: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing snapshot: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), r.snapshot.path); err != nil {
+		return fmt.Errorf("error replacing snapshot: %w", err)
+	}
+
+	r.snapshot.written = data
+	return nil
+}
+
+// runSnapshots writes a snapshot every interval until Cleanup. Errors are
+// logged and retried on the next run.
+func (r *InMemoryRepository) runSnapshots() {
+	defer close(r.snapshot.done)
+
+	ticker := time.NewTicker(r.snapshot.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.snapshot.stop:
+			return
+		case <-ticker.C:
+			if err := r.Snapshot(context.Background()); err != nil {
+				fmt.Printf("Warning: Failed to write snapshot: %v\n", err)
+			}
+		}
+	}
+}
+
+// encode returns the repository's whole state as a snapshot document, with
+// every collection ordered by ID so unchanged state encodes identically
+func (r *InMemoryRepository) encode() ([]byte, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	snapshot := memorySnapshot{
+		Trees:          make([]*Tree, 0, len(r.trees)),
+		NextTreeID:     r.nextTreeID,
+		Nodes:          make([]*Node, 0, len(r.nodes)),
+		LastNodeID:     r.lastID,
+		History:        r.history,
+		LastHistoryID:  r.lastHistoryID,
+		Webhooks:       make([]*Webhook, 0, len(r.webhooks)),
+		LastWebhookID:  r.lastWebhookID,
+		Deliveries:     r.deliveries,
+		LastDeliveryID: r.lastDeliveryID,
+	}
+	for _, tree := range r.trees {
+		snapshot.Trees = append(snapshot.Trees, tree)
+	}
+	sort.Slice(snapshot.Trees, func(i, j int) bool {
+		return snapshot.Trees[i].ID < snapshot.Trees[j].ID
+	})
+	for _, node := range r.nodes {
+		snapshot.Nodes = append(snapshot.Nodes, node)
+	}
+	sort.Slice(snapshot.Nodes, func(i, j int) bool {
+		return snapshot.Nodes[i].ID < snapshot.Nodes[j].ID
+	})
+	for _, webhook := range r.webhooks {
+		snapshot.Webhooks = append(snapshot.Webhooks, webhook)
+	}
+	sort.Slice(snapshot.Webhooks, func(i, j int) bool {
+		return snapshot.Webhooks[i].ID < snapshot.Webhooks[j].ID
+	})
+
+	data, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding snapshot: %w", err)
+	}
+	return data, nil
+}
+
+// restore replaces the repository's state with a snapshot document
+func (r *InMemoryRepository) restore(data []byte) error {
+	var snapshot memorySnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return fmt.Errorf("error decoding snapshot: %w", err)
+	}
+
+	trees := make(map[int64]*Tree, len(snapshot.Trees))
+	for _, tree := range snapshot.Trees {
+		trees[tree.ID] = tree
+	}
+	if _, ok := trees[DefaultTreeID]; !ok {
+		return fmt.Errorf("error decoding snapshot: default tree %d is missing", DefaultTreeID)
+	}
+	nodes := make(map[int64]*Node, len(snapshot.Nodes))
+	for _, node := range snapshot.Nodes {
+		nodes[node.ID] = node
+	}
+	webhooks := make(map[int64]*Webhook, len(snapshot.Webhooks))
+	for _, webhook := range snapshot.Webhooks {
+		webhooks[webhook.ID] = webhook
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.trees, r.nextTreeID = trees, snapshot.NextTreeID
+	r.nodes, r.lastID = nodes, snapshot.LastNodeID
+	r.history, r.lastHistoryID = snapshot.History, snapshot.LastHistoryID
+	r.webhooks, r.lastWebhookID = webhooks, snapshot.LastWebhookID
+	r.deliveries, r.lastDeliveryID = snapshot.Deliveries, snapshot.LastDeliveryID
+	return nil
+}
@@ -2,21 +2,48 @@ package repository
 
 import (
 	"context"
+	"fmt"
 	"sort"
+	"strings"
 	"sync"
+	"time"
 )
 
 // MockRepository implements Repository interface for testing
 type MockRepository struct {
-	nodes map[int64]*Node
-	mu    sync.RWMutex
+	*mockState
+	treeID int64
+}
+
+// mockState is the storage shared by a MockRepository and the tree-scoped
+// views returned by ForTree
+type mockState struct {
+	nodes          map[int64]*Node
+	lastID         int64
+	trees          map[int64]*Tree
+	nextTreeID     int64
+	history        []*HistoryEntry
+	lastHistoryID  int64
+	webhooks       map[int64]*Webhook
+	lastWebhookID  int64
+	deliveries     []*WebhookDelivery
+	lastDeliveryID int64
+	mu             sync.RWMutex
 }
 
 // NewMockRepository creates a new mock repository
 func NewMockRepository() *MockRepository {
-	return &MockRepository{
-		nodes: make(map[int64]*Node),
+	state := &mockState{nodes: make(map[int64]*Node), webhooks: make(map[int64]*Webhook)}
+	state.resetTrees()
+	return &MockRepository{mockState: state, treeID: DefaultTreeID}
+}
+
+// resetTrees drops every tree except a fresh default tree
+func (s *mockState) resetTrees() {
+	s.trees = map[int64]*Tree{
+		DefaultTreeID: {ID: DefaultTreeID, Name: "default", CreatedAt: time.Now()},
 	}
+	s.nextTreeID = DefaultTreeID + 1
 }
 
 // Initialize performs any necessary setup
@@ -29,44 +56,285 @@ func (m *MockRepository) Cleanup(ctx context.Context) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	m.nodes = make(map[int64]*Node)
+	m.lastID = 0
+	m.history = nil
+	m.lastHistoryID = 0
+	m.webhooks = make(map[int64]*Webhook)
+	m.lastWebhookID = 0
+	m.deliveries = nil
+	m.lastDeliveryID = 0
+	m.resetTrees()
 	return nil
 }
 
+// ForTree returns a view of the repository scoped to treeID
+func (m *MockRepository) ForTree(treeID int64) Repository {
+	return &MockRepository{mockState: m.mockState, treeID: treeID}
+}
+
+// CreateTree creates a new, empty named tree
+func (m *MockRepository) CreateTree(ctx context.Context, name string) (*Tree, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, ErrInvalidInput
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, tree := range m.trees {
+		if tree.Name == name {
+			return nil, ErrTreeExists
+		}
+	}
+
+	tree := &Tree{ID: m.nextTreeID, Name: name, CreatedAt: time.Now()}
+	m.trees[tree.ID] = tree
+	m.nextTreeID++
+
+	treeCopy := *tree
+	return &treeCopy, nil
+}
+
+// GetTree retrieves a tree by ID
+func (m *MockRepository) GetTree(ctx context.Context, id int64) (*Tree, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	tree, ok := m.trees[id]
+	if !ok {
+		return nil, ErrTreeNotFound
+	}
+
+	treeCopy := *tree
+	return &treeCopy, nil
+}
+
+// ListTrees retrieves every tree ordered by ID
+func (m *MockRepository) ListTrees(ctx context.Context) ([]*Tree, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	trees := make([]*Tree, 0, len(m.trees))
+	for _, tree := range m.trees {
+		treeCopy := *tree
+		trees = append(trees, &treeCopy)
+	}
+	sort.Slice(trees, func(i, j int) bool {
+		return trees[i].ID < trees[j].ID
+	})
+
+	return trees, nil
+}
+
+// DeleteTree deletes a tree together with all of its nodes
+func (m *MockRepository) DeleteTree(ctx context.Context, id int64) (int64, error) {
+	if id == DefaultTreeID {
+		return 0, ErrInvalidInput
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.trees[id]; !ok {
+		return 0, ErrTreeNotFound
+	}
+
+	var deleted int64
+	for nodeID, node := range m.nodes {
+		if node.TreeID == id {
+			delete(m.nodes, nodeID)
+			deleted++
+		}
+	}
+	delete(m.trees, id)
+
+	history := m.history[:0]
+	for _, entry := range m.history {
+		if entry.TreeID != id {
+			history = append(history, entry)
+		}
+	}
+	m.history = history
+
+	for webhookID, webhook := range m.webhooks {
+		if webhook.TreeID == id {
+			delete(m.webhooks, webhookID)
+		}
+	}
+	deliveries := m.deliveries[:0]
+	for _, delivery := range m.deliveries {
+		if delivery.TreeID != id {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	m.deliveries = deliveries
+
+	return deleted, nil
+}
+
 // CreateNode creates a new node
 func (m *MockRepository) CreateNode(ctx context.Context, label string, parentID *int64) (int64, error) {
+	return m.CreateNodeWithAttributes(ctx, label, parentID, nil)
+}
+
+// CreateNodeWithAttributes creates a new node carrying JSON attributes
+func (m *MockRepository) CreateNodeWithAttributes(ctx context.Context, label string, parentID *int64, attributes map[string]interface{}) (int64, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// Generate a new ID
-	id := int64(len(m.nodes) + 1)
+	return m.createNode(ctx, label, parentID, attributes)
+}
+
+// createNode creates a node. Callers must hold the lock.
+func (m *MockRepository) createNode(ctx context.Context, label string, parentID *int64, attributes map[string]interface{}) (int64, error) {
+	if err := m.checkParent(0, parentID); err != nil {
+		return 0, err
+	}
+
+	// Generate a new ID; IDs of purged nodes are never reused
+	m.lastID++
+	id := m.lastID
 
 	// Create the node
+	now := time.Now()
 	node := &Node{
-		ID:       id,
-		Label:    label,
-		ParentID: parentID,
+		ID:         id,
+		TreeID:     m.treeID,
+		Label:      label,
+		ParentID:   copyID(parentID),
+		Position:   m.nextPosition(parentID),
+		Attributes: copyAttributes(attributes),
+		CreatedAt:  now,
+		UpdatedAt:  now,
+		Version:    1,
 	}
 
 	// Store the node
 	m.nodes[id] = node
+	m.record(ctx, HistoryCreate, nil, node)
 
 	return id, nil
 }
 
+// ImportTree creates a nested tree of nodes atomically
+func (m *MockRepository) ImportTree(ctx context.Context, parentID *int64, root *ImportNode) (map[string]int64, error) {
+	// Validate the whole document before creating anything
+	if err := validateImport(root); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if err := m.checkParent(0, parentID); err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]int64)
+	now := time.Now()
+	var insert func(node *ImportNode, parentID *int64, position float64)
+	insert = func(node *ImportNode, parentID *int64, position float64) {
+		m.lastID++
+		id := m.lastID
+		created := &Node{
+			ID:         id,
+			TreeID:     m.treeID,
+			Label:      node.Label,
+			ParentID:   copyID(parentID),
+			Position:   position,
+			Attributes: copyAttributes(node.Attributes),
+			CreatedAt:  now,
+			UpdatedAt:  now,
+			Version:    1,
+		}
+		m.nodes[id] = created
+		m.record(ctx, HistoryCreate, nil, created)
+		ids[node.Key] = id
+		for i, child := range node.Children {
+			insert(child, &id, float64(i+1))
+		}
+	}
+	insert(root, parentID, m.nextPosition(parentID))
+
+	return ids, nil
+}
+
 // GetNode retrieves a node by ID
 func (m *MockRepository) GetNode(ctx context.Context, id int64) (*Node, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
 
-	node, ok := m.nodes[id]
+	node, ok := m.node(id)
+	if !ok {
+		return nil, ErrNodeNotFound
+	}
+
+	return cloneNode(node), nil
+}
+
+// GetSubtree retrieves a node and its descendants down to maxDepth levels
+func (m *MockRepository) GetSubtree(ctx context.Context, id int64, maxDepth int) ([]*Node, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	root, ok := m.node(id)
+	if !ok {
+		return nil, ErrNodeNotFound
+	}
+
+	// Walk the subtree level by level so the result is ordered by depth
+	result := []*Node{cloneNode(root)}
+	level := []int64{id}
+	for depth := 0; len(level) > 0 && (maxDepth < 0 || depth < maxDepth); depth++ {
+		var next []*Node
+		for _, parentID := range level {
+			for _, node := range m.nodes {
+				if node.ParentID != nil && *node.ParentID == parentID && node.DeletedAt == nil {
+					next = append(next, node)
+				}
+			}
+		}
+		sort.Slice(next, func(i, j int) bool {
+			return next[i].ID < next[j].ID
+		})
+
+		level = level[:0]
+		for _, node := range next {
+			result = append(result, cloneNode(node))
+			level = append(level, node.ID)
+		}
+	}
+
+	return result, nil
+}
+
+// GetAncestors retrieves the chain of nodes from the root down to the node
+func (m *MockRepository) GetAncestors(ctx context.Context, id int64) ([]*Node, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	node, ok := m.node(id)
 	if !ok {
 		return nil, ErrNodeNotFound
 	}
 
-	return node, nil
+	// Walk up to the root, then reverse so the root comes first
+	var chain []*Node
+	for node != nil {
+		chain = append(chain, cloneNode(node))
+		if node.ParentID == nil {
+			break
+		}
+		node = m.nodes[*node.ParentID]
+	}
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+
+	return chain, nil
 }
 
-// GetAllNodes retrieves all nodes with pagination
+// GetAllNodes retrieves a page of root nodes with their complete subtrees
 func (m *MockRepository) GetAllNodes(ctx context.Context, page, pageSize int) ([]*Node, int64, error) {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
@@ -74,7 +342,37 @@ func (m *MockRepository) GetAllNodes(ctx context.Context, page, pageSize int) ([
 	// First, identify and sort root nodes
 	var rootNodes []*Node
 	for _, node := range m.nodes {
-		if node.ParentID == nil {
+		if node.ParentID == nil && node.TreeID == m.treeID && node.DeletedAt == nil {
+			rootNodes = append(rootNodes, node)
+		}
+	}
+	sort.Slice(rootNodes, func(i, j int) bool {
+		return rootNodes[i].ID < rootNodes[j].ID
+	})
+	total := int64(len(rootNodes))
+
+	// Calculate pagination for root nodes
+	offset := (page - 1) * pageSize
+	if offset >= len(rootNodes) {
+		return []*Node{}, total, nil
+	}
+	end := offset + pageSize
+	if end > len(rootNodes) {
+		end = len(rootNodes)
+	}
+
+	return m.collectSubtrees(rootNodes[offset:end]), total, nil
+}
+
+// GetNodesAfter retrieves up to pageSize roots with an ID greater than
+// afterID, with their complete subtrees
+func (m *MockRepository) GetNodesAfter(ctx context.Context, afterID int64, pageSize int) ([]*Node, bool, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var rootNodes []*Node
+	for _, node := range m.nodes {
+		if node.ParentID == nil && node.TreeID == m.treeID && node.DeletedAt == nil && node.ID > afterID {
 			rootNodes = append(rootNodes, node)
 		}
 	}
@@ -82,135 +380,153 @@ func (m *MockRepository) GetAllNodes(ctx context.Context, page, pageSize int) ([
 		return rootNodes[i].ID < rootNodes[j].ID
 	})
 
-	// If no pagination is needed (pageSize >= total nodes), return all nodes
-	if pageSize >= len(m.nodes) {
-		result := make([]*Node, 0, len(m.nodes))
-		for _, node := range m.nodes {
-			nodeCopy := &Node{
-				ID:       node.ID,
-				Label:    node.Label,
-				ParentID: node.ParentID,
+	hasMore := len(rootNodes) > pageSize
+	if hasMore {
+		rootNodes = rootNodes[:pageSize]
+	}
+
+	return m.collectSubtrees(rootNodes), hasMore, nil
+}
+
+// GetNodesByAttributes retrieves a page of trees pruned to the nodes whose
+// attributes match filter and their ancestors
+func (m *MockRepository) GetNodesByAttributes(ctx context.Context, filter map[string]string, page, pageSize int) ([]*Node, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	// Keep every match and the chain of ancestors above it
+	kept := make(map[int64]bool)
+	for _, node := range m.nodes {
+		if node.TreeID != m.treeID || node.DeletedAt != nil || !attributesMatch(node.Attributes, filter) {
+			continue
+		}
+		for current := node; current != nil && !kept[current.ID]; {
+			kept[current.ID] = true
+			if current.ParentID == nil {
+				break
 			}
-			result = append(result, nodeCopy)
+			current = m.nodes[*current.ParentID]
+		}
+	}
+
+	var rootNodes []*Node
+	for id := range kept {
+		if node := m.nodes[id]; node.ParentID == nil {
+			rootNodes = append(rootNodes, node)
 		}
-		sort.Slice(result, func(i, j int) bool {
-			return result[i].ID < result[j].ID
-		})
-		return result, int64(len(m.nodes)), nil
 	}
+	sort.Slice(rootNodes, func(i, j int) bool {
+		return rootNodes[i].ID < rootNodes[j].ID
+	})
+	total := int64(len(rootNodes))
 
 	// Calculate pagination for root nodes
 	offset := (page - 1) * pageSize
+	if offset >= len(rootNodes) {
+		return []*Node{}, total, nil
+	}
 	end := offset + pageSize
 	if end > len(rootNodes) {
 		end = len(rootNodes)
 	}
 
-	// Get the paginated root nodes
-	var paginatedRoots []*Node
-	if offset < len(rootNodes) {
-		paginatedRoots = rootNodes[offset:end]
+	var result []*Node
+	for _, node := range m.collectSubtrees(rootNodes[offset:end]) {
+		if kept[node.ID] {
+			result = append(result, node)
+		}
 	}
+	return result, total, nil
+}
 
-	// Build result set: first add roots, then all their children
-	result := make([]*Node, 0)
+// SearchNodes scans every node for labels matching query
+func (m *MockRepository) SearchNodes(ctx context.Context, query string, rootID *int64, page, pageSize int) ([]*SearchHit, int64, error) {
+	if query == "" {
+		return nil, 0, ErrInvalidInput
+	}
 
-	// Add root nodes first
-	for _, root := range paginatedRoots {
-		// Create a copy of the root node without children
-		rootCopy := &Node{
-			ID:       root.ID,
-			Label:    root.Label,
-			ParentID: root.ParentID,
-		}
-		result = append(result, rootCopy)
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-		// Find all children of this root node
-		for _, node := range m.nodes {
-			if node.ParentID != nil && *node.ParentID == root.ID {
-				// Create a copy of the child node without children
-				childCopy := &Node{
-					ID:       node.ID,
-					Label:    node.Label,
-					ParentID: node.ParentID,
-				}
-				result = append(result, childCopy)
-			}
+	if rootID != nil {
+		if _, ok := m.node(*rootID); !ok {
+			return nil, 0, ErrNodeNotFound
 		}
 	}
 
-	// If we have no results but there are nodes in the repository,
-	// it means we need to include nodes whose parents are not in the current page
-	if len(result) == 0 && len(m.nodes) > 0 {
-		// Find all nodes that should be in this page
-		for _, node := range m.nodes {
-			// Skip nodes that are already included
-			alreadyIncluded := false
-			for _, includedNode := range result {
-				if includedNode.ID == node.ID {
-					alreadyIncluded = true
-					break
-				}
+	var hits []*SearchHit
+	for _, node := range m.nodes {
+		if node.TreeID != m.treeID || node.DeletedAt != nil {
+			continue
+		}
+		score, ok := labelScore(node.Label, query)
+		if !ok {
+			continue
+		}
+
+		// Collect the ancestors, checking the node is inside the scope
+		inScope := rootID == nil || node.ID == *rootID
+		var path []*Node
+		for current := node.ParentID; current != nil; {
+			parent, ok := m.nodes[*current]
+			if !ok {
+				break
 			}
-			if !alreadyIncluded {
-				// If this node has a parent, make sure the parent is included
-				if node.ParentID != nil {
-					parent, exists := m.nodes[*node.ParentID]
-					if exists {
-						// Add parent first
-						parentCopy := &Node{
-							ID:       parent.ID,
-							Label:    parent.Label,
-							ParentID: parent.ParentID,
-						}
-						result = append(result, parentCopy)
-					}
-				}
-				// Add the node
-				nodeCopy := &Node{
-					ID:       node.ID,
-					Label:    node.Label,
-					ParentID: node.ParentID,
-				}
-				result = append(result, nodeCopy)
+			if rootID != nil && parent.ID == *rootID {
+				inScope = true
 			}
+			path = append([]*Node{cloneNode(parent)}, path...)
+			current = parent.ParentID
 		}
-		// Sort by ID
-		sort.Slice(result, func(i, j int) bool {
-			return result[i].ID < result[j].ID
-		})
+		if !inScope {
+			continue
+		}
+
+		hits = append(hits, &SearchHit{Node: cloneNode(node), Path: path, Score: score})
 	}
+	sort.Slice(hits, func(i, j int) bool {
+		if hits[i].Score != hits[j].Score {
+			return hits[i].Score > hits[j].Score
+		}
+		return hits[i].Node.ID < hits[j].Node.ID
+	})
+	total := int64(len(hits))
 
-	// If we have a root node with children, make sure we include all children
-	if len(result) > 0 && result[0].ParentID == nil {
-		// Find all children of the root node
-		for _, node := range m.nodes {
-			if node.ParentID != nil && *node.ParentID == result[0].ID {
-				// Skip nodes that are already included
-				alreadyIncluded := false
-				for _, includedNode := range result {
-					if includedNode.ID == node.ID {
-						alreadyIncluded = true
-						break
-					}
-				}
-				if !alreadyIncluded {
-					nodeCopy := &Node{
-						ID:       node.ID,
-						Label:    node.Label,
-						ParentID: node.ParentID,
-					}
-					result = append(result, nodeCopy)
-				}
-			}
+	offset := (page - 1) * pageSize
+	if offset >= len(hits) {
+		return []*SearchHit{}, total, nil
+	}
+	end := offset + pageSize
+	if end > len(hits) {
+		end = len(hits)
+	}
+
+	return hits[offset:end], total, nil
+}
+
+// collectSubtrees returns copies of the given roots and all their
+// descendants ordered by ID. Callers must hold the lock.
+func (m *MockRepository) collectSubtrees(roots []*Node) []*Node {
+	children := make(map[int64][]*Node)
+	for _, node := range m.nodes {
+		if node.ParentID != nil && node.DeletedAt == nil {
+			children[*node.ParentID] = append(children[*node.ParentID], node)
 		}
-		// Sort by ID
-		sort.Slice(result, func(i, j int) bool {
-			return result[i].ID < result[j].ID
-		})
 	}
 
-	return result, int64(len(m.nodes)), nil
+	result := make([]*Node, 0, len(roots))
+	queue := append([]*Node{}, roots...)
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		result = append(result, cloneNode(node))
+		queue = append(queue, children[node.ID]...)
+	}
+
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result
 }
 
 // UpdateNode updates a node
@@ -218,45 +534,1006 @@ func (m *MockRepository) UpdateNode(ctx context.Context, id int64, label string,
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	node, ok := m.nodes[id]
+	node, ok := m.node(id)
 	if !ok {
 		return ErrNodeNotFound
 	}
 
+	if err := m.checkParent(id, parentID); err != nil {
+		return err
+	}
+
+	// A node that changes parent goes to the end of its new siblings
+	before := cloneNode(node)
+	if !sameID(node.ParentID, parentID) {
+		node.Position = m.nextPosition(parentID)
+	}
 	node.Label = label
-	node.ParentID = parentID
+	node.ParentID = copyID(parentID)
+	touch(node)
+	m.record(ctx, HistoryUpdate, before, node)
 
 	return nil
 }
 
-// DeleteNode deletes a node and its children
-func (m *MockRepository) DeleteNode(ctx context.Context, id int64) error {
+// PatchNode updates only the fields set in patch
+func (m *MockRepository) PatchNode(ctx context.Context, id int64, patch NodePatch) error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	// First, find and delete all child nodes
-	toDelete := []int64{id}
-	deleted := make(map[int64]bool)
+	return m.patchNode(ctx, id, patch, nil)
+}
 
-	for len(toDelete) > 0 {
-		currentID := toDelete[0]
-		toDelete = toDelete[1:]
+// UpdateNodeIfVersion applies patch if the node is at the given version
+func (m *MockRepository) UpdateNodeIfVersion(ctx context.Context, id int64, version int64, patch NodePatch) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-		if deleted[currentID] {
-			continue
+	return m.patchNode(ctx, id, patch, &version)
+}
+
+// patchNode applies patch, first checking the node's version if version is
+// set. Callers must hold the lock.
+func (m *MockRepository) patchNode(ctx context.Context, id int64, patch NodePatch, version *int64) error {
+	if err := validatePatch(patch); err != nil {
+		return err
+	}
+
+	node, ok := m.node(id)
+	if !ok {
+		return ErrNodeNotFound
+	}
+	if patch.SetParent {
+		if err := m.checkParent(id, patch.ParentID); err != nil {
+			return err
 		}
+	}
+	if version != nil && node.Version != *version {
+		return ErrVersionConflict
+	}
 
-		// Find all children of the current node
-		for nodeID, node := range m.nodes {
-			if node.ParentID != nil && *node.ParentID == currentID {
-				toDelete = append(toDelete, nodeID)
-			}
+	before := cloneNode(node)
+	if patch.SetParent {
+		// A node that changes parent goes to the end of its new siblings
+		if !sameID(node.ParentID, patch.ParentID) {
+			node.Position = m.nextPosition(patch.ParentID)
 		}
+		node.ParentID = copyID(patch.ParentID)
+	}
+	if patch.Label != nil {
+		node.Label = *patch.Label
+	}
+	if patch.Attributes != nil {
+		node.Attributes = copyAttributes(patch.Attributes)
+	}
+	touch(node)
+	m.record(ctx, HistoryUpdate, before, node)
 
-		// Delete the current node
-		delete(m.nodes, currentID)
-		deleted[currentID] = true
+	return nil
+}
+
+// MoveNode moves a node to a new parent and place among its siblings
+func (m *MockRepository) MoveNode(ctx context.Context, id int64, parentID *int64, pos MovePosition) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.moveNode(ctx, id, parentID, pos, nil)
+}
+
+// MoveNodeIfVersion moves a node if it is at the given version
+func (m *MockRepository) MoveNodeIfVersion(ctx context.Context, id int64, version int64, parentID *int64, pos MovePosition) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.moveNode(ctx, id, parentID, pos, &version)
+}
+
+// moveNode moves a node, first checking its version if version is set.
+// Callers must hold the lock.
+func (m *MockRepository) moveNode(ctx context.Context, id int64, parentID *int64, pos MovePosition, version *int64) error {
+	if err := validateMove(parentID, pos); err != nil {
+		return err
+	}
+
+	node, ok := m.node(id)
+	if !ok {
+		return ErrNodeNotFound
+	}
+
+	if err := m.checkParent(id, parentID); err != nil {
+		return err
+	}
+	if version != nil && node.Version != *version {
+		return ErrVersionConflict
+	}
+
+	// Roots are ordered by ID, so only children need a rank
+	position := float64(1)
+	if parentID != nil {
+		var siblings []sibling
+		for _, child := range m.sortedChildren(*parentID) {
+			if child.ID != id {
+				siblings = append(siblings, sibling{id: child.ID, position: child.Position})
+			}
+		}
+		p, renumbered, err := rankAmong(siblings, pos)
+		if err != nil {
+			return err
+		}
+		for siblingID, siblingPosition := range renumbered {
+			m.nodes[siblingID].Position = siblingPosition
+			touch(m.nodes[siblingID])
+		}
+		position = p
 	}
 
+	before := cloneNode(node)
+	node.ParentID = copyID(parentID)
+	node.Position = position
+	touch(node)
+	m.record(ctx, HistoryMove, before, node)
+
 	return nil
 }
+
+// DeleteNode deletes a node, handling its children according to mode
+func (m *MockRepository) DeleteNode(ctx context.Context, id int64, mode DeleteMode) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.deleteNode(ctx, id, mode, nil)
+}
+
+// DeleteNodeIfVersion deletes a node if it is at the given version
+func (m *MockRepository) DeleteNodeIfVersion(ctx context.Context, id int64, version int64, mode DeleteMode) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.deleteNode(ctx, id, mode, &version)
+}
+
+// deleteNode deletes a node, first checking its version if version is set.
+// Callers must hold the lock.
+func (m *MockRepository) deleteNode(ctx context.Context, id int64, mode DeleteMode, version *int64) (int64, error) {
+	if !mode.Valid() {
+		return 0, ErrInvalidInput
+	}
+
+	node, ok := m.node(id)
+	if !ok {
+		return 0, ErrNodeNotFound
+	}
+	if version != nil && node.Version != *version {
+		return 0, ErrVersionConflict
+	}
+
+	switch mode {
+	case DeleteModeRefuse:
+		if len(m.sortedChildren(id)) > 0 {
+			return 0, ErrNodeHasChildren
+		}
+	case DeleteModeReparent:
+		// Append the children after their new siblings, keeping their order
+		next := m.nextPosition(node.ParentID)
+		for i, child := range m.sortedChildren(id) {
+			before := cloneNode(child)
+			child.ParentID = copyID(node.ParentID)
+			if node.ParentID != nil {
+				child.Position = next + float64(i)
+			} else {
+				child.Position = 1
+			}
+			touch(child)
+			m.record(ctx, HistoryMove, before, child)
+		}
+	}
+
+	// Move the node and its remaining live descendants to the trash; nodes
+	// trashed earlier keep their own deletion time
+	now := time.Now()
+	var trashed int64
+	queue := []*Node{node}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		queue = append(queue, m.sortedChildren(current.ID)...)
+
+		deletedAt := now
+		current.DeletedAt = &deletedAt
+		touch(current)
+		m.record(ctx, HistoryDelete, current, nil)
+		trashed++
+	}
+
+	return trashed, nil
+}
+
+// ApplyBatch applies the operations of a batch in order, restoring the
+// previous state if one fails
+func (m *MockRepository) ApplyBatch(ctx context.Context, ops []*BatchOp) ([]*BatchResult, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	nodes := make(map[int64]*Node, len(m.nodes))
+	for id, node := range m.nodes {
+		nodes[id] = cloneNode(node)
+	}
+	lastID, historyLen, lastHistoryID := m.lastID, len(m.history), m.lastHistoryID
+	deliveriesLen, lastDeliveryID := len(m.deliveries), m.lastDeliveryID
+
+	results, err := applyBatch(ctx, m, ops)
+	if err != nil {
+		m.nodes = nodes
+		m.lastID = lastID
+		m.history = m.history[:historyLen]
+		m.lastHistoryID = lastHistoryID
+		m.deliveries = m.deliveries[:deliveriesLen]
+		m.lastDeliveryID = lastDeliveryID
+		return nil, err
+	}
+	return results, nil
+}
+
+// parentOf returns the parent of a live node. Callers must hold the lock.
+func (m *MockRepository) parentOf(ctx context.Context, id int64) (*int64, error) {
+	node, ok := m.node(id)
+	if !ok {
+		return nil, ErrNodeNotFound
+	}
+	return copyID(node.ParentID), nil
+}
+
+// ListTrash retrieves a page of trash entries, most recently deleted first
+func (m *MockRepository) ListTrash(ctx context.Context, page, pageSize int) ([]*Node, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []*Node
+	for _, node := range m.nodes {
+		if node.TreeID == m.treeID && m.isTrashEntry(node) {
+			entries = append(entries, node)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].DeletedAt.Equal(*entries[j].DeletedAt) {
+			return entries[i].DeletedAt.After(*entries[j].DeletedAt)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	total := int64(len(entries))
+
+	offset := (page - 1) * pageSize
+	if offset >= len(entries) {
+		return []*Node{}, total, nil
+	}
+	end := offset + pageSize
+	if end > len(entries) {
+		end = len(entries)
+	}
+
+	result := make([]*Node, 0, end-offset)
+	for _, node := range entries[offset:end] {
+		result = append(result, cloneNode(node))
+	}
+	return result, total, nil
+}
+
+// RestoreNode moves a trashed node and the descendants deleted with it back
+// into the tree
+func (m *MockRepository) RestoreNode(ctx context.Context, id int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	node, ok := m.trashedNode(id)
+	if !ok {
+		return 0, ErrNodeNotFound
+	}
+	if node.ParentID != nil && m.nodes[*node.ParentID].DeletedAt != nil {
+		return 0, ErrParentDeleted
+	}
+
+	// Descendants deleted separately stay in the trash
+	deletedAt := *node.DeletedAt
+	var restored int64
+	queue := []*Node{node}
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+		for _, child := range m.nodes {
+			if child.ParentID != nil && *child.ParentID == current.ID &&
+				child.DeletedAt != nil && child.DeletedAt.Equal(deletedAt) {
+				queue = append(queue, child)
+			}
+		}
+
+		current.DeletedAt = nil
+		touch(current)
+		m.record(ctx, HistoryRestore, nil, current)
+		restored++
+	}
+
+	return restored, nil
+}
+
+// PurgeNode permanently deletes a trashed node and its descendants
+func (m *MockRepository) PurgeNode(ctx context.Context, id int64) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.trashedNode(id); !ok {
+		return 0, ErrNodeNotFound
+	}
+
+	return m.removeSubtree(id), nil
+}
+
+// PurgeTrash permanently deletes the trash entries deleted before the given time
+func (m *MockRepository) PurgeTrash(ctx context.Context, before time.Time) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var expired []int64
+	for _, node := range m.nodes {
+		if node.TreeID == m.treeID && m.isTrashEntry(node) && node.DeletedAt.Before(before) {
+			expired = append(expired, node.ID)
+		}
+	}
+
+	var purged int64
+	for _, id := range expired {
+		// An entry may have been removed with an expired entry above it
+		if _, ok := m.nodes[id]; ok {
+			purged += m.removeSubtree(id)
+		}
+	}
+	return purged, nil
+}
+
+// GetNodeHistory retrieves a page of a node's history, most recent first
+func (m *MockRepository) GetNodeHistory(ctx context.Context, id int64, page, pageSize int) ([]*HistoryEntry, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	var entries []*HistoryEntry
+	for _, entry := range m.history {
+		if entry.NodeID == id && entry.TreeID == m.treeID {
+			entries = append(entries, entry)
+		}
+	}
+
+	// Trashed nodes and purged nodes with history keep their history
+	if node, ok := m.nodes[id]; len(entries) == 0 && (!ok || node.TreeID != m.treeID) {
+		return nil, 0, ErrNodeNotFound
+	}
+
+	// Entries are appended in order, so newest first is reverse order
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ID > entries[j].ID
+	})
+
+	total := int64(len(entries))
+	start := (page - 1) * pageSize
+	if start >= len(entries) {
+		return []*HistoryEntry{}, total, nil
+	}
+	end := start + pageSize
+	if end > len(entries) {
+		end = len(entries)
+	}
+
+	result := make([]*HistoryEntry, 0, end-start)
+	for _, entry := range entries[start:end] {
+		result = append(result, cloneHistoryEntry(entry))
+	}
+	return result, total, nil
+}
+
+// GetAllNodesAsOf retrieves a page of root nodes with their complete
+// subtrees as they were at asOf
+func (m *MockRepository) GetAllNodesAsOf(ctx context.Context, asOf time.Time, page, pageSize int) ([]*Node, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	nodes := m.nodesAsOf(asOf)
+	var roots []*Node
+	children := make(map[int64][]*Node)
+	for _, node := range nodes {
+		if node.ParentID == nil {
+			roots = append(roots, node)
+		} else {
+			children[*node.ParentID] = append(children[*node.ParentID], node)
+		}
+	}
+	sort.Slice(roots, func(i, j int) bool {
+		return roots[i].ID < roots[j].ID
+	})
+	total := int64(len(roots))
+
+	offset := (page - 1) * pageSize
+	if offset >= len(roots) {
+		return []*Node{}, total, nil
+	}
+	end := offset + pageSize
+	if end > len(roots) {
+		end = len(roots)
+	}
+
+	var result []*Node
+	queue := append([]*Node{}, roots[offset:end]...)
+	for len(queue) > 0 {
+		node := queue[0]
+		queue = queue[1:]
+		result = append(result, node)
+		queue = append(queue, children[node.ID]...)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID < result[j].ID
+	})
+	return result, total, nil
+}
+
+// GetSubtreeAsOf retrieves a node and its descendants down to maxDepth
+// levels as they were at asOf
+func (m *MockRepository) GetSubtreeAsOf(ctx context.Context, id int64, maxDepth int, asOf time.Time) ([]*Node, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	nodes := m.nodesAsOf(asOf)
+	root, ok := nodes[id]
+	if !ok {
+		return nil, ErrNodeNotFound
+	}
+
+	// Walk the subtree level by level so the result is ordered by depth
+	result := []*Node{root}
+	level := []int64{id}
+	for depth := 0; len(level) > 0 && (maxDepth < 0 || depth < maxDepth); depth++ {
+		var next []*Node
+		for _, parentID := range level {
+			for _, node := range nodes {
+				if node.ParentID != nil && *node.ParentID == parentID {
+					next = append(next, node)
+				}
+			}
+		}
+		sort.Slice(next, func(i, j int) bool {
+			return next[i].ID < next[j].ID
+		})
+
+		level = level[:0]
+		for _, node := range next {
+			result = append(result, node)
+			level = append(level, node.ID)
+		}
+	}
+
+	return result, nil
+}
+
+// CreateWebhook creates a webhook for the repository's tree
+func (m *MockRepository) CreateWebhook(ctx context.Context, webhook *Webhook) (*Webhook, error) {
+	if webhook.URL == "" || webhook.Secret == "" {
+		return nil, ErrInvalidInput
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lastWebhookID++
+	now := time.Now()
+	stored := &Webhook{
+		ID:         m.lastWebhookID,
+		TreeID:     m.treeID,
+		URL:        webhook.URL,
+		Secret:     webhook.Secret,
+		EventTypes: append([]string(nil), webhook.EventTypes...),
+		Active:     webhook.Active,
+		CreatedAt:  now,
+		UpdatedAt:  now,
+	}
+	m.webhooks[stored.ID] = stored
+
+	return cloneWebhook(stored), nil
+}
+
+// GetWebhook retrieves a webhook of the repository's tree by ID
+func (m *MockRepository) GetWebhook(ctx context.Context, id int64) (*Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	webhook, ok := m.webhooks[id]
+	if !ok || webhook.TreeID != m.treeID {
+		return nil, ErrWebhookNotFound
+	}
+	return cloneWebhook(webhook), nil
+}
+
+// ListWebhooks retrieves the webhooks of the repository's tree ordered by ID
+func (m *MockRepository) ListWebhooks(ctx context.Context) ([]*Webhook, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	webhooks := []*Webhook{}
+	for _, webhook := range m.webhooks {
+		if webhook.TreeID == m.treeID {
+			webhooks = append(webhooks, cloneWebhook(webhook))
+		}
+	}
+	sort.Slice(webhooks, func(i, j int) bool {
+		return webhooks[i].ID < webhooks[j].ID
+	})
+	return webhooks, nil
+}
+
+// UpdateWebhook applies a partial update to a webhook
+func (m *MockRepository) UpdateWebhook(ctx context.Context, id int64, patch WebhookPatch) (*Webhook, error) {
+	if (patch.URL != nil && *patch.URL == "") || (patch.Secret != nil && *patch.Secret == "") {
+		return nil, ErrInvalidInput
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	webhook, ok := m.webhooks[id]
+	if !ok || webhook.TreeID != m.treeID {
+		return nil, ErrWebhookNotFound
+	}
+
+	if patch.URL != nil {
+		webhook.URL = *patch.URL
+	}
+	if patch.Secret != nil {
+		webhook.Secret = *patch.Secret
+	}
+	if patch.SetEventTypes {
+		webhook.EventTypes = append([]string(nil), patch.EventTypes...)
+	}
+	if patch.Active != nil {
+		webhook.Active = *patch.Active
+	}
+	webhook.UpdatedAt = time.Now()
+
+	return cloneWebhook(webhook), nil
+}
+
+// DeleteWebhook deletes a webhook together with its deliveries
+func (m *MockRepository) DeleteWebhook(ctx context.Context, id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	webhook, ok := m.webhooks[id]
+	if !ok || webhook.TreeID != m.treeID {
+		return ErrWebhookNotFound
+	}
+	delete(m.webhooks, id)
+
+	deliveries := m.deliveries[:0]
+	for _, delivery := range m.deliveries {
+		if delivery.WebhookID != id {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	m.deliveries = deliveries
+
+	return nil
+}
+
+// ClaimWebhookDeliveries reserves the due pending deliveries of the
+// repository's tree for lease
+func (m *MockRepository) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Deliveries are appended in order, so they are already oldest first
+	claimed := []*WebhookDelivery{}
+	for _, delivery := range m.deliveries {
+		if len(claimed) == limit {
+			break
+		}
+		webhook := m.webhooks[delivery.WebhookID]
+		if delivery.TreeID != m.treeID || delivery.Status != DeliveryPending ||
+			delivery.NextAttemptAt.After(now) || !webhook.Active {
+			continue
+		}
+		delivery.NextAttemptAt = now.Add(lease)
+		claimed = append(claimed, cloneDelivery(delivery))
+	}
+	return claimed, nil
+}
+
+// RecordWebhookAttempt records the outcome of a delivery attempt
+func (m *MockRepository) RecordWebhookAttempt(ctx context.Context, id int64, attempt DeliveryAttempt) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, delivery := range m.deliveries {
+		if delivery.ID != id || delivery.TreeID != m.treeID {
+			continue
+		}
+		attemptedAt := attempt.AttemptedAt
+		delivery.Status = attempt.Status
+		delivery.Attempts++
+		delivery.LastAttemptAt = &attemptedAt
+		delivery.ResponseStatus = attempt.ResponseStatus
+		delivery.LastError = attempt.Error
+		if attempt.Status == DeliveryPending {
+			delivery.NextAttemptAt = attempt.NextAttemptAt
+		}
+		return nil
+	}
+	return ErrDeliveryNotFound
+}
+
+// ListWebhookDeliveries retrieves a page of a webhook's deliveries, most
+// recent first
+func (m *MockRepository) ListWebhookDeliveries(ctx context.Context, webhookID int64, page, pageSize int) ([]*WebhookDelivery, int64, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if webhook, ok := m.webhooks[webhookID]; !ok || webhook.TreeID != m.treeID {
+		return nil, 0, ErrWebhookNotFound
+	}
+
+	var deliveries []*WebhookDelivery
+	for i := len(m.deliveries) - 1; i >= 0; i-- {
+		if m.deliveries[i].WebhookID == webhookID {
+			deliveries = append(deliveries, m.deliveries[i])
+		}
+	}
+
+	total := int64(len(deliveries))
+	start := (page - 1) * pageSize
+	if start >= len(deliveries) {
+		return []*WebhookDelivery{}, total, nil
+	}
+	end := start + pageSize
+	if end > len(deliveries) {
+		end = len(deliveries)
+	}
+
+	result := make([]*WebhookDelivery, 0, end-start)
+	for _, delivery := range deliveries[start:end] {
+		result = append(result, cloneDelivery(delivery))
+	}
+	return result, total, nil
+}
+
+// nodesAsOf reconstructs the live nodes of the repository's tree at asOf.
+// A node with history at or before asOf takes the state recorded by the
+// latest such entry; one whose history all comes later takes the old state
+// of its first entry; one without history is taken as stored. The result
+// holds copies. Callers must hold the lock.
+func (m *MockRepository) nodesAsOf(asOf time.Time) map[int64]*Node {
+	latest := make(map[int64]*HistoryEntry)
+	first := make(map[int64]*HistoryEntry)
+	for _, entry := range m.history {
+		if entry.TreeID != m.treeID {
+			continue
+		}
+		if _, ok := first[entry.NodeID]; !ok {
+			first[entry.NodeID] = entry
+		}
+		if !entry.ChangedAt.After(asOf) {
+			latest[entry.NodeID] = entry
+		}
+	}
+
+	nodes := make(map[int64]*Node)
+	for id, entry := range first {
+		createdAt := entry.ChangedAt
+		if stored, ok := m.nodes[id]; ok {
+			createdAt = stored.CreatedAt
+		}
+		node := &Node{ID: id, TreeID: m.treeID, CreatedAt: createdAt, UpdatedAt: createdAt}
+
+		if last, ok := latest[id]; ok {
+			if last.Action == HistoryDelete {
+				continue
+			}
+			node.Label, node.ParentID = *last.NewLabel, copyID(last.NewParentID)
+			node.Position, node.Attributes = last.Position, copyAttributes(last.Attributes)
+			node.UpdatedAt = last.ChangedAt
+		} else {
+			// All of the node's history comes after asOf
+			if entry.OldLabel == nil {
+				// Created after asOf, or in the trash at asOf
+				continue
+			}
+			node.Label, node.ParentID = *entry.OldLabel, copyID(entry.OldParentID)
+			node.Position, node.Attributes = entry.Position, copyAttributes(entry.Attributes)
+		}
+		nodes[id] = node
+	}
+
+	// Nodes from before history was recorded
+	for id, stored := range m.nodes {
+		if _, ok := first[id]; ok || stored.TreeID != m.treeID || stored.CreatedAt.After(asOf) {
+			continue
+		}
+		if stored.DeletedAt != nil && !stored.DeletedAt.After(asOf) {
+			continue
+		}
+		node := cloneNode(stored)
+		node.Version, node.DeletedAt = 0, nil
+		nodes[id] = node
+	}
+
+	return nodes
+}
+
+// record appends a history entry for a change to a node. before is a copy
+// of the node taken before the change and after the stored node once
+// changed; either is nil when the node didn't exist, or wasn't live, on
+// that side of the change. Callers must hold the lock.
+func (m *MockRepository) record(ctx context.Context, action HistoryAction, before, after *Node) {
+	m.lastHistoryID++
+	entry := &HistoryEntry{
+		ID:        m.lastHistoryID,
+		Action:    action,
+		Actor:     ActorFromContext(ctx),
+		ChangedAt: time.Now(),
+	}
+	if before != nil {
+		label := before.Label
+		entry.NodeID, entry.TreeID = before.ID, before.TreeID
+		entry.OldLabel, entry.OldParentID = &label, copyID(before.ParentID)
+		entry.Position, entry.Attributes = before.Position, copyAttributes(before.Attributes)
+	}
+	if after != nil {
+		label := after.Label
+		entry.NodeID, entry.TreeID = after.ID, after.TreeID
+		entry.NewLabel, entry.NewParentID = &label, copyID(after.ParentID)
+		entry.Position, entry.Attributes = after.Position, copyAttributes(after.Attributes)
+	}
+	m.history = append(m.history, entry)
+	m.enqueueWebhooks(entry)
+}
+
+// enqueueWebhooks queues history entry entry for the subscribed active
+// webhooks of its tree, in webhook order so deliveries are claimed
+// deterministically. Callers must hold the lock.
+func (m *MockRepository) enqueueWebhooks(entry *HistoryEntry) {
+	eventType := webhookEventTypes[entry.Action]
+	var webhookIDs []int64
+	for id, webhook := range m.webhooks {
+		if webhook.TreeID == entry.TreeID && webhook.Active && webhook.Subscribes(eventType) {
+			webhookIDs = append(webhookIDs, id)
+		}
+	}
+	if len(webhookIDs) == 0 {
+		return
+	}
+	sort.Slice(webhookIDs, func(i, j int) bool {
+		return webhookIDs[i] < webhookIDs[j]
+	})
+
+	var oldParentPath []int64
+	if entry.OldParentID != nil {
+		oldParentPath = m.path(*entry.OldParentID)
+	}
+	payload, err := webhookPayload(entry, m.nodes[entry.NodeID], m.path(entry.NodeID), oldParentPath)
+	if err != nil {
+		// The attributes came from JSON, so they always encode
+		fmt.Printf("Warning: Failed to queue webhooks for history entry %d: %v\n", entry.ID, err)
+		return
+	}
+
+	for _, webhookID := range webhookIDs {
+		m.lastDeliveryID++
+		m.deliveries = append(m.deliveries, &WebhookDelivery{
+			ID:            m.lastDeliveryID,
+			WebhookID:     webhookID,
+			TreeID:        entry.TreeID,
+			EventType:     eventType,
+			Payload:       payload,
+			Status:        DeliveryPending,
+			NextAttemptAt: entry.ChangedAt,
+			CreatedAt:     entry.ChangedAt,
+		})
+	}
+}
+
+// path returns the IDs from the root of stored node id's tree down to the
+// node, whether or not they are in the trash. Callers must hold the lock.
+func (m *MockRepository) path(id int64) []int64 {
+	var path []int64
+	for node, ok := m.nodes[id]; ok; {
+		path = append([]int64{node.ID}, path...)
+		if node.ParentID == nil {
+			break
+		}
+		node, ok = m.nodes[*node.ParentID]
+	}
+	return path
+}
+
+// trashedNode returns the stored node with the given ID if it is in the
+// trash of the repository's tree. Callers must hold the lock.
+func (m *MockRepository) trashedNode(id int64) (*Node, bool) {
+	node, ok := m.nodes[id]
+	if !ok || node.TreeID != m.treeID || node.DeletedAt == nil {
+		return nil, false
+	}
+	return node, true
+}
+
+// isTrashEntry reports whether node was deleted directly rather than with
+// its parent. Callers must hold the lock.
+func (m *MockRepository) isTrashEntry(node *Node) bool {
+	if node.DeletedAt == nil {
+		return false
+	}
+	if node.ParentID == nil {
+		return true
+	}
+	parent := m.nodes[*node.ParentID]
+	return parent.DeletedAt == nil || !parent.DeletedAt.Equal(*node.DeletedAt)
+}
+
+// removeSubtree permanently deletes a node and all of its descendants,
+// returning how many nodes were removed. Callers must hold the lock.
+func (m *MockRepository) removeSubtree(id int64) int64 {
+	toDelete := []int64{id}
+	var removed int64
+	for len(toDelete) > 0 {
+		currentID := toDelete[0]
+		toDelete = toDelete[1:]
+
+		for nodeID, node := range m.nodes {
+			if node.ParentID != nil && *node.ParentID == currentID {
+				toDelete = append(toDelete, nodeID)
+			}
+		}
+
+		delete(m.nodes, currentID)
+		removed++
+	}
+	return removed
+}
+
+// node returns the stored node with the given ID if it belongs to the
+// repository's tree. Callers must hold the lock.
+func (m *MockRepository) node(id int64) (*Node, bool) {
+	node, ok := m.nodes[id]
+	if !ok || node.TreeID != m.treeID || node.DeletedAt != nil {
+		return nil, false
+	}
+	return node, true
+}
+
+// checkParent verifies that parentID exists in the repository's tree and is
+// not id or one of its descendants. Callers must hold the lock.
+func (m *MockRepository) checkParent(id int64, parentID *int64) error {
+	if parentID == nil {
+		return nil
+	}
+	parent, ok := m.nodes[*parentID]
+	if !ok || parent.DeletedAt != nil {
+		return ErrNodeNotFound
+	}
+	if parent.TreeID != m.treeID {
+		return ErrCrossTreeParent
+	}
+
+	// Walk up from the new parent; reaching the node means it would become its own ancestor
+	visited := make(map[int64]bool)
+	for current := parentID; current != nil && !visited[*current]; {
+		if *current == id {
+			return ErrCycleDetected
+		}
+		visited[*current] = true
+		parent, ok := m.nodes[*current]
+		if !ok {
+			break
+		}
+		current = parent.ParentID
+	}
+	return nil
+}
+
+// sortedChildren returns the stored children of a node ordered by position
+// and then ID. Callers must hold the lock.
+func (m *MockRepository) sortedChildren(parentID int64) []*Node {
+	var children []*Node
+	for _, node := range m.nodes {
+		if node.ParentID != nil && *node.ParentID == parentID && node.DeletedAt == nil {
+			children = append(children, node)
+		}
+	}
+	sort.Slice(children, func(i, j int) bool {
+		if children[i].Position != children[j].Position {
+			return children[i].Position < children[j].Position
+		}
+		return children[i].ID < children[j].ID
+	})
+	return children
+}
+
+// nextPosition returns the position that places a new child of parentID
+// after all of its siblings. Roots are ordered by ID and always get 1.
+// Callers must hold the lock.
+func (m *MockRepository) nextPosition(parentID *int64) float64 {
+	if parentID == nil {
+		return 1
+	}
+	position := float64(0)
+	for _, node := range m.nodes {
+		if node.ParentID != nil && *node.ParentID == *parentID && node.Position > position {
+			position = node.Position
+		}
+	}
+	return position + 1
+}
+
+// touch records a change to a stored node
+func touch(node *Node) {
+	node.UpdatedAt = time.Now()
+	node.Version++
+}
+
+// sameID reports whether two optional IDs are equal
+func sameID(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return *a == *b
+}
+
+// cloneNode returns a copy of a stored node so callers can't mutate repository state
+func cloneNode(node *Node) *Node {
+	nodeCopy := *node
+	nodeCopy.ParentID = copyID(node.ParentID)
+	nodeCopy.Attributes = copyAttributes(node.Attributes)
+	if node.DeletedAt != nil {
+		deletedAt := *node.DeletedAt
+		nodeCopy.DeletedAt = &deletedAt
+	}
+	return &nodeCopy
+}
+
+// cloneHistoryEntry returns a copy of a stored history entry so callers
+// can't mutate repository state
+func cloneHistoryEntry(entry *HistoryEntry) *HistoryEntry {
+	entryCopy := *entry
+	if entry.OldLabel != nil {
+		label := *entry.OldLabel
+		entryCopy.OldLabel = &label
+	}
+	if entry.NewLabel != nil {
+		label := *entry.NewLabel
+		entryCopy.NewLabel = &label
+	}
+	entryCopy.OldParentID = copyID(entry.OldParentID)
+	entryCopy.NewParentID = copyID(entry.NewParentID)
+	entryCopy.Attributes = copyAttributes(entry.Attributes)
+	return &entryCopy
+}
+
+// cloneWebhook returns a copy of a stored webhook so callers can't mutate
+// repository state
+func cloneWebhook(webhook *Webhook) *Webhook {
+	webhookCopy := *webhook
+	webhookCopy.EventTypes = append([]string(nil), webhook.EventTypes...)
+	return &webhookCopy
+}
+
+// cloneDelivery returns a copy of a stored webhook delivery so callers
+// can't mutate repository state
+func cloneDelivery(delivery *WebhookDelivery) *WebhookDelivery {
+	deliveryCopy := *delivery
+	deliveryCopy.Payload = append([]byte(nil), delivery.Payload...)
+	if delivery.LastAttemptAt != nil {
+		lastAttemptAt := *delivery.LastAttemptAt
+		deliveryCopy.LastAttemptAt = &lastAttemptAt
+	}
+	return &deliveryCopy
+}
+
+// copyID returns a copy of an optional ID so stored nodes never share pointers
+func copyID(id *int64) *int64 {
+	if id == nil {
+		return nil
+	}
+	v := *id
+	return &v
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/ammiranda/tree_service/config"
@@ -11,13 +13,15 @@ import (
 	"github.com/golang-migrate/migrate/v4"
 	"github.com/golang-migrate/migrate/v4/database/postgres"
 	_ "github.com/golang-migrate/migrate/v4/source/file"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 )
 
 // PostgresRepository implements Repository using PostgreSQL
 type PostgresRepository struct {
-	db     *sql.DB
-	config *config.DatabaseConfig
+	db        *sql.DB
+	config    *config.DatabaseConfig
+	treeID    int64
+	hierarchy hierarchy
 }
 
 // NewPostgresRepository creates a new PostgreSQL repository
@@ -29,7 +33,9 @@ func NewPostgresRepository(cfgProvider config.Provider) (*PostgresRepository, er
 	}
 
 	return &PostgresRepository{
-		config: cfg,
+		config:    cfg,
+		treeID:    DefaultTreeID,
+		hierarchy: pathHierarchy{},
 	}, nil
 }
 
@@ -116,59 +122,332 @@ func (r *PostgresRepository) Cleanup(ctx context.Context) error {
 	return nil
 }
 
+// ForTree returns a copy of the repository scoped to treeID that shares its
+// connection pool. Call it after Initialize.
+func (r *PostgresRepository) ForTree(treeID int64) Repository {
+	scoped := *r
+	scoped.treeID = treeID
+	return &scoped
+}
+
+// CreateTree creates a new, empty named tree
+func (r *PostgresRepository) CreateTree(ctx context.Context, name string) (*Tree, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, ErrInvalidInput
+	}
+
+	var tree Tree
+	err := r.db.QueryRowContext(ctx,
+		"INSERT INTO trees (name) VALUES ($1) RETURNING id, name, created_at",
+		name,
+	).Scan(&tree.ID, &tree.Name, &tree.CreatedAt)
+	if err != nil {
+		if pqErr, ok := err.(*pq.Error); ok && pqErr.Code == uniqueViolation {
+			return nil, ErrTreeExists
+		}
+		return nil, fmt.Errorf("error creating tree: %w", err)
+	}
+	return &tree, nil
+}
+
+// GetTree retrieves a tree by ID
+func (r *PostgresRepository) GetTree(ctx context.Context, id int64) (*Tree, error) {
+	var tree Tree
+	err := r.db.QueryRowContext(ctx,
+		"SELECT id, name, created_at FROM trees WHERE id = $1",
+		id,
+	).Scan(&tree.ID, &tree.Name, &tree.CreatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTreeNotFound
+		}
+		return nil, fmt.Errorf("error getting tree: %w", err)
+	}
+	return &tree, nil
+}
+
+// ListTrees retrieves every tree ordered by ID
+func (r *PostgresRepository) ListTrees(ctx context.Context) ([]*Tree, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, name, created_at FROM trees ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("error listing trees: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("Warning: Error closing rows: %v\n", err)
+		}
+	}()
+
+	trees := []*Tree{}
+	for rows.Next() {
+		var tree Tree
+		if err := rows.Scan(&tree.ID, &tree.Name, &tree.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning tree: %w", err)
+		}
+		trees = append(trees, &tree)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating trees: %w", err)
+	}
+	return trees, nil
+}
+
+// DeleteTree deletes a tree together with all of its nodes
+func (r *PostgresRepository) DeleteTree(ctx context.Context, id int64) (int64, error) {
+	if id == DefaultTreeID {
+		return 0, ErrInvalidInput
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			// Log the error but don't return it since we're in a defer
+			fmt.Printf("Error rolling back transaction: %v\n", err)
+		}
+	}()
+
+	// Lock the tree so no nodes can be added to it while it is deleted
+	var treeID int64
+	err = tx.QueryRowContext(ctx, "SELECT id FROM trees WHERE id = $1 FOR UPDATE", id).Scan(&treeID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrTreeNotFound
+		}
+		return 0, fmt.Errorf("error locking tree: %w", err)
+	}
+
+	// Nodes only reference parents in the same tree, so deleting all of them
+	// in one statement never violates the parent_id foreign key
+	result, err := tx.ExecContext(ctx, "DELETE FROM nodes WHERE tree_id = $1", id)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting tree nodes: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM node_history WHERE tree_id = $1", id); err != nil {
+		return 0, fmt.Errorf("error deleting tree history: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM trees WHERE id = $1", id); err != nil {
+		return 0, fmt.Errorf("error deleting tree: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return deleted, nil
+}
+
 // CreateNode creates a new node in the database
 func (r *PostgresRepository) CreateNode(ctx context.Context, label string, parentID *int64) (int64, error) {
+	return r.CreateNodeWithAttributes(ctx, label, parentID, nil)
+}
+
+// CreateNodeWithAttributes creates a new node carrying JSON attributes
+func (r *PostgresRepository) CreateNodeWithAttributes(ctx context.Context, label string, parentID *int64, attributes map[string]interface{}) (int64, error) {
+	var id int64
+	err := r.inTransaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		id, err = r.createNode(ctx, tx, label, parentID, attributes)
+		return err
+	})
+	return id, err
+}
+
+// createNode creates a node within tx
+func (r *PostgresRepository) createNode(ctx context.Context, tx *sql.Tx, label string, parentID *int64, attributes map[string]interface{}) (int64, error) {
 	if label == "" {
 		return 0, ErrInvalidInput
 	}
 
-	// Check if parent exists
+	// Lock the parent so it can't be deleted before the node is inserted. A
+	// delete that locked it first has committed by the time the lock is
+	// granted, and the trashed parent is no longer found.
 	if parentID != nil {
-		exists, err := r.nodeExists(ctx, *parentID)
-		if err != nil {
-			return 0, err
+		var parentTree sql.NullInt64
+		err := tx.QueryRowContext(ctx,
+			"SELECT tree_id FROM nodes WHERE id = $1 AND deleted_at IS NULL FOR SHARE",
+			*parentID,
+		).Scan(&parentTree)
+		if err != nil && err != sql.ErrNoRows {
+			return 0, fmt.Errorf("error locking parent node: %w", err)
 		}
-		if !exists {
-			return 0, ErrNodeNotFound
+		if err := r.checkParentTree(parentTree); err != nil {
+			return 0, err
 		}
 	}
 
+	encoded, err := marshalAttributes(attributes)
+	if err != nil {
+		return 0, err
+	}
+
 	var id int64
-	err := r.db.QueryRowContext(ctx,
-		"INSERT INTO nodes (label, parent_id) VALUES ($1, $2) RETURNING id",
-		label, parentID,
-	).Scan(&id)
+	err = tx.QueryRowContext(ctx, withHistory(
+		"INSERT INTO nodes (tree_id, label, parent_id, position, attributes) VALUES ($1, $2, $3, "+nextPosition("$3")+", $4::jsonb)"+
+			historyReturning(HistoryCreate, "NULL::text", "NULL::integer"),
+		"$5",
+	), r.treeID, label, parentID, encoded, ActorFromContext(ctx)).Scan(&id)
 	if err != nil {
 		return 0, fmt.Errorf("error creating node: %w", err)
 	}
 	return id, nil
 }
 
+// ImportTree creates a nested tree of nodes in a single transaction
+func (r *PostgresRepository) ImportTree(ctx context.Context, parentID *int64, root *ImportNode) (map[string]int64, error) {
+	// Validate the whole document before touching the database
+	if err := validateImport(root); err != nil {
+		return nil, err
+	}
+
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			// Log the error but don't return it since we're in a defer
+			fmt.Printf("Error rolling back transaction: %v\n", err)
+		}
+	}()
+
+	// Lock the parent so it can't be deleted while the import is running
+	if parentID != nil {
+		var parentTree sql.NullInt64
+		err := tx.QueryRowContext(ctx,
+			"SELECT tree_id FROM nodes WHERE id = $1 AND deleted_at IS NULL FOR SHARE",
+			*parentID,
+		).Scan(&parentTree)
+		if err != nil && err != sql.ErrNoRows {
+			return nil, fmt.Errorf("error locking parent node: %w", err)
+		}
+		if err := r.checkParentTree(parentTree); err != nil {
+			return nil, err
+		}
+	}
+
+	// The imported root goes after the parent's existing children; below it
+	// the document order is kept
+	var rootPosition float64
+	err = tx.QueryRowContext(ctx, "SELECT "+nextPosition("$1"), parentID).Scan(&rootPosition)
+	if err != nil {
+		return nil, fmt.Errorf("error getting import position: %w", err)
+	}
+
+	actor := ActorFromContext(ctx)
+	ids := make(map[string]int64)
+	var insert func(node *ImportNode, parentID *int64, position float64) error
+	insert = func(node *ImportNode, parentID *int64, position float64) error {
+		encoded, err := marshalAttributes(node.Attributes)
+		if err != nil {
+			return err
+		}
+		var id int64
+		err = tx.QueryRowContext(ctx, withHistory(
+			"INSERT INTO nodes (tree_id, label, parent_id, position, attributes) VALUES ($1, $2, $3, $4, $5::jsonb)"+
+				historyReturning(HistoryCreate, "NULL::text", "NULL::integer"),
+			"$6",
+		), r.treeID, node.Label, parentID, position, encoded, actor).Scan(&id)
+		if err != nil {
+			return fmt.Errorf("error importing node %q: %w", node.Key, err)
+		}
+		ids[node.Key] = id
+		for i, child := range node.Children {
+			if err := insert(child, &id, float64(i+1)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	if err := insert(root, parentID, rootPosition); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return ids, nil
+}
+
 // GetNode retrieves a node by ID
 func (r *PostgresRepository) GetNode(ctx context.Context, id int64) (*Node, error) {
-	var node Node
-	var parentID sql.NullInt64
-	err := r.db.QueryRowContext(ctx,
-		"SELECT id, label, parent_id FROM nodes WHERE id = $1",
-		id,
-	).Scan(&node.ID, &node.Label, &parentID)
+	node, err := scanNode(r.db.QueryRowContext(ctx,
+		"SELECT "+nodeColumns+" FROM nodes WHERE id = $1 AND tree_id = $2 AND deleted_at IS NULL",
+		id, r.treeID,
+	))
 	if err != nil {
 		if err == sql.ErrNoRows {
 			return nil, ErrNodeNotFound
 		}
 		return nil, fmt.Errorf("error getting node: %w", err)
 	}
-	if parentID.Valid {
-		node.ParentID = &parentID.Int64
+	return node, nil
+}
+
+// GetSubtree retrieves a node and its descendants down to maxDepth levels
+func (r *PostgresRepository) GetSubtree(ctx context.Context, id int64, maxDepth int) ([]*Node, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+nodeColumns+` FROM (
+			SELECT `+qualifiedNodeColumns+`, `+r.hierarchy.depth("n", "s")+` AS depth
+			FROM nodes s
+			INNER JOIN nodes n ON `+r.hierarchy.subtree("n", "s")+`
+			WHERE s.id = $1 AND s.tree_id = $3 AND s.deleted_at IS NULL AND n.deleted_at IS NULL
+		) subtree
+		WHERE $2 < 0 OR depth <= $2
+		ORDER BY depth, id
+	`, id, maxDepth, r.treeID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting subtree: %w", err)
 	}
-	return &node, nil
+
+	nodes, err := scanNodes(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, ErrNodeNotFound
+	}
+	return nodes, nil
+}
+
+// GetAncestors retrieves the chain of nodes from the root down to the node
+func (r *PostgresRepository) GetAncestors(ctx context.Context, id int64) ([]*Node, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+qualifiedNodeColumns+`
+		FROM nodes s
+		INNER JOIN nodes n ON `+r.hierarchy.ancestors("n", "s")+`
+		WHERE s.id = $1 AND s.tree_id = $2 AND s.deleted_at IS NULL
+		ORDER BY `+r.hierarchy.depth("s", "n")+` DESC
+	`, id, r.treeID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting ancestors: %w", err)
+	}
+
+	nodes, err := scanNodes(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, ErrNodeNotFound
+	}
+	return nodes, nil
 }
 
-// GetAllNodes retrieves all nodes from the database with pagination
+// GetAllNodes retrieves a page of root nodes with their complete subtrees
 func (r *PostgresRepository) GetAllNodes(ctx context.Context, page int, pageSize int) ([]*Node, int64, error) {
-	// Get total count
+	// Get total count of trees
 	var total int64
-	err := r.db.QueryRowContext(ctx, "SELECT COUNT(*) FROM nodes").Scan(&total)
+	err := r.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM nodes WHERE parent_id IS NULL AND tree_id = $1 AND deleted_at IS NULL",
+		r.treeID,
+	).Scan(&total)
 	if err != nil {
 		return nil, 0, fmt.Errorf("error getting total count: %w", err)
 	}
@@ -176,132 +455,1429 @@ func (r *PostgresRepository) GetAllNodes(ctx context.Context, page int, pageSize
 	// Calculate offset
 	offset := (page - 1) * pageSize
 
-	// Get paginated nodes
-	rows, err := r.db.QueryContext(ctx,
-		"SELECT id, label, parent_id FROM nodes ORDER BY id LIMIT $1 OFFSET $2",
-		pageSize, offset,
-	)
+	// Get the page's roots and everything below them
+	nodes, err := r.querySubtrees(ctx, `
+		SELECT id FROM nodes
+		WHERE parent_id IS NULL AND tree_id = $3 AND deleted_at IS NULL
+		ORDER BY id
+		LIMIT $1 OFFSET $2
+	`, pageSize, offset, r.treeID)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return nodes, total, nil
+}
+
+// GetNodesAfter retrieves up to pageSize roots with an ID greater than
+// afterID, with their complete subtrees
+func (r *PostgresRepository) GetNodesAfter(ctx context.Context, afterID int64, pageSize int) ([]*Node, bool, error) {
+	nodes, err := r.querySubtrees(ctx, `
+		SELECT id FROM nodes
+		WHERE parent_id IS NULL AND tree_id = $3 AND deleted_at IS NULL AND id > $1
+		ORDER BY id
+		LIMIT $2
+	`, afterID, pageSize, r.treeID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Seek past the last root on this page to see whether another page exists
+	var hasMore bool
+	err = r.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM nodes WHERE parent_id IS NULL AND tree_id = $2 AND deleted_at IS NULL AND id > $1)",
+		lastRootID(nodes, afterID), r.treeID,
+	).Scan(&hasMore)
+	if err != nil {
+		return nil, false, fmt.Errorf("error checking for more trees: %w", err)
+	}
+
+	return nodes, hasMore, nil
+}
+
+// GetNodesByAttributes retrieves a page of trees pruned to the nodes whose
+// attributes match filter and their ancestors
+func (r *PostgresRepository) GetNodesByAttributes(ctx context.Context, filter map[string]string, page, pageSize int) ([]*Node, int64, error) {
+	if len(filter) == 0 {
+		return r.GetAllNodes(ctx, page, pageSize)
+	}
+
+	// Build the match condition in a stable order so query plans can be reused
+	keys := sortedKeys(filter)
+	conditions := make([]string, 0, len(keys)+2)
+	args := make([]interface{}, 0, 2*len(keys)+3)
+	args = append(args, r.treeID)
+	conditions = append(conditions, "tree_id = $1", "deleted_at IS NULL")
+	for _, key := range keys {
+		args = append(args, key, filter[key])
+		conditions = append(conditions, fmt.Sprintf("attributes->>$%d = $%d", len(args)-1, len(args)))
+	}
+
+	// kept holds every match and the chain of ancestors above it, along
+	// with the root the chain starts at
+	keptCTE := `
+		WITH matched AS (
+			SELECT * FROM nodes WHERE ` + strings.Join(conditions, " AND ") + `
+		), kept AS (
+			SELECT DISTINCT n.id, n.parent_id, ` + r.hierarchy.root("n") + ` AS root_id
+			FROM matched m
+			INNER JOIN nodes n ON ` + r.hierarchy.ancestors("n", "m") + `
+		)`
+
+	var total int64
+	err := r.db.QueryRowContext(ctx,
+		keptCTE+" SELECT COUNT(*) FROM kept WHERE parent_id IS NULL",
+		args...,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting total count: %w", err)
+	}
+
+	// Calculate offset
+	offset := (page - 1) * pageSize
+	args = append(args, pageSize, offset)
+
+	rows, err := r.db.QueryContext(ctx, keptCTE+fmt.Sprintf(`, page_roots AS (
+			SELECT id FROM kept
+			WHERE parent_id IS NULL
+			ORDER BY id
+			LIMIT $%d OFFSET $%d
+		)
+		SELECT `+qualifiedNodeColumns+`
+		FROM nodes n
+		INNER JOIN kept k ON n.id = k.id
+		WHERE k.root_id IN (SELECT id FROM page_roots)
+		ORDER BY n.id
+	`, len(args)-1, len(args)), args...)
 	if err != nil {
 		return nil, 0, fmt.Errorf("error getting nodes: %w", err)
 	}
+
+	nodes, err := scanNodes(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return nodes, total, nil
+}
+
+// SearchNodes finds nodes whose label matches query using ILIKE and pg_trgm
+// similarity, which the trigram index from migration 5 serves
+func (r *PostgresRepository) SearchNodes(ctx context.Context, query string, rootID *int64, page, pageSize int) ([]*SearchHit, int64, error) {
+	if query == "" {
+		return nil, 0, ErrInvalidInput
+	}
+
+	args := []interface{}{query, "%" + escapeLike(query) + "%", r.treeID}
+	scope, where := "", "tree_id = $3 AND deleted_at IS NULL AND (label ILIKE $2 OR label % $1)"
+	if rootID != nil {
+		exists, err := r.nodeExists(ctx, *rootID)
+		if err != nil {
+			return nil, 0, err
+		}
+		if !exists {
+			return nil, 0, ErrNodeNotFound
+		}
+		args = append(args, *rootID)
+		scope = `
+			WITH scope AS (
+				SELECT n.id FROM nodes s
+				INNER JOIN nodes n ON ` + r.hierarchy.subtree("n", "s") + `
+				WHERE s.id = $4
+			)`
+		where += " AND id IN (SELECT id FROM scope)"
+	}
+
+	var total int64
+	err := r.db.QueryRowContext(ctx,
+		scope+" SELECT COUNT(*) FROM nodes WHERE "+where,
+		args...,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error counting search hits: %w", err)
+	}
+
+	// Scores mirror labelScore: exact, prefix and substring matches rank
+	// first unless the trigram similarity is higher
+	offset := (page - 1) * pageSize
+	args = append(args, pageSize, offset)
+	limit := len(args) - 1
+	rows, err := r.db.QueryContext(ctx, scope+`
+		SELECT `+nodeColumns+`, score FROM (
+			SELECT `+nodeColumns+`, GREATEST(
+				CASE
+					WHEN lower(label) = lower($1) THEN `+fmt.Sprint(scoreExactMatch)+`
+					WHEN left(lower(label), length($1)) = lower($1) THEN `+fmt.Sprint(scorePrefixMatch)+`
+					WHEN label ILIKE $2 THEN `+fmt.Sprint(scoreSubstringMatch)+`
+					ELSE 0
+				END,
+				similarity(label, $1)
+			)::float8 AS score
+			FROM nodes
+			WHERE `+where+`
+		) hits
+		ORDER BY score DESC, id
+		LIMIT $`+fmt.Sprint(limit)+` OFFSET $`+fmt.Sprint(limit+1),
+		args...,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error searching nodes: %w", err)
+	}
 	defer func() {
 		if err := rows.Close(); err != nil {
 			fmt.Printf("Warning: Error closing rows: %v\n", err)
 		}
 	}()
 
-	var nodes []*Node
+	hits := []*SearchHit{}
+	var parentIDs []int64
 	for rows.Next() {
-		var node Node
-		var parentID sql.NullInt64
-		if err := rows.Scan(&node.ID, &node.Label, &parentID); err != nil {
-			return nil, 0, fmt.Errorf("error scanning node: %w", err)
+		hit := &SearchHit{}
+		node, err := scanNode(rows, &hit.Score)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error scanning search hit: %w", err)
 		}
-		if parentID.Valid {
-			node.ParentID = &parentID.Int64
+		hit.Node = node
+		hits = append(hits, hit)
+		if node.ParentID != nil {
+			parentIDs = append(parentIDs, *node.ParentID)
 		}
-		nodes = append(nodes, &node)
 	}
 	if err := rows.Err(); err != nil {
-		return nil, 0, fmt.Errorf("error iterating nodes: %w", err)
+		return nil, 0, fmt.Errorf("error iterating search hits: %w", err)
 	}
 
-	return nodes, total, nil
+	if err := r.fillSearchPaths(ctx, hits, parentIDs); err != nil {
+		return nil, 0, err
+	}
+	return hits, total, nil
 }
 
-// UpdateNode updates a node's properties
-func (r *PostgresRepository) UpdateNode(ctx context.Context, id int64, label string, parentID *int64) error {
-	if label == "" {
-		return ErrInvalidInput
+// fillSearchPaths loads the ancestors of every hit in one query, starting
+// from the hits' parents, and sets each hit's Path
+func (r *PostgresRepository) fillSearchPaths(ctx context.Context, hits []*SearchHit, parentIDs []int64) error {
+	if len(parentIDs) == 0 {
+		return nil
 	}
 
-	// Check if node exists
-	exists, err := r.nodeExists(ctx, id)
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+qualifiedNodeColumns+`
+		FROM nodes n
+		WHERE n.id IN (
+			SELECT a.id FROM nodes p
+			INNER JOIN nodes a ON `+r.hierarchy.ancestors("a", "p")+`
+			WHERE p.id = ANY($1)
+		)
+	`, pq.Array(parentIDs))
 	if err != nil {
-		return err
+		return fmt.Errorf("error getting search paths: %w", err)
 	}
-	if !exists {
-		return ErrNodeNotFound
+	ancestors, err := scanNodes(rows)
+	if err != nil {
+		return err
 	}
 
-	// Check if new parent exists
-	if parentID != nil {
-		exists, err := r.nodeExists(ctx, *parentID)
-		if err != nil {
-			return err
-		}
-		if !exists {
-			return ErrNodeNotFound
+	byID := make(map[int64]*Node, len(ancestors))
+	for _, node := range ancestors {
+		byID[node.ID] = node
+	}
+	for _, hit := range hits {
+		for current := hit.Node.ParentID; current != nil; {
+			parent, ok := byID[*current]
+			if !ok {
+				break
+			}
+			hit.Path = append([]*Node{parent}, hit.Path...)
+			current = parent.ParentID
 		}
 	}
+	return nil
+}
 
-	result, err := r.db.ExecContext(ctx,
-		"UPDATE nodes SET label = $1, parent_id = $2 WHERE id = $3",
-		label, parentID, id,
-	)
+// querySubtrees loads the complete subtrees of the roots selected by
+// rootsQuery, which must select a single id column, ordered by node ID
+func (r *PostgresRepository) querySubtrees(ctx context.Context, rootsQuery string, args ...interface{}) ([]*Node, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		WITH page_roots AS (`+rootsQuery+`)
+		SELECT `+qualifiedNodeColumns+`
+		FROM page_roots p
+		INNER JOIN nodes s ON s.id = p.id
+		INNER JOIN nodes n ON `+r.hierarchy.subtree("n", "s")+`
+		WHERE n.deleted_at IS NULL
+		ORDER BY n.id
+	`, args...)
 	if err != nil {
-		return fmt.Errorf("error updating node: %w", err)
-	}
-	rows, err := result.RowsAffected()
-	if err != nil {
-		return fmt.Errorf("error getting rows affected: %w", err)
-	}
-	if rows == 0 {
-		return ErrNodeNotFound
+		return nil, fmt.Errorf("error getting nodes: %w", err)
 	}
-	return nil
+
+	return scanNodes(rows)
 }
 
-// DeleteNode deletes a node and its children
-func (r *PostgresRepository) DeleteNode(ctx context.Context, id int64) error {
-	// Use a transaction to ensure atomicity
+// UpdateNode updates a node's properties
+func (r *PostgresRepository) UpdateNode(ctx context.Context, id int64, label string, parentID *int64) error {
+	if label == "" {
+		return ErrInvalidInput
+	}
+
+	// Use a transaction so the cycle check and the update see the same tree
 	tx, err := r.db.BeginTx(ctx, nil)
 	if err != nil {
 		return fmt.Errorf("error beginning transaction: %w", err)
 	}
 	defer func() {
-		if err := tx.Rollback(); err != nil {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
 			// Log the error but don't return it since we're in a defer
 			fmt.Printf("Error rolling back transaction: %v\n", err)
 		}
 	}()
 
-	// Delete all child nodes recursively using a CTE
-	_, err = tx.ExecContext(ctx, `
-		WITH RECURSIVE children AS (
-			SELECT id FROM nodes WHERE parent_id = $1
-			UNION ALL
-			SELECT n.id FROM nodes n
-			INNER JOIN children c ON n.parent_id = c.id
-		)
-		DELETE FROM nodes WHERE id IN (SELECT id FROM children)
-	`, id)
+	if err := r.checkParent(ctx, tx, id, parentID); err != nil {
+		return err
+	}
+
+	old, err := r.lockNode(ctx, tx, id)
 	if err != nil {
-		return fmt.Errorf("error deleting child nodes: %w", err)
+		return err
 	}
 
-	// Delete the node itself
-	result, err := tx.ExecContext(ctx, "DELETE FROM nodes WHERE id = $1", id)
+	// A node that changes parent goes to the end of its new siblings
+	result, err := tx.ExecContext(ctx, withHistory(`
+		UPDATE nodes SET
+			label = $1,
+			position = CASE WHEN parent_id IS NOT DISTINCT FROM $2 THEN position
+				ELSE `+nextPosition("$2")+` END,
+			parent_id = $2
+		WHERE id = $3`+historyReturning(HistoryUpdate, "$4::text", "$5::integer"),
+		"$6",
+	), label, parentID, id, old.label, old.parentID, ActorFromContext(ctx))
 	if err != nil {
-		return fmt.Errorf("error deleting node: %w", err)
+		return fmt.Errorf("error updating node: %w", err)
 	}
-	rowsAffected, err := result.RowsAffected()
+	rows, err := result.RowsAffected()
 	if err != nil {
 		return fmt.Errorf("error getting rows affected: %w", err)
 	}
-	if rowsAffected == 0 {
+	if rows == 0 {
 		return ErrNodeNotFound
 	}
 
-	return tx.Commit()
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
 }
 
-// nodeExists checks if a node exists
-func (r *PostgresRepository) nodeExists(ctx context.Context, id int64) (bool, error) {
-	var exists bool
-	err := r.db.QueryRowContext(ctx,
-		"SELECT EXISTS(SELECT 1 FROM nodes WHERE id = $1)",
-		id,
+// PatchNode updates only the fields set in patch
+func (r *PostgresRepository) PatchNode(ctx context.Context, id int64, patch NodePatch) error {
+	return r.patchNode(ctx, id, patch, nil)
+}
+
+// UpdateNodeIfVersion applies patch if the node is at the given version
+func (r *PostgresRepository) UpdateNodeIfVersion(ctx context.Context, id int64, version int64, patch NodePatch) error {
+	return r.patchNode(ctx, id, patch, &version)
+}
+
+// patchNode applies patch, first checking the node's version if version is set
+func (r *PostgresRepository) patchNode(ctx context.Context, id int64, patch NodePatch, version *int64) error {
+	// Use a transaction so the cycle check and the update see the same tree
+	return r.inTransaction(ctx, func(tx *sql.Tx) error {
+		return r.patchNodeTx(ctx, tx, id, patch, version)
+	})
+}
+
+// patchNodeTx applies patch within tx
+func (r *PostgresRepository) patchNodeTx(ctx context.Context, tx *sql.Tx, id int64, patch NodePatch, version *int64) error {
+	if err := validatePatch(patch); err != nil {
+		return err
+	}
+
+	if patch.SetParent {
+		if err := r.checkParent(ctx, tx, id, patch.ParentID); err != nil {
+			return err
+		}
+	}
+
+	// Check the version after checkParent so the row lock is always taken
+	// after the re-parent lock, the order MoveNode uses
+	if version != nil {
+		if err := r.checkVersion(ctx, tx, id, *version); err != nil {
+			return err
+		}
+	}
+
+	old, err := r.lockNode(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	// Nil attributes are left untouched
+	var attributes interface{}
+	if patch.Attributes != nil {
+		encoded, err := marshalAttributes(patch.Attributes)
+		if err != nil {
+			return err
+		}
+		attributes = encoded
+	}
+
+	// A node that changes parent goes to the end of its new siblings
+	result, err := tx.ExecContext(ctx, withHistory(`
+		UPDATE nodes SET
+			label = COALESCE($1, label),
+			position = CASE WHEN NOT $3 OR parent_id IS NOT DISTINCT FROM $2 THEN position
+				ELSE `+nextPosition("$2")+` END,
+			parent_id = CASE WHEN $3 THEN $2 ELSE parent_id END,
+			attributes = COALESCE($5::jsonb, attributes)
+		WHERE id = $4`+historyReturning(HistoryUpdate, "$6::text", "$7::integer"),
+		"$8",
+	), patch.Label, patch.ParentID, patch.SetParent, id, attributes, old.label, old.parentID, ActorFromContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error updating node: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if rows == 0 {
+		return ErrNodeNotFound
+	}
+	return nil
+}
+
+// MoveNode moves a node to a new parent and place among its siblings
+func (r *PostgresRepository) MoveNode(ctx context.Context, id int64, parentID *int64, pos MovePosition) error {
+	return r.moveNode(ctx, id, parentID, pos, nil)
+}
+
+// MoveNodeIfVersion moves a node if it is at the given version
+func (r *PostgresRepository) MoveNodeIfVersion(ctx context.Context, id int64, version int64, parentID *int64, pos MovePosition) error {
+	return r.moveNode(ctx, id, parentID, pos, &version)
+}
+
+// moveNode moves a node, first checking its version if version is set
+func (r *PostgresRepository) moveNode(ctx context.Context, id int64, parentID *int64, pos MovePosition, version *int64) error {
+	return r.inTransaction(ctx, func(tx *sql.Tx) error {
+		return r.moveNodeTx(ctx, tx, id, parentID, pos, version)
+	})
+}
+
+// moveNodeTx moves a node within tx
+func (r *PostgresRepository) moveNodeTx(ctx context.Context, tx *sql.Tx, id int64, parentID *int64, pos MovePosition, version *int64) error {
+	if err := validateMove(parentID, pos); err != nil {
+		return err
+	}
+
+	// checkParent takes the re-parent lock, so concurrent moves into the
+	// same siblings can't pick the same rank
+	if err := r.checkParent(ctx, tx, id, parentID); err != nil {
+		return err
+	}
+	if version != nil {
+		if err := r.checkVersion(ctx, tx, id, *version); err != nil {
+			return err
+		}
+	}
+
+	old, err := r.lockNode(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	// Roots are ordered by ID, so only children need a rank
+	position := float64(1)
+	if parentID != nil {
+		siblings, err := querySiblings(ctx, tx, *parentID, id)
+		if err != nil {
+			return err
+		}
+		p, renumbered, err := rankAmong(siblings, pos)
+		if err != nil {
+			return err
+		}
+		for siblingID, siblingPosition := range renumbered {
+			_, err := tx.ExecContext(ctx,
+				"UPDATE nodes SET position = $1 WHERE id = $2",
+				siblingPosition, siblingID,
+			)
+			if err != nil {
+				return fmt.Errorf("error renumbering siblings: %w", err)
+			}
+		}
+		position = p
+	}
+
+	result, err := tx.ExecContext(ctx, withHistory(
+		"UPDATE nodes SET parent_id = $1, position = $2 WHERE id = $3"+
+			historyReturning(HistoryMove, "$4::text", "$5::integer"),
+		"$6",
+	), parentID, position, id, old.label, old.parentID, ActorFromContext(ctx))
+	if err != nil {
+		return fmt.Errorf("error moving node: %w", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if rows == 0 {
+		return ErrNodeNotFound
+	}
+	return nil
+}
+
+// querySiblings locks and returns the children of parentID other than
+// excludeID, ordered by position and then ID
+func querySiblings(ctx context.Context, tx *sql.Tx, parentID, excludeID int64) ([]sibling, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, position FROM nodes
+		WHERE parent_id = $1 AND id <> $2 AND deleted_at IS NULL
+		ORDER BY position, id
+		FOR UPDATE
+	`, parentID, excludeID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting siblings: %w", err)
+	}
+	return scanSiblings(rows)
+}
+
+// DeleteNode deletes a node, handling its children according to mode
+func (r *PostgresRepository) DeleteNode(ctx context.Context, id int64, mode DeleteMode) (int64, error) {
+	return r.deleteNode(ctx, id, mode, nil)
+}
+
+// DeleteNodeIfVersion deletes a node if it is at the given version
+func (r *PostgresRepository) DeleteNodeIfVersion(ctx context.Context, id int64, version int64, mode DeleteMode) (int64, error) {
+	return r.deleteNode(ctx, id, mode, &version)
+}
+
+// deleteNode deletes a node, first checking its version if version is set
+func (r *PostgresRepository) deleteNode(ctx context.Context, id int64, mode DeleteMode, version *int64) (int64, error) {
+	// Use a transaction to ensure atomicity
+	var deleted int64
+	err := r.inTransaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		deleted, err = r.deleteNodeTx(ctx, tx, id, mode, version)
+		return err
+	})
+	return deleted, err
+}
+
+// deleteNodeTx deletes a node within tx
+func (r *PostgresRepository) deleteNodeTx(ctx context.Context, tx *sql.Tx, id int64, mode DeleteMode, version *int64) (int64, error) {
+	if !mode.Valid() {
+		return 0, ErrInvalidInput
+	}
+
+	// Reparenting the children and trashing the subtree change the
+	// hierarchy like a move, so take the re-parent lock first as moves do
+	if err := lockReparent(ctx, tx); err != nil {
+		return 0, err
+	}
+
+	// Lock the node for the mode checks. Creates, imports and moves lock a
+	// new parent FOR SHARE, so one under the node either commits first and
+	// is deleted or reparented below, or waits and then finds it trashed.
+	var parentID sql.NullInt64
+	var currentVersion int64
+	err := tx.QueryRowContext(ctx,
+		"SELECT parent_id, version FROM nodes WHERE id = $1 AND tree_id = $2 AND deleted_at IS NULL FOR UPDATE",
+		id, r.treeID,
+	).Scan(&parentID, &currentVersion)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrNodeNotFound
+		}
+		return 0, fmt.Errorf("error locking node: %w", err)
+	}
+	if version != nil && currentVersion != *version {
+		return 0, ErrVersionConflict
+	}
+	if err := r.lockSubtree(ctx, tx, id); err != nil {
+		return 0, err
+	}
+
+	switch mode {
+	case DeleteModeRefuse:
+		var hasChildren bool
+		err = tx.QueryRowContext(ctx,
+			"SELECT EXISTS(SELECT 1 FROM nodes WHERE parent_id = $1 AND deleted_at IS NULL)",
+			id,
+		).Scan(&hasChildren)
+		if err != nil {
+			return 0, fmt.Errorf("error checking for children: %w", err)
+		}
+		if hasChildren {
+			return 0, ErrNodeHasChildren
+		}
+	case DeleteModeReparent:
+		var newParent *int64
+		if parentID.Valid {
+			newParent = &parentID.Int64
+		}
+		// Append the children after their new siblings, keeping their order
+		_, err = tx.ExecContext(ctx, withHistory(`
+			UPDATE nodes c SET
+				parent_id = $1,
+				position = CASE WHEN $1::integer IS NULL THEN 1
+					ELSE `+nextPosition("$1")+` + o.rank - 1 END
+			FROM (
+				SELECT id AS child_id, ROW_NUMBER() OVER (ORDER BY position, id) AS rank
+				FROM nodes WHERE parent_id = $2 AND deleted_at IS NULL
+			) o
+			WHERE c.id = o.child_id`+historyReturning(HistoryMove, "label", "$2::integer"),
+			"$3",
+		), newParent, id, ActorFromContext(ctx))
+		if err != nil {
+			return 0, fmt.Errorf("error reparenting child nodes: %w", err)
+		}
+	}
+
+	// Move the node and its remaining live descendants to the trash. now()
+	// is fixed for the transaction, so they all share one deletion time;
+	// nodes trashed earlier keep their own. Children reparented above have
+	// already left the node's path.
+	result, err := tx.ExecContext(ctx, withHistory(`
+		UPDATE nodes SET deleted_at = now()
+		WHERE id IN (
+			SELECT n.id FROM nodes s
+			INNER JOIN nodes n ON `+r.hierarchy.subtree("n", "s")+`
+			WHERE s.id = $1
+		) AND deleted_at IS NULL
+		RETURNING id AS node_id, tree_id, 'delete' AS action, label AS old_label, NULL::text AS new_label,
+			parent_id AS old_parent_id, NULL::integer AS new_parent_id, position, attributes`,
+		"$2",
+	), id, ActorFromContext(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("error deleting node: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if deleted == 0 {
+		return 0, ErrNodeNotFound
+	}
+	return deleted, nil
+}
+
+// ApplyBatch applies the operations of a batch in order in a single
+// transaction. Soft deletes of the batch share the transaction's deletion
+// time, so a node deleted before its ancestor is restored with it.
+func (r *PostgresRepository) ApplyBatch(ctx context.Context, ops []*BatchOp) ([]*BatchResult, error) {
+	var results []*BatchResult
+	err := r.inTransaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		results, err = applyBatch(ctx, &postgresBatch{r: r, tx: tx}, ops)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// postgresBatch applies the operations of a batch within one transaction
+type postgresBatch struct {
+	r  *PostgresRepository
+	tx *sql.Tx
+}
+
+func (b *postgresBatch) createNode(ctx context.Context, label string, parentID *int64, attributes map[string]interface{}) (int64, error) {
+	return b.r.createNode(ctx, b.tx, label, parentID, attributes)
+}
+
+func (b *postgresBatch) patchNode(ctx context.Context, id int64, patch NodePatch, version *int64) error {
+	return b.r.patchNodeTx(ctx, b.tx, id, patch, version)
+}
+
+func (b *postgresBatch) moveNode(ctx context.Context, id int64, parentID *int64, pos MovePosition, version *int64) error {
+	return b.r.moveNodeTx(ctx, b.tx, id, parentID, pos, version)
+}
+
+func (b *postgresBatch) deleteNode(ctx context.Context, id int64, mode DeleteMode, version *int64) (int64, error) {
+	return b.r.deleteNodeTx(ctx, b.tx, id, mode, version)
+}
+
+func (b *postgresBatch) parentOf(ctx context.Context, id int64) (*int64, error) {
+	var parentID sql.NullInt64
+	err := b.tx.QueryRowContext(ctx,
+		"SELECT parent_id FROM nodes WHERE id = $1 AND tree_id = $2 AND deleted_at IS NULL",
+		id, b.r.treeID,
+	).Scan(&parentID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNodeNotFound
+		}
+		return nil, fmt.Errorf("error getting parent: %w", err)
+	}
+	if !parentID.Valid {
+		return nil, nil
+	}
+	return &parentID.Int64, nil
+}
+
+// trashEntryCondition selects the trash entries of the tree bound to $1 from
+// nodes aliased as n: trashed nodes that weren't deleted with their parent
+const trashEntryCondition = `n.tree_id = $1 AND n.deleted_at IS NOT NULL AND NOT EXISTS (
+		SELECT 1 FROM nodes p WHERE p.id = n.parent_id AND p.deleted_at = n.deleted_at
+	)`
+
+// ListTrash retrieves a page of trash entries, most recently deleted first
+func (r *PostgresRepository) ListTrash(ctx context.Context, page, pageSize int) ([]*Node, int64, error) {
+	var total int64
+	err := r.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM nodes n WHERE "+trashEntryCondition,
+		r.treeID,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting total count: %w", err)
+	}
+
+	offset := (page - 1) * pageSize
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+qualifiedNodeColumns+` FROM nodes n
+		WHERE `+trashEntryCondition+`
+		ORDER BY n.deleted_at DESC, n.id
+		LIMIT $2 OFFSET $3
+	`, r.treeID, pageSize, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error listing trash: %w", err)
+	}
+
+	nodes, err := scanNodes(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	if nodes == nil {
+		nodes = []*Node{}
+	}
+	return nodes, total, nil
+}
+
+// RestoreNode moves a trashed node and the descendants deleted with it back
+// into the tree
+func (r *PostgresRepository) RestoreNode(ctx context.Context, id int64) (int64, error) {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			// Log the error but don't return it since we're in a defer
+			fmt.Printf("Error rolling back transaction: %v\n", err)
+		}
+	}()
+
+	// A trashed node keeps its parent, so it can be read before the node
+	// is locked. Locking the parent first takes the locks top down, in the
+	// order moves and deletes lock a subtree.
+	var parentID sql.NullInt64
+	err = tx.QueryRowContext(ctx,
+		"SELECT parent_id FROM nodes WHERE id = $1 AND tree_id = $2 AND deleted_at IS NOT NULL",
+		id, r.treeID,
+	).Scan(&parentID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, ErrNodeNotFound
+		}
+		return 0, fmt.Errorf("error getting node: %w", err)
+	}
+
+	// Lock the parent so it can't be deleted while the node is restored
+	if parentID.Valid {
+		var parentDeleted bool
+		err = tx.QueryRowContext(ctx,
+			"SELECT deleted_at IS NOT NULL FROM nodes WHERE id = $1 FOR SHARE",
+			parentID.Int64,
+		).Scan(&parentDeleted)
+		if err != nil {
+			return 0, fmt.Errorf("error checking parent node: %w", err)
+		}
+		if parentDeleted {
+			return 0, ErrParentDeleted
+		}
+	}
+
+	// A concurrent restore may have won the race for the node
+	var trashed bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT deleted_at IS NOT NULL FROM nodes WHERE id = $1 FOR UPDATE",
+		id,
+	).Scan(&trashed)
+	if err != nil {
+		return 0, fmt.Errorf("error locking node: %w", err)
+	}
+	if !trashed {
+		return 0, ErrNodeNotFound
+	}
+
+	// Descendants deleted separately have another deletion time and stay
+	// in the trash
+	result, err := tx.ExecContext(ctx, withHistory(`
+		UPDATE nodes SET deleted_at = NULL WHERE id IN (
+			WITH RECURSIVE batch AS (
+				SELECT id, deleted_at FROM nodes WHERE id = $1
+				UNION ALL
+				SELECT n.id, n.deleted_at FROM nodes n
+				INNER JOIN batch b ON n.parent_id = b.id
+				WHERE n.deleted_at = b.deleted_at
+			)
+			SELECT id FROM batch
+		)`+historyReturning(HistoryRestore, "NULL::text", "NULL::integer"),
+		"$2",
+	), id, ActorFromContext(ctx))
+	if err != nil {
+		return 0, fmt.Errorf("error restoring node: %w", err)
+	}
+	restored, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("error committing transaction: %w", err)
+	}
+	return restored, nil
+}
+
+// PurgeNode permanently deletes a trashed node and its descendants
+func (r *PostgresRepository) PurgeNode(ctx context.Context, id int64) (int64, error) {
+	// Only trashed nodes can be purged, and every descendant of a trashed
+	// node is trashed too. Deleting the subtree in one statement means the
+	// parent_id foreign key is never violated mid-way.
+	result, err := r.db.ExecContext(ctx, `
+		DELETE FROM nodes WHERE id IN (
+			SELECT n.id FROM nodes s
+			INNER JOIN nodes n ON `+r.hierarchy.subtree("n", "s")+`
+			WHERE s.id = $1 AND s.tree_id = $2 AND s.deleted_at IS NOT NULL
+		)`, id, r.treeID)
+	if err != nil {
+		return 0, fmt.Errorf("error purging node: %w", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if purged == 0 {
+		return 0, ErrNodeNotFound
+	}
+	return purged, nil
+}
+
+// PurgeTrash permanently deletes the trash entries deleted before the given time
+func (r *PostgresRepository) PurgeTrash(ctx context.Context, before time.Time) (int64, error) {
+	// Expired entries can be nested, which the join doesn't mind
+	result, err := r.db.ExecContext(ctx, `
+		DELETE FROM nodes c USING nodes n
+		WHERE `+trashEntryCondition+` AND n.deleted_at < $2 AND `+r.hierarchy.subtree("c", "n"),
+		r.treeID, before)
+	if err != nil {
+		return 0, fmt.Errorf("error purging trash: %w", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	return purged, nil
+}
+
+// GetNodeHistory retrieves a page of a node's history, most recent first
+func (r *PostgresRepository) GetNodeHistory(ctx context.Context, id int64, page, pageSize int) ([]*HistoryEntry, int64, error) {
+	var total int64
+	err := r.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM node_history WHERE node_id = $1 AND tree_id = $2",
+		id, r.treeID,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting total count: %w", err)
+	}
+
+	// Nodes created before history was recorded have none yet
+	if total == 0 {
+		var exists bool
+		err := r.db.QueryRowContext(ctx,
+			"SELECT EXISTS(SELECT 1 FROM nodes WHERE id = $1 AND tree_id = $2)",
+			id, r.treeID,
+		).Scan(&exists)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error checking node: %w", err)
+		}
+		if !exists {
+			return nil, 0, ErrNodeNotFound
+		}
+		return []*HistoryEntry{}, 0, nil
+	}
+
+	offset := (page - 1) * pageSize
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+historyColumns+` FROM node_history
+		WHERE node_id = $1 AND tree_id = $2
+		ORDER BY id DESC
+		LIMIT $3 OFFSET $4
+	`, id, r.treeID, pageSize, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting node history: %w", err)
+	}
+
+	entries, err := scanHistoryEntries(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	if entries == nil {
+		entries = []*HistoryEntry{}
+	}
+	return entries, total, nil
+}
+
+// historicalNodesCTEs defines the CTE historical, the live nodes of the
+// tree bound to $1 as they were at the time bound to $2. A node with history
+// at or before that time takes the state recorded by the latest such entry;
+// one whose history all comes later takes the old state of its first entry;
+// one without history is taken as stored.
+const historicalNodesCTEs = `
+	last_change AS (
+		SELECT DISTINCT ON (node_id) node_id, action, new_label, new_parent_id, position, attributes, changed_at
+		FROM node_history
+		WHERE tree_id = $1 AND changed_at <= $2
+		ORDER BY node_id, id DESC
+	), first_change AS (
+		SELECT DISTINCT ON (node_id) node_id, action, old_label, old_parent_id, position, attributes, changed_at
+		FROM node_history
+		WHERE tree_id = $1
+		ORDER BY node_id, id
+	), historical AS (
+		SELECT l.node_id AS id, l.new_label AS label, l.new_parent_id AS parent_id, l.position, l.attributes,
+			COALESCE(n.created_at, f.changed_at) AS created_at, l.changed_at AS updated_at
+		FROM last_change l
+		INNER JOIN first_change f ON f.node_id = l.node_id
+		LEFT JOIN nodes n ON n.id = l.node_id
+		WHERE l.action <> 'delete'
+		UNION ALL
+		-- Old labels are only missing for creates and restores, i.e. nodes
+		-- that didn't exist or were in the trash at the time
+		SELECT f.node_id, f.old_label, f.old_parent_id, f.position, f.attributes,
+			COALESCE(n.created_at, f.changed_at), COALESCE(n.created_at, f.changed_at)
+		FROM first_change f
+		LEFT JOIN nodes n ON n.id = f.node_id
+		WHERE f.changed_at > $2 AND f.old_label IS NOT NULL
+		UNION ALL
+		SELECT n.id, n.label, n.parent_id, n.position, n.attributes, n.created_at, n.updated_at
+		FROM nodes n
+		WHERE n.tree_id = $1 AND n.created_at <= $2 AND (n.deleted_at IS NULL OR n.deleted_at > $2)
+			AND NOT EXISTS (SELECT 1 FROM node_history h WHERE h.node_id = n.id)
+	)`
+
+// historicalNodeColumns selects nodeColumns from historical
+const historicalNodeColumns = "id, $1::integer AS tree_id, label, parent_id, position, attributes, created_at, updated_at, 0::bigint AS version, NULL::timestamptz AS deleted_at"
+
+// GetAllNodesAsOf retrieves a page of root nodes with their complete
+// subtrees as they were at asOf
+func (r *PostgresRepository) GetAllNodesAsOf(ctx context.Context, asOf time.Time, page, pageSize int) ([]*Node, int64, error) {
+	var total int64
+	err := r.db.QueryRowContext(ctx,
+		"WITH "+historicalNodesCTEs+" SELECT COUNT(*) FROM historical WHERE parent_id IS NULL",
+		r.treeID, asOf,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting total count: %w", err)
+	}
+
+	offset := (page - 1) * pageSize
+	rows, err := r.db.QueryContext(ctx, `
+		WITH RECURSIVE `+historicalNodesCTEs+`, page_roots AS (
+			SELECT id FROM historical
+			WHERE parent_id IS NULL
+			ORDER BY id
+			LIMIT $3 OFFSET $4
+		), subtree AS (
+			SELECT h.* FROM historical h
+			INNER JOIN page_roots p ON h.id = p.id
+			UNION ALL
+			SELECT h.* FROM historical h
+			INNER JOIN subtree s ON h.parent_id = s.id
+		)
+		SELECT `+historicalNodeColumns+` FROM subtree ORDER BY id
+	`, r.treeID, asOf, pageSize, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting nodes: %w", err)
+	}
+
+	nodes, err := scanNodes(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return nodes, total, nil
+}
+
+// GetSubtreeAsOf retrieves a node and its descendants down to maxDepth
+// levels as they were at asOf
+func (r *PostgresRepository) GetSubtreeAsOf(ctx context.Context, id int64, maxDepth int, asOf time.Time) ([]*Node, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		WITH RECURSIVE `+historicalNodesCTEs+`, subtree AS (
+			SELECT h.*, 0 AS depth FROM historical h WHERE h.id = $3
+			UNION ALL
+			SELECT h.*, s.depth + 1 FROM historical h
+			INNER JOIN subtree s ON h.parent_id = s.id
+			WHERE $4 < 0 OR s.depth < $4
+		)
+		SELECT `+historicalNodeColumns+` FROM subtree ORDER BY depth, id
+	`, r.treeID, asOf, id, maxDepth)
+	if err != nil {
+		return nil, fmt.Errorf("error getting subtree: %w", err)
+	}
+
+	nodes, err := scanNodes(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, ErrNodeNotFound
+	}
+	return nodes, nil
+}
+
+// CreateWebhook creates a webhook for the repository's tree
+func (r *PostgresRepository) CreateWebhook(ctx context.Context, webhook *Webhook) (*Webhook, error) {
+	if webhook.URL == "" || webhook.Secret == "" {
+		return nil, ErrInvalidInput
+	}
+
+	created, err := scanWebhook(r.db.QueryRowContext(ctx, `
+		INSERT INTO webhooks (tree_id, url, secret, event_types, active)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING `+webhookColumns,
+		r.treeID, webhook.URL, webhook.Secret, eventTypesArray(webhook.EventTypes), webhook.Active,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("error creating webhook: %w", err)
+	}
+	return created, nil
+}
+
+// GetWebhook retrieves a webhook of the repository's tree by ID
+func (r *PostgresRepository) GetWebhook(ctx context.Context, id int64) (*Webhook, error) {
+	webhook, err := scanWebhook(r.db.QueryRowContext(ctx,
+		"SELECT "+webhookColumns+" FROM webhooks WHERE id = $1 AND tree_id = $2",
+		id, r.treeID,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, fmt.Errorf("error getting webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+// ListWebhooks retrieves the webhooks of the repository's tree ordered by ID
+func (r *PostgresRepository) ListWebhooks(ctx context.Context) ([]*Webhook, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT "+webhookColumns+" FROM webhooks WHERE tree_id = $1 ORDER BY id",
+		r.treeID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error listing webhooks: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("Warning: Error closing rows: %v\n", err)
+		}
+	}()
+
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		webhook, err := scanWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning webhook: %w", err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhooks: %w", err)
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook applies a partial update to a webhook
+func (r *PostgresRepository) UpdateWebhook(ctx context.Context, id int64, patch WebhookPatch) (*Webhook, error) {
+	if (patch.URL != nil && *patch.URL == "") || (patch.Secret != nil && *patch.Secret == "") {
+		return nil, ErrInvalidInput
+	}
+
+	webhook, err := scanWebhook(r.db.QueryRowContext(ctx, `
+		UPDATE webhooks SET
+			url = COALESCE($3, url),
+			secret = COALESCE($4, secret),
+			event_types = CASE WHEN $5 THEN $6::text[] ELSE event_types END,
+			active = COALESCE($7, active)
+		WHERE id = $1 AND tree_id = $2
+		RETURNING `+webhookColumns,
+		id, r.treeID, patch.URL, patch.Secret, patch.SetEventTypes, eventTypesArray(patch.EventTypes), patch.Active,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, fmt.Errorf("error updating webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+// DeleteWebhook deletes a webhook; its deliveries are removed by the
+// foreign key cascade
+func (r *PostgresRepository) DeleteWebhook(ctx context.Context, id int64) error {
+	result, err := r.db.ExecContext(ctx,
+		"DELETE FROM webhooks WHERE id = $1 AND tree_id = $2",
+		id, r.treeID,
+	)
+	if err != nil {
+		return fmt.Errorf("error deleting webhook: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if deleted == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// ClaimWebhookDeliveries reserves the due pending deliveries of the
+// repository's tree for lease. SKIP LOCKED lets concurrent workers claim
+// different deliveries instead of waiting for each other.
+func (r *PostgresRepository) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*WebhookDelivery, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		UPDATE webhook_deliveries SET next_attempt_at = $3
+		WHERE id IN (
+			SELECT d.id FROM webhook_deliveries d
+			INNER JOIN webhooks w ON w.id = d.webhook_id
+			WHERE d.tree_id = $1 AND d.status = 'pending' AND d.next_attempt_at <= $2 AND w.active
+			ORDER BY d.id
+			LIMIT $4
+			FOR UPDATE OF d SKIP LOCKED
+		)
+		RETURNING `+deliveryColumns,
+		r.treeID, now, now.Add(lease), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error claiming webhook deliveries: %w", err)
+	}
+
+	deliveries, err := scanDeliveries(rows)
+	if err != nil {
+		return nil, err
+	}
+	// RETURNING doesn't preserve the subquery's order
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID < deliveries[j].ID
+	})
+	return deliveries, nil
+}
+
+// RecordWebhookAttempt records the outcome of a delivery attempt
+func (r *PostgresRepository) RecordWebhookAttempt(ctx context.Context, id int64, attempt DeliveryAttempt) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET
+			status = $3::text,
+			attempts = attempts + 1,
+			last_attempt_at = $4,
+			response_status = NULLIF($5::integer, 0),
+			last_error = NULLIF($6::text, ''),
+			next_attempt_at = CASE WHEN $3::text = 'pending' THEN $7::timestamptz ELSE next_attempt_at END
+		WHERE id = $1 AND tree_id = $2
+	`, id, r.treeID, string(attempt.Status), attempt.AttemptedAt, attempt.ResponseStatus, attempt.Error, attempt.NextAttemptAt)
+	if err != nil {
+		return fmt.Errorf("error recording webhook attempt: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if updated == 0 {
+		return ErrDeliveryNotFound
+	}
+	return nil
+}
+
+// ListWebhookDeliveries retrieves a page of a webhook's deliveries, most
+// recent first
+func (r *PostgresRepository) ListWebhookDeliveries(ctx context.Context, webhookID int64, page, pageSize int) ([]*WebhookDelivery, int64, error) {
+	var exists bool
+	err := r.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM webhooks WHERE id = $1 AND tree_id = $2)",
+		webhookID, r.treeID,
+	).Scan(&exists)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error checking webhook: %w", err)
+	}
+	if !exists {
+		return nil, 0, ErrWebhookNotFound
+	}
+
+	var total int64
+	err = r.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM webhook_deliveries WHERE webhook_id = $1",
+		webhookID,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting total count: %w", err)
+	}
+
+	offset := (page - 1) * pageSize
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY id DESC
+		LIMIT $2 OFFSET $3
+	`, webhookID, pageSize, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting webhook deliveries: %w", err)
+	}
+
+	deliveries, err := scanDeliveries(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	if deliveries == nil {
+		deliveries = []*WebhookDelivery{}
+	}
+	return deliveries, total, nil
+}
+
+// lockNode locks node id inside tx and returns its label and parent, which
+// the node's next history entry records as the old values
+func (r *PostgresRepository) lockNode(ctx context.Context, tx *sql.Tx, id int64) (*lockedNode, error) {
+	var node lockedNode
+	var parentID sql.NullInt64
+	err := tx.QueryRowContext(ctx,
+		"SELECT label, parent_id FROM nodes WHERE id = $1 AND tree_id = $2 AND deleted_at IS NULL FOR UPDATE",
+		id, r.treeID,
+	).Scan(&node.label, &parentID)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNodeNotFound
+		}
+		return nil, fmt.Errorf("error locking node: %w", err)
+	}
+	if parentID.Valid {
+		node.parentID = &parentID.Int64
+	}
+	return &node, nil
+}
+
+// inTransaction runs fn in a transaction, committing it if fn succeeds and
+// rolling it back otherwise
+func (r *PostgresRepository) inTransaction(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			// Log the error but don't return it since we're in a defer
+			fmt.Printf("Error rolling back transaction: %v\n", err)
+		}
+	}()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// withHistory wraps mutation, an INSERT or UPDATE of nodes ending in a
+// historyReturning clause, so that the same statement records a history
+// entry for every row it changes. actorParam is the placeholder bound to
+// the actor; an empty actor is stored as NULL. The wrapped statement
+// returns the changed node IDs and its rows affected count is the number of
+// changed nodes.
+func withHistory(mutation, actorParam string) string {
+	return `
+		WITH changed AS (` + mutation + `)
+		INSERT INTO node_history (node_id, tree_id, action, old_label, new_label, old_parent_id, new_parent_id, position, attributes, actor)
+		SELECT node_id, tree_id, action, old_label, new_label, old_parent_id, new_parent_id, position, attributes, NULLIF(` + actorParam + `::text, '')
+		FROM changed
+		RETURNING node_id`
+}
+
+// historyReturning returns the RETURNING clause of a mutation wrapped by
+// withHistory that leaves the node live. oldLabel and oldParent are SQL
+// expressions for the node's label and parent before the change.
+func historyReturning(action HistoryAction, oldLabel, oldParent string) string {
+	return `
+		RETURNING id AS node_id, tree_id, '` + string(action) + `' AS action, ` + oldLabel + ` AS old_label, label AS new_label,
+			` + oldParent + ` AS old_parent_id, parent_id AS new_parent_id, position, attributes`
+}
+
+// reparentLockKey is the transaction-level advisory lock taken by every
+// operation that changes a node's parent, including making it a root and
+// reparenting the children of a deleted node, and by cascading deletes.
+// Serializing re-parents means two concurrent moves can't each pass the
+// cycle check and together form a cycle, or rewrite overlapping paths.
+const reparentLockKey int64 = 0x74726565 // "tree"
+
+// lockReparent takes the re-parent advisory lock, which is held until tx ends
+func lockReparent(ctx context.Context, tx *sql.Tx) error {
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock($1)", reparentLockKey); err != nil {
+		return fmt.Errorf("error acquiring reparent lock: %w", err)
+	}
+	return nil
+}
+
+// lockSubtree locks node id and all of its descendants, trashed ones
+// included, from the top down. Creates share-lock the parent of the new
+// node, so one under the subtree either commits before the lock is granted
+// or waits for tx to end. Statements run after lockSubtree see every node
+// created before it returned, and rewrite their paths and closure rows too.
+func (r *PostgresRepository) lockSubtree(ctx context.Context, tx *sql.Tx, id int64) error {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT n.id FROM nodes s
+		INNER JOIN nodes n ON `+r.hierarchy.subtree("n", "s")+`
+		WHERE s.id = $1
+		ORDER BY `+r.hierarchy.depth("n", "s")+`, n.id
+		FOR UPDATE OF n
+	`, id)
+	if err != nil {
+		return fmt.Errorf("error locking subtree: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("Warning: Error closing rows: %v\n", err)
+		}
+	}()
+
+	for rows.Next() {
+		// Each row is locked as it is read
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error locking subtree: %w", err)
+	}
+	return nil
+}
+
+// checkParent prepares tx for changing the parent of node id to parentID,
+// or making it a root if parentID is nil. It verifies that the node exists
+// in the repository's tree and that parentID, if set, exists in the same
+// tree and is neither id nor one of its descendants. It takes the re-parent
+// advisory lock, a share lock on the parent and locks the node's subtree,
+// all of which are held until tx ends.
+func (r *PostgresRepository) checkParent(ctx context.Context, tx *sql.Tx, id int64, parentID *int64) error {
+	var exists bool
+	err := tx.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM nodes WHERE id = $1 AND tree_id = $2 AND deleted_at IS NULL)",
+		id, r.treeID,
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("error checking node: %w", err)
+	}
+	if !exists {
+		return ErrNodeNotFound
+	}
+
+	if err := lockReparent(ctx, tx); err != nil {
+		return err
+	}
+
+	if parentID != nil {
+		// Lock the new parent so it can't be deleted before the node is
+		// moved under it
+		var parentTree sql.NullInt64
+		err = tx.QueryRowContext(ctx,
+			"SELECT tree_id FROM nodes WHERE id = $1 AND deleted_at IS NULL FOR SHARE",
+			*parentID,
+		).Scan(&parentTree)
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("error locking parent node: %w", err)
+		}
+		if err := r.checkParentTree(parentTree); err != nil {
+			return err
+		}
+
+		// The node would become its own ancestor if the new parent is in
+		// its subtree
+		var cycle bool
+		err = tx.QueryRowContext(ctx, `
+			SELECT `+r.hierarchy.subtree("p", "n")+` FROM nodes p, nodes n WHERE p.id = $1 AND n.id = $2
+		`, *parentID, id).Scan(&cycle)
+		if err != nil {
+			return fmt.Errorf("error checking for cycles: %w", err)
+		}
+		if cycle {
+			return ErrCycleDetected
+		}
+	}
+
+	// Moving the node rewrites the paths and closure rows of its whole
+	// subtree
+	return r.lockSubtree(ctx, tx, id)
+}
+
+// checkVersion locks node id inside tx and verifies it is at version
+func (r *PostgresRepository) checkVersion(ctx context.Context, tx *sql.Tx, id int64, version int64) error {
+	var current int64
+	err := tx.QueryRowContext(ctx,
+		"SELECT version FROM nodes WHERE id = $1 AND tree_id = $2 AND deleted_at IS NULL FOR UPDATE",
+		id, r.treeID,
+	).Scan(&current)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNodeNotFound
+		}
+		return fmt.Errorf("error locking node: %w", err)
+	}
+	if current != version {
+		return ErrVersionConflict
+	}
+	return nil
+}
+
+// checkParentTree returns the error for attaching a node of the
+// repository's tree to a parent in parentTree, which is NULL when the parent
+// doesn't exist
+func (r *PostgresRepository) checkParentTree(parentTree sql.NullInt64) error {
+	if !parentTree.Valid {
+		return ErrNodeNotFound
+	}
+	if parentTree.Int64 != r.treeID {
+		return ErrCrossTreeParent
+	}
+	return nil
+}
+
+// uniqueViolation is the PostgreSQL error code for unique constraint violations
+const uniqueViolation = "23505"
+
+// scanWebhook scans a single row selected with webhookColumns
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	var webhook Webhook
+	err := row.Scan(&webhook.ID, &webhook.TreeID, &webhook.URL, &webhook.Secret,
+		pq.Array(&webhook.EventTypes), &webhook.Active, &webhook.CreatedAt, &webhook.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if len(webhook.EventTypes) == 0 {
+		webhook.EventTypes = nil
+	}
+	return &webhook, nil
+}
+
+// eventTypesArray encodes webhook event types for a TEXT[] column, using an
+// empty array rather than NULL when there are none
+func eventTypesArray(eventTypes []string) interface{} {
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+	return pq.Array(eventTypes)
+}
+
+// nodeExists checks if a node exists in the repository's tree
+func (r *PostgresRepository) nodeExists(ctx context.Context, id int64) (bool, error) {
+	var exists bool
+	err := r.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM nodes WHERE id = $1 AND tree_id = $2 AND deleted_at IS NULL)",
+		id, r.treeID,
 	).Scan(&exists)
 	return exists, err
 }
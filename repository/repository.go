@@ -2,18 +2,106 @@ package repository
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
+	"time"
 )
 
+// UnlimitedDepth can be passed to GetSubtree to load every descendant
+const UnlimitedDepth = -1
+
+// DefaultTreeID is the tree that unscoped repositories operate on. It always
+// exists and holds every node created before named trees were introduced.
+const DefaultTreeID int64 = 1
+
+// Tree is a named, independent forest of nodes
+type Tree struct {
+	ID        int64     // Unique identifier for the tree
+	Name      string    // Unique name of the tree
+	CreatedAt time.Time // Time the tree was created
+}
+
 // Node represents a node in the tree structure
 type Node struct {
-	ID       int64  // Unique identifier for the node
-	Label    string // Display name or content of the node
-	ParentID *int64 // Optional reference to the parent node's ID
+	ID         int64                  // Unique identifier for the node
+	TreeID     int64                  // ID of the tree the node belongs to
+	Label      string                 // Display name or content of the node
+	ParentID   *int64                 // Optional reference to the parent node's ID
+	Position   float64                // Sort key among the node's siblings, ascending
+	Attributes map[string]interface{} // Arbitrary JSON attributes, nil when there are none
+	CreatedAt  time.Time              // Time the node was created
+	UpdatedAt  time.Time              // Time the node was last modified
+	Version    int64                  // Incremented on every change to the node, starting at 1
+	DeletedAt  *time.Time             // Time the node was moved to the trash, nil while it is live
+}
+
+// DeleteMode controls what happens to the children of a deleted node
+type DeleteMode string
+
+const (
+	// DeleteModeCascade deletes the node together with its whole subtree
+	DeleteModeCascade DeleteMode = "cascade"
+	// DeleteModeReparent moves the node's children to the node's parent
+	// (or makes them roots) before deleting the node itself
+	DeleteModeReparent DeleteMode = "reparent"
+	// DeleteModeRefuse only deletes the node if it has no children
+	DeleteModeRefuse DeleteMode = "refuse"
+)
+
+// Valid reports whether the mode is one of the known delete modes
+func (m DeleteMode) Valid() bool {
+	switch m {
+	case DeleteModeCascade, DeleteModeReparent, DeleteModeRefuse:
+		return true
+	}
+	return false
+}
+
+// MovePosition says where among its new siblings MoveNode places a node.
+// At most one field may be set; when none is, the node is placed last.
+type MovePosition struct {
+	BeforeID *int64 // Place the node directly before this sibling
+	AfterID  *int64 // Place the node directly after this sibling
+	Index    *int   // Place the node at this 0-based index among its siblings
+}
+
+// IsZero reports whether no placement was requested
+func (p MovePosition) IsZero() bool {
+	return p.BeforeID == nil && p.AfterID == nil && p.Index == nil
+}
+
+// NodePatch describes a partial update applied by PatchNode. Fields that
+// are not set are left untouched.
+type NodePatch struct {
+	Label      *string                // New label, if set
+	SetParent  bool                   // Whether to change the parent to ParentID
+	ParentID   *int64                 // New parent ID, or nil to make the node a root; only used if SetParent
+	Attributes map[string]interface{} // Replacement attributes, if non-nil
+}
+
+// SearchHit is a node whose label matched a search
+type SearchHit struct {
+	Node  *Node   // The matching node
+	Path  []*Node // The node's ancestors ordered from the root, excluding the node itself
+	Score float64 // Relevance between 0 and 1, higher is better
+}
+
+// ImportNode describes a node of a nested tree passed to ImportTree
+type ImportNode struct {
+	Key        string                 // Caller-chosen key identifying the node in ImportTree's result
+	Label      string                 // Display name or content of the node
+	Attributes map[string]interface{} // Optional JSON attributes of the node
+	Children   []*ImportNode          // Nodes to create below this one, in order
 }
 
 // Repository defines the interface for data access operations.
 // It provides methods for managing tree nodes in a persistent storage.
+// Deleted nodes are moved to the trash, where only the trash methods see
+// them, until they are restored or purged. All nodes trashed by one delete
+// share their DeletedAt time; the topmost of them is the trash entry.
+// Node operations are scoped to a single tree: DefaultTreeID unless the
+// Repository was returned by ForTree. Nodes of other trees are treated as
+// if they didn't exist, and a node's parent must be in the same tree.
 type Repository interface {
 	// Initialize performs any necessary setup for the repository.
 	// This may include establishing database connections, creating tables,
@@ -27,6 +115,51 @@ type Repository interface {
 	// Returns an error if cleanup fails.
 	Cleanup(ctx context.Context) error
 
+	// ForTree returns a Repository whose node operations are scoped to the
+	// given tree. It shares the underlying storage, so it needs no separate
+	// initialization. It does not check that the tree exists.
+	ForTree(treeID int64) Repository
+
+	// CreateTree creates a new, empty named tree.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - name: The unique name of the tree
+	// Returns:
+	//   - The created tree
+	//   - ErrTreeExists if a tree with the name already exists
+	//   - ErrInvalidInput if the name is empty
+	//   - Other error if the operation fails
+	CreateTree(ctx context.Context, name string) (*Tree, error)
+
+	// GetTree retrieves a tree by its ID.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - id: The ID of the tree to retrieve
+	// Returns:
+	//   - A pointer to the Tree if found
+	//   - ErrTreeNotFound if no tree exists with the given ID
+	//   - Other error if the operation fails
+	GetTree(ctx context.Context, id int64) (*Tree, error)
+
+	// ListTrees retrieves every tree ordered by ID.
+	// Parameters:
+	//   - ctx: Context for the operation
+	// Returns:
+	//   - The trees
+	//   - An error if the operation fails
+	ListTrees(ctx context.Context) ([]*Tree, error)
+
+	// DeleteTree deletes a tree together with all of its nodes.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - id: The ID of the tree to delete
+	// Returns:
+	//   - The number of nodes that were deleted
+	//   - ErrTreeNotFound if no tree exists with the given ID
+	//   - ErrInvalidInput if id is DefaultTreeID, which can't be deleted
+	//   - Other error if the operation fails
+	DeleteTree(ctx context.Context, id int64) (int64, error)
+
 	// CreateNode creates a new node in the tree structure.
 	// Parameters:
 	//   - ctx: Context for the operation
@@ -37,6 +170,47 @@ type Repository interface {
 	//   - An error if the operation fails
 	CreateNode(ctx context.Context, label string, parentID *int64) (int64, error)
 
+	// CreateNodeWithAttributes creates a new node carrying JSON attributes.
+	// CreateNode is equivalent to calling it with nil attributes.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - label: The display name or content for the new node
+	//   - parentID: Optional reference to the parent node's ID
+	//   - attributes: Optional attributes to store on the node
+	// Returns:
+	//   - The ID of the newly created node
+	//   - An error if the operation fails
+	CreateNodeWithAttributes(ctx context.Context, label string, parentID *int64, attributes map[string]interface{}) (int64, error)
+
+	// ImportTree creates a nested tree of nodes atomically: either every node
+	// is created or none is.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - parentID: Optional existing node to attach the imported root under
+	//   - root: The root of the tree to create
+	// Returns:
+	//   - The IDs of the created nodes, keyed by each ImportNode's Key
+	//   - ErrNodeNotFound if parentID does not exist
+	//   - ErrCrossTreeParent if parentID belongs to another tree
+	//   - ErrInvalidInput if any node has an empty label
+	//   - Other error if the operation fails
+	ImportTree(ctx context.Context, parentID *int64, root *ImportNode) (map[string]int64, error)
+
+	// ApplyBatch applies create, update, move and delete operations in order
+	// in a single transaction: either every operation takes effect or none
+	// does. Operations may refer to nodes created by earlier operations of
+	// the batch by their temp IDs.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - ops: The operations to apply
+	// Returns:
+	//   - One result per operation, in order
+	//   - *BatchError with the index of the first failing operation, wrapping
+	//     the error it failed with (e.g. ErrNodeNotFound, ErrCycleDetected,
+	//     ErrVersionConflict, or ErrInvalidInput for malformed operations)
+	//   - Other error if the operation fails
+	ApplyBatch(ctx context.Context, ops []*BatchOp) ([]*BatchResult, error)
+
 	// GetNode retrieves a node by its ID.
 	// Parameters:
 	//   - ctx: Context for the operation
@@ -47,36 +221,368 @@ type Repository interface {
 	//   - Other error if the operation fails
 	GetNode(ctx context.Context, id int64) (*Node, error)
 
-	// GetAllNodes retrieves all nodes from the repository with pagination.
+	// GetSubtree retrieves a node together with its descendants in a single query.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - id: The ID of the subtree's root node
+	//   - maxDepth: How many levels of descendants to load (0 loads only the
+	//     node itself, UnlimitedDepth loads the whole subtree)
+	// Returns:
+	//   - The subtree's nodes ordered by depth and then ID, starting with the node itself
+	//   - ErrNodeNotFound if no node exists with the given ID
+	//   - Other error if the operation fails
+	GetSubtree(ctx context.Context, id int64, maxDepth int) ([]*Node, error)
+
+	// GetAncestors retrieves the chain of nodes from the root of a node's tree
+	// down to the node itself.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - id: The ID of the node whose ancestors to retrieve
+	// Returns:
+	//   - The ancestors ordered from the root to the node, including the node itself
+	//   - ErrNodeNotFound if no node exists with the given ID
+	//   - Other error if the operation fails
+	GetAncestors(ctx context.Context, id int64) ([]*Node, error)
+
+	// GetAllNodes retrieves the nodes of a page of trees.
+	// Pagination is over root nodes ordered by ID: each page holds up to
+	// pageSize roots together with their complete subtrees, so a page never
+	// contains a node whose parent is on another page.
 	// Parameters:
 	//   - ctx: Context for the operation
 	//   - page: Page number (1-based)
-	//   - pageSize: Number of items per page
+	//   - pageSize: Number of root nodes per page
 	// Returns:
-	//   - A slice of all nodes in the repository
-	//   - Total count of nodes
+	//   - The page's roots and all their descendants, ordered by ID
+	//   - Total count of root nodes
 	//   - An error if the operation fails
 	GetAllNodes(ctx context.Context, page, pageSize int) ([]*Node, int64, error)
 
-	// UpdateNode updates an existing node's properties.
+	// GetNodesAfter retrieves the next page of trees using keyset pagination.
+	// It returns the same shape of page as GetAllNodes but seeks on root IDs
+	// instead of counting and skipping rows, so pages stay consistent while
+	// nodes are being inserted.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - afterID: Only roots with an ID greater than this are returned (0 for the first page)
+	//   - pageSize: Number of root nodes per page
+	// Returns:
+	//   - The page's roots and all their descendants, ordered by ID
+	//   - Whether more roots exist after this page
+	//   - An error if the operation fails
+	GetNodesAfter(ctx context.Context, afterID int64, pageSize int) ([]*Node, bool, error)
+
+	// GetNodesByAttributes retrieves a page of trees pruned to the nodes whose
+	// attributes match filter, together with their ancestors so every match
+	// stays connected to its root. A node matches when, for every key in
+	// filter, it has that attribute and the attribute's text form (strings
+	// as-is, other values as JSON) equals the filter value.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - filter: Attribute values to match, keyed by attribute name
+	//   - page: Page number (1-based)
+	//   - pageSize: Number of root nodes per page
+	// Returns:
+	//   - The page's roots and their kept descendants, ordered by ID
+	//   - Total count of root nodes with at least one match in their tree
+	//   - An error if the operation fails
+	GetNodesByAttributes(ctx context.Context, filter map[string]string, page, pageSize int) ([]*Node, int64, error)
+
+	// SearchNodes finds nodes whose label matches query, either as a
+	// case-insensitive substring or by trigram similarity.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - query: The text to search for
+	//   - rootID: Optional node whose subtree (including itself) limits the search
+	//   - page: Page number (1-based)
+	//   - pageSize: Number of hits per page
+	// Returns:
+	//   - The page's hits ordered by descending score and then ID
+	//   - Total count of hits
+	//   - ErrNodeNotFound if rootID does not exist
+	//   - ErrInvalidInput if query is empty
+	//   - Other error if the operation fails
+	SearchNodes(ctx context.Context, query string, rootID *int64, page, pageSize int) ([]*SearchHit, int64, error)
+
+	// UpdateNode replaces an existing node's properties. A nil parentID makes
+	// the node a root; use PatchNode to change only some fields.
 	// Parameters:
 	//   - ctx: Context for the operation
 	//   - id: The ID of the node to update
 	//   - label: The new label for the node
 	//   - parentID: The new parent ID for the node
 	// Returns:
-	//   - ErrNodeNotFound if no node exists with the given ID
+	//   - ErrNodeNotFound if no node exists with the given ID or the new parent does not exist
+	//   - ErrCrossTreeParent if the new parent belongs to another tree
+	//   - ErrCycleDetected if the new parent is the node itself or one of its descendants
 	//   - Other error if the operation fails
 	UpdateNode(ctx context.Context, id int64, label string, parentID *int64) error
 
-	// DeleteNode deletes a node and all its children from the repository.
+	// PatchNode updates only the fields set in patch, leaving the others as
+	// they are. A node that changes parent is placed after its new siblings.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - id: The ID of the node to update
+	//   - patch: The fields to change
+	// Returns:
+	//   - ErrNodeNotFound if no node exists with the given ID or the new parent does not exist
+	//   - ErrCrossTreeParent if the new parent belongs to another tree
+	//   - ErrCycleDetected if the new parent is the node itself or one of its descendants
+	//   - ErrInvalidInput if the patch sets an empty label
+	//   - Other error if the operation fails
+	PatchNode(ctx context.Context, id int64, patch NodePatch) error
+
+	// UpdateNodeIfVersion applies patch like PatchNode, but only if the
+	// node's current version is version.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - id: The ID of the node to update
+	//   - version: The version the node must have
+	//   - patch: The fields to change
+	// Returns:
+	//   - ErrVersionConflict if the node's version is not version
+	//   - Any error PatchNode returns
+	UpdateNodeIfVersion(ctx context.Context, id int64, version int64, patch NodePatch) error
+
+	// MoveNode moves a node under a new parent (or within its current parent)
+	// at the requested place among its siblings. Positions are fractional, so
+	// a move normally rewrites only the moved node.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - id: The ID of the node to move
+	//   - parentID: The new parent ID for the node, or nil to make it a root
+	//   - pos: Where to place the node among the new parent's children
+	// Returns:
+	//   - ErrNodeNotFound if no node exists with the given ID or the new parent does not exist
+	//   - ErrCrossTreeParent if the new parent belongs to another tree
+	//   - ErrCycleDetected if the new parent is the node itself or one of its descendants
+	//   - ErrInvalidInput if more than one placement is set, the index is
+	//     negative, a placement is given for a root, or the before/after node
+	//     is not a child of the new parent
+	//   - Other error if the operation fails
+	MoveNode(ctx context.Context, id int64, parentID *int64, pos MovePosition) error
+
+	// MoveNodeIfVersion moves a node like MoveNode, but only if the node's
+	// current version is version.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - id: The ID of the node to move
+	//   - version: The version the node must have
+	//   - parentID: The new parent ID for the node, or nil to make it a root
+	//   - pos: Where to place the node among the new parent's children
+	// Returns:
+	//   - ErrVersionConflict if the node's version is not version
+	//   - Any error MoveNode returns
+	MoveNodeIfVersion(ctx context.Context, id int64, version int64, parentID *int64, pos MovePosition) error
+
+	// DeleteNode moves a node to the trash. Descendants that are trashed
+	// with it (all of them in cascade mode) can be restored together.
 	// Parameters:
 	//   - ctx: Context for the operation
 	//   - id: The ID of the node to delete
+	//   - mode: How the node's children are handled (cascade, reparent or refuse)
 	// Returns:
+	//   - The number of nodes that were moved to the trash
 	//   - ErrNodeNotFound if no node exists with the given ID
+	//   - ErrNodeHasChildren if mode is DeleteModeRefuse and the node has children
+	//   - ErrInvalidInput if the mode is unknown
+	//   - Other error if the operation fails
+	DeleteNode(ctx context.Context, id int64, mode DeleteMode) (int64, error)
+
+	// DeleteNodeIfVersion deletes a node like DeleteNode, but only if the
+	// node's current version is version.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - id: The ID of the node to delete
+	//   - version: The version the node must have
+	//   - mode: How the node's children are handled (cascade, reparent or refuse)
+	// Returns:
+	//   - The number of nodes that were deleted
+	//   - ErrVersionConflict if the node's version is not version
+	//   - Any error DeleteNode returns
+	DeleteNodeIfVersion(ctx context.Context, id int64, version int64, mode DeleteMode) (int64, error)
+
+	// ListTrash retrieves a page of trash entries: the nodes that were
+	// deleted directly, as opposed to with an ancestor, most recent first.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - page: The page number (1-based)
+	//   - pageSize: The number of entries per page
+	// Returns:
+	//   - The trashed nodes, with DeletedAt set
+	//   - The total number of trash entries
+	//   - An error if the operation fails
+	ListTrash(ctx context.Context, page, pageSize int) ([]*Node, int64, error)
+
+	// RestoreNode moves a trashed node back into the tree together with the
+	// descendants that were deleted with it.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - id: The ID of the trashed node
+	// Returns:
+	//   - The number of nodes that were restored
+	//   - ErrNodeNotFound if no trashed node exists with the given ID
+	//   - ErrParentDeleted if the node's parent is in the trash
+	//   - Other error if the operation fails
+	RestoreNode(ctx context.Context, id int64) (int64, error)
+
+	// PurgeNode permanently deletes a trashed node and its descendants.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - id: The ID of the trashed node
+	// Returns:
+	//   - The number of nodes that were deleted
+	//   - ErrNodeNotFound if no trashed node exists with the given ID
+	//   - Other error if the operation fails
+	PurgeNode(ctx context.Context, id int64) (int64, error)
+
+	// PurgeTrash permanently deletes every trash entry deleted before the
+	// given time, with its descendants.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - before: Entries deleted before this time are purged
+	// Returns:
+	//   - The number of nodes that were deleted
+	//   - An error if the operation fails
+	PurgeTrash(ctx context.Context, before time.Time) (int64, error)
+
+	// GetNodeHistory retrieves a page of the changes made to a node, most
+	// recent first. Every create, update, move, delete and restore is
+	// recorded together with the actor stored in the context by WithActor.
+	// History outlives the node: it is kept after the node is purged.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - id: The ID of the node
+	//   - page: The page number (1-based)
+	//   - pageSize: The number of entries per page
+	// Returns:
+	//   - The history entries of the page
+	//   - The total number of history entries of the node
+	//   - ErrNodeNotFound if the node has no history and doesn't exist
+	//   - Other error if the operation fails
+	GetNodeHistory(ctx context.Context, id int64, page, pageSize int) ([]*HistoryEntry, int64, error)
+
+	// GetAllNodesAsOf retrieves the nodes of a page of trees like
+	// GetAllNodes, but as they were at the given time, reconstructed from
+	// the node history. Labels and parent links are exact; positions and
+	// attributes are best effort, and Version is 0 since it isn't recorded.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - asOf: The time to read the tree at
+	//   - page: Page number (1-based)
+	//   - pageSize: Number of root nodes per page
+	// Returns:
+	//   - The page's roots and all their descendants, ordered by ID
+	//   - Total count of root nodes at that time
+	//   - An error if the operation fails
+	GetAllNodesAsOf(ctx context.Context, asOf time.Time, page, pageSize int) ([]*Node, int64, error)
+
+	// GetSubtreeAsOf retrieves a node together with its descendants like
+	// GetSubtree, but as they were at the given time. See GetAllNodesAsOf
+	// for how the nodes are reconstructed.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - id: The ID of the subtree's root node
+	//   - maxDepth: How many levels of descendants to load (0 loads only the
+	//     node itself, UnlimitedDepth loads the whole subtree)
+	//   - asOf: The time to read the subtree at
+	// Returns:
+	//   - The subtree's nodes ordered by depth and then ID, starting with the node itself
+	//   - ErrNodeNotFound if the node didn't exist, or was in the trash, at that time
+	//   - Other error if the operation fails
+	GetSubtreeAsOf(ctx context.Context, id int64, maxDepth int, asOf time.Time) ([]*Node, error)
+
+	// CreateWebhook creates a webhook for the repository's tree. Every
+	// history entry recorded for the tree afterwards is queued for the
+	// active webhooks subscribed to its event type, in the transaction
+	// that makes the change, with the entry's ID as the event ID.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - webhook: The URL, secret, event types and active state of the
+	//     webhook; its other fields are ignored
+	// Returns:
+	//   - The created webhook
+	//   - ErrInvalidInput if the URL or secret is empty
+	//   - Other error if the operation fails
+	CreateWebhook(ctx context.Context, webhook *Webhook) (*Webhook, error)
+
+	// GetWebhook retrieves a webhook of the repository's tree by ID.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - id: The ID of the webhook
+	// Returns:
+	//   - The webhook
+	//   - ErrWebhookNotFound if the tree has no webhook with the ID
+	//   - Other error if the operation fails
+	GetWebhook(ctx context.Context, id int64) (*Webhook, error)
+
+	// ListWebhooks retrieves the webhooks of the repository's tree ordered by ID.
+	// Parameters:
+	//   - ctx: Context for the operation
+	// Returns:
+	//   - The webhooks
+	//   - An error if the operation fails
+	ListWebhooks(ctx context.Context) ([]*Webhook, error)
+
+	// UpdateWebhook applies a partial update to a webhook.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - id: The ID of the webhook
+	//   - patch: The fields to change
+	// Returns:
+	//   - The updated webhook
+	//   - ErrWebhookNotFound if the tree has no webhook with the ID
+	//   - ErrInvalidInput if the patch sets an empty URL or secret
+	//   - Other error if the operation fails
+	UpdateWebhook(ctx context.Context, id int64, patch WebhookPatch) (*Webhook, error)
+
+	// DeleteWebhook deletes a webhook together with its deliveries.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - id: The ID of the webhook
+	// Returns:
+	//   - ErrWebhookNotFound if the tree has no webhook with the ID
+	//   - Other error if the operation fails
+	DeleteWebhook(ctx context.Context, id int64) error
+
+	// ClaimWebhookDeliveries retrieves the pending deliveries of the
+	// repository's tree that are due, oldest first, skipping those of
+	// inactive webhooks. Claimed deliveries aren't returned again until
+	// lease has passed, so concurrent workers don't deliver them twice.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - now: The current time
+	//   - lease: How long the deliveries are reserved for the caller
+	//   - limit: The maximum number of deliveries to claim
+	// Returns:
+	//   - The claimed deliveries
+	//   - An error if the operation fails
+	ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*WebhookDelivery, error)
+
+	// RecordWebhookAttempt records the outcome of an attempt to deliver a
+	// delivery and counts the attempt.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - id: The ID of the delivery
+	//   - attempt: The outcome of the attempt
+	// Returns:
+	//   - ErrDeliveryNotFound if the tree has no delivery with the ID
+	//   - Other error if the operation fails
+	RecordWebhookAttempt(ctx context.Context, id int64, attempt DeliveryAttempt) error
+
+	// ListWebhookDeliveries retrieves a page of a webhook's deliveries, most
+	// recent first.
+	// Parameters:
+	//   - ctx: Context for the operation
+	//   - webhookID: The ID of the webhook
+	//   - page: The page number (1-based)
+	//   - pageSize: The number of deliveries per page
+	// Returns:
+	//   - The deliveries of the page
+	//   - The total number of deliveries of the webhook
+	//   - ErrWebhookNotFound if the tree has no webhook with the ID
 	//   - Other error if the operation fails
-	DeleteNode(ctx context.Context, id int64) error
+	ListWebhookDeliveries(ctx context.Context, webhookID int64, page, pageSize int) ([]*WebhookDelivery, int64, error)
 }
 
 // Common errors
@@ -85,4 +591,168 @@ var (
 	ErrNodeNotFound = errors.New("node not found")
 	// ErrInvalidInput is returned when the input parameters are invalid
 	ErrInvalidInput = errors.New("invalid input")
+	// ErrNodeHasChildren is returned when a delete is refused because the node has children
+	ErrNodeHasChildren = errors.New("node has children")
+	// ErrCycleDetected is returned when a node would become its own ancestor
+	ErrCycleDetected = errors.New("move would create a cycle")
+	// ErrCrossTreeParent is returned when a node's parent belongs to another tree
+	ErrCrossTreeParent = errors.New("parent belongs to another tree")
+	// ErrTreeNotFound is returned when a requested tree does not exist
+	ErrTreeNotFound = errors.New("tree not found")
+	// ErrParentDeleted is returned when restoring a node whose parent is in the trash
+	ErrParentDeleted = errors.New("parent node is deleted")
+	// ErrVersionConflict is returned when a conditional write finds the node at another version
+	ErrVersionConflict = errors.New("node version does not match")
+	// ErrTreeExists is returned when a tree name is already taken
+	ErrTreeExists = errors.New("tree already exists")
+	// ErrWebhookNotFound is returned when a requested webhook does not exist
+	ErrWebhookNotFound = errors.New("webhook not found")
+	// ErrDeliveryNotFound is returned when a requested webhook delivery does not exist
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
 )
+
+// sibling is the ID and position of a node's sibling, used to rank moves
+type sibling struct {
+	id       int64
+	position float64
+}
+
+// rankAmong computes the position of a node placed among siblings, which
+// must be ordered by position and then ID and must not include the node
+// itself. Placing a node normally only needs a value between its new
+// neighbours; when floating point precision between them is exhausted, every
+// sibling is renumbered and the new positions are returned keyed by ID.
+func rankAmong(siblings []sibling, pos MovePosition) (float64, map[int64]float64, error) {
+	index := len(siblings)
+	switch {
+	case pos.BeforeID != nil && pos.AfterID == nil && pos.Index == nil:
+		index = siblingIndex(siblings, *pos.BeforeID)
+	case pos.AfterID != nil && pos.BeforeID == nil && pos.Index == nil:
+		index = siblingIndex(siblings, *pos.AfterID)
+		if index >= 0 {
+			index++
+		}
+	case pos.Index != nil && pos.BeforeID == nil && pos.AfterID == nil:
+		index = *pos.Index
+		if index > len(siblings) {
+			index = len(siblings)
+		}
+	case !pos.IsZero():
+		return 0, nil, ErrInvalidInput
+	}
+	if index < 0 {
+		return 0, nil, ErrInvalidInput
+	}
+
+	// Take the midpoint between the neighbours, or step past the only one
+	var position float64
+	switch {
+	case len(siblings) == 0:
+		return 1, nil, nil
+	case index == 0:
+		position = siblings[0].position - 1
+	case index == len(siblings):
+		position = siblings[index-1].position + 1
+	default:
+		prev, next := siblings[index-1].position, siblings[index].position
+		position = prev + (next-prev)/2
+		if position <= prev || position >= next {
+			return rebalance(siblings, index)
+		}
+	}
+	return position, nil, nil
+}
+
+// rebalance renumbers siblings 1..n leaving a slot for a node at index
+func rebalance(siblings []sibling, index int) (float64, map[int64]float64, error) {
+	positions := make(map[int64]float64, len(siblings))
+	for i, s := range siblings {
+		rank := i + 1
+		if i >= index {
+			rank++
+		}
+		positions[s.id] = float64(rank)
+	}
+	return float64(index + 1), positions, nil
+}
+
+// siblingIndex returns the index of the sibling with the given ID, or -1
+func siblingIndex(siblings []sibling, id int64) int {
+	for i, s := range siblings {
+		if s.id == id {
+			return i
+		}
+	}
+	return -1
+}
+
+// attributesMatch reports whether attributes contain every value in filter,
+// comparing the text form PostgreSQL's ->> operator produces
+func attributesMatch(attributes map[string]interface{}, filter map[string]string) bool {
+	for key, want := range filter {
+		value, ok := attributes[key]
+		if !ok || value == nil {
+			return false
+		}
+		text, isString := value.(string)
+		if !isString {
+			encoded, err := json.Marshal(value)
+			if err != nil {
+				return false
+			}
+			text = string(encoded)
+		}
+		if text != want {
+			return false
+		}
+	}
+	return true
+}
+
+// copyAttributes returns a deep copy of attributes so stored nodes never
+// share maps with callers
+func copyAttributes(attributes map[string]interface{}) map[string]interface{} {
+	if len(attributes) == 0 {
+		return nil
+	}
+	// A JSON round trip copies nested maps and slices too
+	encoded, err := json.Marshal(attributes)
+	if err != nil {
+		return nil
+	}
+	var attributesCopy map[string]interface{}
+	if err := json.Unmarshal(encoded, &attributesCopy); err != nil {
+		return nil
+	}
+	return attributesCopy
+}
+
+// validateImport checks that every node of an import has a label
+func validateImport(node *ImportNode) error {
+	if node == nil || node.Label == "" {
+		return ErrInvalidInput
+	}
+	for _, child := range node.Children {
+		if err := validateImport(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validatePatch checks the fields of patch that don't depend on the tree
+func validatePatch(patch NodePatch) error {
+	if patch.Label != nil && *patch.Label == "" {
+		return ErrInvalidInput
+	}
+	return nil
+}
+
+// validateMove checks a move that doesn't depend on the tree: roots are
+// ordered by ID, so a position only makes sense under a parent
+func validateMove(parentID *int64, pos MovePosition) error {
+	if parentID == nil && !pos.IsZero() {
+		return ErrInvalidInput
+	}
+	return nil
+}
@@ -0,0 +1,495 @@
+// Package repotest provides a conformance suite that checks a
+// repository.Repository implementation behaves like every other one. Run it
+// from a test of each implementation:
+//
+//	func TestSQLiteConformance(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) repository.Repository {
+//			repo := repository.NewSQLiteRepository(":memory:")
+//			if err := repo.Initialize(context.Background()); err != nil {
+//				t.Fatal(err)
+//			}
+//			t.Cleanup(func() { _ = repo.Cleanup(context.Background()) })
+//			return repo
+//		})
+//	}
+package repotest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/ammiranda/tree_service/repository"
+)
+
+// Factory returns an initialized repository with no nodes, whose cleanup
+// the factory registers with t. Every subtest of the suite calls it once,
+// so repositories sharing a database can return a view scoped to a fresh
+// tree with ForTree.
+type Factory func(t *testing.T) repository.Repository
+
+// concurrentWriters and writesPerWriter size the concurrent write checks
+const (
+	concurrentWriters = 8
+	writesPerWriter   = 10
+)
+
+// Run runs the conformance suite as subtests of t.
+//
+// Parameters:
+//   - t: The test to run the suite under
+//   - newRepo: Factory creating an empty repository for every subtest
+func Run(t *testing.T, newRepo Factory) {
+	t.Run("CRUD", func(t *testing.T) { testCRUD(t, newRepo(t)) })
+	t.Run("Errors", func(t *testing.T) { testErrors(t, newRepo(t)) })
+	t.Run("Pagination", func(t *testing.T) { testPagination(t, newRepo(t)) })
+	t.Run("CascadeDelete", func(t *testing.T) { testCascadeDelete(t, newRepo(t)) })
+	t.Run("ConcurrentWrites", func(t *testing.T) { testConcurrentWrites(t, newRepo(t)) })
+	t.Run("WebhookOutbox", func(t *testing.T) { testWebhookOutbox(t, newRepo(t)) })
+}
+
+func testCRUD(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+
+	rootID, err := repo.CreateNode(ctx, "root", nil)
+	require.NoError(t, err)
+	childID, err := repo.CreateNodeWithAttributes(ctx, "child", &rootID, map[string]interface{}{"status": "draft"})
+	require.NoError(t, err)
+	assert.Greater(t, childID, rootID, "IDs increase")
+
+	// Read back what was created
+	root, err := repo.GetNode(ctx, rootID)
+	require.NoError(t, err)
+	assert.Equal(t, "root", root.Label)
+	assert.Nil(t, root.ParentID)
+	assert.Equal(t, int64(1), root.Version)
+	assert.Nil(t, root.DeletedAt)
+
+	child, err := repo.GetNode(ctx, childID)
+	require.NoError(t, err)
+	assert.Equal(t, "child", child.Label)
+	require.NotNil(t, child.ParentID)
+	assert.Equal(t, rootID, *child.ParentID)
+	assert.Equal(t, "draft", child.Attributes["status"])
+
+	subtree, err := repo.GetSubtree(ctx, rootID, repository.UnlimitedDepth)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{rootID, childID}, nodeIDs(subtree))
+
+	ancestors, err := repo.GetAncestors(ctx, childID)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{rootID, childID}, nodeIDs(ancestors))
+
+	// Updates replace the label and parent and bump the version
+	otherID, err := repo.CreateNode(ctx, "other", nil)
+	require.NoError(t, err)
+	require.NoError(t, repo.UpdateNode(ctx, childID, "renamed", &otherID))
+	child, err = repo.GetNode(ctx, childID)
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", child.Label)
+	require.NotNil(t, child.ParentID)
+	assert.Equal(t, otherID, *child.ParentID)
+	assert.Equal(t, int64(2), child.Version)
+
+	// Patches only touch the fields they set
+	require.NoError(t, repo.PatchNode(ctx, childID, repository.NodePatch{
+		Attributes: map[string]interface{}{"status": "published"},
+	}))
+	child, err = repo.GetNode(ctx, childID)
+	require.NoError(t, err)
+	assert.Equal(t, "renamed", child.Label)
+	assert.Equal(t, "published", child.Attributes["status"])
+	assert.Equal(t, int64(3), child.Version)
+
+	err = repo.UpdateNodeIfVersion(ctx, childID, 2, repository.NodePatch{Label: stringPtr("stale")})
+	assert.ErrorIs(t, err, repository.ErrVersionConflict)
+	require.NoError(t, repo.UpdateNodeIfVersion(ctx, childID, 3, repository.NodePatch{Label: stringPtr("fresh")}))
+
+	err = repo.MoveNodeIfVersion(ctx, childID, 3, &rootID, repository.MovePosition{})
+	assert.ErrorIs(t, err, repository.ErrVersionConflict)
+	require.NoError(t, repo.MoveNodeIfVersion(ctx, childID, 4, &rootID, repository.MovePosition{}))
+	child, err = repo.GetNode(ctx, childID)
+	require.NoError(t, err)
+	require.NotNil(t, child.ParentID)
+	assert.Equal(t, rootID, *child.ParentID)
+
+	// Deleting a leaf removes only the leaf
+	deleted, err := repo.DeleteNode(ctx, childID, repository.DeleteModeRefuse)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	_, err = repo.GetNode(ctx, childID)
+	assert.ErrorIs(t, err, repository.ErrNodeNotFound)
+	_, err = repo.GetNode(ctx, otherID)
+	assert.NoError(t, err)
+
+	// IDs of deleted and purged nodes are never handed out again
+	_, err = repo.PurgeNode(ctx, childID)
+	require.NoError(t, err)
+	newID, err := repo.CreateNode(ctx, "new", nil)
+	require.NoError(t, err)
+	assert.Greater(t, newID, childID)
+}
+
+func testErrors(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+	// Beyond any ID the suite creates, but within PostgreSQL's integer range
+	const missingID int64 = 1<<31 - 1
+
+	rootID, err := repo.CreateNode(ctx, "root", nil)
+	require.NoError(t, err)
+	childID, err := repo.CreateNode(ctx, "child", &rootID)
+	require.NoError(t, err)
+	missing := missingID
+
+	// Missing nodes
+	_, err = repo.GetNode(ctx, missingID)
+	assert.ErrorIs(t, err, repository.ErrNodeNotFound)
+	_, err = repo.GetSubtree(ctx, missingID, repository.UnlimitedDepth)
+	assert.ErrorIs(t, err, repository.ErrNodeNotFound)
+	_, err = repo.GetAncestors(ctx, missingID)
+	assert.ErrorIs(t, err, repository.ErrNodeNotFound)
+	err = repo.UpdateNode(ctx, missingID, "label", nil)
+	assert.ErrorIs(t, err, repository.ErrNodeNotFound)
+	err = repo.PatchNode(ctx, missingID, repository.NodePatch{Label: stringPtr("label")})
+	assert.ErrorIs(t, err, repository.ErrNodeNotFound)
+	err = repo.MoveNode(ctx, missingID, &rootID, repository.MovePosition{})
+	assert.ErrorIs(t, err, repository.ErrNodeNotFound)
+	_, err = repo.DeleteNode(ctx, missingID, repository.DeleteModeCascade)
+	assert.ErrorIs(t, err, repository.ErrNodeNotFound)
+	_, err = repo.RestoreNode(ctx, missingID)
+	assert.ErrorIs(t, err, repository.ErrNodeNotFound)
+	_, _, err = repo.GetNodeHistory(ctx, missingID, 1, 10)
+	assert.ErrorIs(t, err, repository.ErrNodeNotFound)
+
+	// Missing parents
+	_, err = repo.CreateNode(ctx, "orphan", &missing)
+	assert.ErrorIs(t, err, repository.ErrNodeNotFound)
+	err = repo.UpdateNode(ctx, childID, "child", &missing)
+	assert.ErrorIs(t, err, repository.ErrNodeNotFound)
+	err = repo.MoveNode(ctx, childID, &missing, repository.MovePosition{})
+	assert.ErrorIs(t, err, repository.ErrNodeNotFound)
+
+	// Invalid input
+	_, err = repo.CreateNode(ctx, "", nil)
+	assert.ErrorIs(t, err, repository.ErrInvalidInput)
+	err = repo.UpdateNode(ctx, childID, "", &rootID)
+	assert.ErrorIs(t, err, repository.ErrInvalidInput)
+	err = repo.PatchNode(ctx, childID, repository.NodePatch{Label: stringPtr("")})
+	assert.ErrorIs(t, err, repository.ErrInvalidInput)
+	_, err = repo.DeleteNode(ctx, childID, repository.DeleteMode("shred"))
+	assert.ErrorIs(t, err, repository.ErrInvalidInput)
+	_, _, err = repo.SearchNodes(ctx, "", nil, 1, 10)
+	assert.ErrorIs(t, err, repository.ErrInvalidInput)
+
+	// Cycles
+	err = repo.UpdateNode(ctx, rootID, "root", &childID)
+	assert.ErrorIs(t, err, repository.ErrCycleDetected)
+	err = repo.MoveNode(ctx, rootID, &rootID, repository.MovePosition{})
+	assert.ErrorIs(t, err, repository.ErrCycleDetected)
+
+	// Failed calls leave the nodes untouched
+	nodes, total, err := repo.GetAllNodes(ctx, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, []int64{rootID, childID}, nodeIDs(nodes))
+	for _, node := range nodes {
+		assert.Equal(t, int64(1), node.Version)
+	}
+}
+
+func testPagination(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+
+	// Five roots with one child each; pages count roots, not nodes
+	var rootIDs []int64
+	for i := 1; i <= 5; i++ {
+		rootID, err := repo.CreateNode(ctx, fmt.Sprintf("root %d", i), nil)
+		require.NoError(t, err)
+		_, err = repo.CreateNode(ctx, fmt.Sprintf("child %d", i), &rootID)
+		require.NoError(t, err)
+		rootIDs = append(rootIDs, rootID)
+	}
+
+	tests := []struct {
+		page      int
+		pageSize  int
+		wantRoots []int64
+	}{
+		{page: 1, pageSize: 2, wantRoots: rootIDs[0:2]},
+		{page: 2, pageSize: 2, wantRoots: rootIDs[2:4]},
+		{page: 3, pageSize: 2, wantRoots: rootIDs[4:5]},
+		{page: 4, pageSize: 2, wantRoots: nil},
+		{page: 1, pageSize: 10, wantRoots: rootIDs},
+	}
+	for _, tt := range tests {
+		nodes, total, err := repo.GetAllNodes(ctx, tt.page, tt.pageSize)
+		require.NoError(t, err)
+		assert.Equal(t, int64(5), total, "page %d of %d", tt.page, tt.pageSize)
+		assert.Equal(t, tt.wantRoots, rootIDsOf(nodes), "page %d of %d", tt.page, tt.pageSize)
+		assert.Len(t, nodes, 2*len(tt.wantRoots), "page %d of %d includes the children", tt.page, tt.pageSize)
+	}
+
+	// Cursor pages start after the given root
+	nodes, hasMore, err := repo.GetNodesAfter(ctx, rootIDs[1], 2)
+	require.NoError(t, err)
+	assert.Equal(t, rootIDs[2:4], rootIDsOf(nodes))
+	assert.True(t, hasMore)
+	nodes, hasMore, err = repo.GetNodesAfter(ctx, rootIDs[3], 2)
+	require.NoError(t, err)
+	assert.Equal(t, rootIDs[4:5], rootIDsOf(nodes))
+	assert.False(t, hasMore)
+
+	// Search counts every hit but returns one page of them
+	hits, total, err := repo.SearchNodes(ctx, "child", nil, 2, 3)
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), total)
+	assert.Len(t, hits, 2)
+
+	// Deleted roots drop out of the totals and into the trash
+	_, err = repo.DeleteNode(ctx, rootIDs[0], repository.DeleteModeCascade)
+	require.NoError(t, err)
+	_, total, err = repo.GetAllNodes(ctx, 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), total)
+	trash, total, err := repo.ListTrash(ctx, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, []int64{rootIDs[0]}, nodeIDs(trash))
+
+	// History is paged newest first
+	for i := 1; i <= 3; i++ {
+		require.NoError(t, repo.UpdateNode(ctx, rootIDs[1], fmt.Sprintf("renamed %d", i), nil))
+	}
+	entries, total, err := repo.GetNodeHistory(ctx, rootIDs[1], 1, 2)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), total)
+	require.Len(t, entries, 2)
+	assert.Equal(t, repository.HistoryUpdate, entries[0].Action)
+	require.NotNil(t, entries[0].NewLabel)
+	assert.Equal(t, "renamed 3", *entries[0].NewLabel)
+	entries, _, err = repo.GetNodeHistory(ctx, rootIDs[1], 2, 2)
+	require.NoError(t, err)
+	require.Len(t, entries, 2)
+	assert.Equal(t, repository.HistoryCreate, entries[1].Action)
+}
+
+func testCascadeDelete(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+
+	rootID, err := repo.CreateNode(ctx, "root", nil)
+	require.NoError(t, err)
+	childID, err := repo.CreateNode(ctx, "child", &rootID)
+	require.NoError(t, err)
+	grandchildID, err := repo.CreateNode(ctx, "grandchild", &childID)
+	require.NoError(t, err)
+	siblingID, err := repo.CreateNode(ctx, "sibling", &rootID)
+	require.NoError(t, err)
+
+	// Refusing leaves nodes with children alone
+	_, err = repo.DeleteNode(ctx, childID, repository.DeleteModeRefuse)
+	assert.ErrorIs(t, err, repository.ErrNodeHasChildren)
+
+	// Cascading takes the whole subtree and nothing else
+	deleted, err := repo.DeleteNode(ctx, childID, repository.DeleteModeCascade)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	for _, id := range []int64{childID, grandchildID} {
+		_, err := repo.GetNode(ctx, id)
+		assert.ErrorIs(t, err, repository.ErrNodeNotFound)
+	}
+	subtree, err := repo.GetSubtree(ctx, rootID, repository.UnlimitedDepth)
+	require.NoError(t, err)
+	assert.Equal(t, []int64{rootID, siblingID}, nodeIDs(subtree))
+
+	// The subtree is one trash entry and comes back as a whole
+	trash, total, err := repo.ListTrash(ctx, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, []int64{childID}, nodeIDs(trash))
+	restored, err := repo.RestoreNode(ctx, childID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), restored)
+	_, err = repo.GetNode(ctx, grandchildID)
+	assert.NoError(t, err)
+
+	// Re-parenting keeps the children
+	deleted, err = repo.DeleteNode(ctx, childID, repository.DeleteModeReparent)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
+	grandchild, err := repo.GetNode(ctx, grandchildID)
+	require.NoError(t, err)
+	require.NotNil(t, grandchild.ParentID)
+	assert.Equal(t, rootID, *grandchild.ParentID)
+
+	// Deleting the root empties the tree
+	deleted, err = repo.DeleteNode(ctx, rootID, repository.DeleteModeCascade)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), deleted)
+	nodes, total, err := repo.GetAllNodes(ctx, 1, 10)
+	require.NoError(t, err)
+	assert.Equal(t, int64(0), total)
+	assert.Empty(t, nodes)
+
+	// Purging removes the nodes for good, including the child trashed
+	// separately below the root
+	purged, err := repo.PurgeNode(ctx, rootID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), purged)
+	_, err = repo.RestoreNode(ctx, rootID)
+	assert.ErrorIs(t, err, repository.ErrNodeNotFound)
+}
+
+func testConcurrentWrites(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+
+	rootID, err := repo.CreateNode(ctx, "root", nil)
+	require.NoError(t, err)
+	counterID, err := repo.CreateNode(ctx, "counter", nil)
+	require.NoError(t, err)
+
+	// Every writer creates children of the same parent and patches the
+	// same node
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var ids []int64
+	for w := 0; w < concurrentWriters; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writesPerWriter; i++ {
+				id, err := repo.CreateNode(ctx, fmt.Sprintf("writer %d node %d", w, i), &rootID)
+				if !assert.NoError(t, err) {
+					return
+				}
+				mu.Lock()
+				ids = append(ids, id)
+				mu.Unlock()
+
+				label := fmt.Sprintf("counter %d.%d", w, i)
+				assert.NoError(t, repo.PatchNode(ctx, counterID, repository.NodePatch{Label: &label}))
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	const writes = concurrentWriters * writesPerWriter
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	for i := 1; i < len(ids); i++ {
+		assert.NotEqual(t, ids[i-1], ids[i], "IDs are unique")
+	}
+	assert.Len(t, ids, writes)
+
+	// No write was lost
+	subtree, err := repo.GetSubtree(ctx, rootID, repository.UnlimitedDepth)
+	require.NoError(t, err)
+	assert.Len(t, subtree, writes+1)
+	counter, err := repo.GetNode(ctx, counterID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(writes+1), counter.Version)
+	_, total, err := repo.GetNodeHistory(ctx, counterID, 1, 1)
+	require.NoError(t, err)
+	assert.Equal(t, int64(writes+1), total)
+}
+
+func testWebhookOutbox(t *testing.T, repo repository.Repository) {
+	ctx := context.Background()
+
+	// Changes made before the webhook exists aren't queued
+	rootID, err := repo.CreateNode(ctx, "root", nil)
+	require.NoError(t, err)
+	_, err = repo.CreateWebhook(ctx, &repository.Webhook{
+		URL:        "http://example.com/hooks/tree",
+		Secret:     "0123456789abcdef",
+		EventTypes: []string{"node.created", "node.moved", "node.deleted"},
+		Active:     true,
+	})
+	require.NoError(t, err)
+
+	childID, err := repo.CreateNode(ctx, "child", &rootID)
+	require.NoError(t, err)
+	otherID, err := repo.CreateNode(ctx, "other", nil)
+	require.NoError(t, err)
+	// Updates aren't subscribed to
+	require.NoError(t, repo.PatchNode(ctx, childID, repository.NodePatch{Label: stringPtr("renamed")}))
+	require.NoError(t, repo.MoveNode(ctx, childID, &otherID, repository.MovePosition{}))
+	_, err = repo.DeleteNode(ctx, otherID, repository.DeleteModeCascade)
+	require.NoError(t, err)
+
+	// A failed batch queues nothing
+	_, err = repo.ApplyBatch(ctx, []*repository.BatchOp{
+		{Type: repository.BatchCreate, Label: stringPtr("rolled back"), Parent: &repository.BatchRef{ID: rootID}},
+		{Type: repository.BatchDelete, Node: repository.BatchRef{ID: otherID}, Mode: repository.DeleteModeCascade},
+	})
+	assert.ErrorIs(t, err, repository.ErrNodeNotFound)
+
+	// Every change is queued by the mutation itself, with the ID of its
+	// history entry
+	deliveries, err := repo.ClaimWebhookDeliveries(ctx, time.Now().Add(time.Minute), time.Minute, 10)
+	require.NoError(t, err)
+	var changes []string
+	for _, delivery := range deliveries {
+		var event struct {
+			ID           int64   `json:"id"`
+			Type         string  `json:"type"`
+			NodeID       int64   `json:"nodeId"`
+			Path         []int64 `json:"path"`
+			PreviousPath []int64 `json:"previousPath"`
+			Node         struct {
+				ID    int64  `json:"id"`
+				Label string `json:"label"`
+			} `json:"node"`
+		}
+		require.NoError(t, json.Unmarshal(delivery.Payload, &event))
+		assert.Equal(t, delivery.EventType, event.Type)
+		assert.Equal(t, event.NodeID, event.Node.ID)
+
+		entries, _, err := repo.GetNodeHistory(ctx, event.NodeID, 1, 10)
+		require.NoError(t, err)
+		var recorded bool
+		for _, entry := range entries {
+			recorded = recorded || entry.ID == event.ID
+		}
+		assert.True(t, recorded, "event %d is a history entry of node %d", event.ID, event.NodeID)
+
+		changes = append(changes, fmt.Sprintf("%s %s %v %v", event.Type, event.Node.Label, event.Path, event.PreviousPath))
+	}
+	assert.ElementsMatch(t, []string{
+		fmt.Sprintf("node.created child %v []", []int64{rootID, childID}),
+		fmt.Sprintf("node.created other %v []", []int64{otherID}),
+		fmt.Sprintf("node.moved renamed %v %v", []int64{otherID, childID}, []int64{rootID, childID}),
+		fmt.Sprintf("node.deleted other %v []", []int64{otherID}),
+		fmt.Sprintf("node.deleted renamed %v []", []int64{otherID, childID}),
+	}, changes)
+}
+
+// nodeIDs returns the IDs of nodes in order
+func nodeIDs(nodes []*repository.Node) []int64 {
+	var ids []int64
+	for _, node := range nodes {
+		ids = append(ids, node.ID)
+	}
+	return ids
+}
+
+// rootIDsOf returns the IDs of the roots among nodes in order
+func rootIDsOf(nodes []*repository.Node) []int64 {
+	var ids []int64
+	for _, node := range nodes {
+		if node.ParentID == nil {
+			ids = append(ids, node.ID)
+		}
+	}
+	return ids
+}
+
+// stringPtr returns a pointer to s
+func stringPtr(s string) *string {
+	return &s
+}
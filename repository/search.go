@@ -0,0 +1,81 @@
+package repository
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Relevance scores of label matches. Labels that only match by trigram
+// similarity score their similarity, which is at least similarityThreshold.
+const (
+	scoreExactMatch     = 1.0
+	scorePrefixMatch    = 0.9
+	scoreSubstringMatch = 0.8
+
+	// similarityThreshold matches pg_trgm's default for the % operator
+	similarityThreshold = 0.3
+)
+
+// labelScore scores a label against a search query the same way
+// PostgresRepository.SearchNodes does. It reports false if the label
+// doesn't match at all.
+func labelScore(label, query string) (float64, bool) {
+	lowerLabel, lowerQuery := strings.ToLower(label), strings.ToLower(query)
+
+	score := 0.0
+	switch {
+	case lowerLabel == lowerQuery:
+		score = scoreExactMatch
+	case strings.HasPrefix(lowerLabel, lowerQuery):
+		score = scorePrefixMatch
+	case strings.Contains(lowerLabel, lowerQuery):
+		score = scoreSubstringMatch
+	}
+
+	similarity := trigramSimilarity(lowerLabel, lowerQuery)
+	if score == 0 && similarity < similarityThreshold {
+		return 0, false
+	}
+	if similarity > score {
+		score = similarity
+	}
+	return score, true
+}
+
+// trigramSimilarity approximates pg_trgm's similarity(): the number of
+// trigrams two strings share divided by the number of distinct trigrams in
+// either. Each word is padded with two spaces in front and one behind.
+func trigramSimilarity(a, b string) float64 {
+	trigramsA, trigramsB := trigrams(a), trigrams(b)
+	if len(trigramsA) == 0 || len(trigramsB) == 0 {
+		return 0
+	}
+
+	shared := 0
+	for trigram := range trigramsA {
+		if trigramsB[trigram] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(trigramsA)+len(trigramsB)-shared)
+}
+
+// trigrams returns the set of padded word trigrams of a lowercase string
+func trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+	words := strings.FieldsFunc(s, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		padded := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(padded); i++ {
+			set[string(padded[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+// escapeLike escapes the LIKE wildcards in s so it matches literally
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
@@ -0,0 +1,306 @@
+package repository
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// This file holds what PostgresRepository and SQLiteRepository share: the
+// column lists both select, the scanning of their rows and the pure-Go
+// steps around their queries. Only the SQL itself differs between them.
+
+// nodeColumns lists the columns scanned by scanNode, in order
+const nodeColumns = "id, tree_id, label, parent_id, position, attributes, created_at, updated_at, version, deleted_at"
+
+// qualifiedNodeColumns is nodeColumns for a nodes table aliased as n
+const qualifiedNodeColumns = "n.id, n.tree_id, n.label, n.parent_id, n.position, n.attributes, n.created_at, n.updated_at, n.version, n.deleted_at"
+
+// nextPosition returns an SQL expression evaluating to the position that
+// places a new child of the parent ID bound to param after all of its
+// siblings. Roots are ordered by ID, and since parent_id = NULL matches
+// nothing they always get 1.
+func nextPosition(param string) string {
+	return "COALESCE((SELECT MAX(position) FROM nodes WHERE parent_id = " + param + "), 0) + 1"
+}
+
+// historyColumns lists the node_history columns scanned by
+// scanHistoryEntries, in order
+const historyColumns = "id, node_id, tree_id, action, old_label, new_label, old_parent_id, new_parent_id, position, attributes, COALESCE(actor, ''), changed_at"
+
+// webhookColumns lists the webhooks columns in the order expected by
+// scanWebhook and scanSQLiteWebhook
+const webhookColumns = "id, tree_id, url, secret, event_types, active, created_at, updated_at"
+
+// deliveryColumns lists the webhook_deliveries columns in the order
+// expected by scanDeliveries
+const deliveryColumns = "id, webhook_id, tree_id, event_type, payload, status, attempts, next_attempt_at, last_attempt_at, COALESCE(response_status, 0), COALESCE(last_error, ''), created_at"
+
+// lockedNode is the state of a node locked by lockNode
+type lockedNode struct {
+	label    string
+	parentID *int64
+}
+
+// sortedKeys returns the keys of an attribute filter in order, so the
+// queries built from it are stable and their plans can be reused
+func sortedKeys(filter map[string]string) []string {
+	keys := make([]string, 0, len(filter))
+	for key := range filter {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// lastRootID returns the greatest root ID in nodes, a page of subtrees
+// fetched after afterID, or afterID if the page is empty. The next page
+// starts after it.
+func lastRootID(nodes []*Node, afterID int64) int64 {
+	last := afterID
+	for _, node := range nodes {
+		if node.ParentID == nil && node.ID > last {
+			last = node.ID
+		}
+	}
+	return last
+}
+
+// marshalAttributes encodes attributes for a JSON column
+func marshalAttributes(attributes map[string]interface{}) (string, error) {
+	if len(attributes) == 0 {
+		return "{}", nil
+	}
+	encoded, err := json.Marshal(attributes)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	return string(encoded), nil
+}
+
+// unmarshalAttributes decodes a JSON attributes column, returning nil for
+// an empty object
+func unmarshalAttributes(encoded []byte) (map[string]interface{}, error) {
+	if len(encoded) == 0 {
+		return nil, nil
+	}
+	var attributes map[string]interface{}
+	if err := json.Unmarshal(encoded, &attributes); err != nil {
+		return nil, fmt.Errorf("error decoding attributes: %w", err)
+	}
+	if len(attributes) == 0 {
+		return nil, nil
+	}
+	return attributes, nil
+}
+
+// storedTime scans a time column into the time it points to. PostgreSQL
+// returns native timestamps and SQLite the text written by
+// formatSQLiteTime.
+type storedTime struct {
+	t *time.Time
+}
+
+// Scan implements sql.Scanner
+func (s storedTime) Scan(src interface{}) error {
+	var text string
+	switch v := src.(type) {
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	case time.Time:
+		*s.t = v
+		return nil
+	default:
+		return fmt.Errorf("cannot scan %T into a time", src)
+	}
+	parsed, err := time.ParseInLocation(sqliteTimeFormat, text, time.UTC)
+	if err != nil {
+		return fmt.Errorf("error parsing time: %w", err)
+	}
+	*s.t = parsed
+	return nil
+}
+
+// storedNullTime scans a nullable time column into the pointer it points
+// to, which is left nil for NULL
+type storedNullTime struct {
+	t **time.Time
+}
+
+// Scan implements sql.Scanner
+func (s storedNullTime) Scan(src interface{}) error {
+	if src == nil {
+		*s.t = nil
+		return nil
+	}
+	var t time.Time
+	if err := (storedTime{&t}).Scan(src); err != nil {
+		return err
+	}
+	*s.t = &t
+	return nil
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanNode scans a single row selected with nodeColumns, followed by any
+// extra columns scanned into extra
+func scanNode(row rowScanner, extra ...interface{}) (*Node, error) {
+	var node Node
+	var parentID sql.NullInt64
+	var attributes []byte
+	dest := []interface{}{&node.ID, &node.TreeID, &node.Label, &parentID, &node.Position, &attributes,
+		storedTime{&node.CreatedAt}, storedTime{&node.UpdatedAt}, &node.Version, storedNullTime{&node.DeletedAt}}
+	if err := row.Scan(append(dest, extra...)...); err != nil {
+		return nil, err
+	}
+	if parentID.Valid {
+		node.ParentID = &parentID.Int64
+	}
+	var err error
+	if node.Attributes, err = unmarshalAttributes(attributes); err != nil {
+		return nil, err
+	}
+	return &node, nil
+}
+
+// scanNodes scans and closes a result set selected with nodeColumns
+func scanNodes(rows *sql.Rows) ([]*Node, error) {
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("Warning: Error closing rows: %v\n", err)
+		}
+	}()
+
+	var nodes []*Node
+	for rows.Next() {
+		node, err := scanNode(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning node: %w", err)
+		}
+		nodes = append(nodes, node)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating nodes: %w", err)
+	}
+	return nodes, nil
+}
+
+// scanIDs scans and closes a result set of a single ID column
+func scanIDs(rows *sql.Rows) ([]int64, error) {
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("Warning: Error closing rows: %v\n", err)
+		}
+	}()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning ID: %w", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating IDs: %w", err)
+	}
+	return ids, nil
+}
+
+// scanSiblings scans and closes a result set of sibling IDs and positions
+func scanSiblings(rows *sql.Rows) ([]sibling, error) {
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("Warning: Error closing rows: %v\n", err)
+		}
+	}()
+
+	var siblings []sibling
+	for rows.Next() {
+		var s sibling
+		if err := rows.Scan(&s.id, &s.position); err != nil {
+			return nil, fmt.Errorf("error scanning sibling: %w", err)
+		}
+		siblings = append(siblings, s)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating siblings: %w", err)
+	}
+	return siblings, nil
+}
+
+// scanHistoryEntries scans and closes a result set selected with historyColumns
+func scanHistoryEntries(rows *sql.Rows) ([]*HistoryEntry, error) {
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("Warning: Error closing rows: %v\n", err)
+		}
+	}()
+
+	var entries []*HistoryEntry
+	for rows.Next() {
+		var entry HistoryEntry
+		var oldLabel, newLabel sql.NullString
+		var oldParentID, newParentID sql.NullInt64
+		var attributes []byte
+		err := rows.Scan(&entry.ID, &entry.NodeID, &entry.TreeID, &entry.Action, &oldLabel, &newLabel,
+			&oldParentID, &newParentID, &entry.Position, &attributes, &entry.Actor, storedTime{&entry.ChangedAt})
+		if err != nil {
+			return nil, fmt.Errorf("error scanning history entry: %w", err)
+		}
+		if oldLabel.Valid {
+			entry.OldLabel = &oldLabel.String
+		}
+		if newLabel.Valid {
+			entry.NewLabel = &newLabel.String
+		}
+		if oldParentID.Valid {
+			entry.OldParentID = &oldParentID.Int64
+		}
+		if newParentID.Valid {
+			entry.NewParentID = &newParentID.Int64
+		}
+		if entry.Attributes, err = unmarshalAttributes(attributes); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating history: %w", err)
+	}
+	return entries, nil
+}
+
+// scanDeliveries scans and closes a result set selected with deliveryColumns
+func scanDeliveries(rows *sql.Rows) ([]*WebhookDelivery, error) {
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("Warning: Error closing rows: %v\n", err)
+		}
+	}()
+
+	var deliveries []*WebhookDelivery
+	for rows.Next() {
+		var delivery WebhookDelivery
+		err := rows.Scan(&delivery.ID, &delivery.WebhookID, &delivery.TreeID, &delivery.EventType,
+			&delivery.Payload, &delivery.Status, &delivery.Attempts, storedTime{&delivery.NextAttemptAt},
+			storedNullTime{&delivery.LastAttemptAt}, &delivery.ResponseStatus, &delivery.LastError,
+			storedTime{&delivery.CreatedAt})
+		if err != nil {
+			return nil, fmt.Errorf("error scanning webhook delivery: %w", err)
+		}
+		deliveries = append(deliveries, &delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhook deliveries: %w", err)
+	}
+	return deliveries, nil
+}
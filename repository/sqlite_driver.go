@@ -0,0 +1,8 @@
+package repository
+
+// The SQLite driver is a pure-Go translation of SQLite, so the service
+// builds without cgo
+import _ "modernc.org/sqlite"
+
+// sqliteDriverName is the database/sql driver SQLiteRepository opens
+const sqliteDriverName = "sqlite"
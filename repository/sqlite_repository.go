@@ -0,0 +1,1696 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ammiranda/tree_service/migrations"
+)
+
+// SQLiteRepository implements Repository using an embedded SQLite database
+// stored in a single file or in memory, so the service can run locally
+// without PostgreSQL. It keeps the same tables and follows the same
+// semantics as PostgresRepository, walking the hierarchy with recursive
+// queries, and shares its row scanning and the steps that don't depend on
+// the SQL dialect. All operations share one connection and so run one at a
+// time.
+type SQLiteRepository struct {
+	db     *sql.DB
+	path   string
+	treeID int64
+}
+
+// NewSQLiteRepository creates a new SQLite repository stored in the file at
+// path, or in memory for the lifetime of the repository if path is ":memory:"
+func NewSQLiteRepository(path string) *SQLiteRepository {
+	return &SQLiteRepository{
+		path:   path,
+		treeID: DefaultTreeID,
+	}
+}
+
+// Initialize opens the SQLite database and runs its migrations
+func (r *SQLiteRepository) Initialize(ctx context.Context) error {
+	db, err := sql.Open(sqliteDriverName, r.path)
+	if err != nil {
+		return fmt.Errorf("error opening database: %w", err)
+	}
+
+	// SQLite allows a single writer, and every connection to ":memory:"
+	// opens a database of its own, so keep exactly one connection open
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+	db.SetConnMaxLifetime(0)
+
+	// Foreign keys are enforced per connection, and off by default
+	if _, err := db.ExecContext(ctx, "PRAGMA foreign_keys = ON"); err != nil {
+		if closeErr := db.Close(); closeErr != nil {
+			fmt.Printf("Warning: Error closing database connection: %v\n", closeErr)
+		}
+		return fmt.Errorf("error enabling foreign keys: %w", err)
+	}
+
+	if err := migrations.RunSQLiteMigrations(ctx, db); err != nil {
+		if closeErr := db.Close(); closeErr != nil {
+			fmt.Printf("Warning: Error closing database connection: %v\n", closeErr)
+		}
+		return fmt.Errorf("error running migrations: %w", err)
+	}
+
+	r.db = db
+	return nil
+}
+
+// Cleanup closes the database connection
+func (r *SQLiteRepository) Cleanup(ctx context.Context) error {
+	if r.db != nil {
+		return r.db.Close()
+	}
+	return nil
+}
+
+// ForTree returns a copy of the repository scoped to treeID that shares its
+// connection. Call it after Initialize.
+func (r *SQLiteRepository) ForTree(treeID int64) Repository {
+	scoped := *r
+	scoped.treeID = treeID
+	return &scoped
+}
+
+// CreateTree creates a new, empty named tree
+func (r *SQLiteRepository) CreateTree(ctx context.Context, name string) (*Tree, error) {
+	if strings.TrimSpace(name) == "" {
+		return nil, ErrInvalidInput
+	}
+
+	var tree Tree
+	err := r.inTransaction(ctx, func(tx *sqliteTx) error {
+		var exists bool
+		err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM trees WHERE name = ?1)", name).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("error checking tree name: %w", err)
+		}
+		if exists {
+			return ErrTreeExists
+		}
+
+		err = tx.QueryRowContext(ctx,
+			"INSERT INTO trees (name, created_at) VALUES (?1, ?2) RETURNING id, name, created_at",
+			name, tx.now,
+		).Scan(&tree.ID, &tree.Name, storedTime{&tree.CreatedAt})
+		if err != nil {
+			return fmt.Errorf("error creating tree: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &tree, nil
+}
+
+// GetTree retrieves a tree by ID
+func (r *SQLiteRepository) GetTree(ctx context.Context, id int64) (*Tree, error) {
+	var tree Tree
+	err := r.db.QueryRowContext(ctx,
+		"SELECT id, name, created_at FROM trees WHERE id = ?1",
+		id,
+	).Scan(&tree.ID, &tree.Name, storedTime{&tree.CreatedAt})
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrTreeNotFound
+		}
+		return nil, fmt.Errorf("error getting tree: %w", err)
+	}
+	return &tree, nil
+}
+
+// ListTrees retrieves every tree ordered by ID
+func (r *SQLiteRepository) ListTrees(ctx context.Context) ([]*Tree, error) {
+	rows, err := r.db.QueryContext(ctx, "SELECT id, name, created_at FROM trees ORDER BY id")
+	if err != nil {
+		return nil, fmt.Errorf("error listing trees: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("Warning: Error closing rows: %v\n", err)
+		}
+	}()
+
+	trees := []*Tree{}
+	for rows.Next() {
+		var tree Tree
+		if err := rows.Scan(&tree.ID, &tree.Name, storedTime{&tree.CreatedAt}); err != nil {
+			return nil, fmt.Errorf("error scanning tree: %w", err)
+		}
+		trees = append(trees, &tree)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating trees: %w", err)
+	}
+	return trees, nil
+}
+
+// DeleteTree deletes a tree together with all of its nodes; its webhooks
+// are removed by the foreign key cascade
+func (r *SQLiteRepository) DeleteTree(ctx context.Context, id int64) (int64, error) {
+	if id == DefaultTreeID {
+		return 0, ErrInvalidInput
+	}
+
+	var deleted int64
+	err := r.inTransaction(ctx, func(tx *sqliteTx) error {
+		var exists bool
+		err := tx.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM trees WHERE id = ?1)", id).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("error checking tree: %w", err)
+		}
+		if !exists {
+			return ErrTreeNotFound
+		}
+
+		// Foreign keys are checked at the end of each statement, so deleting
+		// all nodes in one statement never violates parent_id
+		result, err := tx.ExecContext(ctx, "DELETE FROM nodes WHERE tree_id = ?1", id)
+		if err != nil {
+			return fmt.Errorf("error deleting tree nodes: %w", err)
+		}
+		deleted, err = result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("error getting rows affected: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM node_history WHERE tree_id = ?1", id); err != nil {
+			return fmt.Errorf("error deleting tree history: %w", err)
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM trees WHERE id = ?1", id); err != nil {
+			return fmt.Errorf("error deleting tree: %w", err)
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// CreateNode creates a new node in the database
+func (r *SQLiteRepository) CreateNode(ctx context.Context, label string, parentID *int64) (int64, error) {
+	return r.CreateNodeWithAttributes(ctx, label, parentID, nil)
+}
+
+// CreateNodeWithAttributes creates a new node carrying JSON attributes
+func (r *SQLiteRepository) CreateNodeWithAttributes(ctx context.Context, label string, parentID *int64, attributes map[string]interface{}) (int64, error) {
+	var id int64
+	err := r.inTransaction(ctx, func(tx *sqliteTx) error {
+		var err error
+		id, err = r.createNode(ctx, tx, label, parentID, attributes)
+		return err
+	})
+	return id, err
+}
+
+// createNode creates a node within tx, after its parent's existing children
+func (r *SQLiteRepository) createNode(ctx context.Context, tx *sqliteTx, label string, parentID *int64, attributes map[string]interface{}) (int64, error) {
+	if label == "" {
+		return 0, ErrInvalidInput
+	}
+
+	if parentID != nil {
+		if err := r.checkParentExists(ctx, tx, *parentID); err != nil {
+			return 0, err
+		}
+	}
+
+	var position float64
+	if err := tx.QueryRowContext(ctx, "SELECT "+nextPosition("?1"), parentID).Scan(&position); err != nil {
+		return 0, fmt.Errorf("error getting position: %w", err)
+	}
+	return r.insertNode(ctx, tx, label, parentID, position, attributes)
+}
+
+// insertNode inserts a node within tx at the given position and records its
+// creation
+func (r *SQLiteRepository) insertNode(ctx context.Context, tx *sqliteTx, label string, parentID *int64, position float64, attributes map[string]interface{}) (int64, error) {
+	encoded, err := marshalAttributes(attributes)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int64
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO nodes (tree_id, label, parent_id, position, attributes, created_at, updated_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?6)
+		RETURNING id
+	`, r.treeID, label, parentID, position, encoded, tx.now).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error creating node: %w", err)
+	}
+
+	if err := r.recordHistory(ctx, tx, HistoryCreate, "NULL", "NULL", "id = ?3", id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+// ImportTree creates a nested tree of nodes in a single transaction
+func (r *SQLiteRepository) ImportTree(ctx context.Context, parentID *int64, root *ImportNode) (map[string]int64, error) {
+	// Validate the whole document before touching the database
+	if err := validateImport(root); err != nil {
+		return nil, err
+	}
+
+	ids := make(map[string]int64)
+	err := r.inTransaction(ctx, func(tx *sqliteTx) error {
+		if parentID != nil {
+			if err := r.checkParentExists(ctx, tx, *parentID); err != nil {
+				return err
+			}
+		}
+
+		// The imported root goes after the parent's existing children; below
+		// it the document order is kept
+		var rootPosition float64
+		err := tx.QueryRowContext(ctx, "SELECT "+nextPosition("?1"), parentID).Scan(&rootPosition)
+		if err != nil {
+			return fmt.Errorf("error getting import position: %w", err)
+		}
+
+		var insert func(node *ImportNode, parentID *int64, position float64) error
+		insert = func(node *ImportNode, parentID *int64, position float64) error {
+			id, err := r.insertNode(ctx, tx, node.Label, parentID, position, node.Attributes)
+			if err != nil {
+				return fmt.Errorf("error importing node %q: %w", node.Key, err)
+			}
+			ids[node.Key] = id
+			for i, child := range node.Children {
+				if err := insert(child, &id, float64(i+1)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		return insert(root, parentID, rootPosition)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// GetNode retrieves a node by ID
+func (r *SQLiteRepository) GetNode(ctx context.Context, id int64) (*Node, error) {
+	node, err := scanNode(r.db.QueryRowContext(ctx,
+		"SELECT "+nodeColumns+" FROM nodes WHERE id = ?1 AND tree_id = ?2 AND deleted_at IS NULL",
+		id, r.treeID,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNodeNotFound
+		}
+		return nil, fmt.Errorf("error getting node: %w", err)
+	}
+	return node, nil
+}
+
+// GetSubtree retrieves a node and its descendants down to maxDepth levels
+func (r *SQLiteRepository) GetSubtree(ctx context.Context, id int64, maxDepth int) ([]*Node, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		WITH RECURSIVE subtree AS (
+			SELECT n.*, 0 AS depth FROM nodes n
+			WHERE n.id = ?1 AND n.tree_id = ?3 AND n.deleted_at IS NULL
+			UNION ALL
+			SELECT n.*, s.depth + 1 FROM nodes n
+			INNER JOIN subtree s ON n.parent_id = s.id
+			WHERE n.deleted_at IS NULL AND (?2 < 0 OR s.depth < ?2)
+		)
+		SELECT `+nodeColumns+` FROM subtree ORDER BY depth, id
+	`, id, maxDepth, r.treeID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting subtree: %w", err)
+	}
+
+	nodes, err := scanNodes(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, ErrNodeNotFound
+	}
+	return nodes, nil
+}
+
+// GetAncestors retrieves the chain of nodes from the root down to the node
+func (r *SQLiteRepository) GetAncestors(ctx context.Context, id int64) ([]*Node, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		WITH RECURSIVE ancestors AS (
+			SELECT n.*, 0 AS depth FROM nodes n
+			WHERE n.id = ?1 AND n.tree_id = ?2 AND n.deleted_at IS NULL
+			UNION ALL
+			SELECT n.*, a.depth + 1 FROM nodes n
+			INNER JOIN ancestors a ON n.id = a.parent_id
+		)
+		SELECT `+nodeColumns+` FROM ancestors ORDER BY depth DESC
+	`, id, r.treeID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting ancestors: %w", err)
+	}
+
+	nodes, err := scanNodes(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, ErrNodeNotFound
+	}
+	return nodes, nil
+}
+
+// GetAllNodes retrieves a page of root nodes with their complete subtrees
+func (r *SQLiteRepository) GetAllNodes(ctx context.Context, page int, pageSize int) ([]*Node, int64, error) {
+	var total int64
+	err := r.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM nodes WHERE parent_id IS NULL AND tree_id = ?1 AND deleted_at IS NULL",
+		r.treeID,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting total count: %w", err)
+	}
+
+	offset := (page - 1) * pageSize
+	nodes, err := r.querySubtrees(ctx, `
+		SELECT id FROM nodes
+		WHERE parent_id IS NULL AND tree_id = ?3 AND deleted_at IS NULL
+		ORDER BY id
+		LIMIT ?1 OFFSET ?2
+	`, pageSize, offset, r.treeID)
+	if err != nil {
+		return nil, 0, err
+	}
+	return nodes, total, nil
+}
+
+// GetNodesAfter retrieves up to pageSize roots with an ID greater than
+// afterID, with their complete subtrees
+func (r *SQLiteRepository) GetNodesAfter(ctx context.Context, afterID int64, pageSize int) ([]*Node, bool, error) {
+	nodes, err := r.querySubtrees(ctx, `
+		SELECT id FROM nodes
+		WHERE parent_id IS NULL AND tree_id = ?3 AND deleted_at IS NULL AND id > ?1
+		ORDER BY id
+		LIMIT ?2
+	`, afterID, pageSize, r.treeID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	// Seek past the last root on this page to see whether another page exists
+	var hasMore bool
+	err = r.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM nodes WHERE parent_id IS NULL AND tree_id = ?2 AND deleted_at IS NULL AND id > ?1)",
+		lastRootID(nodes, afterID), r.treeID,
+	).Scan(&hasMore)
+	if err != nil {
+		return nil, false, fmt.Errorf("error checking for more trees: %w", err)
+	}
+	return nodes, hasMore, nil
+}
+
+// GetNodesByAttributes retrieves a page of trees pruned to the nodes whose
+// attributes match filter and their ancestors
+func (r *SQLiteRepository) GetNodesByAttributes(ctx context.Context, filter map[string]string, page, pageSize int) ([]*Node, int64, error) {
+	if len(filter) == 0 {
+		return r.GetAllNodes(ctx, page, pageSize)
+	}
+
+	// An attribute's text form is the string itself for strings and its JSON
+	// otherwise, like ->> in PostgreSQL
+	keys := sortedKeys(filter)
+	conditions := []string{"tree_id = ?1", "deleted_at IS NULL"}
+	args := []interface{}{r.treeID}
+	for _, key := range keys {
+		args = append(args, "$."+strconv.Quote(key), filter[key])
+		path, value := len(args)-1, len(args)
+		conditions = append(conditions, fmt.Sprintf(
+			"CASE json_type(attributes, ?%[1]d) WHEN 'text' THEN attributes ->> ?%[1]d WHEN 'null' THEN NULL ELSE attributes -> ?%[1]d END = ?%[2]d",
+			path, value,
+		))
+	}
+
+	// kept holds every match and the chain of ancestors above it
+	keptCTE := `
+		WITH RECURSIVE kept(id, parent_id) AS (
+			SELECT id, parent_id FROM nodes WHERE ` + strings.Join(conditions, " AND ") + `
+			UNION
+			SELECT n.id, n.parent_id FROM nodes n
+			INNER JOIN kept k ON n.id = k.parent_id
+		)`
+
+	var total int64
+	err := r.db.QueryRowContext(ctx,
+		keptCTE+" SELECT COUNT(*) FROM kept WHERE parent_id IS NULL",
+		args...,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting total count: %w", err)
+	}
+
+	offset := (page - 1) * pageSize
+	args = append(args, pageSize, offset)
+	rows, err := r.db.QueryContext(ctx, keptCTE+fmt.Sprintf(`, page_roots AS (
+			SELECT id FROM kept
+			WHERE parent_id IS NULL
+			ORDER BY id
+			LIMIT ?%d OFFSET ?%d
+		), page_nodes(id) AS (
+			SELECT id FROM page_roots
+			UNION ALL
+			SELECT k.id FROM kept k
+			INNER JOIN page_nodes p ON k.parent_id = p.id
+		)
+		SELECT `+qualifiedNodeColumns+`
+		FROM nodes n
+		INNER JOIN page_nodes p ON n.id = p.id
+		ORDER BY n.id
+	`, len(args)-1, len(args)), args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting nodes: %w", err)
+	}
+
+	nodes, err := scanNodes(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return nodes, total, nil
+}
+
+// SearchNodes finds nodes whose label matches query. SQLite has no trigram
+// index, so the candidates are scored with labelScore, which mirrors the
+// scoring of PostgresRepository.SearchNodes.
+func (r *SQLiteRepository) SearchNodes(ctx context.Context, query string, rootID *int64, page, pageSize int) ([]*SearchHit, int64, error) {
+	if query == "" {
+		return nil, 0, ErrInvalidInput
+	}
+
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT "+nodeColumns+" FROM nodes WHERE tree_id = ?1 AND deleted_at IS NULL ORDER BY id",
+		r.treeID,
+	)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error searching nodes: %w", err)
+	}
+	nodes, err := scanNodes(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	byID := make(map[int64]*Node, len(nodes))
+	for _, node := range nodes {
+		byID[node.ID] = node
+	}
+	if rootID != nil {
+		if _, ok := byID[*rootID]; !ok {
+			return nil, 0, ErrNodeNotFound
+		}
+	}
+
+	hits := []*SearchHit{}
+	for _, node := range nodes {
+		score, ok := labelScore(node.Label, query)
+		if !ok {
+			continue
+		}
+
+		// Collect the ancestors, which also tells whether the node is in scope
+		var path []*Node
+		inScope := rootID == nil || node.ID == *rootID
+		for current := node.ParentID; current != nil; {
+			parent, ok := byID[*current]
+			if !ok {
+				break
+			}
+			path = append([]*Node{parent}, path...)
+			if rootID != nil && parent.ID == *rootID {
+				inScope = true
+			}
+			current = parent.ParentID
+		}
+		if inScope {
+			hits = append(hits, &SearchHit{Node: node, Path: path, Score: score})
+		}
+	}
+
+	sort.SliceStable(hits, func(i, j int) bool {
+		return hits[i].Score > hits[j].Score
+	})
+
+	total := int64(len(hits))
+	start := (page - 1) * pageSize
+	if start > len(hits) {
+		start = len(hits)
+	}
+	end := start + pageSize
+	if end > len(hits) {
+		end = len(hits)
+	}
+	return hits[start:end], total, nil
+}
+
+// querySubtrees loads the complete subtrees of the roots selected by
+// rootsQuery, which must select a single id column, ordered by node ID
+func (r *SQLiteRepository) querySubtrees(ctx context.Context, rootsQuery string, args ...interface{}) ([]*Node, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		WITH RECURSIVE page_roots AS (`+rootsQuery+`), subtree AS (
+			SELECT n.* FROM nodes n
+			INNER JOIN page_roots p ON n.id = p.id
+			UNION ALL
+			SELECT n.* FROM nodes n
+			INNER JOIN subtree s ON n.parent_id = s.id
+			WHERE n.deleted_at IS NULL
+		)
+		SELECT `+nodeColumns+` FROM subtree ORDER BY id
+	`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error getting nodes: %w", err)
+	}
+	return scanNodes(rows)
+}
+
+// UpdateNode replaces a node's label and parent
+func (r *SQLiteRepository) UpdateNode(ctx context.Context, id int64, label string, parentID *int64) error {
+	if label == "" {
+		return ErrInvalidInput
+	}
+	return r.patchNode(ctx, id, NodePatch{Label: &label, SetParent: true, ParentID: parentID}, nil)
+}
+
+// PatchNode updates only the fields set in patch
+func (r *SQLiteRepository) PatchNode(ctx context.Context, id int64, patch NodePatch) error {
+	return r.patchNode(ctx, id, patch, nil)
+}
+
+// UpdateNodeIfVersion applies patch if the node is at the given version
+func (r *SQLiteRepository) UpdateNodeIfVersion(ctx context.Context, id int64, version int64, patch NodePatch) error {
+	return r.patchNode(ctx, id, patch, &version)
+}
+
+// patchNode applies patch, first checking the node's version if version is set
+func (r *SQLiteRepository) patchNode(ctx context.Context, id int64, patch NodePatch, version *int64) error {
+	return r.inTransaction(ctx, func(tx *sqliteTx) error {
+		return r.patchNodeTx(ctx, tx, id, patch, version)
+	})
+}
+
+// patchNodeTx applies patch within tx
+func (r *SQLiteRepository) patchNodeTx(ctx context.Context, tx *sqliteTx, id int64, patch NodePatch, version *int64) error {
+	if err := validatePatch(patch); err != nil {
+		return err
+	}
+
+	// Without a parent change this only checks that the node exists
+	var parentID *int64
+	if patch.SetParent {
+		parentID = patch.ParentID
+	}
+	if err := r.checkParent(ctx, tx, id, parentID); err != nil {
+		return err
+	}
+
+	old, err := r.lockNode(ctx, tx, id, version)
+	if err != nil {
+		return err
+	}
+
+	// Nil attributes are left untouched
+	var attributes interface{}
+	if patch.Attributes != nil {
+		encoded, err := marshalAttributes(patch.Attributes)
+		if err != nil {
+			return err
+		}
+		attributes = encoded
+	}
+
+	// A node that changes parent goes to the end of its new siblings
+	_, err = tx.ExecContext(ctx, `
+		UPDATE nodes SET
+			label = COALESCE(?1, label),
+			position = CASE WHEN NOT ?3 OR parent_id IS ?2 THEN position
+				ELSE `+nextPosition("?2")+` END,
+			parent_id = CASE WHEN ?3 THEN ?2 ELSE parent_id END,
+			attributes = COALESCE(?5, attributes),
+			version = version + 1,
+			updated_at = ?6
+		WHERE id = ?4
+	`, patch.Label, patch.ParentID, patch.SetParent, id, attributes, tx.now)
+	if err != nil {
+		return fmt.Errorf("error updating node: %w", err)
+	}
+	return r.recordHistory(ctx, tx, HistoryUpdate, "?3", "?4", "id = ?5", old.label, old.parentID, id)
+}
+
+// MoveNode moves a node to a new parent and place among its siblings
+func (r *SQLiteRepository) MoveNode(ctx context.Context, id int64, parentID *int64, pos MovePosition) error {
+	return r.moveNode(ctx, id, parentID, pos, nil)
+}
+
+// MoveNodeIfVersion moves a node if it is at the given version
+func (r *SQLiteRepository) MoveNodeIfVersion(ctx context.Context, id int64, version int64, parentID *int64, pos MovePosition) error {
+	return r.moveNode(ctx, id, parentID, pos, &version)
+}
+
+// moveNode moves a node, first checking its version if version is set
+func (r *SQLiteRepository) moveNode(ctx context.Context, id int64, parentID *int64, pos MovePosition, version *int64) error {
+	return r.inTransaction(ctx, func(tx *sqliteTx) error {
+		return r.moveNodeTx(ctx, tx, id, parentID, pos, version)
+	})
+}
+
+// moveNodeTx moves a node within tx
+func (r *SQLiteRepository) moveNodeTx(ctx context.Context, tx *sqliteTx, id int64, parentID *int64, pos MovePosition, version *int64) error {
+	if err := validateMove(parentID, pos); err != nil {
+		return err
+	}
+
+	if err := r.checkParent(ctx, tx, id, parentID); err != nil {
+		return err
+	}
+
+	old, err := r.lockNode(ctx, tx, id, version)
+	if err != nil {
+		return err
+	}
+
+	// Roots are ordered by ID, so only children need a rank
+	position := float64(1)
+	if parentID != nil {
+		siblings, err := r.querySiblings(ctx, tx, *parentID, id)
+		if err != nil {
+			return err
+		}
+		p, renumbered, err := rankAmong(siblings, pos)
+		if err != nil {
+			return err
+		}
+		for siblingID, siblingPosition := range renumbered {
+			_, err := tx.ExecContext(ctx,
+				"UPDATE nodes SET position = ?1, version = version + 1, updated_at = ?3 WHERE id = ?2",
+				siblingPosition, siblingID, tx.now,
+			)
+			if err != nil {
+				return fmt.Errorf("error renumbering siblings: %w", err)
+			}
+		}
+		position = p
+	}
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE nodes SET parent_id = ?1, position = ?2, version = version + 1, updated_at = ?4 WHERE id = ?3",
+		parentID, position, id, tx.now,
+	)
+	if err != nil {
+		return fmt.Errorf("error moving node: %w", err)
+	}
+	return r.recordHistory(ctx, tx, HistoryMove, "?3", "?4", "id = ?5", old.label, old.parentID, id)
+}
+
+// querySiblings returns the children of parentID other than excludeID,
+// ordered by position and then ID
+func (r *SQLiteRepository) querySiblings(ctx context.Context, tx *sqliteTx, parentID, excludeID int64) ([]sibling, error) {
+	rows, err := tx.QueryContext(ctx, `
+		SELECT id, position FROM nodes
+		WHERE parent_id = ?1 AND id <> ?2 AND deleted_at IS NULL
+		ORDER BY position, id
+	`, parentID, excludeID)
+	if err != nil {
+		return nil, fmt.Errorf("error getting siblings: %w", err)
+	}
+	return scanSiblings(rows)
+}
+
+// DeleteNode deletes a node, handling its children according to mode
+func (r *SQLiteRepository) DeleteNode(ctx context.Context, id int64, mode DeleteMode) (int64, error) {
+	return r.deleteNode(ctx, id, mode, nil)
+}
+
+// DeleteNodeIfVersion deletes a node if it is at the given version
+func (r *SQLiteRepository) DeleteNodeIfVersion(ctx context.Context, id int64, version int64, mode DeleteMode) (int64, error) {
+	return r.deleteNode(ctx, id, mode, &version)
+}
+
+// deleteNode deletes a node, first checking its version if version is set
+func (r *SQLiteRepository) deleteNode(ctx context.Context, id int64, mode DeleteMode, version *int64) (int64, error) {
+	var deleted int64
+	err := r.inTransaction(ctx, func(tx *sqliteTx) error {
+		var err error
+		deleted, err = r.deleteNodeTx(ctx, tx, id, mode, version)
+		return err
+	})
+	return deleted, err
+}
+
+// deleteNodeTx deletes a node within tx
+func (r *SQLiteRepository) deleteNodeTx(ctx context.Context, tx *sqliteTx, id int64, mode DeleteMode, version *int64) (int64, error) {
+	if !mode.Valid() {
+		return 0, ErrInvalidInput
+	}
+
+	old, err := r.lockNode(ctx, tx, id, version)
+	if err != nil {
+		return 0, err
+	}
+
+	switch mode {
+	case DeleteModeRefuse:
+		var hasChildren bool
+		err = tx.QueryRowContext(ctx,
+			"SELECT EXISTS(SELECT 1 FROM nodes WHERE parent_id = ?1 AND deleted_at IS NULL)",
+			id,
+		).Scan(&hasChildren)
+		if err != nil {
+			return 0, fmt.Errorf("error checking for children: %w", err)
+		}
+		if hasChildren {
+			return 0, ErrNodeHasChildren
+		}
+	case DeleteModeReparent:
+		if err := r.reparentChildren(ctx, tx, id, old.parentID); err != nil {
+			return 0, err
+		}
+	}
+
+	// Move the node and its remaining live descendants to the trash, all
+	// with the transaction's time; nodes trashed earlier keep their own.
+	// Their history records them as they were before the delete.
+	subtree := sqliteSubtree("SELECT ?3")
+	err = r.recordHistory(ctx, tx, HistoryDelete, "label", "parent_id",
+		"deleted_at IS NULL AND id IN ("+subtree+")", id)
+	if err != nil {
+		return 0, err
+	}
+	result, err := tx.ExecContext(ctx, `
+		UPDATE nodes SET deleted_at = ?1, version = version + 1, updated_at = ?1
+		WHERE deleted_at IS NULL AND id IN (`+sqliteSubtree("SELECT ?2")+`)
+	`, tx.now, id)
+	if err != nil {
+		return 0, fmt.Errorf("error deleting node: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if deleted == 0 {
+		return 0, ErrNodeNotFound
+	}
+	return deleted, nil
+}
+
+// reparentChildren moves the live children of node id to newParent, after
+// their new siblings and keeping their order, or makes them roots
+func (r *SQLiteRepository) reparentChildren(ctx context.Context, tx *sqliteTx, id int64, newParent *int64) error {
+	children, err := r.querySiblings(ctx, tx, id, 0)
+	if err != nil {
+		return err
+	}
+
+	next := float64(1)
+	if newParent != nil {
+		if err := tx.QueryRowContext(ctx, "SELECT "+nextPosition("?1"), newParent).Scan(&next); err != nil {
+			return fmt.Errorf("error getting position: %w", err)
+		}
+	}
+
+	for i, child := range children {
+		position := float64(1)
+		if newParent != nil {
+			position = next + float64(i)
+		}
+		_, err := tx.ExecContext(ctx,
+			"UPDATE nodes SET parent_id = ?1, position = ?2, version = version + 1, updated_at = ?4 WHERE id = ?3",
+			newParent, position, child.id, tx.now,
+		)
+		if err != nil {
+			return fmt.Errorf("error reparenting child nodes: %w", err)
+		}
+		if err := r.recordHistory(ctx, tx, HistoryMove, "label", "?3", "id = ?4", id, child.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyBatch applies the operations of a batch in order in a single
+// transaction. Soft deletes of the batch share the transaction's time, so a
+// node deleted before its ancestor is restored with it.
+func (r *SQLiteRepository) ApplyBatch(ctx context.Context, ops []*BatchOp) ([]*BatchResult, error) {
+	var results []*BatchResult
+	err := r.inTransaction(ctx, func(tx *sqliteTx) error {
+		var err error
+		results, err = applyBatch(ctx, &sqliteBatch{r: r, tx: tx}, ops)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+// sqliteBatch applies the operations of a batch within one transaction
+type sqliteBatch struct {
+	r  *SQLiteRepository
+	tx *sqliteTx
+}
+
+func (b *sqliteBatch) createNode(ctx context.Context, label string, parentID *int64, attributes map[string]interface{}) (int64, error) {
+	return b.r.createNode(ctx, b.tx, label, parentID, attributes)
+}
+
+func (b *sqliteBatch) patchNode(ctx context.Context, id int64, patch NodePatch, version *int64) error {
+	return b.r.patchNodeTx(ctx, b.tx, id, patch, version)
+}
+
+func (b *sqliteBatch) moveNode(ctx context.Context, id int64, parentID *int64, pos MovePosition, version *int64) error {
+	return b.r.moveNodeTx(ctx, b.tx, id, parentID, pos, version)
+}
+
+func (b *sqliteBatch) deleteNode(ctx context.Context, id int64, mode DeleteMode, version *int64) (int64, error) {
+	return b.r.deleteNodeTx(ctx, b.tx, id, mode, version)
+}
+
+func (b *sqliteBatch) parentOf(ctx context.Context, id int64) (*int64, error) {
+	old, err := b.r.lockNode(ctx, b.tx, id, nil)
+	if err != nil {
+		return nil, err
+	}
+	return old.parentID, nil
+}
+
+// sqliteTrashEntryCondition selects the trash entries of the tree bound to
+// ?1 from nodes aliased as n: trashed nodes that weren't deleted with their
+// parent
+const sqliteTrashEntryCondition = `n.tree_id = ?1 AND n.deleted_at IS NOT NULL AND NOT EXISTS (
+		SELECT 1 FROM nodes p WHERE p.id = n.parent_id AND p.deleted_at = n.deleted_at
+	)`
+
+// ListTrash retrieves a page of trash entries, most recently deleted first
+func (r *SQLiteRepository) ListTrash(ctx context.Context, page, pageSize int) ([]*Node, int64, error) {
+	var total int64
+	err := r.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM nodes n WHERE "+sqliteTrashEntryCondition,
+		r.treeID,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting total count: %w", err)
+	}
+
+	offset := (page - 1) * pageSize
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+qualifiedNodeColumns+` FROM nodes n
+		WHERE `+sqliteTrashEntryCondition+`
+		ORDER BY n.deleted_at DESC, n.id
+		LIMIT ?2 OFFSET ?3
+	`, r.treeID, pageSize, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error listing trash: %w", err)
+	}
+
+	nodes, err := scanNodes(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	if nodes == nil {
+		nodes = []*Node{}
+	}
+	return nodes, total, nil
+}
+
+// RestoreNode moves a trashed node and the descendants deleted with it back
+// into the tree
+func (r *SQLiteRepository) RestoreNode(ctx context.Context, id int64) (int64, error) {
+	var restored int64
+	err := r.inTransaction(ctx, func(tx *sqliteTx) error {
+		var parentID sql.NullInt64
+		err := tx.QueryRowContext(ctx,
+			"SELECT parent_id FROM nodes WHERE id = ?1 AND tree_id = ?2 AND deleted_at IS NOT NULL",
+			id, r.treeID,
+		).Scan(&parentID)
+		if err != nil {
+			if err == sql.ErrNoRows {
+				return ErrNodeNotFound
+			}
+			return fmt.Errorf("error getting node: %w", err)
+		}
+
+		if parentID.Valid {
+			var parentDeleted bool
+			err = tx.QueryRowContext(ctx,
+				"SELECT deleted_at IS NOT NULL FROM nodes WHERE id = ?1",
+				parentID.Int64,
+			).Scan(&parentDeleted)
+			if err != nil {
+				return fmt.Errorf("error checking parent node: %w", err)
+			}
+			if parentDeleted {
+				return ErrParentDeleted
+			}
+		}
+
+		// Descendants deleted separately have another deletion time and stay
+		// in the trash
+		batch := func(param string) string {
+			return `
+				WITH RECURSIVE batch AS (
+					SELECT id, deleted_at FROM nodes WHERE id = ` + param + `
+					UNION ALL
+					SELECT n.id, n.deleted_at FROM nodes n
+					INNER JOIN batch b ON n.parent_id = b.id
+					WHERE n.deleted_at = b.deleted_at
+				)
+				SELECT id FROM batch`
+		}
+		rows, err := tx.QueryContext(ctx, batch("?1"), id)
+		if err != nil {
+			return fmt.Errorf("error getting restored nodes: %w", err)
+		}
+		ids, err := scanIDs(rows)
+		if err != nil {
+			return err
+		}
+
+		for _, restoredID := range ids {
+			_, err := tx.ExecContext(ctx,
+				"UPDATE nodes SET deleted_at = NULL, version = version + 1, updated_at = ?2 WHERE id = ?1",
+				restoredID, tx.now,
+			)
+			if err != nil {
+				return fmt.Errorf("error restoring node: %w", err)
+			}
+			if err := r.recordHistory(ctx, tx, HistoryRestore, "NULL", "NULL", "id = ?3", restoredID); err != nil {
+				return err
+			}
+		}
+		restored = int64(len(ids))
+		return nil
+	})
+	return restored, err
+}
+
+// PurgeNode permanently deletes a trashed node and its descendants
+func (r *SQLiteRepository) PurgeNode(ctx context.Context, id int64) (int64, error) {
+	// Only trashed nodes can be purged, and every descendant of a trashed
+	// node is trashed too
+	result, err := r.db.ExecContext(ctx,
+		"DELETE FROM nodes WHERE id IN ("+sqliteSubtree(
+			"SELECT id FROM nodes WHERE id = ?1 AND tree_id = ?2 AND deleted_at IS NOT NULL",
+		)+")",
+		id, r.treeID,
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error purging node: %w", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if purged == 0 {
+		return 0, ErrNodeNotFound
+	}
+	return purged, nil
+}
+
+// PurgeTrash permanently deletes the trash entries deleted before the given time
+func (r *SQLiteRepository) PurgeTrash(ctx context.Context, before time.Time) (int64, error) {
+	// Expired entries can be nested, which the subtree's UNION doesn't mind
+	result, err := r.db.ExecContext(ctx,
+		"DELETE FROM nodes WHERE id IN ("+sqliteSubtree(
+			"SELECT n.id FROM nodes n WHERE "+sqliteTrashEntryCondition+" AND n.deleted_at < ?2",
+		)+")",
+		r.treeID, formatSQLiteTime(before),
+	)
+	if err != nil {
+		return 0, fmt.Errorf("error purging trash: %w", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error getting rows affected: %w", err)
+	}
+	return purged, nil
+}
+
+// GetNodeHistory retrieves a page of a node's history, most recent first
+func (r *SQLiteRepository) GetNodeHistory(ctx context.Context, id int64, page, pageSize int) ([]*HistoryEntry, int64, error) {
+	var total int64
+	err := r.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM node_history WHERE node_id = ?1 AND tree_id = ?2",
+		id, r.treeID,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting total count: %w", err)
+	}
+	// Nodes created before history was recorded have none yet
+	if total == 0 {
+		var exists bool
+		err := r.db.QueryRowContext(ctx,
+			"SELECT EXISTS(SELECT 1 FROM nodes WHERE id = ?1 AND tree_id = ?2)",
+			id, r.treeID,
+		).Scan(&exists)
+		if err != nil {
+			return nil, 0, fmt.Errorf("error checking node: %w", err)
+		}
+		if !exists {
+			return nil, 0, ErrNodeNotFound
+		}
+		return []*HistoryEntry{}, 0, nil
+	}
+
+	offset := (page - 1) * pageSize
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+historyColumns+` FROM node_history
+		WHERE node_id = ?1 AND tree_id = ?2
+		ORDER BY id DESC
+		LIMIT ?3 OFFSET ?4
+	`, id, r.treeID, pageSize, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting node history: %w", err)
+	}
+
+	entries, err := scanHistoryEntries(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	if entries == nil {
+		entries = []*HistoryEntry{}
+	}
+	return entries, total, nil
+}
+
+// sqliteHistoricalNodesCTEs defines the CTE historical like
+// historicalNodesCTEs, for the tree bound to ?1 at the time bound to ?2.
+// Window functions stand in for DISTINCT ON.
+const sqliteHistoricalNodesCTEs = `
+	last_change AS (
+		SELECT node_id, action, new_label, new_parent_id, position, attributes, changed_at FROM (
+			SELECT *, ROW_NUMBER() OVER (PARTITION BY node_id ORDER BY id DESC) AS rank
+			FROM node_history
+			WHERE tree_id = ?1 AND changed_at <= ?2
+		) WHERE rank = 1
+	), first_change AS (
+		SELECT node_id, action, old_label, old_parent_id, position, attributes, changed_at FROM (
+			SELECT *, ROW_NUMBER() OVER (PARTITION BY node_id ORDER BY id) AS rank
+			FROM node_history
+			WHERE tree_id = ?1
+		) WHERE rank = 1
+	), historical AS (
+		SELECT l.node_id AS id, l.new_label AS label, l.new_parent_id AS parent_id, l.position, l.attributes,
+			COALESCE(n.created_at, f.changed_at) AS created_at, l.changed_at AS updated_at
+		FROM last_change l
+		INNER JOIN first_change f ON f.node_id = l.node_id
+		LEFT JOIN nodes n ON n.id = l.node_id
+		WHERE l.action <> 'delete'
+		UNION ALL
+		-- Old labels are only missing for creates and restores, i.e. nodes
+		-- that didn't exist or were in the trash at the time
+		SELECT f.node_id, f.old_label, f.old_parent_id, f.position, f.attributes,
+			COALESCE(n.created_at, f.changed_at), COALESCE(n.created_at, f.changed_at)
+		FROM first_change f
+		LEFT JOIN nodes n ON n.id = f.node_id
+		WHERE f.changed_at > ?2 AND f.old_label IS NOT NULL
+		UNION ALL
+		SELECT n.id, n.label, n.parent_id, n.position, n.attributes, n.created_at, n.updated_at
+		FROM nodes n
+		WHERE n.tree_id = ?1 AND n.created_at <= ?2 AND (n.deleted_at IS NULL OR n.deleted_at > ?2)
+			AND NOT EXISTS (SELECT 1 FROM node_history h WHERE h.node_id = n.id)
+	)`
+
+// sqliteHistoricalNodeColumns selects nodeColumns from historical
+const sqliteHistoricalNodeColumns = "id, ?1 AS tree_id, label, parent_id, position, attributes, created_at, updated_at, 0 AS version, NULL AS deleted_at"
+
+// GetAllNodesAsOf retrieves a page of root nodes with their complete
+// subtrees as they were at asOf
+func (r *SQLiteRepository) GetAllNodesAsOf(ctx context.Context, asOf time.Time, page, pageSize int) ([]*Node, int64, error) {
+	at := formatSQLiteTime(asOf)
+	var total int64
+	err := r.db.QueryRowContext(ctx,
+		"WITH "+sqliteHistoricalNodesCTEs+" SELECT COUNT(*) FROM historical WHERE parent_id IS NULL",
+		r.treeID, at,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting total count: %w", err)
+	}
+
+	offset := (page - 1) * pageSize
+	rows, err := r.db.QueryContext(ctx, `
+		WITH RECURSIVE `+sqliteHistoricalNodesCTEs+`, page_roots AS (
+			SELECT id FROM historical
+			WHERE parent_id IS NULL
+			ORDER BY id
+			LIMIT ?3 OFFSET ?4
+		), subtree AS (
+			SELECT h.* FROM historical h
+			INNER JOIN page_roots p ON h.id = p.id
+			UNION ALL
+			SELECT h.* FROM historical h
+			INNER JOIN subtree s ON h.parent_id = s.id
+		)
+		SELECT `+sqliteHistoricalNodeColumns+` FROM subtree ORDER BY id
+	`, r.treeID, at, pageSize, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting nodes: %w", err)
+	}
+
+	nodes, err := scanNodes(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	return nodes, total, nil
+}
+
+// GetSubtreeAsOf retrieves a node and its descendants down to maxDepth
+// levels as they were at asOf
+func (r *SQLiteRepository) GetSubtreeAsOf(ctx context.Context, id int64, maxDepth int, asOf time.Time) ([]*Node, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		WITH RECURSIVE `+sqliteHistoricalNodesCTEs+`, subtree AS (
+			SELECT h.*, 0 AS depth FROM historical h WHERE h.id = ?3
+			UNION ALL
+			SELECT h.*, s.depth + 1 FROM historical h
+			INNER JOIN subtree s ON h.parent_id = s.id
+			WHERE ?4 < 0 OR s.depth < ?4
+		)
+		SELECT `+sqliteHistoricalNodeColumns+` FROM subtree ORDER BY depth, id
+	`, r.treeID, formatSQLiteTime(asOf), id, maxDepth)
+	if err != nil {
+		return nil, fmt.Errorf("error getting subtree: %w", err)
+	}
+
+	nodes, err := scanNodes(rows)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 0 {
+		return nil, ErrNodeNotFound
+	}
+	return nodes, nil
+}
+
+// CreateWebhook creates a webhook for the repository's tree
+func (r *SQLiteRepository) CreateWebhook(ctx context.Context, webhook *Webhook) (*Webhook, error) {
+	if webhook.URL == "" || webhook.Secret == "" {
+		return nil, ErrInvalidInput
+	}
+
+	eventTypes, err := marshalEventTypes(webhook.EventTypes)
+	if err != nil {
+		return nil, err
+	}
+	now := formatSQLiteTime(time.Now())
+	created, err := scanSQLiteWebhook(r.db.QueryRowContext(ctx, `
+		INSERT INTO webhooks (tree_id, url, secret, event_types, active, created_at, updated_at)
+		VALUES (?1, ?2, ?3, ?4, ?5, ?6, ?6)
+		RETURNING `+webhookColumns,
+		r.treeID, webhook.URL, webhook.Secret, eventTypes, webhook.Active, now,
+	))
+	if err != nil {
+		return nil, fmt.Errorf("error creating webhook: %w", err)
+	}
+	return created, nil
+}
+
+// GetWebhook retrieves a webhook of the repository's tree by ID
+func (r *SQLiteRepository) GetWebhook(ctx context.Context, id int64) (*Webhook, error) {
+	webhook, err := scanSQLiteWebhook(r.db.QueryRowContext(ctx,
+		"SELECT "+webhookColumns+" FROM webhooks WHERE id = ?1 AND tree_id = ?2",
+		id, r.treeID,
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, fmt.Errorf("error getting webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+// ListWebhooks retrieves the webhooks of the repository's tree ordered by ID
+func (r *SQLiteRepository) ListWebhooks(ctx context.Context) ([]*Webhook, error) {
+	rows, err := r.db.QueryContext(ctx,
+		"SELECT "+webhookColumns+" FROM webhooks WHERE tree_id = ?1 ORDER BY id",
+		r.treeID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error listing webhooks: %w", err)
+	}
+	defer func() {
+		if err := rows.Close(); err != nil {
+			fmt.Printf("Warning: Error closing rows: %v\n", err)
+		}
+	}()
+
+	webhooks := []*Webhook{}
+	for rows.Next() {
+		webhook, err := scanSQLiteWebhook(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning webhook: %w", err)
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webhooks: %w", err)
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook applies a partial update to a webhook
+func (r *SQLiteRepository) UpdateWebhook(ctx context.Context, id int64, patch WebhookPatch) (*Webhook, error) {
+	if (patch.URL != nil && *patch.URL == "") || (patch.Secret != nil && *patch.Secret == "") {
+		return nil, ErrInvalidInput
+	}
+
+	eventTypes, err := marshalEventTypes(patch.EventTypes)
+	if err != nil {
+		return nil, err
+	}
+	webhook, err := scanSQLiteWebhook(r.db.QueryRowContext(ctx, `
+		UPDATE webhooks SET
+			url = COALESCE(?3, url),
+			secret = COALESCE(?4, secret),
+			event_types = CASE WHEN ?5 THEN ?6 ELSE event_types END,
+			active = COALESCE(?7, active),
+			updated_at = ?8
+		WHERE id = ?1 AND tree_id = ?2
+		RETURNING `+webhookColumns,
+		id, r.treeID, patch.URL, patch.Secret, patch.SetEventTypes, eventTypes, patch.Active, formatSQLiteTime(time.Now()),
+	))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrWebhookNotFound
+		}
+		return nil, fmt.Errorf("error updating webhook: %w", err)
+	}
+	return webhook, nil
+}
+
+// DeleteWebhook deletes a webhook; its deliveries are removed by the
+// foreign key cascade
+func (r *SQLiteRepository) DeleteWebhook(ctx context.Context, id int64) error {
+	result, err := r.db.ExecContext(ctx,
+		"DELETE FROM webhooks WHERE id = ?1 AND tree_id = ?2",
+		id, r.treeID,
+	)
+	if err != nil {
+		return fmt.Errorf("error deleting webhook: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if deleted == 0 {
+		return ErrWebhookNotFound
+	}
+	return nil
+}
+
+// ClaimWebhookDeliveries reserves the due pending deliveries of the
+// repository's tree for lease. Operations run one at a time, so a single
+// UPDATE is enough to keep concurrent workers from claiming the same ones.
+func (r *SQLiteRepository) ClaimWebhookDeliveries(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]*WebhookDelivery, error) {
+	rows, err := r.db.QueryContext(ctx, `
+		UPDATE webhook_deliveries SET next_attempt_at = ?3
+		WHERE id IN (
+			SELECT d.id FROM webhook_deliveries d
+			INNER JOIN webhooks w ON w.id = d.webhook_id
+			WHERE d.tree_id = ?1 AND d.status = 'pending' AND d.next_attempt_at <= ?2 AND w.active
+			ORDER BY d.id
+			LIMIT ?4
+		)
+		RETURNING `+deliveryColumns,
+		r.treeID, formatSQLiteTime(now), formatSQLiteTime(now.Add(lease)), limit,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error claiming webhook deliveries: %w", err)
+	}
+
+	deliveries, err := scanDeliveries(rows)
+	if err != nil {
+		return nil, err
+	}
+	// RETURNING doesn't preserve the subquery's order
+	sort.Slice(deliveries, func(i, j int) bool {
+		return deliveries[i].ID < deliveries[j].ID
+	})
+	return deliveries, nil
+}
+
+// RecordWebhookAttempt records the outcome of a delivery attempt
+func (r *SQLiteRepository) RecordWebhookAttempt(ctx context.Context, id int64, attempt DeliveryAttempt) error {
+	result, err := r.db.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET
+			status = ?3,
+			attempts = attempts + 1,
+			last_attempt_at = ?4,
+			response_status = NULLIF(?5, 0),
+			last_error = NULLIF(?6, ''),
+			next_attempt_at = CASE WHEN ?3 = 'pending' THEN ?7 ELSE next_attempt_at END
+		WHERE id = ?1 AND tree_id = ?2
+	`, id, r.treeID, string(attempt.Status), formatSQLiteTime(attempt.AttemptedAt), attempt.ResponseStatus,
+		attempt.Error, formatSQLiteTime(attempt.NextAttemptAt))
+	if err != nil {
+		return fmt.Errorf("error recording webhook attempt: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error getting rows affected: %w", err)
+	}
+	if updated == 0 {
+		return ErrDeliveryNotFound
+	}
+	return nil
+}
+
+// ListWebhookDeliveries retrieves a page of a webhook's deliveries, most
+// recent first
+func (r *SQLiteRepository) ListWebhookDeliveries(ctx context.Context, webhookID int64, page, pageSize int) ([]*WebhookDelivery, int64, error) {
+	var exists bool
+	err := r.db.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM webhooks WHERE id = ?1 AND tree_id = ?2)",
+		webhookID, r.treeID,
+	).Scan(&exists)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error checking webhook: %w", err)
+	}
+	if !exists {
+		return nil, 0, ErrWebhookNotFound
+	}
+
+	var total int64
+	err = r.db.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM webhook_deliveries WHERE webhook_id = ?1",
+		webhookID,
+	).Scan(&total)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting total count: %w", err)
+	}
+
+	offset := (page - 1) * pageSize
+	rows, err := r.db.QueryContext(ctx, `
+		SELECT `+deliveryColumns+` FROM webhook_deliveries
+		WHERE webhook_id = ?1
+		ORDER BY id DESC
+		LIMIT ?2 OFFSET ?3
+	`, webhookID, pageSize, offset)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting webhook deliveries: %w", err)
+	}
+
+	deliveries, err := scanDeliveries(rows)
+	if err != nil {
+		return nil, 0, err
+	}
+	if deliveries == nil {
+		deliveries = []*WebhookDelivery{}
+	}
+	return deliveries, total, nil
+}
+
+// sqliteTx is a transaction together with its start time in the format
+// times are stored in. Every change made in the transaction is stamped with
+// that time, like now() in PostgreSQL.
+type sqliteTx struct {
+	*sql.Tx
+	now string
+}
+
+// inTransaction runs fn in a transaction, committing it if fn succeeds and
+// rolling it back otherwise
+func (r *SQLiteRepository) inTransaction(ctx context.Context, fn func(tx *sqliteTx) error) error {
+	tx, err := r.db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error beginning transaction: %w", err)
+	}
+	defer func() {
+		if err := tx.Rollback(); err != nil && err != sql.ErrTxDone {
+			// Log the error but don't return it since we're in a defer
+			fmt.Printf("Error rolling back transaction: %v\n", err)
+		}
+	}()
+
+	if err := fn(&sqliteTx{Tx: tx, now: formatSQLiteTime(time.Now())}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// recordHistory records a history entry for every node matched by where,
+// taking the node's state after the change from the node itself; deletes
+// record no state after the change. oldLabel and oldParent are SQL
+// expressions for the node's label and parent before the change. ?1 and ?2
+// are bound to the actor and the time, so where and the expressions number
+// their own parameters, args, from ?3. The entries are queued for the
+// tree's webhooks in the same transaction.
+func (r *SQLiteRepository) recordHistory(ctx context.Context, tx *sqliteTx, action HistoryAction, oldLabel, oldParent, where string, args ...interface{}) error {
+	newLabel, newParent := "label", "parent_id"
+	if action == HistoryDelete {
+		newLabel, newParent = "NULL", "NULL"
+	}
+	rows, err := tx.QueryContext(ctx, `
+		INSERT INTO node_history (node_id, tree_id, action, old_label, new_label, old_parent_id, new_parent_id, position, attributes, actor, changed_at)
+		SELECT id, tree_id, '`+string(action)+`', `+oldLabel+`, `+newLabel+`, `+oldParent+`, `+newParent+`, position, attributes, NULLIF(?1, ''), ?2
+		FROM nodes WHERE `+where+`
+		RETURNING id`,
+		append([]interface{}{ActorFromContext(ctx), tx.now}, args...)...,
+	)
+	if err != nil {
+		return fmt.Errorf("error recording history: %w", err)
+	}
+	historyIDs, err := scanIDs(rows)
+	if err != nil {
+		return err
+	}
+	return r.enqueueWebhooks(ctx, tx, historyIDs)
+}
+
+// enqueueWebhooks queues the history entries historyIDs for the subscribed
+// active webhooks of the repository's tree, in webhook order so deliveries
+// are claimed deterministically
+func (r *SQLiteRepository) enqueueWebhooks(ctx context.Context, tx *sqliteTx, historyIDs []int64) error {
+	var subscribed bool
+	err := tx.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM webhooks WHERE tree_id = ?1 AND active)",
+		r.treeID,
+	).Scan(&subscribed)
+	if err != nil {
+		return fmt.Errorf("error checking webhooks: %w", err)
+	}
+	if !subscribed {
+		return nil
+	}
+
+	for _, historyID := range historyIDs {
+		rows, err := tx.QueryContext(ctx, "SELECT "+historyColumns+" FROM node_history WHERE id = ?1", historyID)
+		if err != nil {
+			return fmt.Errorf("error getting history entry: %w", err)
+		}
+		entries, err := scanHistoryEntries(rows)
+		if err != nil {
+			return err
+		}
+		entry := entries[0]
+
+		node, err := scanNode(tx.QueryRowContext(ctx, "SELECT "+nodeColumns+" FROM nodes WHERE id = ?1", entry.NodeID))
+		if err != nil {
+			return fmt.Errorf("error getting node: %w", err)
+		}
+		path, err := r.nodePath(ctx, tx, entry.NodeID)
+		if err != nil {
+			return err
+		}
+		var oldParentPath []int64
+		if entry.OldParentID != nil {
+			if oldParentPath, err = r.nodePath(ctx, tx, *entry.OldParentID); err != nil {
+				return err
+			}
+		}
+		payload, err := webhookPayload(entry, node, path, oldParentPath)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO webhook_deliveries (webhook_id, tree_id, event_type, payload, next_attempt_at, created_at)
+			SELECT id, tree_id, ?2, ?3, ?4, ?4 FROM webhooks w
+			WHERE tree_id = ?1 AND active AND (
+				json_array_length(event_types) = 0
+				OR EXISTS (SELECT 1 FROM json_each(w.event_types) WHERE value = ?2)
+			)
+			ORDER BY id
+		`, r.treeID, webhookEventTypes[entry.Action], string(payload), tx.now)
+		if err != nil {
+			return fmt.Errorf("error queueing webhook deliveries: %w", err)
+		}
+	}
+	return nil
+}
+
+// nodePath returns the IDs from the root of node id's tree down to the
+// node, whether or not they are in the trash
+func (r *SQLiteRepository) nodePath(ctx context.Context, tx *sqliteTx, id int64) ([]int64, error) {
+	rows, err := tx.QueryContext(ctx, `
+		WITH RECURSIVE ancestors(id, parent_id, depth) AS (
+			SELECT id, parent_id, 0 FROM nodes WHERE id = ?1
+			UNION ALL
+			SELECT n.id, n.parent_id, a.depth + 1 FROM nodes n
+			INNER JOIN ancestors a ON n.id = a.parent_id
+		)
+		SELECT id FROM ancestors ORDER BY depth DESC
+	`, id)
+	if err != nil {
+		return nil, fmt.Errorf("error getting node path: %w", err)
+	}
+	return scanIDs(rows)
+}
+
+// lockNode returns the label and parent of live node id, which the node's
+// next history entry records as the old values, first checking that the
+// node is at version if version is set. The transaction holds the
+// database's only connection, so the node can't change until it ends.
+func (r *SQLiteRepository) lockNode(ctx context.Context, tx *sqliteTx, id int64, version *int64) (*lockedNode, error) {
+	var node lockedNode
+	var parentID sql.NullInt64
+	var current int64
+	err := tx.QueryRowContext(ctx,
+		"SELECT label, parent_id, version FROM nodes WHERE id = ?1 AND tree_id = ?2 AND deleted_at IS NULL",
+		id, r.treeID,
+	).Scan(&node.label, &parentID, &current)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, ErrNodeNotFound
+		}
+		return nil, fmt.Errorf("error getting node: %w", err)
+	}
+	if version != nil && current != *version {
+		return nil, ErrVersionConflict
+	}
+	if parentID.Valid {
+		node.parentID = &parentID.Int64
+	}
+	return &node, nil
+}
+
+// checkParent verifies inside tx that node id exists in the repository's
+// tree and that parentID, if set, exists in the same tree and is neither id
+// nor one of its descendants
+func (r *SQLiteRepository) checkParent(ctx context.Context, tx *sqliteTx, id int64, parentID *int64) error {
+	var exists bool
+	err := tx.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM nodes WHERE id = ?1 AND tree_id = ?2 AND deleted_at IS NULL)",
+		id, r.treeID,
+	).Scan(&exists)
+	if err != nil {
+		return fmt.Errorf("error checking node: %w", err)
+	}
+	if !exists {
+		return ErrNodeNotFound
+	}
+
+	if parentID == nil {
+		return nil
+	}
+	if err := r.checkParentExists(ctx, tx, *parentID); err != nil {
+		return err
+	}
+
+	// The node would become its own ancestor if the new parent is in its
+	// subtree
+	var cycle bool
+	err = tx.QueryRowContext(ctx,
+		"SELECT ?1 IN ("+sqliteSubtree("SELECT ?2")+")",
+		*parentID, id,
+	).Scan(&cycle)
+	if err != nil {
+		return fmt.Errorf("error checking for cycles: %w", err)
+	}
+	if cycle {
+		return ErrCycleDetected
+	}
+	return nil
+}
+
+// checkParentExists verifies inside tx that parentID is a live node of the
+// repository's tree
+func (r *SQLiteRepository) checkParentExists(ctx context.Context, tx *sqliteTx, parentID int64) error {
+	var parentTree int64
+	err := tx.QueryRowContext(ctx,
+		"SELECT tree_id FROM nodes WHERE id = ?1 AND deleted_at IS NULL",
+		parentID,
+	).Scan(&parentTree)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return ErrNodeNotFound
+		}
+		return fmt.Errorf("error checking parent node: %w", err)
+	}
+	if parentTree != r.treeID {
+		return ErrCrossTreeParent
+	}
+	return nil
+}
+
+// sqliteSubtree returns a query selecting the IDs of the nodes selected by
+// roots, a query selecting a single column of node IDs, and of all of their
+// descendants
+func sqliteSubtree(roots string) string {
+	return `
+		WITH RECURSIVE subtree(id) AS (
+			` + roots + `
+			UNION
+			SELECT n.id FROM nodes n
+			INNER JOIN subtree s ON n.parent_id = s.id
+		)
+		SELECT id FROM subtree`
+}
+
+// sqliteTimeFormat is how SQLiteRepository stores times: fixed-width UTC
+// text, so comparing and sorting the text orders the times chronologically.
+// storedTime parses it back.
+const sqliteTimeFormat = "2006-01-02 15:04:05.000000000"
+
+// formatSQLiteTime formats t for storing in SQLite
+func formatSQLiteTime(t time.Time) string {
+	return t.UTC().Format(sqliteTimeFormat)
+}
+
+// scanSQLiteWebhook scans a single row selected with webhookColumns
+func scanSQLiteWebhook(row rowScanner) (*Webhook, error) {
+	var webhook Webhook
+	var eventTypes string
+	err := row.Scan(&webhook.ID, &webhook.TreeID, &webhook.URL, &webhook.Secret,
+		&eventTypes, &webhook.Active, storedTime{&webhook.CreatedAt}, storedTime{&webhook.UpdatedAt})
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(eventTypes), &webhook.EventTypes); err != nil {
+		return nil, fmt.Errorf("error decoding event types: %w", err)
+	}
+	if len(webhook.EventTypes) == 0 {
+		webhook.EventTypes = nil
+	}
+	return &webhook, nil
+}
+
+// marshalEventTypes encodes webhook event types as a JSON array, using an
+// empty array rather than null when there are none
+func marshalEventTypes(eventTypes []string) (string, error) {
+	if eventTypes == nil {
+		eventTypes = []string{}
+	}
+	encoded, err := json.Marshal(eventTypes)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidInput, err)
+	}
+	return string(encoded), nil
+}
@@ -0,0 +1,159 @@
+package repository
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DeliveryStatus is the state of a webhook delivery
+type DeliveryStatus string
+
+const (
+	// DeliveryPending is a delivery waiting for its first or next attempt
+	DeliveryPending DeliveryStatus = "pending"
+	// DeliverySucceeded is a delivery the receiver accepted
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	// DeliveryDead is a delivery that failed too many times and won't be
+	// attempted again
+	DeliveryDead DeliveryStatus = "dead"
+)
+
+// Webhook is a subscription to the node changes of a tree
+type Webhook struct {
+	ID         int64     // Unique identifier for the webhook
+	TreeID     int64     // ID of the tree whose changes are delivered
+	URL        string    // URL the changes are POSTed to
+	Secret     string    // Key the payloads are signed with
+	EventTypes []string  // Event types delivered; empty delivers every type
+	Active     bool      // Whether new changes are delivered
+	CreatedAt  time.Time // Time the webhook was created
+	UpdatedAt  time.Time // Time the webhook was last modified
+}
+
+// Subscribes reports whether the webhook receives events of eventType
+func (w *Webhook) Subscribes(eventType string) bool {
+	if len(w.EventTypes) == 0 {
+		return true
+	}
+	for _, t := range w.EventTypes {
+		if t == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// WebhookPatch describes a partial update of a webhook. Nil fields are left
+// unchanged.
+type WebhookPatch struct {
+	URL           *string  // New URL, if set
+	Secret        *string  // New signing secret, if set
+	SetEventTypes bool     // Whether to replace the event types with EventTypes
+	EventTypes    []string // New event types; only used if SetEventTypes
+	Active        *bool    // New active state, if set
+}
+
+// WebhookDelivery is one event queued for, or delivered to, a webhook
+type WebhookDelivery struct {
+	ID             int64          // Unique identifier for the delivery
+	WebhookID      int64          // ID of the receiving webhook
+	TreeID         int64          // ID of the webhook's tree
+	EventType      string         // Type of the delivered event
+	Payload        []byte         // JSON body POSTed to the webhook
+	Status         DeliveryStatus // Current state of the delivery
+	Attempts       int            // Number of attempts made so far
+	NextAttemptAt  time.Time      // Earliest time of the next attempt while pending
+	LastAttemptAt  *time.Time     // Time of the latest attempt, nil before the first
+	ResponseStatus int            // HTTP status of the latest attempt, 0 if there was no response
+	LastError      string         // Why the latest attempt failed, empty if it didn't
+	CreatedAt      time.Time      // Time the event was queued
+}
+
+// DeliveryAttempt is the outcome of an attempt to deliver a webhook delivery
+type DeliveryAttempt struct {
+	Status         DeliveryStatus // State of the delivery after the attempt
+	ResponseStatus int            // HTTP status returned by the receiver, 0 if there was no response
+	Error          string         // Why the attempt failed, empty if it succeeded
+	AttemptedAt    time.Time      // Time of the attempt
+	NextAttemptAt  time.Time      // Time of the next attempt; only used if Status is DeliveryPending
+}
+
+// webhookEventTypes maps each history action to the type of the event its
+// entries are delivered to webhooks as. The types are those of the feed
+// package's events.
+var webhookEventTypes = map[HistoryAction]string{
+	HistoryCreate:  "node.created",
+	HistoryUpdate:  "node.updated",
+	HistoryMove:    "node.moved",
+	HistoryDelete:  "node.deleted",
+	HistoryRestore: "node.restored",
+}
+
+// webhookEvent is the payload queued for a history entry. It has the JSON
+// of the feed package's events, but its ID is the history entry's, which
+// unlike the feed's survives restarts.
+type webhookEvent struct {
+	ID           int64       `json:"id"`
+	Type         string      `json:"type"`
+	TreeID       int64       `json:"treeId"`
+	NodeID       int64       `json:"nodeId"`
+	Node         webhookNode `json:"node"`
+	Path         []int64     `json:"path"`
+	PreviousPath []int64     `json:"previousPath,omitempty"`
+	Time         time.Time   `json:"time"`
+}
+
+// webhookNode is the changed node of a webhookEvent
+type webhookNode struct {
+	ID         int64                  `json:"id"`
+	Label      string                 `json:"label"`
+	ParentID   *int64                 `json:"parentId"`
+	Position   float64                `json:"position"`
+	Attributes map[string]interface{} `json:"attributes,omitempty"`
+	CreatedAt  time.Time              `json:"createdAt"`
+	UpdatedAt  time.Time              `json:"updatedAt"`
+	Version    int64                  `json:"version"`
+	DeletedAt  *time.Time             `json:"deletedAt,omitempty"`
+}
+
+// reparents reports whether entry records a change of the node's parent
+func (e *HistoryEntry) reparents() bool {
+	return (e.Action == HistoryUpdate || e.Action == HistoryMove) && !sameID(e.OldParentID, e.NewParentID)
+}
+
+// webhookPayload encodes the webhook event for entry. node is the changed
+// node as the change left it and path the IDs from its root down to it.
+// oldParentPath is the path of the node's parent before the change; it is
+// only used if the change re-parented the node, and is nil if the node was
+// a root.
+func webhookPayload(entry *HistoryEntry, node *Node, path, oldParentPath []int64) ([]byte, error) {
+	event := webhookEvent{
+		ID:     entry.ID,
+		Type:   webhookEventTypes[entry.Action],
+		TreeID: entry.TreeID,
+		NodeID: entry.NodeID,
+		Node: webhookNode{
+			ID:         node.ID,
+			Label:      node.Label,
+			ParentID:   node.ParentID,
+			Position:   node.Position,
+			Attributes: node.Attributes,
+			CreatedAt:  node.CreatedAt,
+			UpdatedAt:  node.UpdatedAt,
+			Version:    node.Version,
+			DeletedAt:  node.DeletedAt,
+		},
+		Path: path,
+		Time: entry.ChangedAt,
+	}
+	if entry.reparents() {
+		event.PreviousPath = append(append(make([]int64, 0, len(oldParentPath)+1), oldParentPath...), node.ID)
+	}
+
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("error encoding webhook payload: %w", err)
+	}
+	return payload, nil
+}
@@ -134,6 +134,12 @@ resource "aws_apigatewayv2_route" "get_tree" {
   target    = "integrations/${aws_apigatewayv2_integration.lambda.id}"
 }
 
+resource "aws_apigatewayv2_route" "export_tree" {
+  api_id    = aws_apigatewayv2_api.api.id
+  route_key = "GET /api/tree/export"
+  target    = "integrations/${aws_apigatewayv2_integration.lambda.id}"
+}
+
 resource "aws_apigatewayv2_route" "create_node" {
   api_id    = aws_apigatewayv2_api.api.id
   route_key = "POST /api/tree"
@@ -142,13 +148,13 @@ resource "aws_apigatewayv2_route" "create_node" {
 
 resource "aws_apigatewayv2_route" "update_node" {
   api_id    = aws_apigatewayv2_api.api.id
-  route_key = "PUT /api/tree/{id}"
+  route_key = "PUT /api/node/{id}"
   target    = "integrations/${aws_apigatewayv2_integration.lambda.id}"
 }
 
 resource "aws_apigatewayv2_route" "delete_node" {
   api_id    = aws_apigatewayv2_api.api.id
-  route_key = "DELETE /api/tree/{id}"
+  route_key = "DELETE /api/node/{id}"
   target    = "integrations/${aws_apigatewayv2_integration.lambda.id}"
 }
 
@@ -214,3 +214,76 @@ func TestMultiplePages(t *testing.T) {
 	assert.False(t, found)
 	assert.Nil(t, response)
 }
+
+func TestCursorPages(t *testing.T) {
+	// Create cache provider
+	cacheProvider := cache.NewMemoryCache()
+	err := cacheProvider.Initialize()
+	assert.NoError(t, err)
+
+	// Create a cursor page
+	cursorResponse := &cache.PaginatedTreeResponse{
+		Data: []*models.Node{
+			{
+				ID:       3,
+				Label:    "node3",
+				Children: make([]*models.Node, 0),
+			},
+		},
+	}
+	cursorResponse.Pagination.PageSize = 1
+	cursorResponse.Pagination.Cursor = models.EncodeCursor(2)
+	cursorResponse.Pagination.NextCursor = models.EncodeCursor(3)
+	cursorResponse.Pagination.HasNext = true
+	cursorResponse.Pagination.HasPrev = true
+
+	cacheProvider.SetCursorTree(models.EncodeCursor(2), 1, cursorResponse)
+
+	// Cursor pages are keyed by cursor and page size
+	response, found := cacheProvider.GetCursorTree(models.EncodeCursor(2), 1)
+	assert.True(t, found)
+	assert.Equal(t, models.EncodeCursor(3), response.Pagination.NextCursor)
+
+	response, found = cacheProvider.GetCursorTree(models.EncodeCursor(2), 2)
+	assert.False(t, found)
+	assert.Nil(t, response)
+
+	// Cursor pages don't collide with numbered pages
+	response, found = cacheProvider.GetPaginatedTree(1, 1)
+	assert.False(t, found)
+	assert.Nil(t, response)
+
+	// Test cache invalidation affects cursor pages
+	cacheProvider.InvalidateCache()
+	response, found = cacheProvider.GetCursorTree(models.EncodeCursor(2), 1)
+	assert.False(t, found)
+	assert.Nil(t, response)
+}
+
+func TestCacheTreeNamespaces(t *testing.T) {
+	cacheProvider := cache.NewMemoryCache()
+	err := cacheProvider.Initialize()
+	assert.NoError(t, err)
+
+	response := &cache.PaginatedTreeResponse{Data: []*models.Node{{ID: 1, Label: "root"}}}
+	other := cacheProvider.ForTree(2)
+
+	// The provider itself holds the default tree's entries
+	cacheProvider.SetPaginatedTree(1, 10, response)
+	_, found := cacheProvider.ForTree(cache.DefaultTreeID).GetPaginatedTree(1, 10)
+	assert.True(t, found)
+	_, found = other.GetPaginatedTree(1, 10)
+	assert.False(t, found)
+
+	other.SetPaginatedTree(1, 10, response)
+	other.SetCursorTree("", 10, response)
+
+	// Invalidating one tree keeps the other tree's entries
+	other.InvalidateCache()
+	_, found = other.GetPaginatedTree(1, 10)
+	assert.False(t, found)
+	_, found = other.GetCursorTree("", 10)
+	assert.False(t, found)
+	_, found = cacheProvider.GetPaginatedTree(1, 10)
+	assert.True(t, found)
+}
@@ -0,0 +1,78 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ammiranda/tree_service/config"
+	"github.com/ammiranda/tree_service/repository"
+	"github.com/ammiranda/tree_service/repository/repotest"
+)
+
+// initialized initializes repo and registers its cleanup with t
+func initialized[R repository.Repository](t *testing.T, repo R) R {
+	if err := repo.Initialize(context.Background()); err != nil {
+		t.Fatalf("Failed to initialize repository: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := repo.Cleanup(context.Background()); err != nil {
+			t.Errorf("Failed to cleanup repository: %v", err)
+		}
+	})
+	return repo
+}
+
+// TestInMemoryRepositoryConformance also covers MockRepository, whose
+// storage it shares. The mock has no run of its own because it leaves out
+// the input checks InMemoryRepository adds.
+func TestInMemoryRepositoryConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.Repository {
+		return initialized(t, repository.NewInMemoryRepository("", 0))
+	})
+}
+
+func TestSQLiteRepositoryConformance(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) repository.Repository {
+		return initialized(t, repository.NewSQLiteRepository(":memory:"))
+	})
+}
+
+// TestPostgresRepositoryConformance runs the suite against the database
+// configured by the DB_* environment variables, for every hierarchy. Each
+// subtest works in a tree of its own, which it deletes afterwards.
+func TestPostgresRepositoryConformance(t *testing.T) {
+	if os.Getenv("DB_HOST") == "" {
+		t.Skip("DB_HOST is not set")
+	}
+
+	var trees atomic.Int64
+	for _, hierarchy := range []string{config.HierarchyPath, config.HierarchyClosure} {
+		t.Run(hierarchy, func(t *testing.T) {
+			t.Setenv("DB_DRIVER", config.DriverPostgres)
+			t.Setenv("DB_HIERARCHY", hierarchy)
+			repo, err := repository.New(config.NewEnvProvider(""))
+			if err != nil {
+				t.Fatalf("Failed to create repository: %v", err)
+			}
+			repo = initialized(t, repo)
+
+			repotest.Run(t, func(t *testing.T) repository.Repository {
+				name := fmt.Sprintf("conformance-%d-%d", time.Now().UnixNano(), trees.Add(1))
+				tree, err := repo.CreateTree(context.Background(), name)
+				if err != nil {
+					t.Fatalf("Failed to create tree: %v", err)
+				}
+				t.Cleanup(func() {
+					if _, err := repo.DeleteTree(context.Background(), tree.ID); err != nil {
+						t.Errorf("Failed to delete tree: %v", err)
+					}
+				})
+				return repo.ForTree(tree.ID)
+			})
+		})
+	}
+}
@@ -2,8 +2,10 @@ package tests
 
 import (
 	"context"
+	"path/filepath"
 	"testing"
 
+	"github.com/ammiranda/tree_service/config"
 	"github.com/ammiranda/tree_service/repository"
 
 	"github.com/stretchr/testify/assert"
@@ -49,11 +51,175 @@ func TestMockRepository(t *testing.T) {
 	assert.Equal(t, "updated", node.Label)
 
 	// Test deleting the node
-	err = repo.DeleteNode(context.Background(), id)
+	deleted, err := repo.DeleteNode(context.Background(), id, repository.DeleteModeCascade)
 	assert.NoError(t, err)
+	assert.Equal(t, int64(1), deleted)
 
 	// Verify the deletion
 	_, err = repo.GetNode(context.Background(), id)
 	assert.Error(t, err)
 	assert.Equal(t, repository.ErrNodeNotFound, err)
 }
+
+func TestNewRepositorySelectsHierarchy(t *testing.T) {
+	t.Setenv("REPO_TEST_DB_HOST", "127.0.0.1")
+	t.Setenv("REPO_TEST_DB_PORT", "5432")
+	t.Setenv("REPO_TEST_DB_USER", "postgres")
+	t.Setenv("REPO_TEST_DB_PASSWORD", "postgres")
+	t.Setenv("REPO_TEST_DB_NAME", "tree_db")
+	provider := config.NewEnvProvider("REPO_TEST_")
+
+	repo, err := repository.New(provider)
+	assert.NoError(t, err)
+	assert.IsType(t, &repository.PostgresRepository{}, repo)
+
+	t.Setenv("REPO_TEST_DB_HIERARCHY", "closure")
+	repo, err = repository.New(provider)
+	assert.NoError(t, err)
+	assert.IsType(t, &repository.ClosureRepository{}, repo)
+	assert.IsType(t, &repository.ClosureRepository{}, repo.ForTree(2))
+
+	t.Setenv("REPO_TEST_DB_HIERARCHY", "nested-sets")
+	_, err = repository.New(provider)
+	var validationErr *config.ValidationError
+	assert.ErrorAs(t, err, &validationErr)
+
+	// The SQLite driver needs no database settings
+	t.Setenv("REPO_TEST_DB_HIERARCHY", "")
+	t.Setenv("REPO_TEST_DB_HOST", "")
+	t.Setenv("REPO_TEST_DB_DRIVER", "sqlite")
+	t.Setenv("REPO_TEST_SQLITE_PATH", ":memory:")
+	repo, err = repository.New(provider)
+	assert.NoError(t, err)
+	assert.IsType(t, &repository.SQLiteRepository{}, repo)
+
+	t.Setenv("REPO_TEST_DB_DRIVER", "memory")
+	repo, err = repository.New(provider)
+	assert.NoError(t, err)
+	assert.IsType(t, &repository.InMemoryRepository{}, repo)
+	assert.IsType(t, &repository.InMemoryRepository{}, repo.ForTree(2))
+
+	t.Setenv("REPO_TEST_DB_DRIVER", "mysql")
+	_, err = repository.New(provider)
+	assert.ErrorAs(t, err, &validationErr)
+}
+
+func TestInMemoryRepositorySnapshot(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "snapshot.json")
+
+	repo := repository.NewInMemoryRepository(path, 0)
+	assert.NoError(t, repo.Initialize(ctx))
+
+	tree, err := repo.CreateTree(ctx, "docs")
+	assert.NoError(t, err)
+	docs := repo.ForTree(tree.ID)
+	rootID, err := docs.CreateNodeWithAttributes(ctx, "root", nil, map[string]interface{}{"team": "core"})
+	assert.NoError(t, err)
+	childID, err := docs.CreateNode(ctx, "child", &rootID)
+	assert.NoError(t, err)
+
+	// Purged IDs are never handed out again, even after a restart
+	_, err = docs.DeleteNode(ctx, childID, repository.DeleteModeCascade)
+	assert.NoError(t, err)
+	_, err = docs.PurgeNode(ctx, childID)
+	assert.NoError(t, err)
+	assert.NoError(t, repo.Cleanup(ctx))
+
+	repo = repository.NewInMemoryRepository(path, 0)
+	assert.NoError(t, repo.Initialize(ctx))
+	docs = repo.ForTree(tree.ID)
+
+	root, err := docs.GetNode(ctx, rootID)
+	assert.NoError(t, err)
+	assert.Equal(t, "root", root.Label)
+	assert.Equal(t, "core", root.Attributes["team"])
+	_, err = docs.GetNode(ctx, childID)
+	assert.Equal(t, repository.ErrNodeNotFound, err)
+
+	newID, err := docs.CreateNode(ctx, "new child", &rootID)
+	assert.NoError(t, err)
+	assert.Greater(t, newID, childID)
+
+	history, total, err := docs.GetNodeHistory(ctx, rootID, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Equal(t, repository.HistoryCreate, history[0].Action)
+
+	// Parents are validated and pages before the first are refused
+	missing := int64(999)
+	_, err = docs.CreateNode(ctx, "orphan", &missing)
+	assert.Equal(t, repository.ErrNodeNotFound, err)
+	_, _, err = docs.GetAllNodes(ctx, 0, 10)
+	assert.Equal(t, repository.ErrInvalidInput, err)
+
+	assert.NoError(t, repo.Cleanup(ctx))
+}
+
+func TestSQLiteRepository(t *testing.T) {
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "tree.db")
+
+	repo := repository.NewSQLiteRepository(path)
+	err := repo.Initialize(ctx)
+	assert.NoError(t, err)
+
+	// Test creating a small tree
+	rootID, err := repo.CreateNodeWithAttributes(ctx, "root", nil, map[string]interface{}{"team": "core"})
+	assert.NoError(t, err)
+	childID, err := repo.CreateNode(ctx, "child", &rootID)
+	assert.NoError(t, err)
+	grandchildID, err := repo.CreateNode(ctx, "grandchild", &childID)
+	assert.NoError(t, err)
+
+	nodes, total, err := repo.GetAllNodes(ctx, 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	assert.Len(t, nodes, 3)
+	assert.Equal(t, "core", nodes[0].Attributes["team"])
+
+	ancestors, err := repo.GetAncestors(ctx, grandchildID)
+	assert.NoError(t, err)
+	assert.Len(t, ancestors, 3)
+	assert.Equal(t, rootID, ancestors[0].ID)
+
+	// Test that cycles are refused
+	err = repo.UpdateNode(ctx, rootID, "root", &grandchildID)
+	assert.Equal(t, repository.ErrCycleDetected, err)
+
+	// Test that updates bump the version
+	renamed := "renamed"
+	err = repo.UpdateNodeIfVersion(ctx, childID, 1, repository.NodePatch{Label: &renamed})
+	assert.NoError(t, err)
+	err = repo.UpdateNodeIfVersion(ctx, childID, 1, repository.NodePatch{Label: &renamed})
+	assert.Equal(t, repository.ErrVersionConflict, err)
+
+	// Test that the database survives reopening the file
+	assert.NoError(t, repo.Cleanup(ctx))
+	repo = repository.NewSQLiteRepository(path)
+	err = repo.Initialize(ctx)
+	assert.NoError(t, err)
+	defer func() {
+		if err := repo.Cleanup(ctx); err != nil {
+			t.Errorf("Failed to cleanup repository: %v", err)
+		}
+	}()
+
+	child, err := repo.GetNode(ctx, childID)
+	assert.NoError(t, err)
+	assert.Equal(t, "renamed", child.Label)
+	assert.Equal(t, int64(2), child.Version)
+
+	// Test that deleting cascades to the trash and restoring brings it back
+	deleted, err := repo.DeleteNode(ctx, childID, repository.DeleteModeCascade)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+	_, err = repo.GetNode(ctx, grandchildID)
+	assert.Equal(t, repository.ErrNodeNotFound, err)
+
+	restored, err := repo.RestoreNode(ctx, childID)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), restored)
+	_, err = repo.GetNode(ctx, grandchildID)
+	assert.NoError(t, err)
+}
@@ -0,0 +1,97 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ammiranda/tree_service/repository"
+)
+
+// closureMismatches returns the nodes of treeID whose node_closure rows
+// don't list exactly the ancestors in their path at the right depths
+func closureMismatches(t *testing.T, db *sql.DB, treeID int64) []int64 {
+	rows, err := db.Query(`
+		SELECT n.id FROM nodes n
+		CROSS JOIN LATERAL (
+			SELECT string_to_array(trim(BOTH '/' FROM n.path), '/')::integer[] AS ids
+		) p
+		WHERE n.tree_id = $1 AND ARRAY(
+			SELECT c.ancestor_id || ':' || c.depth FROM node_closure c
+			WHERE c.descendant_id = n.id ORDER BY c.depth DESC
+		) IS DISTINCT FROM ARRAY(
+			SELECT a.id || ':' || (cardinality(p.ids) - a.ord) FROM unnest(p.ids) WITH ORDINALITY a(id, ord)
+			ORDER BY a.ord
+		)
+		ORDER BY n.id
+	`, treeID)
+	if err != nil {
+		t.Fatalf("Failed to compare closure rows: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			t.Fatalf("Failed to scan node ID: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		t.Fatalf("Failed to compare closure rows: %v", err)
+	}
+	return ids
+}
+
+// Both hierarchy structures are written whichever one a repository reads,
+// so after any sequence of writes they must agree
+func TestPostgresClosureMatchesPaths(t *testing.T) {
+	for hierarchy, repo := range postgresTrees(t) {
+		t.Run(hierarchy, func(t *testing.T) {
+			ctx := context.Background()
+			db := postgresDB(t, "")
+
+			create := func(label string, parentID *int64) int64 {
+				id, err := repo.CreateNode(ctx, label, parentID)
+				if err != nil {
+					t.Fatalf("Failed to create %s: %v", label, err)
+				}
+				return id
+			}
+			a := create("a", nil)
+			b := create("b", &a)
+			c := create("c", &b)
+			d := create("d", nil)
+			_, err := repo.ImportTree(ctx, &c, &repository.ImportNode{
+				Key:   "e",
+				Label: "e",
+				Children: []*repository.ImportNode{
+					{Key: "f", Label: "f", Children: []*repository.ImportNode{{Key: "g", Label: "g"}}},
+				},
+			})
+			assert.NoError(t, err)
+
+			assert.NoError(t, repo.MoveNode(ctx, b, &d, repository.MovePosition{}))
+			assert.NoError(t, repo.MoveNode(ctx, c, nil, repository.MovePosition{}))
+			assert.NoError(t, repo.PatchNode(ctx, c, repository.NodePatch{ParentID: &a, SetParent: true}))
+			assert.NoError(t, repo.UpdateNode(ctx, a, "a", &d))
+			_, err = repo.DeleteNode(ctx, d, repository.DeleteModeReparent)
+			assert.NoError(t, err)
+			_, err = repo.DeleteNode(ctx, a, repository.DeleteModeCascade)
+			assert.NoError(t, err)
+			h := create("h", nil)
+			assert.NoError(t, repo.MoveNode(ctx, b, &h, repository.MovePosition{}))
+			_, err = repo.RestoreNode(ctx, a)
+			assert.NoError(t, err)
+
+			node, err := repo.GetNode(ctx, a)
+			if !assert.NoError(t, err) {
+				return
+			}
+			assert.Empty(t, closureMismatches(t, db, node.TreeID))
+		})
+	}
+}
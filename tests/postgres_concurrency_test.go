@@ -0,0 +1,187 @@
+package tests
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ammiranda/tree_service/config"
+	"github.com/ammiranda/tree_service/repository"
+)
+
+// concurrentRounds is how many times each race is run
+const concurrentRounds = 20
+
+// postgresTrees returns, for every hierarchy, a view of the database
+// configured by the DB_* environment variables scoped to a fresh tree,
+// which is deleted after the test. It skips the test if DB_HOST is not set.
+func postgresTrees(t *testing.T) map[string]repository.Repository {
+	if os.Getenv("DB_HOST") == "" {
+		t.Skip("DB_HOST is not set")
+	}
+
+	repos := make(map[string]repository.Repository)
+	for _, hierarchy := range []string{config.HierarchyPath, config.HierarchyClosure} {
+		t.Setenv("DB_DRIVER", config.DriverPostgres)
+		t.Setenv("DB_HIERARCHY", hierarchy)
+		repo, err := repository.New(config.NewEnvProvider(""))
+		if err != nil {
+			t.Fatalf("Failed to create repository: %v", err)
+		}
+		repo = initialized(t, repo)
+
+		tree, err := repo.CreateTree(context.Background(), fmt.Sprintf("%s-%s-%d", t.Name(), hierarchy, time.Now().UnixNano()))
+		if err != nil {
+			t.Fatalf("Failed to create tree: %v", err)
+		}
+		t.Cleanup(func() {
+			if _, err := repo.DeleteTree(context.Background(), tree.ID); err != nil {
+				t.Errorf("Failed to delete tree: %v", err)
+			}
+		})
+		repos[hierarchy] = repo.ForTree(tree.ID)
+	}
+	return repos
+}
+
+// race runs a and b at the same time and waits for both
+func race(a, b func()) {
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		a()
+	}()
+	go func() {
+		defer wg.Done()
+		b()
+	}()
+	wg.Wait()
+}
+
+func TestPostgresCreateRacesDelete(t *testing.T) {
+	for hierarchy, repo := range postgresTrees(t) {
+		t.Run(hierarchy, func(t *testing.T) {
+			ctx := context.Background()
+			for i := 0; i < concurrentRounds; i++ {
+				parentID, err := repo.CreateNode(ctx, "parent", nil)
+				if !assert.NoError(t, err) {
+					return
+				}
+
+				var childID int64
+				var createErr, deleteErr error
+				race(func() {
+					childID, createErr = repo.CreateNode(ctx, "child", &parentID)
+				}, func() {
+					_, deleteErr = repo.DeleteNode(ctx, parentID, repository.DeleteModeCascade)
+				})
+				if !assert.NoError(t, deleteErr) {
+					return
+				}
+
+				// The child was either trashed with its parent or never created
+				if createErr != nil {
+					assert.ErrorIs(t, createErr, repository.ErrNodeNotFound)
+					continue
+				}
+				_, err = repo.GetNode(ctx, childID)
+				assert.ErrorIs(t, err, repository.ErrNodeNotFound, "child %d outlived its parent", childID)
+				restored, err := repo.RestoreNode(ctx, parentID)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(2), restored, "the child is restored with its parent")
+			}
+		})
+	}
+}
+
+// nodeIDs returns the IDs of nodes in order
+func nodeIDs(nodes []*repository.Node) []int64 {
+	ids := make([]int64, len(nodes))
+	for i, node := range nodes {
+		ids[i] = node.ID
+	}
+	return ids
+}
+
+func TestPostgresCreateRacesAncestorMove(t *testing.T) {
+	for hierarchy, repo := range postgresTrees(t) {
+		t.Run(hierarchy, func(t *testing.T) {
+			ctx := context.Background()
+
+			// assertAncestors checks that the hierarchy agrees on id's
+			// ancestors and that it is in the subtree of its root
+			assertAncestors := func(id int64, want ...int64) {
+				ancestors, err := repo.GetAncestors(ctx, id)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, want, nodeIDs(ancestors))
+				subtree, err := repo.GetSubtree(ctx, want[0], repository.UnlimitedDepth)
+				if assert.NoError(t, err) {
+					assert.Contains(t, nodeIDs(subtree), id)
+				}
+			}
+
+			for i := 0; i < concurrentRounds; i++ {
+				a, err := repo.CreateNode(ctx, "a", nil)
+				if !assert.NoError(t, err) {
+					return
+				}
+				b, err := repo.CreateNode(ctx, "b", nil)
+				if !assert.NoError(t, err) {
+					return
+				}
+				p, err := repo.CreateNode(ctx, "p", &a)
+				if !assert.NoError(t, err) {
+					return
+				}
+
+				// Create under p while its parent moves under b
+				var c1 int64
+				var createErr, moveErr error
+				race(func() {
+					c1, createErr = repo.CreateNode(ctx, "c1", &p)
+				}, func() {
+					moveErr = repo.MoveNode(ctx, a, &b, repository.MovePosition{})
+				})
+				if !assert.NoError(t, createErr) || !assert.NoError(t, moveErr) {
+					return
+				}
+				assertAncestors(c1, b, a, p, c1)
+
+				// ...while its parent becomes a root again
+				var c2 int64
+				race(func() {
+					c2, createErr = repo.CreateNode(ctx, "c2", &p)
+				}, func() {
+					moveErr = repo.MoveNode(ctx, a, nil, repository.MovePosition{})
+				})
+				if !assert.NoError(t, createErr) || !assert.NoError(t, moveErr) {
+					return
+				}
+				assertAncestors(c1, a, p, c1)
+				assertAncestors(c2, a, p, c2)
+
+				// ...and while its parent is deleted, making it a root
+				var c3 int64
+				var deleteErr error
+				race(func() {
+					c3, createErr = repo.CreateNode(ctx, "c3", &p)
+				}, func() {
+					_, deleteErr = repo.DeleteNode(ctx, a, repository.DeleteModeReparent)
+				})
+				if !assert.NoError(t, createErr) || !assert.NoError(t, deleteErr) {
+					return
+				}
+				assertAncestors(c1, p, c1)
+				assertAncestors(c3, p, c3)
+			}
+		})
+	}
+}
@@ -0,0 +1,228 @@
+package tests
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/source/file"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ammiranda/tree_service/config"
+	"github.com/ammiranda/tree_service/repository"
+)
+
+// postgresDB opens the database configured by the DB_* environment
+// variables, resolving names in searchPath if it is set, and closes it
+// after the test. It skips the test if DB_HOST is not set.
+func postgresDB(t *testing.T, searchPath string) *sql.DB {
+	if os.Getenv("DB_HOST") == "" {
+		t.Skip("DB_HOST is not set")
+	}
+
+	cfg, err := config.GetDatabaseConfig(context.Background(), config.NewEnvProvider(""))
+	if err != nil {
+		t.Fatalf("Failed to get database config: %v", err)
+	}
+	connStr := fmt.Sprintf(
+		"postgres://%s:%s@%s:%d/%s?sslmode=%s",
+		cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.DBName, cfg.SSLMode,
+	)
+	if searchPath != "" {
+		connStr += "&search_path=" + url.QueryEscape(searchPath)
+	}
+	db, err := sql.Open("postgres", connStr)
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := db.Close(); err != nil {
+			t.Errorf("Failed to close database: %v", err)
+		}
+	})
+	return db
+}
+
+// storedNode is the part of a nodes row the path triggers maintain or
+// must leave alone
+type storedNode struct {
+	path      string
+	version   int64
+	updatedAt time.Time
+}
+
+// loadStoredNode reads node id straight from db, trashed or not
+func loadStoredNode(t *testing.T, db *sql.DB, id int64) storedNode {
+	var node storedNode
+	err := db.QueryRow(
+		"SELECT path, version, updated_at FROM nodes WHERE id = $1", id,
+	).Scan(&node.path, &node.version, &node.updatedAt)
+	if err != nil {
+		t.Fatalf("Failed to load node %d: %v", id, err)
+	}
+	return node
+}
+
+// pathOf returns the materialized path listing ids
+func pathOf(ids ...int64) string {
+	var path strings.Builder
+	path.WriteString("/")
+	for _, id := range ids {
+		fmt.Fprintf(&path, "%d/", id)
+	}
+	return path.String()
+}
+
+func TestPostgresNodePaths(t *testing.T) {
+	for hierarchy, repo := range postgresTrees(t) {
+		t.Run(hierarchy, func(t *testing.T) {
+			ctx := context.Background()
+			db := postgresDB(t, "")
+
+			create := func(label string, parentID *int64) int64 {
+				id, err := repo.CreateNode(ctx, label, parentID)
+				if err != nil {
+					t.Fatalf("Failed to create %s: %v", label, err)
+				}
+				return id
+			}
+			a := create("a", nil)
+			b := create("b", &a)
+			c := create("c", &b)
+			d := create("d", nil)
+			assert.Equal(t, pathOf(a, b), loadStoredNode(t, db, b).path)
+			assert.Equal(t, pathOf(a, b, c), loadStoredNode(t, db, c).path)
+
+			// Rewriting a descendant's path doesn't modify the descendant
+			before := loadStoredNode(t, db, c)
+			assertRewritten := func(id int64, path string) {
+				after := loadStoredNode(t, db, id)
+				assert.Equal(t, path, after.path)
+				assert.Equal(t, before.version, after.version, "path rewrites don't bump the version")
+				assert.True(t, before.updatedAt.Equal(after.updatedAt), "path rewrites don't touch updated_at")
+			}
+
+			t.Run("Move", func(t *testing.T) {
+				assert.NoError(t, repo.MoveNode(ctx, b, &d, repository.MovePosition{}))
+				assert.Equal(t, pathOf(d, b), loadStoredNode(t, db, b).path)
+				assertRewritten(c, pathOf(d, b, c))
+			})
+
+			t.Run("Make root", func(t *testing.T) {
+				assert.NoError(t, repo.MoveNode(ctx, b, nil, repository.MovePosition{}))
+				assert.Equal(t, pathOf(b), loadStoredNode(t, db, b).path)
+				assertRewritten(c, pathOf(b, c))
+			})
+
+			t.Run("Reparent delete", func(t *testing.T) {
+				assert.NoError(t, repo.PatchNode(ctx, b, repository.NodePatch{ParentID: &a, SetParent: true}))
+				assertRewritten(c, pathOf(a, b, c))
+				e := create("e", &c)
+				beforeE := loadStoredNode(t, db, e)
+
+				_, err := repo.DeleteNode(ctx, b, repository.DeleteModeReparent)
+				assert.NoError(t, err)
+				assert.Equal(t, pathOf(a, c), loadStoredNode(t, db, c).path)
+				afterE := loadStoredNode(t, db, e)
+				assert.Equal(t, pathOf(a, c, e), afterE.path)
+				assert.Equal(t, beforeE.version, afterE.version)
+			})
+
+			t.Run("Restore", func(t *testing.T) {
+				// The trashed subtree follows its live parent while in the
+				// trash and keeps its paths when restored
+				_, err := repo.DeleteNode(ctx, c, repository.DeleteModeCascade)
+				assert.NoError(t, err)
+				assert.NoError(t, repo.MoveNode(ctx, a, &d, repository.MovePosition{}))
+				assert.Equal(t, pathOf(d, a, c), loadStoredNode(t, db, c).path)
+
+				restored, err := repo.RestoreNode(ctx, c)
+				assert.NoError(t, err)
+				assert.Equal(t, int64(2), restored)
+				assert.Equal(t, pathOf(d, a, c), loadStoredNode(t, db, c).path)
+				subtree, err := repo.GetSubtree(ctx, d, repository.UnlimitedDepth)
+				if assert.NoError(t, err) {
+					assert.Len(t, subtree, 4)
+				}
+			})
+		})
+	}
+}
+
+func TestPostgresHierarchyBackfill(t *testing.T) {
+	ctx := context.Background()
+	db := postgresDB(t, "")
+
+	// Migrate a schema of its own so the shared tables are left alone
+	schema := fmt.Sprintf("path_backfill_%d", time.Now().UnixNano())
+	if _, err := db.ExecContext(ctx, "CREATE SCHEMA "+schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	defer func() {
+		if _, err := db.ExecContext(ctx, "DROP SCHEMA "+schema+" CASCADE"); err != nil {
+			t.Errorf("Failed to drop schema: %v", err)
+		}
+	}()
+
+	scoped := postgresDB(t, schema+",public")
+	driver, err := postgres.WithInstance(scoped, &postgres.Config{})
+	if err != nil {
+		t.Fatalf("Failed to create migration driver: %v", err)
+	}
+	m, err := migrate.NewWithDatabaseInstance("file://../migrations", "postgres", driver)
+	if err != nil {
+		t.Fatalf("Failed to create migration instance: %v", err)
+	}
+	defer m.Close()
+
+	// Build a tree before the path column exists
+	if err := m.Migrate(10); err != nil {
+		t.Fatalf("Failed to migrate to version 10: %v", err)
+	}
+	insert := func(label string, parentID *int64) int64 {
+		var id int64
+		err := scoped.QueryRowContext(ctx,
+			"INSERT INTO nodes (label, parent_id) VALUES ($1, $2) RETURNING id",
+			label, parentID,
+		).Scan(&id)
+		if err != nil {
+			t.Fatalf("Failed to insert %s: %v", label, err)
+		}
+		return id
+	}
+	a := insert("a", nil)
+	b := insert("b", &a)
+	c := insert("c", &b)
+	d := insert("d", nil)
+	var version int64
+	var updatedAt time.Time
+	err = scoped.QueryRowContext(ctx, "SELECT version, updated_at FROM nodes WHERE id = $1", c).Scan(&version, &updatedAt)
+	if err != nil {
+		t.Fatalf("Failed to load node: %v", err)
+	}
+
+	if err := m.Up(); err != nil {
+		t.Fatalf("Failed to migrate up: %v", err)
+	}
+	for id, path := range map[int64]string{
+		a: pathOf(a),
+		b: pathOf(a, b),
+		c: pathOf(a, b, c),
+		d: pathOf(d),
+	} {
+		assert.Equal(t, path, loadStoredNode(t, scoped, id).path)
+	}
+	backfilled := loadStoredNode(t, scoped, c)
+	assert.Equal(t, version, backfilled.version, "the backfill doesn't bump the version")
+	assert.True(t, updatedAt.Equal(backfilled.updatedAt), "the backfill doesn't touch updated_at")
+
+	// Migration 12 backfills the closure table from the same parents
+	assert.Empty(t, closureMismatches(t, scoped, repository.DefaultTreeID))
+}
@@ -1,19 +1,27 @@
 package tests
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/aws/aws-lambda-go/events"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/assert"
 
 	"github.com/ammiranda/tree_service/cache"
+	"github.com/ammiranda/tree_service/feed"
 	"github.com/ammiranda/tree_service/handlers"
+	"github.com/ammiranda/tree_service/internal/lambda"
+	"github.com/ammiranda/tree_service/jobs"
 	"github.com/ammiranda/tree_service/models"
 	"github.com/ammiranda/tree_service/repository"
 )
@@ -48,7 +56,7 @@ func TestGetTree(t *testing.T) {
 	repo, cleanup := setupTest(t)
 	defer cleanup()
 
-	// Create initial root node
+	// Create two trees: one root with 15 children, and a lone root
 	rootID, err := repo.CreateNode(context.Background(), "root", nil)
 	assert.NoError(t, err)
 
@@ -58,6 +66,9 @@ func TestGetTree(t *testing.T) {
 		assert.NoError(t, err)
 	}
 
+	_, err = repo.CreateNode(context.Background(), "second_root", nil)
+	assert.NoError(t, err)
+
 	// Create handler
 	handler := handlers.NewTreeHandler(repo)
 
@@ -75,52 +86,63 @@ func TestGetTree(t *testing.T) {
 	err = json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
 
-	// Check pagination metadata
+	// Check pagination metadata: totals count trees, not nodes
 	assert.Equal(t, 1, response.Pagination.Page)
 	assert.Equal(t, 10, response.Pagination.PageSize)
-	assert.Equal(t, int64(16), response.Pagination.Total) // root + 15 children
-	assert.Equal(t, int64(2), response.Pagination.TotalPages)
-	assert.True(t, response.Pagination.HasNext)
+	assert.Equal(t, int64(2), response.Pagination.Total)
+	assert.Equal(t, int64(1), response.Pagination.TotalPages)
+	assert.False(t, response.Pagination.HasNext)
 	assert.False(t, response.Pagination.HasPrev)
 
-	// Test second page
-	req, _ = http.NewRequest("GET", "/tree?page=2", nil)
+	// Every child stays attached to its root
+	assert.Len(t, response.Data, 2)
+	assert.Len(t, response.Data[0].Children, 15)
+
+	// Test one tree per page
+	req, _ = http.NewRequest("GET", "/tree?pageSize=1", nil)
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
+	response = cache.PaginatedTreeResponse{}
 	err = json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
 
-	// Check pagination metadata for second page
-	assert.Equal(t, 2, response.Pagination.Page)
-	assert.Equal(t, 10, response.Pagination.PageSize)
-	assert.Equal(t, int64(16), response.Pagination.Total)
+	// Check pagination metadata for custom page size
+	assert.Equal(t, 1, response.Pagination.Page)
+	assert.Equal(t, 1, response.Pagination.PageSize)
+	assert.Equal(t, int64(2), response.Pagination.Total)
 	assert.Equal(t, int64(2), response.Pagination.TotalPages)
-	assert.False(t, response.Pagination.HasNext)
-	assert.True(t, response.Pagination.HasPrev)
+	assert.True(t, response.Pagination.HasNext)
+	assert.False(t, response.Pagination.HasPrev)
+	if assert.Len(t, response.Data, 1) {
+		assert.Equal(t, "root", response.Data[0].Label)
+		assert.Len(t, response.Data[0].Children, 15)
+	}
 
-	// Test custom page size
-	req, _ = http.NewRequest("GET", "/tree?pageSize=5", nil)
+	// Test second page
+	req, _ = http.NewRequest("GET", "/tree?page=2&pageSize=1", nil)
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
 	assert.Equal(t, http.StatusOK, w.Code)
 
+	response = cache.PaginatedTreeResponse{}
 	err = json.Unmarshal(w.Body.Bytes(), &response)
 	assert.NoError(t, err)
 
-	// Check pagination metadata for custom page size
-	assert.Equal(t, 1, response.Pagination.Page)
-	assert.Equal(t, 5, response.Pagination.PageSize)
-	assert.Equal(t, int64(16), response.Pagination.Total)
-	assert.Equal(t, int64(4), response.Pagination.TotalPages)
-	assert.True(t, response.Pagination.HasNext)
-	assert.False(t, response.Pagination.HasPrev)
+	// Check pagination metadata for second page
+	assert.Equal(t, 2, response.Pagination.Page)
+	assert.Equal(t, int64(2), response.Pagination.TotalPages)
+	assert.False(t, response.Pagination.HasNext)
+	assert.True(t, response.Pagination.HasPrev)
+	if assert.Len(t, response.Data, 1) {
+		assert.Equal(t, "second_root", response.Data[0].Label)
+	}
 
 	// Test cache hit
-	req, _ = http.NewRequest("GET", "/tree?pageSize=5", nil)
+	req, _ = http.NewRequest("GET", "/tree?page=2&pageSize=1", nil)
 	w = httptest.NewRecorder()
 	router.ServeHTTP(w, req)
 
@@ -135,6 +157,54 @@ func TestGetTree(t *testing.T) {
 	assert.Equal(t, len(response.Data), len(cachedResponse.Data))
 }
 
+func TestGetTreeDeepSubtreesStayWhole(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	// Create three roots, each with a chain of descendants
+	for i := 0; i < 3; i++ {
+		parentID, err := repo.CreateNode(context.Background(), fmt.Sprintf("root_%d", i+1), nil)
+		assert.NoError(t, err)
+		for level := 0; level < 4; level++ {
+			parentID, err = repo.CreateNode(context.Background(), fmt.Sprintf("level_%d", level+1), &parentID)
+			assert.NoError(t, err)
+		}
+	}
+
+	// Create handler
+	handler := handlers.NewTreeHandler(repo)
+
+	// Set up routes
+	router.GET("/tree", handler.GetTree)
+
+	for page := 1; page <= 3; page++ {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/tree?page=%d&pageSize=1", page), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response cache.PaginatedTreeResponse
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, int64(3), response.Pagination.Total)
+
+		// Each page holds exactly one root whose chain is four levels deep
+		if assert.Len(t, response.Data, 1) {
+			assert.Equal(t, fmt.Sprintf("root_%d", page), response.Data[0].Label)
+			depth := 0
+			for node := response.Data[0]; len(node.Children) > 0; node = node.Children[0] {
+				depth++
+			}
+			assert.Equal(t, 4, depth)
+		}
+	}
+}
+
 func TestGetTreeEmpty(t *testing.T) {
 	// Set up test environment
 	gin.SetMode(gin.TestMode)
@@ -354,8 +424,8 @@ func TestCreateNodeDeepNesting(t *testing.T) {
 	// Verify the tree structure
 	nodes, total, err := repo.GetAllNodes(context.Background(), 1, 20)
 	assert.NoError(t, err)
-	assert.Equal(t, int64(11), total) // Root + 10 levels
-	assert.Len(t, nodes, 11)
+	assert.Equal(t, int64(1), total) // A single tree
+	assert.Len(t, nodes, 11)         // Root + 10 levels
 }
 
 func TestUpdateNode(t *testing.T) {
@@ -706,16 +776,16 @@ func TestMultipleTrees(t *testing.T) {
 		{
 			name:           "First page with 2 items",
 			query:          "?pageSize=2",
-			expectedCount:  2,  // Tree1 and Tree2
-			expectedTotal:  15, // Total number of nodes (3 root nodes + 9 children + 3 grandchildren)
+			expectedCount:  2, // Tree1 and Tree2
+			expectedTotal:  3, // Total number of trees
 			expectedStatus: http.StatusOK,
 			expectedLabels: []string{"Tree1", "Tree2"},
 		},
 		{
 			name:           "Second page with 2 items",
 			query:          "?page=2&pageSize=2",
-			expectedCount:  1,  // Tree3
-			expectedTotal:  15, // Total number of nodes (3 root nodes + 9 children + 3 grandchildren)
+			expectedCount:  1, // Tree3
+			expectedTotal:  3, // Total number of trees
 			expectedStatus: http.StatusOK,
 			expectedLabels: []string{"Tree3"},
 		},
@@ -769,14 +839,14 @@ func TestMultipleTrees(t *testing.T) {
 	}
 
 	// Test deleting one tree
-	err = repo.DeleteNode(context.Background(), rootIDs[0])
+	_, err = repo.DeleteNode(context.Background(), rootIDs[0], repository.DeleteModeCascade)
 	assert.NoError(t, err)
 
 	// Verify remaining trees
 	nodes, total, err := repo.GetAllNodes(context.Background(), 1, 10)
 	assert.NoError(t, err)
-	assert.Equal(t, int64(8), total) // 2 root nodes + 6 children (2 from Tree2 + 4 from Tree3)
-	assert.Len(t, nodes, 8)          // Verify we have all remaining nodes
+	assert.Equal(t, int64(2), total) // Tree2 and Tree3
+	assert.Len(t, nodes, 8)          // 2 root nodes + 6 children (2 from Tree2 + 4 from Tree3)
 
 	// Verify Tree1 and its children are deleted
 	for _, node := range nodes {
@@ -795,3 +865,2490 @@ func TestMultipleTrees(t *testing.T) {
 	}
 	assert.Equal(t, 2, remainingTrees) // Should have 2 root nodes (Tree2 and Tree3)
 }
+
+func TestDeleteNode(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+
+	// buildTree creates root -> child -> grandchild and returns their IDs
+	buildTree := func(t *testing.T, repo *repository.MockRepository) (int64, int64, int64) {
+		rootID, err := repo.CreateNode(context.Background(), "root", nil)
+		assert.NoError(t, err)
+		childID, err := repo.CreateNode(context.Background(), "child", &rootID)
+		assert.NoError(t, err)
+		grandchildID, err := repo.CreateNode(context.Background(), "grandchild", &childID)
+		assert.NoError(t, err)
+		return rootID, childID, grandchildID
+	}
+
+	t.Run("Cascade deletes subtree", func(t *testing.T) {
+		repo, cleanup := setupTest(t)
+		defer cleanup()
+		router := gin.New()
+		router.DELETE("/node/:id", handlers.NewTreeHandler(repo).DeleteNode)
+
+		_, childID, grandchildID := buildTree(t, repo)
+
+		req, _ := http.NewRequest("DELETE", fmt.Sprintf("/node/%d", childID), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, "cascade", response["mode"])
+		assert.Equal(t, float64(2), response["deleted"])
+
+		_, err = repo.GetNode(context.Background(), grandchildID)
+		assert.ErrorIs(t, err, repository.ErrNodeNotFound)
+	})
+
+	t.Run("Reparent moves children to grandparent", func(t *testing.T) {
+		repo, cleanup := setupTest(t)
+		defer cleanup()
+		router := gin.New()
+		router.DELETE("/node/:id", handlers.NewTreeHandler(repo).DeleteNode)
+
+		rootID, childID, grandchildID := buildTree(t, repo)
+
+		req, _ := http.NewRequest("DELETE", fmt.Sprintf("/node/%d?mode=reparent", childID), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response map[string]interface{}
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		assert.Equal(t, float64(1), response["deleted"])
+
+		grandchild, err := repo.GetNode(context.Background(), grandchildID)
+		assert.NoError(t, err)
+		if assert.NotNil(t, grandchild.ParentID) {
+			assert.Equal(t, rootID, *grandchild.ParentID)
+		}
+	})
+
+	t.Run("Refuse with children returns conflict", func(t *testing.T) {
+		repo, cleanup := setupTest(t)
+		defer cleanup()
+		router := gin.New()
+		router.DELETE("/node/:id", handlers.NewTreeHandler(repo).DeleteNode)
+
+		rootID, _, grandchildID := buildTree(t, repo)
+
+		req, _ := http.NewRequest("DELETE", fmt.Sprintf("/node/%d?mode=refuse", rootID), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusConflict, w.Code)
+
+		// A leaf can still be deleted in refuse mode
+		req, _ = http.NewRequest("DELETE", fmt.Sprintf("/node/%d?mode=refuse", grandchildID), nil)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+	})
+
+	t.Run("Invalid requests", func(t *testing.T) {
+		repo, cleanup := setupTest(t)
+		defer cleanup()
+		router := gin.New()
+		router.DELETE("/node/:id", handlers.NewTreeHandler(repo).DeleteNode)
+
+		rootID, _, _ := buildTree(t, repo)
+
+		testCases := []struct {
+			name     string
+			path     string
+			expected int
+		}{
+			{name: "Unknown mode", path: fmt.Sprintf("/node/%d?mode=explode", rootID), expected: http.StatusBadRequest},
+			{name: "Invalid ID", path: "/node/abc", expected: http.StatusBadRequest},
+			{name: "Missing node", path: "/node/999", expected: http.StatusNotFound},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				req, _ := http.NewRequest("DELETE", tc.path, nil)
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, req)
+				assert.Equal(t, tc.expected, w.Code)
+			})
+		}
+	})
+}
+
+func TestGetNode(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	// Create root -> child -> grandchild -> great-grandchild
+	rootID, err := repo.CreateNode(context.Background(), "root", nil)
+	assert.NoError(t, err)
+	childID, err := repo.CreateNode(context.Background(), "child", &rootID)
+	assert.NoError(t, err)
+	grandchildID, err := repo.CreateNode(context.Background(), "grandchild", &childID)
+	assert.NoError(t, err)
+	_, err = repo.CreateNode(context.Background(), "great-grandchild", &grandchildID)
+	assert.NoError(t, err)
+
+	// Create handler
+	handler := handlers.NewTreeHandler(repo)
+
+	// Set up routes
+	router.GET("/node/:id", handler.GetNode)
+
+	// Whole subtree
+	req, _ := http.NewRequest("GET", fmt.Sprintf("/node/%d", childID), nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	var node models.Node
+	err = json.Unmarshal(w.Body.Bytes(), &node)
+	assert.NoError(t, err)
+	assert.Equal(t, childID, node.ID)
+	assert.Equal(t, "child", node.Label)
+	if assert.NotNil(t, node.ParentID) {
+		assert.Equal(t, rootID, *node.ParentID)
+	}
+	assert.False(t, node.CreatedAt.IsZero())
+	if assert.Len(t, node.Children, 1) {
+		assert.Equal(t, "grandchild", node.Children[0].Label)
+		assert.Len(t, node.Children[0].Children, 1)
+	}
+
+	// Depth-limited subtree
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/node/%d?depth=1", childID), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	node = models.Node{}
+	err = json.Unmarshal(w.Body.Bytes(), &node)
+	assert.NoError(t, err)
+	if assert.Len(t, node.Children, 1) {
+		assert.Empty(t, node.Children[0].Children)
+	}
+
+	// Only the node itself
+	req, _ = http.NewRequest("GET", fmt.Sprintf("/node/%d?depth=0", rootID), nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	node = models.Node{}
+	err = json.Unmarshal(w.Body.Bytes(), &node)
+	assert.NoError(t, err)
+	assert.Nil(t, node.ParentID)
+	assert.Empty(t, node.Children)
+
+	// Error cases
+	testCases := []struct {
+		name     string
+		path     string
+		expected int
+	}{
+		{name: "Missing node", path: "/node/999", expected: http.StatusNotFound},
+		{name: "Invalid ID", path: "/node/abc", expected: http.StatusBadRequest},
+		{name: "Negative depth", path: fmt.Sprintf("/node/%d?depth=-1", rootID), expected: http.StatusBadRequest},
+		{name: "Invalid depth", path: fmt.Sprintf("/node/%d?depth=deep", rootID), expected: http.StatusBadRequest},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", tc.path, nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.expected, w.Code)
+		})
+	}
+}
+
+func TestUpdateNodeCycle(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	// Create root -> child -> grandchild
+	rootID, err := repo.CreateNode(context.Background(), "root", nil)
+	assert.NoError(t, err)
+	childID, err := repo.CreateNode(context.Background(), "child", &rootID)
+	assert.NoError(t, err)
+	grandchildID, err := repo.CreateNode(context.Background(), "grandchild", &childID)
+	assert.NoError(t, err)
+	otherRootID, err := repo.CreateNode(context.Background(), "other", nil)
+	assert.NoError(t, err)
+
+	// Create handler
+	handler := handlers.NewTreeHandler(repo)
+
+	// Set up routes
+	router.PUT("/node/:id", handler.UpdateNode)
+
+	testCases := []struct {
+		name     string
+		nodeID   int64
+		parentID int64
+		expected int
+	}{
+		{name: "Under itself", nodeID: childID, parentID: childID, expected: http.StatusConflict},
+		{name: "Under own child", nodeID: childID, parentID: grandchildID, expected: http.StatusConflict},
+		{name: "Root under descendant", nodeID: rootID, parentID: grandchildID, expected: http.StatusConflict},
+		{name: "Missing parent", nodeID: childID, parentID: 999, expected: http.StatusNotFound},
+		{name: "Valid move", nodeID: childID, parentID: otherRootID, expected: http.StatusOK},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			parentID := tc.parentID
+			payload := models.UpdateNodeRequest{
+				Label:    "moved",
+				ParentID: &parentID,
+			}
+			jsonPayload, _ := json.Marshal(payload)
+			req, _ := http.NewRequest("PUT", fmt.Sprintf("/node/%d", tc.nodeID), bytes.NewBuffer(jsonPayload))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.expected, w.Code)
+		})
+	}
+
+	// Rejected moves must leave the tree untouched
+	grandchild, err := repo.GetNode(context.Background(), grandchildID)
+	assert.NoError(t, err)
+	if assert.NotNil(t, grandchild.ParentID) {
+		assert.Equal(t, childID, *grandchild.ParentID)
+	}
+	root, err := repo.GetNode(context.Background(), rootID)
+	assert.NoError(t, err)
+	assert.Nil(t, root.ParentID)
+
+	// The repository reports the dedicated error
+	err = repo.UpdateNode(context.Background(), otherRootID, "other", &grandchildID)
+	assert.ErrorIs(t, err, repository.ErrCycleDetected)
+}
+
+func TestGetTreeCursor(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	// Create five roots, each with one child
+	for i := 0; i < 5; i++ {
+		rootID, err := repo.CreateNode(context.Background(), fmt.Sprintf("root_%d", i+1), nil)
+		assert.NoError(t, err)
+		_, err = repo.CreateNode(context.Background(), fmt.Sprintf("child_%d", i+1), &rootID)
+		assert.NoError(t, err)
+	}
+
+	// Create handler
+	handler := handlers.NewTreeHandler(repo)
+
+	// Set up routes
+	router.GET("/tree", handler.GetTree)
+
+	getPage := func(query string) cache.PaginatedTreeResponse {
+		req, _ := http.NewRequest("GET", "/tree"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response cache.PaginatedTreeResponse
+		err := json.Unmarshal(w.Body.Bytes(), &response)
+		assert.NoError(t, err)
+		return response
+	}
+
+	// Walk every page by following nextCursor
+	var labels []string
+	response := getPage("?cursor=&pageSize=2")
+	assert.False(t, response.Pagination.HasPrev)
+	for pages := 1; ; pages++ {
+		for _, root := range response.Data {
+			labels = append(labels, root.Label)
+			assert.Len(t, root.Children, 1)
+		}
+		if !response.Pagination.HasNext {
+			assert.Empty(t, response.Pagination.NextCursor)
+			assert.Equal(t, 3, pages)
+			break
+		}
+		assert.NotEmpty(t, response.Pagination.NextCursor)
+		response = getPage("?pageSize=2&cursor=" + response.Pagination.NextCursor)
+		assert.True(t, response.Pagination.HasPrev)
+	}
+	assert.Equal(t, []string{"root_1", "root_2", "root_3", "root_4", "root_5"}, labels)
+
+	// Page-based responses hand out a cursor for the following page
+	pageResponse := getPage("?page=1&pageSize=2")
+	assert.NotEmpty(t, pageResponse.Pagination.NextCursor)
+
+	// Inserting a tree doesn't shift the pages that follow a cursor
+	_, err := repo.CreateNode(context.Background(), "root_6", nil)
+	assert.NoError(t, err)
+	cache.InvalidateCache()
+
+	response = getPage("?pageSize=2&cursor=" + pageResponse.Pagination.NextCursor)
+	if assert.Len(t, response.Data, 2) {
+		assert.Equal(t, "root_3", response.Data[0].Label)
+		assert.Equal(t, "root_4", response.Data[1].Label)
+	}
+
+	// Garbage cursors are rejected
+	req, _ := http.NewRequest("GET", "/tree?cursor=not-a-cursor", nil)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetAncestors(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	// Create root -> child -> grandchild
+	rootID, err := repo.CreateNode(context.Background(), "root", nil)
+	assert.NoError(t, err)
+	childID, err := repo.CreateNode(context.Background(), "child", &rootID)
+	assert.NoError(t, err)
+	grandchildID, err := repo.CreateNode(context.Background(), "grandchild", &childID)
+	assert.NoError(t, err)
+
+	// Create handler
+	handler := handlers.NewTreeHandler(repo)
+
+	// Set up routes
+	router.GET("/node/:id/ancestors", handler.GetAncestors)
+
+	testCases := []struct {
+		name           string
+		nodeID         string
+		expectedStatus int
+		expectedLabels []string
+	}{
+		{
+			name:           "Leaf node",
+			nodeID:         fmt.Sprint(grandchildID),
+			expectedStatus: http.StatusOK,
+			expectedLabels: []string{"root", "child", "grandchild"},
+		},
+		{
+			name:           "Root node",
+			nodeID:         fmt.Sprint(rootID),
+			expectedStatus: http.StatusOK,
+			expectedLabels: []string{"root"},
+		},
+		{
+			name:           "Unknown node",
+			nodeID:         "999",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "Invalid ID",
+			nodeID:         "abc",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/node/"+tc.nodeID+"/ancestors", nil)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, req)
+			assert.Equal(t, tc.expectedStatus, w.Code)
+
+			if tc.expectedStatus == http.StatusOK {
+				var response struct {
+					Data []*models.Node `json:"data"`
+				}
+				err := json.Unmarshal(w.Body.Bytes(), &response)
+				assert.NoError(t, err)
+
+				labels := make([]string, 0, len(response.Data))
+				for _, node := range response.Data {
+					labels = append(labels, node.Label)
+				}
+				assert.Equal(t, tc.expectedLabels, labels)
+				assert.Nil(t, response.Data[0].ParentID)
+			}
+		})
+	}
+}
+
+func TestImportTree(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	// Create an existing node to import under
+	parentID, err := repo.CreateNode(context.Background(), "existing", nil)
+	assert.NoError(t, err)
+
+	// Create handler with small limits
+	handler := handlers.NewTreeHandler(repo, handlers.WithImportLimits(5, 3))
+
+	// Set up routes
+	router.POST("/tree/import", handler.ImportTree)
+
+	importTree := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/tree/import", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// Import a nested document under the existing node
+	w := importTree(fmt.Sprintf(`{
+		"parentId": %d,
+		"root": {
+			"label": "imported",
+			"children": [
+				{"label": "first", "children": []},
+				{"label": "second", "children": [{"label": "nested"}]}
+			]
+		}
+	}`, parentID))
+	assert.Equal(t, http.StatusCreated, w.Code)
+
+	var response struct {
+		Created int              `json:"created"`
+		IDs     map[string]int64 `json:"ids"`
+	}
+	err = json.Unmarshal(w.Body.Bytes(), &response)
+	assert.NoError(t, err)
+	assert.Equal(t, 4, response.Created)
+	assert.Len(t, response.IDs, 4)
+
+	// The IDs map document paths onto the created structure
+	nested, err := repo.GetNode(context.Background(), response.IDs["/root/children/1/children/0"])
+	assert.NoError(t, err)
+	assert.Equal(t, "nested", nested.Label)
+	if assert.NotNil(t, nested.ParentID) {
+		assert.Equal(t, response.IDs["/root/children/1"], *nested.ParentID)
+	}
+	imported, err := repo.GetNode(context.Background(), response.IDs["/root"])
+	assert.NoError(t, err)
+	if assert.NotNil(t, imported.ParentID) {
+		assert.Equal(t, parentID, *imported.ParentID)
+	}
+
+	subtree, err := repo.GetSubtree(context.Background(), parentID, repository.UnlimitedDepth)
+	assert.NoError(t, err)
+	assert.Len(t, subtree, 5)
+
+	// Rejected imports create nothing
+	testCases := []struct {
+		name     string
+		body     string
+		expected int
+	}{
+		{
+			name:     "Too many nodes",
+			body:     `{"root": {"label": "a", "children": [{"label": "b"}, {"label": "c"}, {"label": "d"}, {"label": "e"}, {"label": "f"}]}}`,
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "Too deep",
+			body:     `{"root": {"label": "a", "children": [{"label": "b", "children": [{"label": "c", "children": [{"label": "d"}]}]}]}}`,
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "Empty nested label",
+			body:     `{"root": {"label": "a", "children": [{"label": ""}]}}`,
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "Missing root",
+			body:     `{}`,
+			expected: http.StatusBadRequest,
+		},
+		{
+			name:     "Unknown parent",
+			body:     `{"parentId": 999, "root": {"label": "a"}}`,
+			expected: http.StatusNotFound,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := importTree(tc.body)
+			assert.Equal(t, tc.expected, w.Code)
+		})
+	}
+
+	_, total, err := repo.GetAllNodes(context.Background(), 1, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+}
+
+func TestApplyBatch(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	existingID, err := repo.CreateNode(ctx, "existing", nil)
+	assert.NoError(t, err)
+	doomedID, err := repo.CreateNode(ctx, "doomed", &existingID)
+	assert.NoError(t, err)
+
+	// Create handler with a small limit
+	handler := handlers.NewTreeHandler(repo, handlers.WithBatchLimit(5))
+
+	// Set up routes
+	router.POST("/batch", handler.ApplyBatch)
+
+	applyBatch := func(body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", "/batch", bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	history := func() int64 {
+		_, total, err := repo.GetNodeHistory(ctx, existingID, 1, 10)
+		assert.NoError(t, err)
+		return total
+	}
+
+	t.Run("Operations refer to earlier creates by temp ID", func(t *testing.T) {
+		w := applyBatch(fmt.Sprintf(`{"operations": [
+			{"op": "create", "tempId": "a", "label": "a", "parentId": %d},
+			{"op": "create", "tempId": "b", "label": "b", "parentId": "a", "attributes": {"k": "v"}},
+			{"op": "update", "id": "a", "label": "renamed"},
+			{"op": "move", "id": "b", "parentId": null},
+			{"op": "delete", "id": %d, "mode": "refuse"}
+		]}`, existingID, doomedID))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		var response struct {
+			Results []*models.BatchResult `json:"results"`
+			IDs     map[string]int64      `json:"ids"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Len(t, response.Results, 5)
+		assert.Len(t, response.IDs, 2)
+		assert.Equal(t, response.IDs["a"], response.Results[2].ID)
+		assert.Equal(t, "b", response.Results[1].TempID)
+		assert.Equal(t, doomedID, response.Results[4].ID)
+		assert.Equal(t, int64(1), response.Results[4].Deleted)
+
+		a, err := repo.GetNode(ctx, response.IDs["a"])
+		assert.NoError(t, err)
+		assert.Equal(t, "renamed", a.Label)
+		if assert.NotNil(t, a.ParentID) {
+			assert.Equal(t, existingID, *a.ParentID)
+		}
+		b, err := repo.GetNode(ctx, response.IDs["b"])
+		assert.NoError(t, err)
+		assert.Nil(t, b.ParentID)
+		assert.Equal(t, "v", b.Attributes["k"])
+		_, err = repo.GetNode(ctx, doomedID)
+		assert.ErrorIs(t, err, repository.ErrNodeNotFound)
+	})
+
+	t.Run("A failing operation rolls back the whole batch", func(t *testing.T) {
+		_, before, err := repo.GetAllNodes(ctx, 1, 10)
+		assert.NoError(t, err)
+		historyBefore := history()
+
+		testCases := []struct {
+			name     string
+			body     string
+			expected int
+			index    int
+		}{
+			{
+				name:     "Unknown node",
+				body:     fmt.Sprintf(`{"operations": [{"op": "update", "id": %d, "label": "x"}, {"op": "delete", "id": 999}]}`, existingID),
+				expected: http.StatusNotFound,
+				index:    1,
+			},
+			{
+				name:     "Cycle",
+				body:     fmt.Sprintf(`{"operations": [{"op": "create", "tempId": "c", "label": "c", "parentId": %d}, {"op": "move", "id": %d, "parentId": "c"}]}`, existingID, existingID),
+				expected: http.StatusConflict,
+				index:    1,
+			},
+			{
+				name:     "Version conflict",
+				body:     fmt.Sprintf(`{"operations": [{"op": "create", "label": "c"}, {"op": "update", "id": %d, "label": "x", "version": 99}]}`, existingID),
+				expected: http.StatusPreconditionFailed,
+				index:    1,
+			},
+			{
+				name:     "Node deleted earlier in the batch",
+				body:     fmt.Sprintf(`{"operations": [{"op": "delete", "id": %d}, {"op": "create", "label": "c", "parentId": %d}]}`, existingID, existingID),
+				expected: http.StatusNotFound,
+				index:    1,
+			},
+			{
+				name:     "Temp ID used before its create",
+				body:     `{"operations": [{"op": "create", "label": "c", "parentId": "d"}, {"op": "create", "tempId": "d", "label": "d"}]}`,
+				expected: http.StatusBadRequest,
+				index:    0,
+			},
+			{
+				name:     "Duplicate temp ID",
+				body:     `{"operations": [{"op": "create", "tempId": "d", "label": "d"}, {"op": "create", "tempId": "d", "label": "d"}]}`,
+				expected: http.StatusBadRequest,
+				index:    1,
+			},
+			{
+				name:     "Invalid operation",
+				body:     fmt.Sprintf(`{"operations": [{"op": "create", "label": "c"}, {"op": "update", "id": %d}]}`, existingID),
+				expected: http.StatusBadRequest,
+				index:    1,
+			},
+		}
+
+		for _, tc := range testCases {
+			t.Run(tc.name, func(t *testing.T) {
+				w := applyBatch(tc.body)
+				assert.Equal(t, tc.expected, w.Code)
+				var response struct {
+					Error string `json:"error"`
+					Index *int   `json:"index"`
+				}
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+				assert.NotEmpty(t, response.Error)
+				if assert.NotNil(t, response.Index) {
+					assert.Equal(t, tc.index, *response.Index)
+				}
+			})
+		}
+
+		_, after, err := repo.GetAllNodes(ctx, 1, 10)
+		assert.NoError(t, err)
+		assert.Equal(t, before, after)
+		assert.Equal(t, historyBefore, history())
+		existing, err := repo.GetNode(ctx, existingID)
+		assert.NoError(t, err)
+		assert.Equal(t, "existing", existing.Label)
+	})
+
+	t.Run("Malformed requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, applyBatch(`{}`).Code)
+		assert.Equal(t, http.StatusBadRequest, applyBatch(`{"operations": []}`).Code)
+		assert.Equal(t, http.StatusBadRequest, applyBatch(`{"operations": [{"op": "rename", "id": 1}]}`).Code)
+		assert.Equal(t, http.StatusBadRequest, applyBatch(`{"operations": [{"op": "delete", "id": 0}]}`).Code)
+		tooMany := strings.Repeat(`{"op": "create", "label": "x"},`, 6)
+		assert.Equal(t, http.StatusBadRequest, applyBatch(`{"operations": [`+strings.TrimSuffix(tooMany, ",")+`]}`).Code)
+	})
+}
+
+func TestExportTree(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	// Create a tree root -> child -> "grand \"child\"" and a second lone root
+	rootID, err := repo.CreateNode(context.Background(), "root", nil)
+	assert.NoError(t, err)
+	childID, err := repo.CreateNode(context.Background(), "child", &rootID)
+	assert.NoError(t, err)
+	grandchildID, err := repo.CreateNode(context.Background(), `grand "child"`, &childID)
+	assert.NoError(t, err)
+	otherID, err := repo.CreateNode(context.Background(), "other", nil)
+	assert.NoError(t, err)
+
+	// Create handler
+	handler := handlers.NewTreeHandler(repo)
+
+	// Set up routes
+	router.GET("/tree/export", handler.ExportTree)
+
+	exportTree := func(query string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", "/tree/export"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// JSON is the default and matches the nested GET /api/tree shape
+	w := exportTree("")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Contains(t, w.Header().Get("Content-Disposition"), `filename="tree.json"`)
+	var trees []*models.Node
+	err = json.Unmarshal(w.Body.Bytes(), &trees)
+	assert.NoError(t, err)
+	if assert.Len(t, trees, 2) {
+		assert.Equal(t, rootID, trees[0].ID)
+		assert.Equal(t, otherID, trees[1].ID)
+		if assert.Len(t, trees[0].Children, 1) {
+			assert.Len(t, trees[0].Children[0].Children, 1)
+		}
+	}
+
+	// CSV has one row per node with depth and path relative to the roots
+	w = exportTree("?format=csv")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, fmt.Sprintf(
+		"id,parent_id,label,depth,path\n"+
+			"%[1]d,,root,0,/%[1]d\n"+
+			"%[2]d,%[1]d,child,1,/%[1]d/%[2]d\n"+
+			"%[3]d,%[2]d,\"grand \"\"child\"\"\",2,/%[1]d/%[2]d/%[3]d\n"+
+			"%[4]d,,other,0,/%[4]d\n",
+		rootID, childID, grandchildID, otherID), w.Body.String())
+
+	// Exporting from a node restarts depth and path at that node
+	w = exportTree(fmt.Sprintf("?format=csv&rootId=%d", childID))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, fmt.Sprintf(
+		"id,parent_id,label,depth,path\n"+
+			"%[2]d,%[1]d,child,0,/%[2]d\n"+
+			"%[3]d,%[2]d,\"grand \"\"child\"\"\",1,/%[2]d/%[3]d\n",
+		rootID, childID, grandchildID), w.Body.String())
+
+	// DOT declares every node and edge
+	w = exportTree("?format=dot")
+	assert.Equal(t, http.StatusOK, w.Code)
+	body := w.Body.String()
+	assert.True(t, strings.HasPrefix(body, "digraph tree {"))
+	assert.Contains(t, body, fmt.Sprintf(`n%d [label="grand \"child\""];`, grandchildID))
+	assert.Contains(t, body, fmt.Sprintf("n%d -> n%d;", rootID, childID))
+	assert.Contains(t, body, fmt.Sprintf("n%d -> n%d;", childID, grandchildID))
+	assert.True(t, strings.HasSuffix(strings.TrimSpace(body), "}"))
+
+	// Mermaid writes a top-down flowchart
+	w = exportTree("?format=mermaid")
+	assert.Equal(t, http.StatusOK, w.Code)
+	body = w.Body.String()
+	assert.True(t, strings.HasPrefix(body, "flowchart TD"))
+	assert.Contains(t, body, fmt.Sprintf(`n%d["grand #quot;child#quot;"]`, grandchildID))
+	assert.Contains(t, body, fmt.Sprintf("n%d --> n%d", rootID, childID))
+
+	// Unknown formats and bad roots are rejected before anything is written
+	w = exportTree("?format=xml")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = exportTree("?rootId=abc")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = exportTree("?rootId=999")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestMoveNode(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	// Create a root with children a, b, c and a second root with child d
+	rootID, err := repo.CreateNode(context.Background(), "root", nil)
+	assert.NoError(t, err)
+	aID, err := repo.CreateNode(context.Background(), "a", &rootID)
+	assert.NoError(t, err)
+	bID, err := repo.CreateNode(context.Background(), "b", &rootID)
+	assert.NoError(t, err)
+	cID, err := repo.CreateNode(context.Background(), "c", &rootID)
+	assert.NoError(t, err)
+	otherID, err := repo.CreateNode(context.Background(), "other", nil)
+	assert.NoError(t, err)
+	dID, err := repo.CreateNode(context.Background(), "d", &otherID)
+	assert.NoError(t, err)
+
+	// Create handler
+	handler := handlers.NewTreeHandler(repo)
+
+	// Set up routes
+	router.GET("/node/:id", handler.GetNode)
+	router.POST("/node/:id/move", handler.MoveNode)
+
+	moveNode := func(id int64, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("POST", fmt.Sprintf("/node/%d/move", id), bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	childOrder := func(id int64) []string {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/node/%d?depth=1", id), nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var node models.Node
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &node))
+		labels := make([]string, 0, len(node.Children))
+		for _, child := range node.Children {
+			labels = append(labels, child.Label)
+		}
+		return labels
+	}
+
+	// New children are appended in creation order
+	assert.Equal(t, []string{"a", "b", "c"}, childOrder(rootID))
+
+	// Reorder within the same parent without repeating parentId
+	w := moveNode(cID, fmt.Sprintf(`{"beforeId": %d}`, aID))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"c", "a", "b"}, childOrder(rootID))
+
+	w = moveNode(cID, fmt.Sprintf(`{"afterId": %d}`, aID))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"a", "c", "b"}, childOrder(rootID))
+
+	w = moveNode(aID, `{"index": 2}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"c", "b", "a"}, childOrder(rootID))
+
+	// Move under another parent, placed before its existing child
+	w = moveNode(bID, fmt.Sprintf(`{"parentId": %d, "index": 0}`, otherID))
+	assert.Equal(t, http.StatusOK, w.Code)
+	var moved models.Node
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &moved))
+	if assert.NotNil(t, moved.ParentID) {
+		assert.Equal(t, otherID, *moved.ParentID)
+	}
+	assert.Equal(t, []string{"c", "a"}, childOrder(rootID))
+	assert.Equal(t, []string{"b", "d"}, childOrder(otherID))
+
+	// Without a placement the node goes last
+	w = moveNode(cID, fmt.Sprintf(`{"parentId": %d}`, otherID))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []string{"b", "d", "c"}, childOrder(otherID))
+
+	// An explicit null parent makes the node a root
+	w = moveNode(dID, `{"parentId": null}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	node, err := repo.GetNode(context.Background(), dID)
+	assert.NoError(t, err)
+	assert.Nil(t, node.ParentID)
+
+	// Invalid placements
+	w = moveNode(aID, fmt.Sprintf(`{"beforeId": %d, "index": 0}`, bID))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = moveNode(aID, `{"index": -1}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = moveNode(aID, fmt.Sprintf(`{"beforeId": %d}`, bID)) // b is not a's sibling
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = moveNode(aID, `{"parentId": null, "index": 0}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// Cycles and unknown nodes
+	w = moveNode(otherID, fmt.Sprintf(`{"parentId": %d}`, bID))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = moveNode(999, `{"index": 0}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = moveNode(aID, `{"parentId": 999}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestMoveNodeRebalancesExhaustedGaps(t *testing.T) {
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	rootID, err := repo.CreateNode(ctx, "root", nil)
+	assert.NoError(t, err)
+	firstID, err := repo.CreateNode(ctx, "first", &rootID)
+	assert.NoError(t, err)
+	_, err = repo.CreateNode(ctx, "last", &rootID)
+	assert.NoError(t, err)
+
+	// Repeatedly inserting right after the same node halves the same gap
+	// until floating point runs out and the siblings must be renumbered
+	expected := []string{"first"}
+	for i := 0; i < 80; i++ {
+		label := fmt.Sprintf("n%d", i)
+		id, err := repo.CreateNode(ctx, label, &rootID)
+		assert.NoError(t, err)
+		err = repo.MoveNode(ctx, id, &rootID, repository.MovePosition{AfterID: &firstID})
+		assert.NoError(t, err)
+		expected = append([]string{"first", label}, expected[1:]...)
+	}
+	expected = append(expected, "last")
+
+	nodes, err := repo.GetSubtree(ctx, rootID, 1)
+	assert.NoError(t, err)
+	trees, err := handlers.BuildTreeFromNodes(nodes)
+	assert.NoError(t, err)
+	labels := make([]string, 0, len(expected))
+	for _, child := range trees[0].Children {
+		labels = append(labels, child.Label)
+	}
+	assert.Equal(t, expected, labels)
+}
+
+func TestUpdateNodeWithoutParentMakesRoot(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	rootID, err := repo.CreateNode(context.Background(), "root", nil)
+	assert.NoError(t, err)
+	childID, err := repo.CreateNode(context.Background(), "child", &rootID)
+	assert.NoError(t, err)
+
+	// Create handler
+	handler := handlers.NewTreeHandler(repo)
+
+	// Set up routes
+	router.PUT("/node/:id", handler.UpdateNode)
+
+	// PUT replaces the whole node, so a label-only body detaches it
+	req, _ := http.NewRequest("PUT", fmt.Sprintf("/node/%d", childID), bytes.NewBufferString(`{"label": "renamed"}`))
+	req.Header.Set("Content-Type", "application/json")
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	node, err := repo.GetNode(context.Background(), childID)
+	assert.NoError(t, err)
+	assert.Equal(t, "renamed", node.Label)
+	assert.Nil(t, node.ParentID)
+
+	// And the label is mandatory
+	req, _ = http.NewRequest("PUT", fmt.Sprintf("/node/%d", childID), bytes.NewBufferString(fmt.Sprintf(`{"parentId": %d}`, rootID)))
+	req.Header.Set("Content-Type", "application/json")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestPatchNode(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	rootID, err := repo.CreateNode(context.Background(), "root", nil)
+	assert.NoError(t, err)
+	otherID, err := repo.CreateNode(context.Background(), "other", nil)
+	assert.NoError(t, err)
+	childID, err := repo.CreateNode(context.Background(), "child", &rootID)
+	assert.NoError(t, err)
+
+	// Create handler
+	handler := handlers.NewTreeHandler(repo)
+
+	// Set up routes
+	router.PATCH("/node/:id", handler.PatchNode)
+
+	patchNode := func(id int64, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("PATCH", fmt.Sprintf("/node/%d", id), bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	// A label-only patch keeps the parent
+	w := patchNode(childID, `{"label": "renamed"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var response models.Node
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+	assert.Equal(t, "renamed", response.Label)
+	if assert.NotNil(t, response.ParentID) {
+		assert.Equal(t, rootID, *response.ParentID)
+	}
+
+	// A parent-only patch keeps the label
+	w = patchNode(childID, fmt.Sprintf(`{"parentId": %d}`, otherID))
+	assert.Equal(t, http.StatusOK, w.Code)
+	node, err := repo.GetNode(context.Background(), childID)
+	assert.NoError(t, err)
+	assert.Equal(t, "renamed", node.Label)
+	if assert.NotNil(t, node.ParentID) {
+		assert.Equal(t, otherID, *node.ParentID)
+	}
+
+	// An explicit null makes the node a root
+	w = patchNode(childID, `{"parentId": null}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	node, err = repo.GetNode(context.Background(), childID)
+	assert.NoError(t, err)
+	assert.Equal(t, "renamed", node.Label)
+	assert.Nil(t, node.ParentID)
+
+	// Invalid patches
+	w = patchNode(childID, `{}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = patchNode(childID, `{"label": ""}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = patchNode(childID, `{"parentId": 0}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = patchNode(rootID, fmt.Sprintf(`{"parentId": %d}`, rootID))
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = patchNode(999, `{"label": "missing"}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = patchNode(childID, `{"parentId": 999}`)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
+
+func TestNodeAttributes(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	// Create handler with a small size limit and lowercase keys
+	handler := handlers.NewTreeHandler(repo, handlers.WithAttributeLimits(models.AttributeLimits{
+		MaxBytes:   64,
+		KeyPattern: regexp.MustCompile(`^[a-z]+$`),
+	}))
+
+	// Set up routes
+	router.POST("/tree", handler.CreateNode)
+	router.GET("/node/:id", handler.GetNode)
+	router.PUT("/node/:id", handler.UpdateNode)
+	router.PATCH("/node/:id", handler.PatchNode)
+
+	send := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, bytes.NewBufferString(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	getAttributes := func(id int64) map[string]interface{} {
+		w := send("GET", fmt.Sprintf("/node/%d", id), "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var node models.Node
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &node))
+		return node.Attributes
+	}
+
+	// Attributes are stored on create and returned on reads
+	w := send("POST", "/tree", `{"label": "root", "attributes": {"status": "active", "count": 3}}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	assert.Equal(t, map[string]interface{}{"status": "active", "count": float64(3)}, getAttributes(created.ID))
+
+	// PUT replaces them, and without attributes clears them like the parent
+	w = send("PUT", fmt.Sprintf("/node/%d", created.ID), `{"label": "renamed", "attributes": {"status": "draft"}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, map[string]interface{}{"status": "draft"}, getAttributes(created.ID))
+	w = send("PUT", fmt.Sprintf("/node/%d", created.ID), `{"label": "renamed"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, getAttributes(created.ID))
+
+	// The Lambda handler's PUT does the same
+	assert.NoError(t, repo.PatchNode(context.Background(), created.ID, repository.NodePatch{
+		Attributes: map[string]interface{}{"status": "active"},
+	}))
+	response, err := lambda.NewHandler(repo).Handle(context.Background(), events.APIGatewayProxyRequest{
+		HTTPMethod: "PUT",
+		Path:       fmt.Sprintf("/api/node/%d", created.ID),
+		Body:       `{"label": "lambda"}`,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Empty(t, getAttributes(created.ID))
+
+	// PATCH without attributes keeps them
+	w = send("PATCH", fmt.Sprintf("/node/%d", created.ID), `{"attributes": {"status": "active"}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = send("PATCH", fmt.Sprintf("/node/%d", created.ID), `{"label": "patched"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, map[string]interface{}{"status": "active"}, getAttributes(created.ID))
+
+	// PATCH replaces them, and an empty object clears them
+	w = send("PATCH", fmt.Sprintf("/node/%d", created.ID), `{"attributes": {"status": "archived"}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, map[string]interface{}{"status": "archived"}, getAttributes(created.ID))
+	w = send("PATCH", fmt.Sprintf("/node/%d", created.ID), `{"attributes": {}}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, getAttributes(created.ID))
+
+	// Oversized attributes and keys not matching the pattern are rejected
+	w = send("POST", "/tree", fmt.Sprintf(`{"label": "big", "attributes": {"note": %q}}`, strings.Repeat("x", 64)))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = send("POST", "/tree", `{"label": "bad", "attributes": {"Status": "active"}}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = send("PATCH", fmt.Sprintf("/node/%d", created.ID), `{"attributes": {"": "empty"}}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestGetTreeAttributeFilter(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// root1 -> a (active) -> a1 (inactive)
+	//       -> b (inactive)
+	// root2 -> c (inactive)
+	// root3 (active, priority 2)
+	root1, err := repo.CreateNode(ctx, "root1", nil)
+	assert.NoError(t, err)
+	aID, err := repo.CreateNodeWithAttributes(ctx, "a", &root1, map[string]interface{}{"status": "active", "priority": 1})
+	assert.NoError(t, err)
+	_, err = repo.CreateNodeWithAttributes(ctx, "a1", &aID, map[string]interface{}{"status": "inactive"})
+	assert.NoError(t, err)
+	_, err = repo.CreateNodeWithAttributes(ctx, "b", &root1, map[string]interface{}{"status": "inactive"})
+	assert.NoError(t, err)
+	root2, err := repo.CreateNode(ctx, "root2", nil)
+	assert.NoError(t, err)
+	_, err = repo.CreateNodeWithAttributes(ctx, "c", &root2, map[string]interface{}{"status": "inactive"})
+	assert.NoError(t, err)
+	root3, err := repo.CreateNodeWithAttributes(ctx, "root3", nil, map[string]interface{}{"status": "active", "priority": 2})
+	assert.NoError(t, err)
+
+	// Create handler
+	handler := handlers.NewTreeHandler(repo)
+
+	// Set up routes
+	router.GET("/tree", handler.GetTree)
+
+	getTree := func(query string) (*httptest.ResponseRecorder, cache.PaginatedTreeResponse) {
+		req, _ := http.NewRequest("GET", "/tree"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response cache.PaginatedTreeResponse
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		}
+		return w, response
+	}
+
+	// Matches keep their ancestors but drop non-matching siblings and children
+	w, response := getTree("?attr.status=active")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(2), response.Pagination.Total)
+	if assert.Len(t, response.Data, 2) {
+		assert.Equal(t, root1, response.Data[0].ID)
+		if assert.Len(t, response.Data[0].Children, 1) {
+			assert.Equal(t, aID, response.Data[0].Children[0].ID)
+			assert.Empty(t, response.Data[0].Children[0].Children)
+		}
+		assert.Equal(t, root3, response.Data[1].ID)
+	}
+
+	// Filters combine, and non-string values compare by their JSON text
+	w, response = getTree("?attr.status=active&attr.priority=2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	if assert.Len(t, response.Data, 1) {
+		assert.Equal(t, root3, response.Data[0].ID)
+		assert.Equal(t, "active", response.Data[0].Attributes["status"])
+	}
+
+	// Filtered results are paginated by root
+	w, response = getTree("?attr.status=inactive&pageSize=1&page=2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(2), response.Pagination.Total)
+	assert.True(t, response.Pagination.HasPrev)
+	if assert.Len(t, response.Data, 1) {
+		assert.Equal(t, root2, response.Data[0].ID)
+	}
+
+	// No matches is an empty page
+	w, response = getTree("?attr.status=deleted")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, response.Data)
+	assert.Equal(t, int64(0), response.Pagination.Total)
+
+	// Filters don't combine with cursors and must name a key
+	w, _ = getTree("?attr.status=active&cursor=")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = getTree("?attr.=active")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// The Lambda handler applies the same filters, bypassing its cache
+	lambdaHandler := lambda.NewHandler(repo)
+	invoke := func(query map[string]string) (events.APIGatewayProxyResponse, cache.PaginatedTreeResponse) {
+		response, err := lambdaHandler.Handle(ctx, events.APIGatewayProxyRequest{
+			HTTPMethod:            "GET",
+			Path:                  "/api/tree",
+			QueryStringParameters: query,
+		})
+		assert.NoError(t, err)
+		var page cache.PaginatedTreeResponse
+		if response.StatusCode == http.StatusOK {
+			assert.NoError(t, json.Unmarshal([]byte(response.Body), &page))
+		}
+		return response, page
+	}
+
+	// Warm the cache with the unfiltered first page
+	result, page := invoke(nil)
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Len(t, page.Data, 3)
+
+	result, page = invoke(map[string]string{"attr.status": "active"})
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Equal(t, int64(2), page.Pagination.Total)
+	if assert.Len(t, page.Data, 2) {
+		assert.Equal(t, root1, page.Data[0].ID)
+		if assert.Len(t, page.Data[0].Children, 1) {
+			assert.Equal(t, aID, page.Data[0].Children[0].ID)
+		}
+		assert.Equal(t, root3, page.Data[1].ID)
+	}
+
+	result, page = invoke(map[string]string{"attr.status": "deleted"})
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	assert.Empty(t, page.Data)
+
+	result, _ = invoke(map[string]string{"attr.status": "active", "cursor": ""})
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+	result, _ = invoke(map[string]string{"attr.": "active"})
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+}
+
+func TestSearch(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+	ctx := context.Background()
+
+	// Projects -> Alpha project -> alpha tasks
+	// Beta -> Project plan
+	projectsID, err := repo.CreateNode(ctx, "Projects", nil)
+	assert.NoError(t, err)
+	alphaID, err := repo.CreateNode(ctx, "Alpha project", &projectsID)
+	assert.NoError(t, err)
+	tasksID, err := repo.CreateNode(ctx, "alpha tasks", &alphaID)
+	assert.NoError(t, err)
+	betaID, err := repo.CreateNode(ctx, "Beta", nil)
+	assert.NoError(t, err)
+	planID, err := repo.CreateNode(ctx, "Project plan", &betaID)
+	assert.NoError(t, err)
+
+	// Create handler
+	handler := handlers.NewTreeHandler(repo)
+
+	// Set up routes
+	router.GET("/search", handler.Search)
+
+	type searchResponse struct {
+		Data       []*models.SearchHit `json:"data"`
+		Pagination struct {
+			Total   int64 `json:"total"`
+			HasNext bool  `json:"hasNext"`
+		} `json:"pagination"`
+	}
+	search := func(query string) (*httptest.ResponseRecorder, searchResponse) {
+		req, _ := http.NewRequest("GET", "/search"+query, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		var response searchResponse
+		if w.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		}
+		return w, response
+	}
+	hitIDs := func(response searchResponse) []int64 {
+		ids := make([]int64, 0, len(response.Data))
+		for _, hit := range response.Data {
+			ids = append(ids, hit.Node.ID)
+		}
+		return ids
+	}
+
+	// Prefix matches outrank substring matches, ties are ordered by ID
+	w, response := search("?q=PROJECT")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(3), response.Pagination.Total)
+	assert.Equal(t, []int64{projectsID, planID, alphaID}, hitIDs(response))
+	assert.Greater(t, response.Data[0].Score, response.Data[2].Score)
+
+	// Hits carry their ancestor path from the root
+	w, response = search("?q=tasks")
+	assert.Equal(t, http.StatusOK, w.Code)
+	if assert.Len(t, response.Data, 1) {
+		assert.Equal(t, tasksID, response.Data[0].Node.ID)
+		assert.Equal(t, []*models.PathNode{
+			{ID: projectsID, Label: "Projects"},
+			{ID: alphaID, Label: "Alpha project"},
+		}, response.Data[0].Path)
+	}
+
+	// Exact matches score highest
+	w, response = search("?q=beta")
+	assert.Equal(t, http.StatusOK, w.Code)
+	if assert.Len(t, response.Data, 1) {
+		assert.Equal(t, 1.0, response.Data[0].Score)
+		assert.Empty(t, response.Data[0].Path)
+	}
+
+	// Typos still match by trigram similarity, with a lower score
+	w, response = search("?q=projcts")
+	assert.Equal(t, http.StatusOK, w.Code)
+	if assert.Len(t, response.Data, 1) {
+		assert.Equal(t, projectsID, response.Data[0].Node.ID)
+		assert.Less(t, response.Data[0].Score, 0.8)
+	}
+
+	// LIKE wildcards are matched literally
+	w, response = search("?q=%25")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Empty(t, response.Data)
+
+	// Results can be scoped to a subtree and paginated
+	w, response = search(fmt.Sprintf("?q=project&rootId=%d", betaID))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []int64{planID}, hitIDs(response))
+
+	w, response = search("?q=project&pageSize=2&page=2")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, int64(3), response.Pagination.Total)
+	assert.False(t, response.Pagination.HasNext)
+	assert.Equal(t, []int64{alphaID}, hitIDs(response))
+
+	// Invalid requests
+	w, _ = search("?q=")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w, _ = search("?q=project&rootId=999")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w, _ = search("?q=project&pageSize=0")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestNamedTrees(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	handler := handlers.NewTreeHandler(repo)
+	router.GET("/tree", handler.GetTree)
+	router.POST("/tree", handler.CreateNode)
+	router.GET("/trees", handler.ListTrees)
+	router.POST("/trees", handler.CreateTree)
+	trees := router.Group("/trees/:treeId", handler.TreeScope())
+	trees.GET("", handler.GetTreeInfo)
+	trees.DELETE("", handler.DeleteTree)
+	trees.GET("/tree", handler.GetTree)
+	trees.POST("/tree", handler.CreateNode)
+	trees.GET("/node/:id", handler.GetNode)
+	trees.PATCH("/node/:id", handler.PatchNode)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	getTree := func(path string) cache.PaginatedTreeResponse {
+		w := do("GET", path, "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response cache.PaginatedTreeResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+
+	// Create a named tree; names are unique
+	w := do("POST", "/trees", `{"name": "work"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var work models.Tree
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &work))
+	assert.Equal(t, "work", work.Name)
+
+	w = do("POST", "/trees", `{"name": "work"}`)
+	assert.Equal(t, http.StatusConflict, w.Code)
+	w = do("POST", "/trees", `{"name": " "}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	var list struct {
+		Data []*models.Tree `json:"data"`
+	}
+	w = do("GET", "/trees", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+	if assert.Len(t, list.Data, 2) {
+		assert.Equal(t, repository.DefaultTreeID, list.Data[0].ID)
+		assert.Equal(t, work.ID, list.Data[1].ID)
+	}
+
+	workPath := fmt.Sprintf("/trees/%d", work.ID)
+	w = do("GET", workPath, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// Nodes in one tree are invisible to the others
+	defaultRoot, err := repo.CreateNode(context.Background(), "default root", nil)
+	assert.NoError(t, err)
+
+	w = do("POST", workPath+"/tree", `{"label": "work root"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	workRoot := created.ID
+
+	response := getTree(workPath + "/tree")
+	if assert.Len(t, response.Data, 1) {
+		assert.Equal(t, "work root", response.Data[0].Label)
+	}
+	response = getTree("/tree")
+	if assert.Len(t, response.Data, 1) {
+		assert.Equal(t, "default root", response.Data[0].Label)
+	}
+
+	w = do("GET", fmt.Sprintf("%s/node/%d", workPath, defaultRoot), "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+
+	// Parents must be in the same tree
+	w = do("POST", workPath+"/tree", fmt.Sprintf(`{"label": "stray", "parentId": %d}`, defaultRoot))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = do("POST", "/tree", fmt.Sprintf(`{"label": "stray", "parentId": %d}`, workRoot))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = do("POST", workPath+"/tree", `{"label": "task"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	w = do("PATCH", fmt.Sprintf("%s/node/%d", workPath, created.ID), fmt.Sprintf(`{"parentId": %d}`, defaultRoot))
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	// The Lambda handler rejects them too
+	lambdaHandler := lambda.NewHandler(repo)
+	invoke := func(method, path, body string) events.APIGatewayProxyResponse {
+		response, err := lambdaHandler.Handle(context.Background(), events.APIGatewayProxyRequest{
+			HTTPMethod: method,
+			Path:       path,
+			Body:       body,
+		})
+		assert.NoError(t, err)
+		return response
+	}
+	result := invoke("POST", "/api/tree", fmt.Sprintf(`{"label": "stray", "parentId": %d}`, workRoot))
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+	assert.Contains(t, result.Body, "another tree")
+	result = invoke("PUT", fmt.Sprintf("/api/node/%d", defaultRoot), fmt.Sprintf(`{"label": "stray", "parentId": %d}`, workRoot))
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+	assert.Contains(t, result.Body, "another tree")
+	result = invoke("POST", fmt.Sprintf("/api%s/tree", workPath), fmt.Sprintf(`{"label": "stray", "parentId": %d}`, defaultRoot))
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+	assert.Contains(t, result.Body, "another tree")
+
+	// ...and serves each tree under /api/trees/{treeId}
+	result = invoke("POST", fmt.Sprintf("/api%s/tree", workPath), `{"label": "lambda root"}`)
+	assert.Equal(t, http.StatusCreated, result.StatusCode)
+	var lambdaCreated struct {
+		ID       int64  `json:"id"`
+		ParentID *int64 `json:"parentId"`
+	}
+	assert.NoError(t, json.Unmarshal([]byte(result.Body), &lambdaCreated))
+	assert.Nil(t, lambdaCreated.ParentID, "roots have a null parentId")
+	assert.Contains(t, result.Body, `"parentId":null`)
+	result = invoke("GET", fmt.Sprintf("/api%s/node/%d", workPath, lambdaCreated.ID), "")
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	result = invoke("GET", fmt.Sprintf("/api/node/%d", lambdaCreated.ID), "")
+	assert.Equal(t, http.StatusNotFound, result.StatusCode)
+	result = invoke("GET", fmt.Sprintf("/api%s/node/%d", workPath, defaultRoot), "")
+	assert.Equal(t, http.StatusNotFound, result.StatusCode)
+	result = invoke("GET", fmt.Sprintf("/api%s/tree", workPath), "")
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	var lambdaPage cache.PaginatedTreeResponse
+	assert.NoError(t, json.Unmarshal([]byte(result.Body), &lambdaPage))
+	if assert.Len(t, lambdaPage.Data, 3) {
+		assert.Equal(t, workRoot, lambdaPage.Data[0].ID)
+		assert.Equal(t, lambdaCreated.ID, lambdaPage.Data[2].ID)
+	}
+	result = invoke("DELETE", fmt.Sprintf("/api%s/node/%d", workPath, lambdaCreated.ID), "")
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	result = invoke("GET", "/api/trees/abc/tree", "")
+	assert.Equal(t, http.StatusBadRequest, result.StatusCode)
+	result = invoke("GET", "/api/trees/999999/tree", "")
+	assert.Equal(t, http.StatusNotFound, result.StatusCode)
+
+	// Lambda writes to a tree only invalidate that tree's cached pages
+	result = invoke("GET", "/api/tree", "")
+	assert.Equal(t, http.StatusOK, result.StatusCode)
+	_, found := cache.ForTree(repository.DefaultTreeID).GetPaginatedTree(1, 100)
+	assert.True(t, found)
+	result = invoke("POST", fmt.Sprintf("/api%s/tree", workPath), `{"label": "lambda scratch"}`)
+	assert.Equal(t, http.StatusCreated, result.StatusCode)
+	_, found = cache.ForTree(repository.DefaultTreeID).GetPaginatedTree(1, 100)
+	assert.True(t, found)
+
+	// Writes to one tree leave the other trees' cached pages in place
+	getTree("/tree")
+	getTree(workPath + "/tree")
+	_, found = cache.ForTree(repository.DefaultTreeID).GetPaginatedTree(1, 10)
+	assert.True(t, found)
+	w = do("POST", workPath+"/tree", `{"label": "another work root"}`)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	_, found = cache.ForTree(work.ID).GetPaginatedTree(1, 10)
+	assert.False(t, found)
+	_, found = cache.ForTree(repository.DefaultTreeID).GetPaginatedTree(1, 10)
+	assert.True(t, found)
+
+	// Deleting a tree removes its nodes, trashed ones included; the default
+	// tree can't be deleted
+	w = do("DELETE", workPath, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	var deleted struct {
+		Deleted int64 `json:"deleted"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &deleted))
+	assert.Equal(t, int64(5), deleted.Deleted)
+
+	w = do("GET", workPath+"/tree", "")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	w = do("DELETE", fmt.Sprintf("/trees/%d", repository.DefaultTreeID), "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = do("GET", "/trees/abc/tree", "")
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+}
+
+func TestOptimisticConcurrency(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	nodeID, err := repo.CreateNode(context.Background(), "root", nil)
+	assert.NoError(t, err)
+
+	handler := handlers.NewTreeHandler(repo)
+	router.GET("/node/:id", handler.GetNode)
+	router.PUT("/node/:id", handler.UpdateNode)
+	router.PATCH("/node/:id", handler.PatchNode)
+	router.POST("/node/:id/move", handler.MoveNode)
+	router.DELETE("/node/:id", handler.DeleteNode)
+
+	doAt := func(method, path, ifMatch, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	do := func(method, ifMatch, body string) *httptest.ResponseRecorder {
+		return doAt(method, fmt.Sprintf("/node/%d", nodeID), ifMatch, body)
+	}
+
+	// New nodes start at version 1, returned as the ETag and in the JSON
+	w := do("GET", "", "")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"1"`, w.Header().Get("ETag"))
+	var node models.Node
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &node))
+	assert.Equal(t, int64(1), node.Version)
+
+	// The first editor wins and bumps the version
+	w = do("PUT", `"1"`, `{"label": "first"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"2"`, w.Header().Get("ETag"))
+
+	// The second editor read version 1 too and is rejected
+	w = do("PUT", `"1"`, `{"label": "second"}`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	stored, err := repo.GetNode(context.Background(), nodeID)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", stored.Label)
+	assert.Equal(t, int64(2), stored.Version)
+
+	// Weak ETags can't be compared strongly and are rejected like malformed
+	// headers, while * or no header updates unconditionally
+	w = do("PUT", `W/"2"`, `{"label": "weak"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = do("PUT", `"2", "3"`, `{"label": "list"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = do("PUT", "*", `{"label": "any"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	w = do("PUT", "", `{"label": "unconditional"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"4"`, w.Header().Get("ETag"))
+
+	// Patches honour If-Match as well
+	w = do("PATCH", `"3"`, `{"label": "stale patch"}`)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w = do("PATCH", `W/"4"`, `{"label": "weak patch"}`)
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+	w = do("PATCH", `"4"`, `{"label": "patched"}`)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"5"`, w.Header().Get("ETag"))
+
+	// ...and so do moves
+	targetID, err := repo.CreateNode(context.Background(), "target", nil)
+	assert.NoError(t, err)
+	moveBody := fmt.Sprintf(`{"parentId": %d}`, targetID)
+	movePath := fmt.Sprintf("/node/%d/move", nodeID)
+	w = doAt("POST", movePath, `"4"`, moveBody)
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	stored, err = repo.GetNode(context.Background(), nodeID)
+	assert.NoError(t, err)
+	assert.Nil(t, stored.ParentID, "a rejected move leaves the node in place")
+	w = doAt("POST", movePath, `"5"`, moveBody)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, `"6"`, w.Header().Get("ETag"))
+
+	// Deletes honour If-Match as well
+	w = do("DELETE", `"5"`, "")
+	assert.Equal(t, http.StatusPreconditionFailed, w.Code)
+	w = do("DELETE", `"6"`, "")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// The Lambda handler applies the same rules
+	nodeID, err = repo.CreateNode(context.Background(), "lambda root", nil)
+	assert.NoError(t, err)
+	lambdaHandler := lambda.NewHandler(repo)
+	invoke := func(method, ifMatch, body string) events.APIGatewayProxyResponse {
+		response, err := lambdaHandler.Handle(context.Background(), events.APIGatewayProxyRequest{
+			HTTPMethod: method,
+			Path:       fmt.Sprintf("/api/node/%d", nodeID),
+			Headers:    map[string]string{"if-match": ifMatch},
+			Body:       body,
+		})
+		assert.NoError(t, err)
+		return response
+	}
+
+	response := invoke("PUT", `"1"`, `{"label": "lambda first"}`)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	assert.Equal(t, `"2"`, response.Headers["ETag"])
+	response = invoke("PUT", `"1"`, `{"label": "lambda second"}`)
+	assert.Equal(t, http.StatusPreconditionFailed, response.StatusCode)
+	response = invoke("DELETE", `"1"`, "")
+	assert.Equal(t, http.StatusPreconditionFailed, response.StatusCode)
+	response = invoke("DELETE", `"2"`, "")
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+}
+
+func TestTrash(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	handler := handlers.NewTreeHandler(repo)
+	router.GET("/tree", handler.GetTree)
+	router.GET("/node/:id", handler.GetNode)
+	router.DELETE("/node/:id", handler.DeleteNode)
+	router.GET("/trash", handler.ListTrash)
+	router.POST("/trash/:id/restore", handler.RestoreNode)
+	router.DELETE("/trash/:id", handler.PurgeNode)
+
+	do := func(method, path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	listTrash := func() []*models.Node {
+		w := do("GET", "/trash")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Data []*models.Node `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Data
+	}
+
+	// root -> child -> grandchild
+	ctx := context.Background()
+	rootID, err := repo.CreateNode(ctx, "root", nil)
+	assert.NoError(t, err)
+	childID, err := repo.CreateNode(ctx, "child", &rootID)
+	assert.NoError(t, err)
+	grandchildID, err := repo.CreateNode(ctx, "grandchild", &childID)
+	assert.NoError(t, err)
+
+	// Warm the cache so the delete has to invalidate it
+	assert.Equal(t, http.StatusOK, do("GET", "/tree").Code)
+
+	t.Run("Deleted nodes are hidden", func(t *testing.T) {
+		w := do("DELETE", fmt.Sprintf("/node/%d", childID))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		assert.Equal(t, http.StatusNotFound, do("GET", fmt.Sprintf("/node/%d", grandchildID)).Code)
+
+		w = do("GET", fmt.Sprintf("/node/%d", rootID))
+		assert.Equal(t, http.StatusOK, w.Code)
+		var root models.Node
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &root))
+		assert.Empty(t, root.Children)
+
+		w = do("GET", "/tree")
+		var response cache.PaginatedTreeResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		if assert.Len(t, response.Data, 1) {
+			assert.Empty(t, response.Data[0].Children)
+		}
+	})
+
+	t.Run("Trash lists only the deleted node", func(t *testing.T) {
+		trash := listTrash()
+		if assert.Len(t, trash, 1) {
+			assert.Equal(t, childID, trash[0].ID)
+			assert.NotNil(t, trash[0].DeletedAt)
+		}
+	})
+
+	t.Run("Restore requires a live parent", func(t *testing.T) {
+		// Trash the root too; the child can't come back without it
+		assert.Equal(t, http.StatusOK, do("DELETE", fmt.Sprintf("/node/%d", rootID)).Code)
+		assert.Len(t, listTrash(), 2)
+
+		assert.Equal(t, http.StatusConflict, do("POST", fmt.Sprintf("/trash/%d/restore", childID)).Code)
+
+		// Restoring the root leaves the separately deleted child in the trash
+		w := do("POST", fmt.Sprintf("/trash/%d/restore", rootID))
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, float64(1), response["restored"])
+
+		w = do("POST", fmt.Sprintf("/trash/%d/restore", childID))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, float64(2), response["restored"])
+
+		assert.Equal(t, http.StatusOK, do("GET", fmt.Sprintf("/node/%d", grandchildID)).Code)
+		assert.Empty(t, listTrash())
+	})
+
+	t.Run("Purge deletes permanently", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, do("DELETE", fmt.Sprintf("/node/%d", childID)).Code)
+
+		// Live nodes can't be purged
+		assert.Equal(t, http.StatusNotFound, do("DELETE", fmt.Sprintf("/trash/%d", rootID)).Code)
+
+		w := do("DELETE", fmt.Sprintf("/trash/%d", childID))
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response map[string]interface{}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		assert.Equal(t, float64(2), response["purged"])
+
+		assert.Empty(t, listTrash())
+		assert.Equal(t, http.StatusNotFound, do("POST", fmt.Sprintf("/trash/%d/restore", childID)).Code)
+	})
+
+	t.Run("Purge job removes expired entries", func(t *testing.T) {
+		tree, err := repo.CreateTree(ctx, "work")
+		assert.NoError(t, err)
+		work := repo.ForTree(tree.ID)
+		workRootID, err := work.CreateNode(ctx, "work root", nil)
+		assert.NoError(t, err)
+		_, err = work.DeleteNode(ctx, workRootID, repository.DeleteModeCascade)
+		assert.NoError(t, err)
+		_, err = repo.DeleteNode(ctx, rootID, repository.DeleteModeCascade)
+		assert.NoError(t, err)
+
+		// Nothing has expired yet
+		purged, err := jobs.PurgeExpiredTrash(ctx, repo, time.Hour, time.Now())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(0), purged)
+
+		// Every tree's trash is purged once the retention has passed
+		purged, err = jobs.PurgeExpiredTrash(ctx, repo, time.Hour, time.Now().Add(2*time.Hour))
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), purged)
+		assert.Empty(t, listTrash())
+
+		entries, _, err := work.ListTrash(ctx, 1, 10)
+		assert.NoError(t, err)
+		assert.Empty(t, entries)
+	})
+}
+
+func TestNodeHistory(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	// Stand-in for authentication middleware
+	router.Use(func(c *gin.Context) {
+		if c.GetHeader("Authorization") == "Bearer admin" {
+			c.Set(handlers.ActorContextKey, "admin")
+		}
+	}, handlers.ActorContext())
+
+	handler := handlers.NewTreeHandler(repo)
+	router.POST("/tree", handler.CreateNode)
+	router.PATCH("/node/:id", handler.PatchNode)
+	router.POST("/node/:id/move", handler.MoveNode)
+	router.DELETE("/node/:id", handler.DeleteNode)
+	router.POST("/trash/:id/restore", handler.RestoreNode)
+	router.GET("/node/:id/history", handler.GetNodeHistory)
+
+	do := func(method, path, body string, headers map[string]string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		for name, value := range headers {
+			req.Header.Set(name, value)
+		}
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	alice := map[string]string{handlers.ActorHeader: "alice"}
+	history := func(path string) ([]*models.HistoryEntry, int64) {
+		w := do("GET", path, "", nil)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Data       []*models.HistoryEntry `json:"data"`
+			Pagination struct {
+				Total int64 `json:"total"`
+			} `json:"pagination"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Data, response.Pagination.Total
+	}
+
+	ctx := context.Background()
+	rootID, err := repo.CreateNode(ctx, "root", nil)
+	assert.NoError(t, err)
+	otherID, err := repo.CreateNode(ctx, "other", nil)
+	assert.NoError(t, err)
+
+	w := do("POST", "/tree", fmt.Sprintf(`{"label": "draft", "parentId": %d}`, rootID), alice)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var created models.Node
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+	path := fmt.Sprintf("/node/%d/history", created.ID)
+
+	assert.Equal(t, http.StatusOK, do("PATCH", fmt.Sprintf("/node/%d", created.ID), `{"label": "final"}`, alice).Code)
+	assert.Equal(t, http.StatusOK, do("POST", fmt.Sprintf("/node/%d/move", created.ID), fmt.Sprintf(`{"parentId": %d}`, otherID),
+		map[string]string{"Authorization": "Bearer admin", handlers.ActorHeader: "alice"}).Code)
+	assert.Equal(t, http.StatusOK, do("DELETE", fmt.Sprintf("/node/%d", created.ID), "", nil).Code)
+	assert.Equal(t, http.StatusOK, do("POST", fmt.Sprintf("/trash/%d/restore", created.ID), "", alice).Code)
+
+	t.Run("Records every change, most recent first", func(t *testing.T) {
+		entries, total := history(path)
+		assert.Equal(t, int64(5), total)
+		if !assert.Len(t, entries, 5) {
+			return
+		}
+
+		actions := make([]string, 0, len(entries))
+		for _, entry := range entries {
+			actions = append(actions, entry.Action)
+		}
+		assert.Equal(t, []string{"restore", "delete", "move", "update", "create"}, actions)
+
+		restore, deleted, move, update, create := entries[0], entries[1], entries[2], entries[3], entries[4]
+
+		assert.Nil(t, create.OldLabel)
+		assert.Equal(t, "draft", *create.NewLabel)
+		assert.Equal(t, rootID, *create.NewParentID)
+		assert.Equal(t, "alice", create.Actor)
+
+		assert.Equal(t, "draft", *update.OldLabel)
+		assert.Equal(t, "final", *update.NewLabel)
+
+		// The authenticated actor wins over the header
+		assert.Equal(t, rootID, *move.OldParentID)
+		assert.Equal(t, otherID, *move.NewParentID)
+		assert.Equal(t, "admin", move.Actor)
+
+		assert.Equal(t, "final", *deleted.OldLabel)
+		assert.Nil(t, deleted.NewLabel)
+		assert.Empty(t, deleted.Actor)
+
+		assert.Nil(t, restore.OldLabel)
+		assert.Equal(t, otherID, *restore.NewParentID)
+	})
+
+	t.Run("Pagination", func(t *testing.T) {
+		entries, total := history(path + "?page=2&pageSize=2")
+		assert.Equal(t, int64(5), total)
+		if assert.Len(t, entries, 2) {
+			assert.Equal(t, "move", entries[0].Action)
+			assert.Equal(t, "update", entries[1].Action)
+		}
+
+		w := do("GET", path+"?pageSize=0", "", nil)
+		assert.Equal(t, http.StatusBadRequest, w.Code)
+	})
+
+	t.Run("Reparented children are recorded as moves", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, do("DELETE", fmt.Sprintf("/node/%d?mode=reparent", otherID), "", alice).Code)
+
+		entries, _ := history(path)
+		if assert.NotEmpty(t, entries) {
+			assert.Equal(t, "move", entries[0].Action)
+			assert.Equal(t, otherID, *entries[0].OldParentID)
+			assert.Nil(t, entries[0].NewParentID)
+			assert.Equal(t, "alice", entries[0].Actor)
+		}
+	})
+
+	t.Run("Missing node", func(t *testing.T) {
+		assert.Equal(t, http.StatusNotFound, do("GET", "/node/999/history", "", nil).Code)
+		assert.Equal(t, http.StatusBadRequest, do("GET", "/node/abc/history", "", nil).Code)
+	})
+
+	t.Run("Lambda reads the actor header", func(t *testing.T) {
+		response, err := lambda.NewHandler(repo).Handle(ctx, events.APIGatewayProxyRequest{
+			HTTPMethod: "POST",
+			Path:       "/api/tree",
+			Headers:    map[string]string{"x-actor": "bob"},
+			Body:       `{"label": "from lambda"}`,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.StatusCode)
+		var node models.Node
+		assert.NoError(t, json.Unmarshal([]byte(response.Body), &node))
+
+		entries, _, err := repo.GetNodeHistory(ctx, node.ID, 1, 10)
+		assert.NoError(t, err)
+		if assert.Len(t, entries, 1) {
+			assert.Equal(t, "bob", entries[0].Actor)
+		}
+	})
+}
+
+func TestPointInTimeReads(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	handler := handlers.NewTreeHandler(repo)
+	router.GET("/tree", handler.GetTree)
+	router.GET("/node/:id", handler.GetNode)
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	getTree := func(path string) cache.PaginatedTreeResponse {
+		w := get(path)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response cache.PaginatedTreeResponse
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response
+	}
+	// Separate the phases so their changes get distinct timestamps
+	instant := func() string {
+		time.Sleep(5 * time.Millisecond)
+		defer time.Sleep(5 * time.Millisecond)
+		return time.Now().UTC().Format(time.RFC3339Nano)
+	}
+
+	ctx := context.Background()
+	beforeAll := instant()
+
+	// docs -> draft, archive
+	docsID, err := repo.CreateNode(ctx, "docs", nil)
+	assert.NoError(t, err)
+	draftID, err := repo.CreateNode(ctx, "draft", &docsID)
+	assert.NoError(t, err)
+	archiveID, err := repo.CreateNode(ctx, "archive", nil)
+	assert.NoError(t, err)
+	original := instant()
+
+	// Rename and move the draft, then delete docs
+	label := "final"
+	assert.NoError(t, repo.PatchNode(ctx, draftID, repository.NodePatch{Label: &label}))
+	assert.NoError(t, repo.MoveNode(ctx, draftID, &archiveID, repository.MovePosition{}))
+	_, err = repo.DeleteNode(ctx, docsID, repository.DeleteModeCascade)
+	assert.NoError(t, err)
+
+	// Warm the live cache
+	live := getTree("/tree")
+	if assert.Len(t, live.Data, 1) {
+		assert.Equal(t, "archive", live.Data[0].Label)
+		if assert.Len(t, live.Data[0].Children, 1) {
+			assert.Equal(t, "final", live.Data[0].Children[0].Label)
+		}
+	}
+
+	t.Run("Tree as of an earlier time", func(t *testing.T) {
+		historical := getTree("/tree?asOf=" + original)
+		assert.Equal(t, int64(2), historical.Pagination.Total)
+		if assert.Len(t, historical.Data, 2) {
+			assert.Equal(t, "docs", historical.Data[0].Label)
+			if assert.Len(t, historical.Data[0].Children, 1) {
+				assert.Equal(t, "draft", historical.Data[0].Children[0].Label)
+			}
+			assert.Equal(t, "archive", historical.Data[1].Label)
+			assert.Empty(t, historical.Data[1].Children)
+		}
+
+		// Historical reads neither use nor fill the live cache
+		assert.Equal(t, live, getTree("/tree"))
+
+		assert.Empty(t, getTree("/tree?asOf="+beforeAll).Data)
+	})
+
+	t.Run("Node as of an earlier time", func(t *testing.T) {
+		// docs is in the trash now, but existed then
+		assert.Equal(t, http.StatusNotFound, get(fmt.Sprintf("/node/%d", docsID)).Code)
+
+		w := get(fmt.Sprintf("/node/%d?asOf=%s", docsID, original))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.Empty(t, w.Header().Get("ETag"))
+		var node models.Node
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &node))
+		assert.Equal(t, "docs", node.Label)
+		if assert.Len(t, node.Children, 1) {
+			assert.Equal(t, "draft", node.Children[0].Label)
+			assert.Equal(t, docsID, *node.Children[0].ParentID)
+		}
+
+		w = get(fmt.Sprintf("/node/%d?asOf=%s&depth=0", docsID, original))
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &node))
+		assert.Empty(t, node.Children)
+
+		assert.Equal(t, http.StatusNotFound, get(fmt.Sprintf("/node/%d?asOf=%s", draftID, beforeAll)).Code)
+	})
+
+	t.Run("Lambda tree as of an earlier time", func(t *testing.T) {
+		lambdaHandler := lambda.NewHandler(repo)
+		invoke := func(query map[string]string) (events.APIGatewayProxyResponse, cache.PaginatedTreeResponse) {
+			response, err := lambdaHandler.Handle(ctx, events.APIGatewayProxyRequest{
+				HTTPMethod:            "GET",
+				Path:                  "/api/tree",
+				QueryStringParameters: query,
+			})
+			assert.NoError(t, err)
+			var page cache.PaginatedTreeResponse
+			if response.StatusCode == http.StatusOK {
+				assert.NoError(t, json.Unmarshal([]byte(response.Body), &page))
+			}
+			return response, page
+		}
+
+		// Warm the Lambda's live cache with the renamed draft
+		response, page := invoke(nil)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		if assert.Len(t, page.Data, 1) && assert.Len(t, page.Data[0].Children, 1) {
+			assert.Equal(t, "final", page.Data[0].Children[0].Label)
+		}
+
+		response, page = invoke(map[string]string{"asOf": original})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, int64(2), page.Pagination.Total)
+		if assert.Len(t, page.Data, 2) {
+			assert.Equal(t, "docs", page.Data[0].Label)
+			if assert.Len(t, page.Data[0].Children, 1) {
+				assert.Equal(t, "draft", page.Data[0].Children[0].Label)
+			}
+		}
+
+		response, page = invoke(map[string]string{"asOf": beforeAll})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Empty(t, page.Data)
+
+		response, _ = invoke(map[string]string{"asOf": "yesterday"})
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+		response, _ = invoke(map[string]string{"asOf": original, "cursor": ""})
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("Lambda node as of an earlier time", func(t *testing.T) {
+		lambdaHandler := lambda.NewHandler(repo)
+		invoke := func(id int64, query map[string]string) (events.APIGatewayProxyResponse, models.Node) {
+			response, err := lambdaHandler.Handle(ctx, events.APIGatewayProxyRequest{
+				HTTPMethod:            "GET",
+				Path:                  fmt.Sprintf("/api/node/%d", id),
+				QueryStringParameters: query,
+			})
+			assert.NoError(t, err)
+			var node models.Node
+			if response.StatusCode == http.StatusOK {
+				assert.NoError(t, json.Unmarshal([]byte(response.Body), &node))
+			}
+			return response, node
+		}
+
+		response, _ := invoke(docsID, nil)
+		assert.Equal(t, http.StatusNotFound, response.StatusCode)
+
+		response, node := invoke(docsID, map[string]string{"asOf": original})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Empty(t, response.Headers["ETag"])
+		assert.Equal(t, "docs", node.Label)
+		if assert.Len(t, node.Children, 1) {
+			assert.Equal(t, "draft", node.Children[0].Label)
+		}
+
+		response, node = invoke(docsID, map[string]string{"asOf": original, "depth": "0"})
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Empty(t, node.Children)
+
+		// Live reads carry the node's ETag
+		response, node = invoke(draftID, nil)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+		assert.Equal(t, "final", node.Label)
+		assert.Equal(t, models.FormatETag(node.Version), response.Headers["ETag"])
+
+		response, _ = invoke(draftID, map[string]string{"asOf": beforeAll})
+		assert.Equal(t, http.StatusNotFound, response.StatusCode)
+		response, _ = invoke(draftID, map[string]string{"asOf": "yesterday"})
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+		response, _ = invoke(draftID, map[string]string{"depth": "-1"})
+		assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	})
+
+	t.Run("Invalid requests", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, get("/tree?asOf=yesterday").Code)
+		assert.Equal(t, http.StatusBadRequest, get("/tree?cursor=&asOf="+original).Code)
+		assert.Equal(t, http.StatusBadRequest, get("/tree?attr.status=active&asOf="+original).Code)
+		assert.Equal(t, http.StatusBadRequest, get(fmt.Sprintf("/node/%d?asOf=2024-01-01", docsID)).Code)
+	})
+}
+
+// sseEvent is one event read from a Server-Sent Events stream
+type sseEvent struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// readSSEEvent reads the next event from an SSE stream, skipping comments
+func readSSEEvent(t *testing.T, reader *bufio.Reader) sseEvent {
+	var event sseEvent
+	for {
+		line, err := reader.ReadString('\n')
+		if !assert.NoError(t, err) {
+			return event
+		}
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case line == "":
+			if event.Event != "" || event.Data != "" {
+				return event
+			}
+		case strings.HasPrefix(line, "id:"):
+			event.ID = strings.TrimSpace(strings.TrimPrefix(line, "id:"))
+		case strings.HasPrefix(line, "event:"):
+			event.Event = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			event.Data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		}
+	}
+}
+
+func TestEventStream(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	handler := handlers.NewTreeHandler(repo, handlers.WithEventBroker(feed.NewBroker(10)))
+	router.POST("/tree", handler.CreateNode)
+	router.PATCH("/node/:id", handler.PatchNode)
+	router.POST("/node/:id/move", handler.MoveNode)
+	router.DELETE("/node/:id", handler.DeleteNode)
+	router.GET("/events", handler.StreamEvents)
+
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	do := func(method, path, body string) *http.Response {
+		req, _ := http.NewRequestWithContext(ctx, method, server.URL+path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		return resp
+	}
+	write := func(method, path, body string) {
+		resp := do(method, path, body)
+		defer resp.Body.Close()
+		assert.Less(t, resp.StatusCode, 300)
+	}
+	subscribe := func(path, lastEventID string) (*bufio.Reader, func()) {
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+path, nil)
+		if lastEventID != "" {
+			req.Header.Set("Last-Event-ID", lastEventID)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			t.FailNow()
+		}
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+		assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+		return bufio.NewReader(resp.Body), func() { resp.Body.Close() }
+	}
+	decode := func(event sseEvent) feed.Event {
+		var decoded feed.Event
+		assert.NoError(t, json.Unmarshal([]byte(event.Data), &decoded))
+		assert.Equal(t, event.ID, fmt.Sprint(decoded.ID))
+		assert.Equal(t, event.Event, decoded.Type)
+		return decoded
+	}
+
+	rootID, err := repo.CreateNode(ctx, "root", nil)
+	assert.NoError(t, err)
+	aID, err := repo.CreateNode(ctx, "a", &rootID)
+	assert.NoError(t, err)
+	bID, err := repo.CreateNode(ctx, "b", &rootID)
+	assert.NoError(t, err)
+
+	t.Run("Invalid root", func(t *testing.T) {
+		resp := do("GET", "/events?rootId=abc", "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+
+		resp = do("GET", "/events?rootId=999", "")
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	})
+
+	all, closeAll := subscribe("/events", "")
+	defer closeAll()
+	subtree, closeSubtree := subscribe(fmt.Sprintf("/events?rootId=%d", aID), "")
+	defer closeSubtree()
+
+	resp := do("POST", "/tree", fmt.Sprintf(`{"label": "a1", "parentId": %d}`, aID))
+	var created struct {
+		ID int64 `json:"id"`
+	}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&created))
+	resp.Body.Close()
+	write("PATCH", fmt.Sprintf("/node/%d", bID), `{"label": "b2"}`)
+	write("POST", fmt.Sprintf("/node/%d/move", created.ID), fmt.Sprintf(`{"parentId": %d}`, bID))
+	write("DELETE", fmt.Sprintf("/node/%d", bID), "")
+	// Failed writes publish nothing
+	resp = do("PATCH", "/node/999", `{"label": "missing"}`)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	t.Run("All changes", func(t *testing.T) {
+		event := decode(readSSEEvent(t, all))
+		assert.Equal(t, int64(1), event.ID)
+		assert.Equal(t, feed.NodeCreated, event.Type)
+		assert.Equal(t, created.ID, event.NodeID)
+		assert.Equal(t, "a1", event.Node.Label)
+		assert.Equal(t, []int64{rootID, aID, created.ID}, event.Path)
+
+		event = decode(readSSEEvent(t, all))
+		assert.Equal(t, feed.NodeUpdated, event.Type)
+		assert.Equal(t, "b2", event.Node.Label)
+		assert.Empty(t, event.PreviousPath)
+
+		event = decode(readSSEEvent(t, all))
+		assert.Equal(t, feed.NodeMoved, event.Type)
+		assert.Equal(t, []int64{rootID, bID, created.ID}, event.Path)
+		assert.Equal(t, []int64{rootID, aID, created.ID}, event.PreviousPath)
+
+		event = decode(readSSEEvent(t, all))
+		assert.Equal(t, int64(4), event.ID)
+		assert.Equal(t, feed.NodeDeleted, event.Type)
+		assert.Equal(t, bID, event.NodeID)
+		assert.Equal(t, []int64{rootID, bID}, event.Path)
+	})
+
+	t.Run("Subtree filter", func(t *testing.T) {
+		// The update and delete of b happen outside a's subtree; the move
+		// takes a1 out of it
+		event := readSSEEvent(t, subtree)
+		assert.Equal(t, "1", event.ID)
+		assert.Equal(t, feed.NodeCreated, event.Event)
+
+		event = readSSEEvent(t, subtree)
+		assert.Equal(t, "3", event.ID)
+		assert.Equal(t, feed.NodeMoved, event.Event)
+	})
+
+	t.Run("Resume with Last-Event-ID", func(t *testing.T) {
+		resumed, closeResumed := subscribe("/events", "2")
+		defer closeResumed()
+
+		assert.Equal(t, "3", readSSEEvent(t, resumed).ID)
+		assert.Equal(t, "4", readSSEEvent(t, resumed).ID)
+
+		write("POST", "/tree", `{"label": "live"}`)
+		event := decode(readSSEEvent(t, resumed))
+		assert.Equal(t, int64(5), event.ID)
+		assert.Equal(t, "live", event.Node.Label)
+	})
+
+	t.Run("Resume after buffer overflow", func(t *testing.T) {
+		for i := 0; i < 10; i++ {
+			write("POST", "/tree", fmt.Sprintf(`{"label": "filler %d"}`, i))
+		}
+
+		// Event 3 has been evicted from the ten event buffer
+		resumed, closeResumed := subscribe("/events", "2")
+		defer closeResumed()
+
+		assert.Equal(t, "reset", readSSEEvent(t, resumed).Event)
+		assert.Equal(t, "6", readSSEEvent(t, resumed).ID)
+
+		req, _ := http.NewRequestWithContext(ctx, "GET", server.URL+"/events", nil)
+		req.Header.Set("Last-Event-ID", "abc")
+		resp, err := http.DefaultClient.Do(req)
+		assert.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+	})
+}
+
+func TestEventsForImportsAndReparents(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	broker := feed.NewBroker(100)
+	handler := handlers.NewTreeHandler(repo, handlers.WithEventBroker(broker))
+	router.POST("/tree/import", handler.ImportTree)
+	router.DELETE("/node/:id", handler.DeleteNode)
+	router.POST("/batch", handler.ApplyBatch)
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+
+	sub := broker.Subscribe(0, false)
+	defer sub.Close()
+	next := func() feed.Event {
+		select {
+		case event := <-sub.Events():
+			return event
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for an event")
+			return feed.Event{}
+		}
+	}
+
+	rootID, err := repo.CreateNode(context.Background(), "root", nil)
+	assert.NoError(t, err)
+
+	// root -> a -> a1 -> a11
+	//           -> a2
+	w := do("POST", "/tree/import", fmt.Sprintf(`{"parentId": %d, "root": {"label": "a", "children": [
+		{"label": "a1", "children": [{"label": "a11"}]},
+		{"label": "a2"}
+	]}}`, rootID))
+	assert.Equal(t, http.StatusCreated, w.Code)
+	var imported struct {
+		IDs map[string]int64 `json:"ids"`
+	}
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &imported))
+	aID := imported.IDs["/root"]
+	a1ID := imported.IDs["/root/children/0"]
+	a11ID := imported.IDs["/root/children/0/children/0"]
+	a2ID := imported.IDs["/root/children/1"]
+
+	t.Run("Every imported node is created, parents first", func(t *testing.T) {
+		want := [][]int64{
+			{rootID, aID},
+			{rootID, aID, a1ID},
+			{rootID, aID, a2ID},
+			{rootID, aID, a1ID, a11ID},
+		}
+		for _, path := range want {
+			event := next()
+			assert.Equal(t, feed.NodeCreated, event.Type)
+			assert.Equal(t, path[len(path)-1], event.NodeID)
+			assert.Equal(t, path, event.Path)
+		}
+	})
+
+	t.Run("Reparented children are moved before the delete", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, do("DELETE", fmt.Sprintf("/node/%d?mode=reparent", aID), "").Code)
+
+		event := next()
+		assert.Equal(t, feed.NodeMoved, event.Type)
+		assert.Equal(t, a1ID, event.NodeID)
+		assert.Equal(t, []int64{rootID, a1ID}, event.Path)
+		assert.Equal(t, []int64{rootID, aID, a1ID}, event.PreviousPath)
+
+		event = next()
+		assert.Equal(t, feed.NodeMoved, event.Type)
+		assert.Equal(t, a2ID, event.NodeID)
+		assert.Equal(t, []int64{rootID, aID, a2ID}, event.PreviousPath)
+
+		event = next()
+		assert.Equal(t, feed.NodeDeleted, event.Type)
+		assert.Equal(t, aID, event.NodeID)
+		assert.Equal(t, []int64{rootID, aID}, event.Path)
+	})
+
+	t.Run("Batch reparent deletes move the children too", func(t *testing.T) {
+		w := do("POST", "/batch", fmt.Sprintf(`{"operations": [{"op": "delete", "id": %d, "mode": "reparent"}]}`, a1ID))
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		event := next()
+		assert.Equal(t, feed.NodeMoved, event.Type)
+		assert.Equal(t, a11ID, event.NodeID)
+		assert.Equal(t, []int64{rootID, a11ID}, event.Path)
+		assert.Equal(t, []int64{rootID, a1ID, a11ID}, event.PreviousPath)
+
+		event = next()
+		assert.Equal(t, feed.NodeDeleted, event.Type)
+		assert.Equal(t, a1ID, event.NodeID)
+	})
+}
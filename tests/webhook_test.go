@@ -0,0 +1,292 @@
+package tests
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-lambda-go/events"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/ammiranda/tree_service/feed"
+	"github.com/ammiranda/tree_service/handlers"
+	"github.com/ammiranda/tree_service/internal/lambda"
+	"github.com/ammiranda/tree_service/jobs"
+	"github.com/ammiranda/tree_service/models"
+)
+
+// receivedWebhook is a request received by a test webhook receiver
+type receivedWebhook struct {
+	header http.Header
+	body   []byte
+}
+
+func TestWebhooks(t *testing.T) {
+	// Set up test environment
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+
+	// Initialize test dependencies
+	repo, cleanup := setupTest(t)
+	defer cleanup()
+
+	handler := handlers.NewTreeHandler(repo)
+	router.POST("/tree", handler.CreateNode)
+	router.PATCH("/node/:id", handler.PatchNode)
+	router.POST("/node/:id/move", handler.MoveNode)
+	router.GET("/webhooks", handler.ListWebhooks)
+	router.POST("/webhooks", handler.CreateWebhook)
+	router.GET("/webhooks/:id", handler.GetWebhook)
+	router.PATCH("/webhooks/:id", handler.UpdateWebhook)
+	router.DELETE("/webhooks/:id", handler.DeleteWebhook)
+	router.GET("/webhooks/:id/deliveries", handler.ListWebhookDeliveries)
+
+	// The receiver responds with the status in status and records every request
+	var status atomic.Int32
+	status.Store(http.StatusOK)
+	received := make(chan receivedWebhook, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- receivedWebhook{header: r.Header, body: body}
+		w.WriteHeader(int(status.Load()))
+		fmt.Fprint(w, http.StatusText(int(status.Load())))
+	}))
+	defer receiver.Close()
+
+	dispatcher := jobs.NewWebhookDispatcher(repo, &http.Client{Timeout: 5 * time.Second}, 3, time.Minute, time.Hour)
+	ctx := context.Background()
+
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w
+	}
+	deliveries := func(webhookID int64) ([]*models.WebhookDelivery, int64) {
+		w := do("GET", fmt.Sprintf("/webhooks/%d/deliveries", webhookID), "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var response struct {
+			Data       []*models.WebhookDelivery `json:"data"`
+			Pagination struct {
+				Total int64 `json:"total"`
+			} `json:"pagination"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &response))
+		return response.Data, response.Pagination.Total
+	}
+	deliver := func(now time.Time) int {
+		attempted, err := dispatcher.DeliverDue(ctx, now)
+		assert.NoError(t, err)
+		return attempted
+	}
+
+	secret := "0123456789abcdef0123"
+	var webhook models.Webhook
+
+	t.Run("Create and read", func(t *testing.T) {
+		w := do("POST", "/webhooks", fmt.Sprintf(`{"url": %q, "secret": %q, "events": ["node.created", "node.updated"]}`, receiver.URL, secret))
+		assert.Equal(t, http.StatusCreated, w.Code)
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &webhook))
+		assert.Equal(t, receiver.URL, webhook.URL)
+		assert.Equal(t, secret, webhook.Secret)
+		assert.Equal(t, []string{feed.NodeCreated, feed.NodeUpdated}, webhook.Events)
+		assert.True(t, webhook.Active)
+
+		// The secret is only returned on creation
+		w = do("GET", fmt.Sprintf("/webhooks/%d", webhook.ID), "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		assert.NotContains(t, w.Body.String(), secret)
+
+		w = do("GET", "/webhooks", "")
+		assert.Equal(t, http.StatusOK, w.Code)
+		var list struct {
+			Data []*models.Webhook `json:"data"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &list))
+		assert.Len(t, list.Data, 1)
+
+		// Without a secret one is generated
+		w = do("POST", "/webhooks", fmt.Sprintf(`{"url": %q, "active": false}`, receiver.URL))
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var generated models.Webhook
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &generated))
+		assert.Len(t, generated.Secret, 64)
+		assert.False(t, generated.Active)
+		assert.Equal(t, []string{}, generated.Events)
+		assert.Equal(t, http.StatusOK, do("DELETE", fmt.Sprintf("/webhooks/%d", generated.ID), "").Code)
+	})
+
+	t.Run("Invalid input", func(t *testing.T) {
+		assert.Equal(t, http.StatusBadRequest, do("POST", "/webhooks", `{"url": "ftp://example.com/hook"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, do("POST", "/webhooks", `{"url": "not a url"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, do("POST", "/webhooks", `{"url": "http://example.com", "secret": "short"}`).Code)
+		assert.Equal(t, http.StatusBadRequest, do("POST", "/webhooks", `{"url": "http://example.com", "events": ["node.renamed"]}`).Code)
+		assert.Equal(t, http.StatusBadRequest, do("PATCH", fmt.Sprintf("/webhooks/%d", webhook.ID), `{}`).Code)
+		assert.Equal(t, http.StatusBadRequest, do("GET", "/webhooks/abc", "").Code)
+		assert.Equal(t, http.StatusNotFound, do("GET", "/webhooks/999", "").Code)
+		assert.Equal(t, http.StatusNotFound, do("GET", "/webhooks/999/deliveries", "").Code)
+	})
+
+	var nodeID int64
+	t.Run("Signed delivery", func(t *testing.T) {
+		w := do("POST", "/tree", `{"label": "root"}`)
+		assert.Equal(t, http.StatusCreated, w.Code)
+		var created struct {
+			ID int64 `json:"id"`
+		}
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &created))
+		nodeID = created.ID
+
+		// Moves aren't subscribed to
+		w = do("POST", fmt.Sprintf("/node/%d/move", nodeID), `{}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		assert.Equal(t, 1, deliver(time.Now()))
+		request := <-received
+		assert.Equal(t, feed.NodeCreated, request.header.Get(jobs.WebhookEventHeader))
+		assert.Equal(t, jobs.SignWebhookPayload(secret, request.body), request.header.Get(jobs.WebhookSignatureHeader))
+		assert.NotEmpty(t, request.header.Get(jobs.WebhookDeliveryHeader))
+
+		var event feed.Event
+		assert.NoError(t, json.Unmarshal(request.body, &event))
+		assert.Equal(t, feed.NodeCreated, event.Type)
+		assert.Equal(t, nodeID, event.NodeID)
+		assert.Equal(t, "root", event.Node.Label)
+
+		// The event ID is that of the change's history entry, which survives
+		// restarts unlike the change feed's
+		entries, _, err := repo.GetNodeHistory(ctx, nodeID, 1, 10)
+		assert.NoError(t, err)
+		if assert.Len(t, entries, 2) {
+			assert.Equal(t, entries[1].ID, event.ID)
+		}
+
+		// Nothing is left to deliver
+		assert.Equal(t, 0, deliver(time.Now()))
+
+		log, total := deliveries(webhook.ID)
+		assert.Equal(t, int64(1), total)
+		assert.Equal(t, feed.NodeCreated, log[0].Event)
+		assert.Equal(t, "succeeded", log[0].Status)
+		assert.Equal(t, 1, log[0].Attempts)
+		assert.Equal(t, http.StatusOK, log[0].ResponseStatus)
+		assert.Nil(t, log[0].NextAttemptAt)
+		assert.JSONEq(t, string(request.body), string(log[0].Payload))
+	})
+
+	t.Run("Retries with backoff until dead", func(t *testing.T) {
+		status.Store(http.StatusInternalServerError)
+		w := do("PATCH", fmt.Sprintf("/node/%d", nodeID), `{"label": "renamed"}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+
+		now := time.Now()
+		assert.Equal(t, 1, deliver(now))
+		<-received
+		log, _ := deliveries(webhook.ID)
+		assert.Equal(t, "pending", log[0].Status)
+		assert.Equal(t, 1, log[0].Attempts)
+		assert.Equal(t, http.StatusInternalServerError, log[0].ResponseStatus)
+		assert.Contains(t, log[0].LastError, "500")
+		if assert.NotNil(t, log[0].NextAttemptAt) {
+			assert.WithinDuration(t, now.Add(time.Minute), *log[0].NextAttemptAt, 5*time.Second)
+		}
+
+		// Not due until the backoff has passed, which doubles per attempt
+		assert.Equal(t, 0, deliver(now.Add(30*time.Second)))
+		assert.Equal(t, 1, deliver(now.Add(2*time.Minute)))
+		<-received
+		log, _ = deliveries(webhook.ID)
+		assert.Equal(t, 2, log[0].Attempts)
+		if assert.NotNil(t, log[0].NextAttemptAt) {
+			assert.WithinDuration(t, now.Add(2*time.Minute), *log[0].NextAttemptAt, 5*time.Second)
+		}
+
+		// The third failure exhausts the attempts
+		assert.Equal(t, 1, deliver(now.Add(10*time.Minute)))
+		<-received
+		assert.Equal(t, 0, deliver(now.Add(24*time.Hour)))
+
+		log, total := deliveries(webhook.ID)
+		assert.Equal(t, int64(2), total)
+		assert.Equal(t, "dead", log[0].Status)
+		assert.Equal(t, 3, log[0].Attempts)
+		assert.Nil(t, log[0].NextAttemptAt)
+		assert.Equal(t, "succeeded", log[1].Status)
+	})
+
+	t.Run("Backoff is capped", func(t *testing.T) {
+		assert.Equal(t, time.Minute, dispatcher.Backoff(1))
+		assert.Equal(t, 4*time.Minute, dispatcher.Backoff(3))
+		assert.Equal(t, time.Hour, dispatcher.Backoff(20))
+	})
+
+	t.Run("Lambda changes are delivered", func(t *testing.T) {
+		status.Store(http.StatusOK)
+		lambdaHandler := lambda.NewHandler(repo)
+
+		response, err := lambdaHandler.Handle(ctx, events.APIGatewayProxyRequest{
+			HTTPMethod: "POST",
+			Path:       "/api/tree",
+			Body:       `{"label": "from lambda"}`,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusCreated, response.StatusCode)
+		var created models.Node
+		assert.NoError(t, json.Unmarshal([]byte(response.Body), &created))
+
+		response, err = lambdaHandler.Handle(ctx, events.APIGatewayProxyRequest{
+			HTTPMethod: "PUT",
+			Path:       fmt.Sprintf("/api/node/%d", created.ID),
+			Body:       `{"label": "renamed by lambda"}`,
+		})
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusOK, response.StatusCode)
+
+		assert.Equal(t, 2, deliver(time.Now()))
+		for _, expected := range []struct{ eventType, label string }{
+			{feed.NodeCreated, "from lambda"},
+			{feed.NodeUpdated, "renamed by lambda"},
+		} {
+			request := <-received
+			var event feed.Event
+			assert.NoError(t, json.Unmarshal(request.body, &event))
+			assert.Equal(t, expected.eventType, event.Type)
+			assert.Equal(t, created.ID, event.NodeID)
+			assert.Equal(t, expected.label, event.Node.Label)
+		}
+
+		_, total := deliveries(webhook.ID)
+		assert.Equal(t, int64(4), total)
+	})
+
+	t.Run("Inactive webhooks receive nothing", func(t *testing.T) {
+		status.Store(http.StatusOK)
+		w := do("PATCH", fmt.Sprintf("/webhooks/%d", webhook.ID), `{"active": false, "events": []}`)
+		assert.Equal(t, http.StatusOK, w.Code)
+		var updated models.Webhook
+		assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &updated))
+		assert.False(t, updated.Active)
+		assert.Equal(t, []string{}, updated.Events)
+
+		assert.Equal(t, http.StatusCreated, do("POST", "/tree", `{"label": "quiet"}`).Code)
+		assert.Equal(t, 0, deliver(time.Now()))
+		_, total := deliveries(webhook.ID)
+		assert.Equal(t, int64(4), total)
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		assert.Equal(t, http.StatusOK, do("DELETE", fmt.Sprintf("/webhooks/%d", webhook.ID), "").Code)
+		assert.Equal(t, http.StatusNotFound, do("GET", fmt.Sprintf("/webhooks/%d", webhook.ID), "").Code)
+		assert.Equal(t, http.StatusNotFound, do("GET", fmt.Sprintf("/webhooks/%d/deliveries", webhook.ID), "").Code)
+		assert.Equal(t, http.StatusNotFound, do("DELETE", fmt.Sprintf("/webhooks/%d", webhook.ID), "").Code)
+	})
+}